@@ -2,32 +2,159 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/iopred/septapus/septapus"
 )
 
+var extractassets = septapus.NewOptions("assets").String("extract", "", "Write the built in assets to a directory to customize them, then exit")
+
 func main() {
 	flag.Parse()
+	if err := septapus.LoadOptions(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *extractassets != "" {
+		written, err := septapus.ExtractAssets(*extractassets)
+		for _, file := range written {
+			fmt.Println(file)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	// septapus export|import <archive> [dir], state is read from and written to dir, the working directory by default.
+	// septapus supervise <shards>, runs the bot as several shards that split the servers between them.
+	// septapus check [connect], checks the configuration, assets and state, and connects to each server with connect.
+	// septapus state <command> <namespace> [key], inspects and edits the rpg and prs saves, see stateUsage.
+	// septapus site <dir>, writes the rpg pages, comic galleries, meets and lifters into dir as a static site.
+	switch flag.Arg(0) {
+	case "check":
+		if !check(flag.Arg(1) == "connect") {
+			os.Exit(1)
+		}
+		return
+	case "export", "import":
+		if err := transferState(flag.Arg(0), flag.Arg(1), flag.Arg(2)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "state":
+		if err := inspectState(flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "site":
+		if flag.Arg(1) == "" {
+			fmt.Println("Usage: septapus site <dir>")
+			os.Exit(1)
+		}
+		written, err := septapus.ExportSite(flag.Arg(1))
+		if err != nil {
+			fmt.Println("Error exporting site:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Wrote", written, "files to", flag.Arg(1))
+		return
+	case "supervise":
+		count, err := strconv.Atoi(flag.Arg(1))
+		if err == nil {
+			unlock := lockStore()
+			defer unlock()
+			// The shards are run with the same flags as the supervisor.
+			err = septapus.Supervise(count, os.Args[1:len(os.Args)-flag.NArg()])
+		}
+		if err != nil {
+			fmt.Println("Usage: septapus supervise <shards>:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := septapus.ApplyStagedRestore(); err != nil {
+		fmt.Println("Error restoring backup:", err)
+		os.Exit(1)
+	}
 	rand.Seed(time.Now().UTC().UnixNano())
+	// Shards share their supervisor's lock.
+	if !septapus.Sharded() {
+		unlock := lockStore()
+		defer unlock()
+	}
+	if _, err := septapus.OpenSharedStore(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	nofreenode := septapus.NewPluginSettings()
-	nofreenode.AddBannedServer("freenode")
+	// Named settings are persisted, and can be changed at runtime with !plugin.
+	// The config file can turn named plugins off, and ban or force them on servers and rooms.
+	named := septapus.NewNamedPluginSettings
+	nofreenode := func(name string) *septapus.PluginSettings {
+		settings := named(name)
+		settings.AddBannedServer("freenode")
+		return settings
+	}
 
 	bot := septapus.NewBot()
-	bot.AddPlugin(septapus.NewYouTubePlugin(nil))
-	bot.AddPlugin(septapus.NewURLPlugin(nil))
-	bot.AddPlugin(septapus.NewInvitePlugin(nofreenode))
-	bot.AddPlugin(septapus.NewComicPlugin(nofreenode))
-	bot.AddPlugin(septapus.NewRPGPlugin(nil))
-	bot.AddPlugin(septapus.NewPRPlugin(nil))
-	bot.AddServer(septapus.NewServerSimple("synirc", "irc.synirc.net", "SeptapusTest", "Septapus", "Septapus v9", []string{"#septapus", "#septapustest"}))
-	bot.AddServer(septapus.NewServerSimple("freenode", "irc.freenode.net", "SeptapusTest", "Septapus", "Septapus v9", []string{"#septapus"}))
+	add := func(plugin func(*septapus.PluginSettings) septapus.Plugin, settings *septapus.PluginSettings) {
+		if septapus.PluginEnabled(settings.Name) {
+			bot.AddPlugin(plugin(settings))
+		}
+	}
+	add(septapus.NewYouTubePlugin, named("youtube"))
+	add(septapus.NewYouTubeSubscriptionsPlugin, named("ytsub"))
+	add(septapus.NewURLPlugin, named("url"))
+	add(septapus.NewLinksPlugin, named("links"))
+	add(septapus.NewInvitePlugin, nofreenode("invite"))
+	if septapus.PluginEnabled("comic") {
+		bot.AddPlugin(septapus.NewComicPlugin(nofreenode("comic")))
+	}
+	// The rpg's games are still saved and backed up when it is turned off.
+	rpg := septapus.NewRPGPlugin(named("rpg"))
+	if septapus.PluginEnabled("rpg") {
+		bot.AddPlugin(rpg)
+	}
+	add(septapus.NewPRPlugin, named("pr"))
+	add(septapus.NewLocalePlugin, named("locale"))
+	add(septapus.NewLangPlugin, named("lang"))
+	add(septapus.NewAwayPlugin, named("away"))
+	add(septapus.NewGitHubPlugin, named("github"))
+	add(septapus.NewTwitchPlugin, named("twitch"))
+	add(septapus.NewAliasPlugin, named("alias"))
+	add(septapus.NewHooksPlugin, named("hooks"))
+	add(septapus.NewHighlightPlugin, named("highlight"))
+	add(septapus.NewOpsPlugin, named("ops"))
+	add(septapus.NewWhoisPlugin, named("whois"))
+	add(septapus.NewCelebratePlugin, named("celebrate"))
+	add(septapus.NewKarmaPlugin, named("karma"))
+	bot.AddPlugin(septapus.NewUploadQueuePlugin(nil))
+	bot.AddPlugin(septapus.NewReportPlugin(nil))
+	bot.AddPlugin(septapus.NewSettingsPlugin(nil))
+	bot.AddPlugin(septapus.NewControlPlugin(rpg))
+	bot.AddPlugin(septapus.NewBackupPlugin(rpg, nil))
+	servers := configuredServers()
 	defer bot.Disconnect()
+	if septapus.Sharded() {
+		shard := septapus.NewShard(bot, servers)
+		go shard.Run()
+		defer shard.Stop()
+	} else {
+		for _, server := range servers {
+			bot.AddServer(server)
+		}
+	}
 
 	quit := make(chan bool)
 
@@ -50,3 +177,142 @@ func main() {
 
 	<-quit
 }
+
+// Returns the servers the bot connects to, from the config file if it lists any.
+func configuredServers() []*septapus.Server {
+	servers, err := septapus.ConfiguredServers()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if servers != nil {
+		return servers
+	}
+	synirc := septapus.DefaultServerOptions("Septapus v9")
+	synirc.UserModes = "+B"
+	synirc.CTCPReplies["SOURCE"] = "https://github.com/iopred/septapus"
+	return []*septapus.Server{
+		septapus.NewServerWithOptions("synirc", "irc.synirc.net", "SeptapusTest", "Septapus", "Septapus v9", []string{"#septapus", "#septapustest"}, synirc),
+		septapus.NewServerSimple("freenode", "irc.freenode.net", "SeptapusTest", "Septapus", "Septapus v9", []string{"#septapus"}),
+	}
+}
+
+// Runs septapus.Check and prints each result, returns false if any check failed.
+func check(connect bool) bool {
+	passed := true
+	for _, result := range septapus.Check(configuredServers(), connect) {
+		if result.Err != nil {
+			fmt.Printf("FAIL %v:\n\t%v\n", result.Name, result.Err)
+			passed = false
+		} else {
+			fmt.Println("ok  ", result.Name)
+		}
+	}
+	return passed
+}
+
+// Exports or imports the bot's state.
+func transferState(command, archive, dir string) error {
+	if archive == "" {
+		return fmt.Errorf("Usage: septapus %v <archive> [dir]", command)
+	}
+	archive, err := filepath.Abs(archive)
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+	}
+	if command == "export" {
+		err = septapus.ExportState(archive)
+	} else {
+		err = septapus.ImportState(archive)
+	}
+	if err == nil {
+		fmt.Println(command+"ed", archive)
+	}
+	return err
+}
+
+// Locks the store while the bot runs, so septapus state can't edit the saves under it.
+func lockStore() func() {
+	unlock, err := septapus.LockStore()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return unlock
+}
+
+const stateUsage = `Usage: septapus state <command> <namespace> [key]
+	list <namespace>                    lists the keys saved in rpg or prs
+	show <namespace> <key>              prints a save
+	check <namespace> [key]             checks a save can be loaded, or every save in the namespace
+	stats <namespace> <key>             prints figures worked out from a save
+	set <namespace> <key> <path> <json> sets a field while the bot isn't running, eg: set rpg synirc#septapus Characters.iopred.Gold 100`
+
+// Runs septapus state.
+func inspectState(args []string) error {
+	if len(args) < 2 {
+		return errors.New(stateUsage)
+	}
+	command, namespace, key := args[0], args[1], ""
+	if len(args) > 2 {
+		key = args[2]
+	}
+	switch {
+	case command == "list":
+		keys, err := septapus.SharedStore().List(namespace)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return err
+	case command == "check":
+		keys := []string{key}
+		if key == "" {
+			var err error
+			if keys, err = septapus.SharedStore().List(namespace); err != nil {
+				return err
+			}
+		}
+		failed := false
+		for _, key := range keys {
+			problems, err := septapus.CheckState(namespace, key)
+			if err != nil {
+				return err
+			}
+			if len(problems) == 0 {
+				fmt.Println("ok  ", key)
+				continue
+			}
+			failed = true
+			fmt.Printf("FAIL %v:\n\t%v\n", key, strings.Join(problems, "\n\t"))
+		}
+		if failed {
+			return errors.New("Some saves have problems.")
+		}
+		return nil
+	case key == "":
+	case command == "show":
+		text, err := septapus.ShowState(namespace, key)
+		if err == nil {
+			fmt.Println(text)
+		}
+		return err
+	case command == "stats":
+		lines, err := septapus.StateStats(namespace, key)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return err
+	case command == "set" && len(args) == 5:
+		if err := septapus.EditState(namespace, key, args[3], args[4]); err != nil {
+			return err
+		}
+		fmt.Println("Set", args[3], "in", namespace+"/"+key)
+		return nil
+	}
+	return errors.New(stateUsage)
+}
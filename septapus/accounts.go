@@ -0,0 +1,174 @@
+package septapus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+// Sent by servers with account-notify when a nick logs in or out of services.
+const ACCOUNT EventName = "ACCOUNT"
+
+// How long we wait for a WHOIS before asking about a nick again.
+const accountLookupTimeout = time.Minute
+
+// The services accounts of the nicks we have seen on a server. A nick that isn't logged in is known with an empty account.
+type Accounts struct {
+	sync.RWMutex
+	nicks map[string]string
+	// Nicks we have sent a WHOIS for, and when.
+	lookups map[string]time.Time
+}
+
+func NewAccounts() *Accounts {
+	return &Accounts{nicks: make(map[string]string), lookups: make(map[string]time.Time)}
+}
+
+func (accounts *Accounts) reset() {
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	accounts.nicks = make(map[string]string)
+	accounts.lookups = make(map[string]time.Time)
+}
+
+// Records the account a nick is logged in to, * or an empty account when it isn't logged in.
+func (accounts *Accounts) set(nick, account string) {
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	if account == "*" {
+		account = ""
+	}
+	accounts.nicks[NameKey(nick)] = account
+	delete(accounts.lookups, NameKey(nick))
+}
+
+func (accounts *Accounts) rename(from, to string) {
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	if account, ok := accounts.nicks[NameKey(from)]; ok {
+		accounts.nicks[NameKey(to)] = account
+	} else {
+		delete(accounts.nicks, NameKey(to))
+	}
+	delete(accounts.nicks, NameKey(from))
+}
+
+func (accounts *Accounts) forget(nick string) {
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	delete(accounts.nicks, NameKey(nick))
+}
+
+// Returns true if a WHOIS should be sent to learn a nick's account, marking it as sent.
+func (accounts *Accounts) lookup(nick string) bool {
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	key := NameKey(nick)
+	if _, ok := accounts.nicks[key]; ok {
+		return false
+	}
+	if asked, ok := accounts.lookups[key]; ok && time.Since(asked) < accountLookupTimeout {
+		return false
+	}
+	accounts.lookups[key] = time.Now()
+	return true
+}
+
+// Records that a WHOIS ended, a nick we asked about without hearing of an account isn't logged in.
+func (accounts *Accounts) looked(nick string) {
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	key := NameKey(nick)
+	if _, ok := accounts.lookups[key]; ok {
+		accounts.nicks[key] = ""
+		delete(accounts.lookups, key)
+	}
+}
+
+// Returns the services account a nick is logged in to, and whether we know. A nick that isn't logged in has an empty account.
+func (server *Server) Account(nick string) (string, bool) {
+	server.accounts.RLock()
+	defer server.accounts.RUnlock()
+
+	account, ok := server.accounts.nicks[NameKey(nick)]
+	return account, ok
+}
+
+// Returns true if two account names are the same account on a server.
+func SameAccount(server ServerName, a, b string) bool {
+	return FoldCase(CaseMapping(server), a) == FoldCase(CaseMapping(server), b)
+}
+
+// Decides if nick can use something owned by an account, eg: a character or lifter, owner is empty if nobody has
+// claimed it yet. Returns whether nick can use it, and the account that owns it once it is used, nick's account
+// claims what nobody owns.
+func (server *Server) Owns(owner, nick string) (bool, string) {
+	account, known := server.Account(nick)
+	if owner == "" {
+		return true, account
+	}
+	return known && SameAccount(server.Name, owner, account), owner
+}
+
+// Tracks the services account of every nick we see, from account-notify, extended-join and account tags when the
+// server supports them, and otherwise from a WHOIS when a nick first speaks.
+func AccountsPlugin(bot *Bot, settings *PluginSettings) {
+	events := make(chan *Event)
+	for _, name := range []EventName{client.CONNECTED, client.JOIN, client.NICK, client.QUIT, client.PRIVMSG, ACCOUNT, RPL_WHOISACCOUNT, RPL_ENDOFWHOIS} {
+		go func(channel chan *Event) {
+			for event := range channel {
+				events <- event
+			}
+		}(bot.GetEventHandler(name))
+	}
+	for event := range events {
+		server, line := event.Server, event.Line
+		accounts := server.accounts
+		if line.Nick != "" && NameKey(line.Nick) == NameKey(server.Conn.Me().Nick) {
+			continue
+		}
+		switch EventName(line.Cmd) {
+		case client.CONNECTED:
+			accounts.reset()
+			server.Conn.Raw("CAP REQ :account-notify extended-join account-tag")
+		case client.JOIN:
+			// With extended-join the account follows the room, eg: JOIN #septapus iopred :realname
+			if len(line.Args) > 2 {
+				accounts.set(line.Nick, line.Args[1])
+			}
+		case client.NICK:
+			if len(line.Args) > 0 {
+				accounts.rename(line.Nick, line.Args[0])
+			}
+		case client.QUIT:
+			accounts.forget(line.Nick)
+		case ACCOUNT:
+			if len(line.Args) > 0 {
+				accounts.set(line.Nick, line.Args[0])
+				logging.Info("Account for", server.Name, line.Nick, line.Args[0])
+			}
+		case client.PRIVMSG:
+			if account, ok := line.Tags["account"]; ok {
+				accounts.set(line.Nick, account)
+			} else if accounts.lookup(line.Nick) {
+				server.Conn.Whois(line.Nick)
+			}
+		case RPL_WHOISACCOUNT:
+			if len(line.Args) > 2 {
+				accounts.set(line.Args[1], line.Args[2])
+			}
+		case RPL_ENDOFWHOIS:
+			if len(line.Args) > 1 {
+				accounts.looked(line.Args[1])
+			}
+		}
+	}
+}
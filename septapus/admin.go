@@ -0,0 +1,25 @@
+package septapus
+
+import (
+	"strings"
+)
+
+var adminOptions = NewOptions("admin")
+
+var admins = adminOptions.String("accounts", "", "Comma separated list of services accounts that can use admin commands, a nick must be logged in to one of them")
+
+// Returns true if the sender of an event is allowed to use admin commands. Nicks are matched by the services account
+// they are logged in to, so taking an admin's nick isn't enough. A nick whose account we don't know yet isn't an admin.
+func IsAdmin(event *Event) bool {
+	account, known := event.Server.Account(event.Line.Nick)
+	if !known || account == "" {
+		return false
+	}
+	for _, admin := range strings.Split(*admins, ",") {
+		admin = strings.TrimSpace(admin)
+		if admin != "" && SameAccount(event.Server.Name, admin, account) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package septapus
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+const maxAliasDepth = 5
+
+// Most commands an alias can expand into, however its aliases are nested.
+const maxAliasCommands = 10
+
+var (
+	// Returned by Aliases.Expand for an alias that expands into itself, or into aliases nested deeper than maxAliasDepth.
+	errAliasRecursive = errors.New("alias is recursive")
+	// Returned by Aliases.Expand for an alias that expands into more than maxAliasCommands commands.
+	errAliasTooMany = errors.New("alias expands into too many commands")
+)
+
+// The aliases of each room on a server, rooms are keyed by NameKey so rooms that differ only in case share aliases.
+type Aliases struct {
+	sync.RWMutex
+	Rooms map[RoomName]map[string]string
+}
+
+func aliasRoom(room RoomName) RoomName {
+	return RoomName(NameKey(string(room)))
+}
+
+func (aliases *Aliases) Get(room RoomName, name string) string {
+	return aliases.Rooms[aliasRoom(room)][strings.ToLower(name)]
+}
+
+func (aliases *Aliases) Define(room RoomName, name, expansion string) {
+	room = aliasRoom(room)
+	if aliases.Rooms[room] == nil {
+		aliases.Rooms[room] = make(map[string]string)
+	}
+	aliases.Rooms[room][strings.ToLower(name)] = expansion
+}
+
+func (aliases *Aliases) Remove(room RoomName, name string) bool {
+	if aliases.Get(room, name) == "" {
+		return false
+	}
+	delete(aliases.Rooms[aliasRoom(room)], strings.ToLower(name))
+	return true
+}
+
+func (aliases *Aliases) List(room RoomName) []string {
+	names := make([]string, 0)
+	for name, _ := range aliases.Rooms[aliasRoom(room)] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expands text into the list of commands it represents. Expansions can contain multiple commands separated by ;
+// and can reference other aliases, any arguments given to an alias are appended to the last command. Returns
+// errAliasRecursive or errAliasTooMany for aliases nested too deeply or expanding into too many commands.
+func (aliases *Aliases) Expand(room RoomName, text string) ([]string, error) {
+	count := 0
+	return aliases.expand(room, text, 0, make(map[string]bool), &count)
+}
+
+// Expands text for Expand, count is the number of commands expanded so far.
+func (aliases *Aliases) expand(room RoomName, text string, depth int, seen map[string]bool, count *int) ([]string, error) {
+	fields := strings.Fields(text)
+	name := ""
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "!") {
+		name = strings.ToLower(fields[0][1:])
+	}
+	expansion := aliases.Get(room, name)
+	if name == "" || expansion == "" {
+		if *count++; *count > maxAliasCommands {
+			return nil, errAliasTooMany
+		}
+		return []string{text}, nil
+	}
+	if depth >= maxAliasDepth || seen[name] {
+		return nil, errAliasRecursive
+	}
+	seen[name] = true
+	defer delete(seen, name)
+
+	parts := strings.Split(expansion, ";")
+	if len(fields) > 1 {
+		parts[len(parts)-1] += " " + strings.Join(fields[1:], " ")
+	}
+	commands := make([]string, 0)
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		expanded, err := aliases.expand(room, part, depth+1, seen, count)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, expanded...)
+	}
+	return commands, nil
+}
+
+func (aliases *Aliases) Load(server ServerName) {
+	aliases.Lock()
+	defer aliases.Unlock()
+
+	if err := SharedStore().Get("aliases", string(server), aliases); err == nil {
+		logging.Info("Loaded aliases for", server)
+	} else if err == ErrNotFound {
+		logging.Info("No saved aliases for", server)
+	} else {
+		ReportError("alias", "Error loading aliases", server, err)
+	}
+	if aliases.Rooms == nil {
+		aliases.Rooms = make(map[RoomName]map[string]string)
+	}
+	// Older saves kept rooms as they were typed.
+	for room, names := range aliases.Rooms {
+		if key := aliasRoom(room); key != room {
+			delete(aliases.Rooms, room)
+			for name, expansion := range names {
+				aliases.Define(key, name, expansion)
+			}
+		}
+	}
+}
+
+func (aliases *Aliases) Save(server ServerName) {
+	aliases.Lock()
+	defer aliases.Unlock()
+
+	if err := SharedStore().Put("aliases", string(server), aliases); err != nil {
+		ReportError("alias", "Error saving aliases", server, err)
+	} else {
+		logging.Info("Saved aliases", server)
+	}
+}
+
+// Returns a copy of line with its text replaced.
+func replaceText(line *client.Line, text string) *client.Line {
+	newLine := *line
+	newLine.Args = append([]string{}, line.Args...)
+	if len(newLine.Args) == 0 {
+		newLine.Args = []string{text}
+	} else {
+		newLine.Args[len(newLine.Args)-1] = text
+	}
+	return &newLine
+}
+
+func NewAliasPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(AliasPlugin, settings)
+}
+
+func AliasPlugin(bot *Bot, settings *PluginSettings) {
+	aliases := make(map[ServerName]*Aliases)
+	// Lines we have broadcast ourselves, these must not be expanded again.
+	expanded := make(map[*client.Line]bool)
+
+	getAliases := func(server ServerName) *Aliases {
+		if aliases[server] == nil {
+			aliases[server] = &Aliases{}
+			aliases[server].Load(server)
+		}
+		return aliases[server]
+	}
+
+	// Only room operators and admins can define and remove aliases.
+	presence := NewPresence()
+	handlers := []chan *Event{settings.GetEventHandler(bot, client.PRIVMSG)}
+	for _, name := range PresenceEvents() {
+		handlers = append(handlers, settings.GetEventHandler(bot, name))
+	}
+
+	for event := range Merge(handlers...) {
+		if event.Line.Cmd != client.PRIVMSG {
+			presence.Handle(event)
+			continue
+		}
+		if expanded[event.Line] {
+			delete(expanded, event.Line)
+			continue
+		}
+		if !event.Line.Public() {
+			continue
+		}
+		text := event.Line.Text()
+		if !strings.HasPrefix(text, "!") {
+			continue
+		}
+		serverAliases := getAliases(event.Server.Name)
+		fields := strings.Fields(text)
+		respond := func(key string, vars ResponseVars) string {
+			return Response(event.Server.Name, event.Room, event.Line.Nick, key, vars)
+		}
+		if fields[0] == "!alias" {
+			if len(fields) > 1 && (fields[1] == "define" || fields[1] == "remove") && !presence.IsOp(event.Server.Name, event.Room, event.Line.Nick) && !IsAdmin(event) {
+				event.Server.Privmsg(event.Line.Nick, respond("alias.oponly", nil))
+				continue
+			}
+			switch {
+			case len(fields) >= 4 && fields[1] == "define":
+				name := strings.TrimPrefix(strings.ToLower(fields[2]), "!")
+				expansion := strings.Trim(strings.Join(fields[3:], " "), "\"")
+				if name == "alias" || bot.Commands().Has("!"+name) {
+					event.Server.Privmsg(event.Line.Nick, respond("alias.reserved", ResponseVars{"Name": name}))
+					break
+				}
+				serverAliases.Lock()
+				serverAliases.Define(event.Room, name, expansion)
+				serverAliases.Unlock()
+				serverAliases.Save(event.Server.Name)
+				event.Server.Privmsg(event.Line.Nick, respond("alias.defined", ResponseVars{"Name": name, "Expansion": expansion}))
+			case len(fields) == 3 && fields[1] == "remove":
+				name := strings.TrimPrefix(fields[2], "!")
+				serverAliases.Lock()
+				removed := serverAliases.Remove(event.Room, name)
+				serverAliases.Unlock()
+				if removed {
+					serverAliases.Save(event.Server.Name)
+					event.Server.Privmsg(event.Line.Nick, respond("alias.removed", ResponseVars{"Name": name}))
+				} else {
+					event.Server.Privmsg(event.Line.Nick, respond("alias.unknown", ResponseVars{"Name": name}))
+				}
+			case len(fields) == 2 && fields[1] == "list":
+				serverAliases.RLock()
+				names := serverAliases.List(event.Room)
+				serverAliases.RUnlock()
+				if len(names) == 0 {
+					event.Server.Privmsg(event.Line.Nick, respond("alias.none", ResponseVars{"Room": event.Room}))
+				} else {
+					event.Server.Privmsg(event.Line.Nick, respond("alias.list", ResponseVars{"Aliases": "!" + strings.Join(names, ", !")}))
+				}
+			default:
+				event.Server.Privmsg(event.Line.Nick, respond("alias.usage", nil))
+			}
+			continue
+		}
+
+		serverAliases.RLock()
+		commands, err := serverAliases.Expand(event.Room, text)
+		serverAliases.RUnlock()
+		if err == errAliasRecursive {
+			event.Server.Privmsg(event.Line.Nick, respond("alias.recursive", ResponseVars{"Name": strings.ToLower(fields[0][1:])}))
+			continue
+		}
+		if err == errAliasTooMany {
+			event.Server.Privmsg(event.Line.Nick, respond("alias.toomany", ResponseVars{"Name": strings.ToLower(fields[0][1:]), "Max": maxAliasCommands}))
+			continue
+		}
+		if len(commands) == 1 && commands[0] == text {
+			continue
+		}
+		events := make([]*Event, len(commands))
+		for i, command := range commands {
+			line := replaceText(event.Line, command)
+			expanded[line] = true
+			events[i] = &Event{Server: event.Server, Room: event.Room, Line: line, Time: event.Time}
+		}
+		// Broadcast in a goroutine, as we are also listening to these events.
+		go func() {
+			for _, event := range events {
+				bot.BroadcastEvent(client.PRIVMSG, event)
+			}
+		}()
+	}
+}
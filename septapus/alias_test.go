@@ -0,0 +1,34 @@
+package septapus
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAliasesExpand(t *testing.T) {
+	aliases := &Aliases{Rooms: make(map[RoomName]map[string]string)}
+	aliases.Define("#Septapus", "hi", "!wave; !say hi")
+	aliases.Define("#septapus", "loop", "!loop")
+	aliases.Define("#septapus", "ten", strings.Repeat("!hi;", 5))
+	aliases.Define("#septapus", "many", "!ten; !ten")
+
+	// Rooms that differ only in case share aliases.
+	commands, err := aliases.Expand("#SEPTAPUS", "!hi there")
+	if want := []string{"!wave", "!say hi there"}; err != nil || !reflect.DeepEqual(commands, want) {
+		t.Errorf("Expand(!hi there) = %v, %v, want %v", commands, err, want)
+	}
+	if commands, err := aliases.Expand("#septapus", "!ten"); err != nil || len(commands) != maxAliasCommands {
+		t.Errorf("Expand(!ten) = %v, %v, want %d commands", commands, err, maxAliasCommands)
+	}
+	if _, err := aliases.Expand("#septapus", "!loop"); err != errAliasRecursive {
+		t.Errorf("Expand(!loop) = %v, want errAliasRecursive", err)
+	}
+	// Nesting that stays within maxAliasDepth can still multiply the commands.
+	if _, err := aliases.Expand("#septapus", "!many"); err != errAliasTooMany {
+		t.Errorf("Expand(!many) = %v, want errAliasTooMany", err)
+	}
+	if commands, err := aliases.Expand("#septapus", "!other"); err != nil || !reflect.DeepEqual(commands, []string{"!other"}) {
+		t.Errorf("Expand(!other) = %v, %v, want itself", commands, err)
+	}
+}
@@ -0,0 +1,119 @@
+package septapus
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var assetsOptions = NewOptions("assets")
+
+var assetdir = assetsOptions.String("dir", ".", "Directory whose fonts, avatars, language packs and name packs are used instead of the ones built into the binary")
+
+// The default assets, see assets/README.md.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+// Returns the path of an asset in the override directory, absolute names are used as they are.
+func assetPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(*assetdir, name)
+}
+
+// Returns the path of an asset in the embedded assets, or false if name can't be embedded.
+func embeddedPath(name string) (string, bool) {
+	if filepath.IsAbs(name) {
+		return "", false
+	}
+	return path.Join("assets", filepath.ToSlash(filepath.Clean(name))), true
+}
+
+// Opens an asset, from the override directory if it is there, otherwise from the embedded assets.
+func OpenAsset(name string) (io.ReadCloser, error) {
+	file, err := os.Open(assetPath(name))
+	if err == nil {
+		return file, nil
+	}
+	if embedded, ok := embeddedPath(name); ok {
+		if file, embeddedErr := embeddedAssets.Open(embedded); embeddedErr == nil {
+			return file, nil
+		}
+	}
+	return nil, err
+}
+
+// Reads an asset, from the override directory if it is there, otherwise from the embedded assets.
+func ReadAsset(name string) ([]byte, error) {
+	file, err := OpenAsset(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// Returns the names of the files in an asset directory, sorted. Files in the override directory are listed with the embedded ones.
+func ReadAssetDir(dir string) ([]string, error) {
+	names := make(map[string]bool)
+	files, err := ioutil.ReadDir(assetPath(dir))
+	for _, info := range files {
+		if !info.IsDir() {
+			names[info.Name()] = true
+		}
+	}
+	if embedded, ok := embeddedPath(dir); ok {
+		if entries, embeddedErr := embeddedAssets.ReadDir(embedded); embeddedErr == nil {
+			err = nil
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					names[entry.Name()] = true
+				}
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]string, 0, len(names))
+	for name, _ := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// Writes the embedded assets to dir so they can be customized, existing files are left alone. Returns the files written.
+func ExtractAssets(dir string) ([]string, error) {
+	written := make([]string, 0)
+	err := fs.WalkDir(embeddedAssets, "assets", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(strings.TrimPrefix(name, "assets"), "/")))
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		data, err := embeddedAssets.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		written = append(written, target)
+		return nil
+	})
+	return written, err
+}
@@ -0,0 +1,40 @@
+package septapus
+
+import (
+	"image"
+	_ "image/png"
+	"testing"
+
+	"code.google.com/p/freetype-go/freetype/truetype"
+)
+
+func TestReadAssetComicFont(t *testing.T) {
+	data, err := ReadAsset(comicFontFile())
+	if err != nil {
+		t.Fatalf("ReadAsset(%v) = %v", comicFontFile(), err)
+	}
+	if _, err := truetype.Parse(data); err != nil {
+		t.Errorf("%v isn't a truetype font: %v", comicFontFile(), err)
+	}
+}
+
+func TestReadAssetAvatars(t *testing.T) {
+	files, err := ReadAssetDir("avatars")
+	if err != nil {
+		t.Fatalf("ReadAssetDir(avatars) = %v", err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("%d avatars, comics need at least two for two speaker cells", len(files))
+	}
+	for _, name := range files {
+		file, err := OpenAsset("avatars/" + name)
+		if err != nil {
+			t.Errorf("OpenAsset(avatars/%v) = %v", name, err)
+			continue
+		}
+		if _, _, err := image.Decode(file); err != nil {
+			t.Errorf("avatars/%v isn't an image: %v", name, err)
+		}
+		file.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package septapus
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+type Away struct {
+	Nick   string
+	Reason string
+	Since  time.Time
+}
+
+type Aways map[ServerName]map[string]*Away
+
+func (aways Aways) Get(server ServerName, nick string) *Away {
+	if aways[server] == nil {
+		return nil
+	}
+	return aways[server][NameKey(nick)]
+}
+
+func (aways Aways) Set(server ServerName, nick, reason string) *Away {
+	if aways[server] == nil {
+		aways[server] = make(map[string]*Away)
+	}
+	away := &Away{nick, reason, time.Now()}
+	aways[server][NameKey(nick)] = away
+	return away
+}
+
+func (aways Aways) Clear(server ServerName, nick string) *Away {
+	away := aways.Get(server, nick)
+	if away != nil {
+		delete(aways[server], NameKey(nick))
+	}
+	return away
+}
+
+// Returns the away state for every away nick that is highlighted in text.
+func (aways Aways) Highlighted(server ServerName, text string) []*Away {
+	highlighted := make([]*Away, 0)
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(text) {
+		key := NameKey(strings.TrimRight(word, ":,.!?"))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if away := aways.Get(server, key); away != nil {
+			highlighted = append(highlighted, away)
+		}
+	}
+	return highlighted
+}
+
+func DurationString(duration time.Duration) string {
+	if duration < time.Minute {
+		return (duration - duration%time.Second).String()
+	}
+	return (duration - duration%time.Minute).String()
+}
+
+func NewAwayPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(AwayPlugin, settings)
+}
+
+var awayCommand = NewCommand("!away [reason...]").WithHelp("Marks you as away until you next talk, people who mention you are told why.")
+
+func AwayPlugin(bot *Bot, settings *PluginSettings) {
+	aways := make(Aways)
+	settings.DescribeCommand(bot, awayCommand)
+	channel := settings.GetEventHandler(bot, client.PRIVMSG)
+	for event := range channel {
+		server := event.Server.Name
+		nick := event.Line.Nick
+		text := event.Line.Text()
+		if awayCommand.Matches(text) {
+			reason := strings.TrimSpace(strings.TrimPrefix(text, "!away"))
+			if reason == "" {
+				reason = Response(server, event.Room, nick, "away.reason", nil)
+			}
+			aways.Set(server, nick, reason)
+			event.Server.Privmsg(nick, Response(server, event.Room, nick, "away.set", ResponseVars{"Reason": reason}))
+			continue
+		}
+		if away := aways.Clear(server, nick); away != nil {
+			event.Server.Privmsg(nick, Response(server, event.Room, nick, "away.back", ResponseVars{"Duration": DurationString(time.Since(away.Since))}))
+		}
+		if event.Line.Target() == nick || event.Server.CatchingUp(event) {
+			continue
+		}
+		for _, away := range aways.Highlighted(server, text) {
+			settings.Reply(event, Response(server, event.Room, nick, "away.away", ResponseVars{"Nick": away.Nick, "Reason": away.Reason, "Duration": DurationString(time.Since(away.Since))}))
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package septapus
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var backupOptions = NewOptions("backup")
+
+var backupdir = backupOptions.String("dir", "backups", "Directory backups are written to")
+var backupinterval = backupOptions.Duration("interval", 24*time.Hour, "How often state is backed up automatically, 0 turns automatic backups off")
+var backupkeep = backupOptions.Int("keep", 7, "Most automatic backups kept, the oldest are deleted first. Backups made with !backup are kept until they are deleted by hand")
+
+var (
+	backupCommand  = NewCommand("!backup list", "!backup").WithHelp("Admins only, backs up the bot's state now or lists the backups.")
+	restoreCommand = NewCommand("!restore cancel", "!restore <id>").WithHelp("Admins only, restores a backup the next time the bot starts.")
+)
+
+// Everything the plugins save outside the store, relative to the working directory. New state needs adding here, or
+// to storeNamespaces, to be backed up.
+var backupPaths = []string{
+	"bans",
+	"challenges.json",
+	"comicstats",
+	"rooms",
+	"settings",
+	"karma.json",
+	"languages.json",
+	"links.json",
+	"locales.json",
+	"youtube.json",
+}
+
+// The namespaces plugins keep in the store, under store.dir in the file store or in the bolt store's database.
+var storeNamespaces = []string{"aliases", "prs", "rpg"}
+
+// The name the bolt store's database has in archives, wherever store.path puts it.
+const archiveStoreDatabase = "septapus.db"
+
+// A file or directory of state, and the name it has in archives.
+type statePath struct {
+	Path string
+	Name string
+}
+
+// Returns everything the plugins save, with the store's namespaces and database where the store options put them.
+func statePaths() []statePath {
+	paths := make([]statePath, 0, len(backupPaths)+len(storeNamespaces)+1)
+	for _, path := range backupPaths {
+		paths = append(paths, statePath{path, path})
+	}
+	for _, namespace := range storeNamespaces {
+		paths = append(paths, statePath{filepath.Join(*storedir, namespace), namespace})
+	}
+	return append(paths, statePath{*storepath, archiveStoreDatabase})
+}
+
+// Returns where a file from an archive is restored to, the store's files go back to where the store options put them.
+func archivePath(name string) string {
+	if name == archiveStoreDatabase {
+		return *storepath
+	}
+	path := filepath.FromSlash(name)
+	for _, namespace := range storeNamespaces {
+		if strings.HasPrefix(name, namespace+"/") {
+			return filepath.Join(*storedir, path)
+		}
+	}
+	return path
+}
+
+// Why a backup was made, automatic backups are the only ones deleted by retention.
+const (
+	BACKUP_MANUAL     = "manual"
+	BACKUP_AUTOMATIC  = "auto"
+	BACKUP_PRERESTORE = "prerestore"
+)
+
+const backupTimeLayout = "20060102-150405"
+
+var backupIDPattern = regexp.MustCompile(`^(\d{8}-\d{6})-(\w+)$`)
+
+// Names the backup staged to be restored the next time the bot starts.
+func stagedRestoreFile() string {
+	return filepath.Join(*backupdir, "restore")
+}
+
+type Backup struct {
+	ID      string
+	Kind    string
+	Created time.Time
+}
+
+func (backup *Backup) Filename() string {
+	return filepath.Join(*backupdir, backup.ID+".tar.gz")
+}
+
+type Backups []*Backup
+
+func (b Backups) Len() int {
+	return len(b)
+}
+
+func (b Backups) Less(i, j int) bool {
+	return b[i].Created.After(b[j].Created)
+}
+
+func (b Backups) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// Returns the backups in backupdir, newest first.
+func ListBackups() (Backups, error) {
+	files, err := ioutil.ReadDir(*backupdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Backups{}, nil
+		}
+		return nil, err
+	}
+	backups := make(Backups, 0)
+	for _, info := range files {
+		if backup := parseBackupID(strings.TrimSuffix(info.Name(), ".tar.gz")); backup != nil && strings.HasSuffix(info.Name(), ".tar.gz") {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Sort(backups)
+	return backups, nil
+}
+
+func parseBackupID(id string) *Backup {
+	match := backupIDPattern.FindStringSubmatch(id)
+	if match == nil {
+		return nil
+	}
+	created, err := time.ParseInLocation(backupTimeLayout, match[1], time.Local)
+	if err != nil {
+		return nil
+	}
+	return &Backup{id, match[2], created}
+}
+
+// Returns the backup with an id, or nil if there isn't one.
+func GetBackup(id string) *Backup {
+	backup := parseBackupID(id)
+	if backup == nil {
+		return nil
+	}
+	if _, err := os.Stat(backup.Filename()); err != nil {
+		return nil
+	}
+	return backup
+}
+
+// Writes every file in statePaths into a new timestamped archive.
+func CreateBackup(kind string) (*Backup, error) {
+	now := time.Now()
+	backup := &Backup{now.Format(backupTimeLayout) + "-" + kind, kind, now}
+	if err := os.MkdirAll(*backupdir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(backup.Filename())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := writeStateArchive(file, nil); err != nil {
+		file.Close()
+		os.Remove(backup.Filename())
+		return nil, err
+	}
+	logging.Info("Backed up state to", backup.Filename())
+	return backup, nil
+}
+
+// Deletes the oldest automatic backups, keeping backupkeep of them.
+func PruneBackups() {
+	backups, err := ListBackups()
+	if err != nil {
+		ReportError("backup", "Error listing backups", err)
+		return
+	}
+	kept := 0
+	for _, backup := range backups {
+		if backup.Kind != BACKUP_AUTOMATIC {
+			continue
+		}
+		kept++
+		if kept > *backupkeep {
+			if err := os.Remove(backup.Filename()); err != nil {
+				ReportError("backup", "Error deleting backup", backup.ID, err)
+			} else {
+				logging.Info("Deleted old backup", backup.ID)
+			}
+		}
+	}
+}
+
+// Returns true if an automatic backup is due.
+func backupDue(now time.Time) bool {
+	if *backupinterval <= 0 {
+		return false
+	}
+	backups, err := ListBackups()
+	if err != nil {
+		return false
+	}
+	for _, backup := range backups {
+		if backup.Kind == BACKUP_AUTOMATIC {
+			return now.Sub(backup.Created) >= *backupinterval
+		}
+	}
+	return true
+}
+
+// Stages a backup to be restored the next time the bot starts. Running plugins keep their state in memory
+// and would save over anything restored while they run, so restores only happen at startup.
+func StageRestore(id string) error {
+	if GetBackup(id) == nil {
+		return fmt.Errorf("No backup %v.", id)
+	}
+	return ioutil.WriteFile(stagedRestoreFile(), []byte(id), 0644)
+}
+
+func CancelRestore() bool {
+	return os.Remove(stagedRestoreFile()) == nil
+}
+
+// Restores the staged backup, if there is one, the current state is backed up first.
+// Must be called before any plugins load their state.
+func ApplyStagedRestore() error {
+	data, err := ioutil.ReadFile(stagedRestoreFile())
+	if err != nil {
+		return nil
+	}
+	os.Remove(stagedRestoreFile())
+
+	backup := GetBackup(strings.TrimSpace(string(data)))
+	if backup == nil {
+		return fmt.Errorf("The staged backup %v no longer exists.", strings.TrimSpace(string(data)))
+	}
+	if _, err := CreateBackup(BACKUP_PRERESTORE); err != nil {
+		return fmt.Errorf("Not restoring %v, the current state couldn't be backed up: %v", backup.ID, err)
+	}
+	for _, path := range statePaths() {
+		if err := os.RemoveAll(path.Path); err != nil {
+			return err
+		}
+	}
+	if err := extractBackup(backup); err != nil {
+		return err
+	}
+	logging.Info("Restored backup", backup.ID)
+	return nil
+}
+
+func extractBackup(backup *Backup) error {
+	file, err := os.Open(backup.Filename())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readStateArchive(file, extractStateFile)
+}
+
+// Writes every file in statePaths to a gzipped tar, after the extra files. Files are named by their statePath's name
+// and their path within it, with forward slashes. The bolt store's database is a snapshot, see writeStoreSnapshot.
+func writeStateArchive(w io.Writer, extra map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(extra))
+	for name, _ := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(extra[name])), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(extra[name]); err != nil {
+			return err
+		}
+	}
+	for _, root := range statePaths() {
+		if root.Name == archiveStoreDatabase && *storebackend == "bolt" {
+			if err := writeStoreSnapshot(tw); err != nil {
+				return err
+			}
+			continue
+		}
+		err := filepath.Walk(root.Path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root.Path, path)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(filepath.Join(root.Name, rel))
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Writes a snapshot of the bolt store's database to an archive. Copying the file while the bot writes to it could
+// archive half a transaction, only the file store's files are copied. A database this process hasn't opened is opened
+// just for the snapshot, so a restore at startup doesn't leave the bot holding the database it replaces.
+func writeStoreSnapshot(tw *tar.Writer) error {
+	header := func(size int64) (io.Writer, error) {
+		return tw, tw.WriteHeader(&tar.Header{Name: archiveStoreDatabase, Mode: 0644, Size: size, ModTime: time.Now()})
+	}
+	if store, ok := openedSharedStore().(*BoltStore); ok {
+		return store.Snapshot(header)
+	}
+	if _, err := os.Stat(*storepath); os.IsNotExist(err) {
+		return nil
+	}
+	store, err := NewBoltStore(*storepath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Snapshot(header)
+}
+
+// Calls fn with the name and contents of every file in a state archive, names use forward slashes.
+func readStateArchive(r io.Reader, fn func(name string, r io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Writes a file from a state archive to the working directory, or the store's files to where the store options put them.
+func extractStateFile(name string, r io.Reader) error {
+	// Only state is restored, never anything outside the places state is kept.
+	if clean := filepath.Clean(filepath.FromSlash(name)); filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+		return errors.New("Bad file in archive: " + name)
+	}
+	path := archivePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, r)
+	return err
+}
+
+// Saves every running rpg game and every server's prs, returning what was saved.
+func SaveAll(bot *Bot, rpg *RPGPlugin) []string {
+	saved := make([]string, 0)
+	if rpg != nil {
+		games := make([]*Game, 0)
+		rpg.Lock()
+		for _, rooms := range rpg.games {
+			for _, game := range rooms {
+				games = append(games, game)
+			}
+		}
+		rpg.Unlock()
+		for _, game := range games {
+			game.Save()
+			saved = append(saved, fmt.Sprintf("rpg %v %v", game.Server, game.Room))
+		}
+	}
+	for _, server := range bot.Servers() {
+		if prs := GetPRS(server.Name); prs != nil {
+			prs.Save(server.Name)
+			saved = append(saved, fmt.Sprintf("pr %v", server.Name))
+		}
+	}
+	sort.Strings(saved)
+	return saved
+}
+
+type BackupPlugin struct {
+	rpg      *RPGPlugin
+	settings *PluginSettings
+}
+
+// Creates the backup plugin, rpg may be nil if the rpg plugin isn't running.
+func NewBackupPlugin(rpg *RPGPlugin, settings *PluginSettings) Plugin {
+	if settings == nil {
+		settings = DefaultSettings
+	}
+	return &BackupPlugin{rpg, settings}
+}
+
+// Backs up state on a schedule and with !backup, and stages restores with !restore <id>. Only admins can use the commands.
+func (plugin *BackupPlugin) Init(bot *Bot) {
+	backupchan := plugin.settings.HandleCommand(bot, backupCommand)
+	restorechan := plugin.settings.HandleCommand(bot, restoreCommand)
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	backup := func(kind string) (*Backup, error) {
+		SaveAll(bot, plugin.rpg)
+		return CreateBackup(kind)
+	}
+	respond := func(event *Event, key string, vars ResponseVars) {
+		event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, key, vars))
+	}
+
+	for {
+		select {
+		case event, ok := <-backupchan:
+			if !ok {
+				return
+			}
+			if !IsAdmin(event) {
+				continue
+			}
+			args, err := backupCommand.Parse(event.Line.Text())
+			if err != nil {
+				event.Server.Privmsg(event.Line.Nick, err.Error())
+				continue
+			}
+			if args.Pattern == "!backup list" {
+				backups, err := ListBackups()
+				if err != nil {
+					respond(event, "backup.listerror", ResponseVars{"Error": err})
+					continue
+				}
+				lines := make([]string, 0, len(backups))
+				for _, b := range backups {
+					lines = append(lines, b.ID)
+				}
+				if len(lines) == 0 {
+					lines = append(lines, Response(event.Server.Name, event.Room, event.Line.Nick, "backup.none", nil))
+				}
+				PrivmsgLines(event.Server, event.Line.Nick, lines)
+				continue
+			}
+			if b, err := backup(BACKUP_MANUAL); err != nil {
+				ReportError("backup", "Error backing up", err)
+				respond(event, "backup.error", ResponseVars{"Error": err})
+			} else {
+				respond(event, "backup.done", ResponseVars{"ID": b.ID})
+			}
+		case event, ok := <-restorechan:
+			if !ok {
+				return
+			}
+			if !IsAdmin(event) {
+				continue
+			}
+			args, err := restoreCommand.Parse(event.Line.Text())
+			if err != nil {
+				event.Server.Privmsg(event.Line.Nick, err.Error())
+				continue
+			}
+			if args.Pattern == "!restore cancel" {
+				if CancelRestore() {
+					respond(event, "backup.cancelled", nil)
+				} else {
+					respond(event, "backup.nocancel", nil)
+				}
+				continue
+			}
+			if GetBackup(args.String("id")) == nil {
+				respond(event, "backup.unknown", ResponseVars{"ID": args.String("id")})
+				continue
+			}
+			if err := StageRestore(args.String("id")); err != nil {
+				respond(event, "backup.stageerror", ResponseVars{"ID": args.String("id"), "Error": err})
+				continue
+			}
+			respond(event, "backup.staged", ResponseVars{"ID": args.String("id")})
+		case <-ticker.C:
+			if backupDue(time.Now()) {
+				if _, err := backup(BACKUP_AUTOMATIC); err != nil {
+					ReportError("backup", "Error backing up", err)
+				}
+				PruneBackups()
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package septapus
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateArchiveStoreOptions(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, oldPath := *storedir, *storepath
+	defer func() { *storedir, *storepath = oldDir, oldPath }()
+	*storedir = filepath.Join(dir, "store")
+	*storepath = filepath.Join(dir, "data", "bot.db")
+
+	files := map[string]string{
+		filepath.Join(*storedir, "rpg", "synirc.json"):     "game",
+		filepath.Join(*storedir, "aliases", "synirc.json"): "aliases",
+		*storepath: "database",
+	}
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := &bytes.Buffer{}
+	if err := writeStateArchive(archive, nil); err != nil {
+		t.Fatalf("writeStateArchive() = %v", err)
+	}
+	archived := make(map[string]string)
+	err := readStateArchive(archive, func(name string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		archived[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("readStateArchive() = %v", err)
+	}
+
+	// Archives name the store's files the same wherever the options put them, and restore them to where they are now.
+	want := map[string]string{
+		"rpg/synirc.json":     filepath.Join(*storedir, "rpg", "synirc.json"),
+		"aliases/synirc.json": filepath.Join(*storedir, "aliases", "synirc.json"),
+		"septapus.db":         *storepath,
+	}
+	for name, path := range want {
+		if archived[name] != files[path] {
+			t.Errorf("Archived %v = %q, want %q", name, archived[name], files[path])
+		}
+		if got := archivePath(name); got != path {
+			t.Errorf("archivePath(%v) = %v, want %v", name, got, path)
+		}
+	}
+	if len(archived) != len(want) {
+		t.Errorf("Archived %v, want only the store's files", archived)
+	}
+}
+
+func TestStateArchiveBoltSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	oldBackend, oldDir, oldPath := *storebackend, *storedir, *storepath
+	defer func() { *storebackend, *storedir, *storepath = oldBackend, oldDir, oldPath }()
+	*storebackend, *storedir, *storepath = "bolt", filepath.Join(dir, "store"), filepath.Join(dir, "bot.db")
+
+	store, err := NewBoltStore(*storepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("rpg", "synirc", "game"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	archive := &bytes.Buffer{}
+	if err := writeStateArchive(archive, nil); err != nil {
+		t.Fatalf("writeStateArchive() = %v", err)
+	}
+	restored := filepath.Join(dir, "restored.db")
+	err = readStateArchive(archive, func(name string, r io.Reader) error {
+		if name != archiveStoreDatabase {
+			return nil
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(restored, data, 0644)
+	})
+	if err != nil {
+		t.Fatalf("readStateArchive() = %v", err)
+	}
+
+	// The snapshot is a database with what was put.
+	snapshot, err := NewBoltStore(restored)
+	if err != nil {
+		t.Fatalf("Opening the snapshot = %v", err)
+	}
+	defer snapshot.Close()
+	game := ""
+	if err := snapshot.Get("rpg", "synirc", &game); err != nil || game != "game" {
+		t.Errorf("Snapshot rpg/synirc = %q, %v, want game", game, err)
+	}
+}
@@ -1,6 +1,11 @@
 package septapus
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,83 +23,289 @@ type Server struct {
 	Config *client.Config
 	Rooms  []RoomName
 	Conn   *client.Conn
+	// Set when connecting through a bouncer such as ZNC, buffer playback will be ignored.
+	Bouncer bool
+	// Modes to set on our nick once connected, eg: +B to mark us as a bot.
+	UserModes string
+	// Replies to CTCP requests, keyed by the upper case CTCP command, eg: SOURCE.
+	CTCPReplies map[string]string
+	// Password we identify to services with, -services.passwords is used when it is empty.
+	ServicesPassword string
+	// Account we identify as, the nick we were configured with when empty.
+	ServicesAccount string
+	// Log in with SASL PLAIN while connecting, instead of identifying to NickServ afterwards.
+	SASL bool
+
+	bouncerState *BouncerState
+	netsplit     *NetsplitState
+	catchup      *CatchupState
+	live         *LiveRooms
+	outbox       *Outbox
+	queue        *SendQueue
+	accounts     *Accounts
+	services     *ServicesState
+	removers     []client.Remover
+	// Set while reconnecting, so only one attempt runs at a time.
+	reconnecting int32
+	// Cancelled once the server is removed from the bot, see Done.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// The room instances running for the server, see RoomInstances.
+	running sync.WaitGroup
 }
 
 func NewServer(server ServerName, config *client.Config, rooms []RoomName) *Server {
-	return &Server{ServerName(server), config, rooms, nil}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{Name: ServerName(server), Config: config, Rooms: rooms, bouncerState: NewBouncerState(), netsplit: NewNetsplitState(), catchup: NewCatchupState(), live: NewLiveRooms(), outbox: NewOutbox(), queue: NewSendQueue(), accounts: NewAccounts(), services: NewServicesState(), ctx: ctx, cancel: cancel}
+}
+
+// Options for a server that are not needed to connect.
+type ServerOptions struct {
+	// Sent in reply to a CTCP VERSION.
+	Version     string
+	QuitMessage string
+	UserModes   string
+	CTCPReplies map[string]string
+	// See the fields of the same names on Server.
+	ServicesPassword string
+	ServicesAccount  string
+	SASL             bool
+}
+
+func DefaultServerOptions(name string) *ServerOptions {
+	return &ServerOptions{
+		Version:     name,
+		QuitMessage: "Lates",
+		CTCPReplies: make(map[string]string),
+	}
 }
 
 func NewServerSimple(servername, host, nick, ident, name string, rooms []string) *Server {
+	return NewServerWithOptions(servername, host, nick, ident, name, rooms, nil)
+}
+
+// The port servers are connected to over TLS when the host doesn't give one.
+const defaultTLSPort = "6697"
+
+type TLSOptions struct {
+	// Accept any certificate from the server, eg: a self signed one. Not safe outside a trusted network.
+	InsecureSkipVerify bool
+	// PEM files of a client certificate and its key, eg: to be identified by services with CertFP.
+	CertFile string
+	KeyFile  string
+}
+
+// Creates a server connected to over TLS, options can be nil. Returns an error if the client certificate can't be loaded.
+func NewServerTLS(servername, host, nick, ident, name string, rooms []string, options *TLSOptions) (*Server, error) {
+	server := NewServerSimple(servername, host, nick, ident, name, rooms)
+	if err := server.UseTLS(options); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Connects to the server over TLS, on port 6697 if the host doesn't give one. Options can be nil.
+func (server *Server) UseTLS(options *TLSOptions) error {
+	if options == nil {
+		options = &TLSOptions{}
+	}
+	host, _, err := net.SplitHostPort(server.Config.Server)
+	if err != nil {
+		host = server.Config.Server
+		server.Config.Server = net.JoinHostPort(host, defaultTLSPort)
+	}
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: options.InsecureSkipVerify}
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return fmt.Errorf("Error loading client certificate for %v: %v", server.Name, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	server.Config.SSL = true
+	server.Config.SSLConfig = config
+	return nil
+}
+
+// Creates a server, if options is nil DefaultServerOptions are used.
+func NewServerWithOptions(servername, host, nick, ident, name string, rooms []string, options *ServerOptions) *Server {
+	if options == nil {
+		options = DefaultServerOptions(name)
+	}
 	config := client.NewConfig(nick, ident, name)
 	config.Server = host
-	config.Version = name
-	config.QuitMessage = "Lates"
+	config.Version = options.Version
+	config.QuitMessage = options.QuitMessage
 	config.Recover = func(conn *client.Conn, line *client.Line) {}
 	r := make([]RoomName, len(rooms))
 	for i, value := range rooms {
 		r[i] = RoomName(value)
 	}
-	return NewServer(ServerName(servername), config, r)
+	server := NewServer(ServerName(servername), config, r)
+	server.UserModes = options.UserModes
+	server.ServicesPassword = options.ServicesPassword
+	server.ServicesAccount = options.ServicesAccount
+	server.SASL = options.SASL
+	server.CTCPReplies = make(map[string]string)
+	for ctcp, reply := range options.CTCPReplies {
+		server.CTCPReplies[strings.ToUpper(ctcp)] = reply
+	}
+	return server
 }
 
 type Event struct {
 	Server *Server
 	Room   RoomName
 	Line   *client.Line
+	// When the event happened, the server's time when it sends server-time tags, otherwise when we received it.
+	// A bouncer's replayed or a lagging server's lines keep the time they were sent.
+	Time time.Time
+	// Set when the event is traced, see !trace.
+	ID uint64
+	// The typed details of an event a plugin emits, eg: *RPGKill for RPG_KILL. Nil for events from a server.
+	Payload interface{}
+}
+
+// A predicate decides if an event should be sent to a handler.
+type EventPredicate func(*Event) bool
+
+// A subscriber owns a group of handlers and decides which events are passed to the group at all.
+// PluginSettings is a subscriber, so its allowed check is made once per event rather than once per handler.
+type Subscriber interface {
+	Allows(event *Event) bool
+}
+
+type subscription struct {
+	subscriber Subscriber
+	channels   map[chan *Event][]EventPredicate
 }
 
+func (sub *subscription) allows(event *Event) bool {
+	return sub.subscriber == nil || sub.subscriber.Allows(event)
+}
+
+// Predicates are evaluated by the dispatcher, an event is only sent to a handler when all of its predicates pass.
+// This avoids a forwarding goroutine and channel for every filter.
+// Handlers are grouped by subscriber so that the event is only checked against a subscriber once.
 type EventDispatcher struct {
 	sync.RWMutex
 
-	channels map[chan *Event]bool
+	subscriptions map[Subscriber]*subscription
+	owners        map[chan *Event]Subscriber
 }
 
 func NewEventDispatcher() *EventDispatcher {
-	return &EventDispatcher{channels: make(map[chan *Event]bool)}
+	return &EventDispatcher{
+		subscriptions: make(map[Subscriber]*subscription),
+		owners:        make(map[chan *Event]Subscriber),
+	}
 }
 
-func (e *EventDispatcher) GetEventHandler() chan *Event {
+func (e *EventDispatcher) GetEventHandler(predicates ...EventPredicate) chan *Event {
+	return e.Subscribe(nil, predicates...)
+}
+
+// Returns a handler in the subscriber's group, the subscriber may be nil to receive every event.
+func (e *EventDispatcher) Subscribe(subscriber Subscriber, predicates ...EventPredicate) chan *Event {
 	e.Lock()
 	defer e.Unlock()
 
+	sub := e.subscriptions[subscriber]
+	if sub == nil {
+		sub = &subscription{subscriber, make(map[chan *Event][]EventPredicate)}
+		e.subscriptions[subscriber] = sub
+	}
 	channel := make(chan *Event, 10)
-	e.channels[channel] = true
+	sub.channels[channel] = predicates
+	e.owners[channel] = subscriber
 	return channel
 }
 
-func (e *EventDispatcher) RemoveEventHandler(channel chan *Event) {
+// Removes and closes a handler, returns false if the handler does not belong to this dispatcher.
+func (e *EventDispatcher) RemoveEventHandler(channel chan *Event) bool {
 	e.Lock()
 	defer e.Unlock()
 
+	subscriber, ok := e.owners[channel]
+	if !ok {
+		return false
+	}
+	sub := e.subscriptions[subscriber]
+	delete(sub.channels, channel)
+	if len(sub.channels) == 0 {
+		delete(e.subscriptions, subscriber)
+	}
+	delete(e.owners, channel)
 	close(channel)
-	delete(e.channels, channel)
+	return true
+}
+
+func matches(event *Event, predicates []EventPredicate) bool {
+	for _, predicate := range predicates {
+		if !predicate(event) {
+			return false
+		}
+	}
+	return true
 }
 
 func (e *EventDispatcher) Broadcast(event *Event) {
 	e.Lock()
 	defer e.Unlock()
 
-	for channel, _ := range e.channels {
-		channel <- event
+	if IsTracing() {
+		e.traceBroadcast(event)
+		return
+	}
+	for _, sub := range e.subscriptions {
+		if !sub.allows(event) {
+			continue
+		}
+		for channel, predicates := range sub.channels {
+			if matches(event, predicates) {
+				channel <- event
+			}
+		}
 	}
 }
 
+// Returns the number of handlers listening to this dispatcher.
+func (e *EventDispatcher) Len() int {
+	e.RLock()
+	defer e.RUnlock()
+
+	return len(e.owners)
+}
+
+// Returns the number of subscribers listening to this dispatcher, handlers without a subscriber count as one.
+func (e *EventDispatcher) Subscribers() int {
+	e.RLock()
+	defer e.RUnlock()
+
+	return len(e.subscriptions)
+}
+
 func (e *EventDispatcher) Close() {
 	e.Lock()
 	defer e.Unlock()
 
-	for channel, _ := range e.channels {
+	for channel, _ := range e.owners {
 		close(channel)
 	}
+	e.subscriptions = make(map[Subscriber]*subscription)
+	e.owners = make(map[chan *Event]Subscriber)
 }
 
 type Bot struct {
 	sync.RWMutex
 
-	servers  map[ServerName]*Server
-	removers []client.Remover
-	events   map[EventName]*EventDispatcher
-	plugins  []Plugin
+	servers map[ServerName]*Server
+	events  map[EventName]*EventDispatcher
+	plugins []Plugin
+	// Plugins with named settings, by name.
+	named map[string]ConfigurablePlugin
+	// The commands plugins handle, for !help.
+	commands CommandRouter
 }
 
 func NewBot() *Bot {
@@ -102,6 +313,16 @@ func NewBot() *Bot {
 	bot := &Bot{}
 	bot.AddPlugin(NewSimplePlugin(ConnectPlugin, nil))
 	bot.AddPlugin(NewSimplePlugin(DisconnectPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(CTCPPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(TracePlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(NetsplitPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(OutboxPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(CaseMappingPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(JoinFailurePlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(ServicesPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(AccountsPlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(ChallengePlugin, nil))
+	bot.AddPlugin(NewSimplePlugin(HelpPlugin, nil))
 	return bot
 }
 
@@ -116,24 +337,134 @@ func (bot *Bot) AddServer(server *Server) (*Server, error) {
 		return bot.servers[server.Name], nil
 	}
 	bot.servers[server.Name] = server
+	// A server a shard handed away and has taken back starts over.
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+
+	if *floodrate > 0 {
+		// Messages are already rate limited and split to fit a line by the send queue.
+		server.Config.Flood = true
+		server.Config.SplitLen = ircLineLength
+	}
+	conn := client.Client(server.Config)
+	server.Conn = conn
 	for event, _ := range bot.events {
 		bot.makeEvents(server, event)
 	}
+	// Followed whether or not a plugin handles them, so lines in a bouncer's playback batch are known to be replayed.
+	server.removers = append(server.removers, conn.HandleFunc(string(BATCH), func(conn *client.Conn, line *client.Line) {
+		server.bouncerState.Batch(line)
+	}))
+
+	err := conn.Connect()
+	if err != nil {
+		go bot.reconnect(server)
+	}
+	return server, err
+}
 
-	conn := client.Client(server.Config)
-	server.Conn = conn
+// Disconnects a server and removes it from the bot, it is not reconnected. Returns false if the bot didn't have the server.
+// Removes a server and quits it. The plugins running in its rooms see Done closed, and have stopped and saved by the
+// time this returns, so another process can take the server over.
+func (bot *Bot) RemoveServer(name ServerName) bool {
+	bot.Lock()
+	server := bot.servers[name]
+	if server == nil {
+		bot.Unlock()
+		return false
+	}
+	delete(bot.servers, name)
+	bot.Unlock()
 
-	return server, conn.Connect()
+	server.cancel()
+	server.running.Wait()
+
+	bot.Lock()
+	defer bot.Unlock()
+
+	// Removed before quitting, so the disconnect isn't seen and the server isn't reconnected.
+	for _, remover := range server.removers {
+		remover.Remove()
+	}
+	server.removers = nil
+	server.Conn.Quit()
+	return true
+}
+
+// Returns a channel that is closed once the server is removed from the bot, plugins running for the server stop then.
+// Unlike a disconnect the server won't be reconnected.
+func (server *Server) Done() <-chan struct{} {
+	return server.ctx.Done()
+}
+
+func (bot *Bot) GetServer(name ServerName) *Server {
+	bot.RLock()
+	defer bot.RUnlock()
+
+	return bot.servers[name]
+}
+
+// Returns every server the bot has been added to, sorted by name.
+func (bot *Bot) Servers() []*Server {
+	bot.RLock()
+	defer bot.RUnlock()
+
+	names := make([]string, 0, len(bot.servers))
+	for name, _ := range bot.servers {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	servers := make([]*Server, len(names))
+	for i, name := range names {
+		servers[i] = bot.servers[ServerName(name)]
+	}
+	return servers
+}
+
+// Returns the time in a line's server-time tag, eg: @time=2011-10-19T16:40:51.620Z, and false if it has none.
+func serverTime(line *client.Line) (time.Time, bool) {
+	tag, ok := line.Tags["time"]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, tag)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Returns when a line was sent, from its server-time tag when it has one. Otherwise the client sets Line.Time to when
+// the line was read.
+func lineTime(line *client.Line) time.Time {
+	if t, ok := serverTime(line); ok {
+		return t
+	}
+	if line.Time.IsZero() {
+		return time.Now()
+	}
+	return line.Time
 }
 
 func (bot *Bot) makeEvents(server *Server, event EventName) {
 	events := bot.events[event]
-	bot.removers = append(bot.removers, server.Conn.HandleFunc(string(event), func(conn *client.Conn, line *client.Line) {
-		events.Broadcast(&Event{server, RoomName(line.Target()), line})
+	server.removers = append(server.removers, server.Conn.HandleFunc(string(event), func(conn *client.Conn, line *client.Line) {
+		if server.Bouncer && server.bouncerState.IsReplay(line) {
+			return
+		}
+		server.catchup.Track(server, line)
+		server.live.Track(server, line)
+		events.Broadcast(&Event{Server: server, Room: RoomName(line.Target()), Line: line, Time: lineTime(line)})
 	}))
 }
 
-func (bot *Bot) GetEventHandler(event EventName) chan *Event {
+// Returns a channel that receives every event with this name that passes all of the predicates.
+func (bot *Bot) GetEventHandler(event EventName, predicates ...EventPredicate) chan *Event {
+	return bot.Subscribe(event, nil, predicates...)
+}
+
+// Returns a channel that receives the events with this name that the subscriber allows and pass all of the predicates.
+// Handlers for the same subscriber share the subscriber check.
+func (bot *Bot) Subscribe(event EventName, subscriber Subscriber, predicates ...EventPredicate) chan *Event {
 	bot.Lock()
 	defer bot.Unlock()
 
@@ -149,16 +480,45 @@ func (bot *Bot) GetEventHandler(event EventName) chan *Event {
 			bot.makeEvents(server, event)
 		}
 	}
-	return events.GetEventHandler()
+	return events.Subscribe(subscriber, predicates...)
 }
 
 func (bot *Bot) RemoveEventHandler(event chan *Event) {
+	bot.commands.remove(event)
+
+	bot.RLock()
+	defer bot.RUnlock()
+
+	for _, events := range bot.events {
+		if events.RemoveEventHandler(event) {
+			return
+		}
+	}
+}
+
+// Removes several handlers, draining them while they are removed so a pending broadcast cannot block.
+func (bot *Bot) RemoveEventHandlers(channels ...chan *Event) {
+	for _, channel := range channels {
+		go func(channel chan *Event) {
+			for _ = range channel {
+			}
+		}(channel)
+	}
+	for _, channel := range channels {
+		bot.RemoveEventHandler(channel)
+	}
+}
+
+// Returns the number of handlers listening to events, useful to check for leaks.
+func (bot *Bot) EventHandlerCount() int {
 	bot.RLock()
 	defer bot.RUnlock()
 
+	count := 0
 	for _, events := range bot.events {
-		events.RemoveEventHandler(event)
+		count += events.Len()
 	}
+	return count
 }
 
 func (bot *Bot) BroadcastEvent(name EventName, event *Event) {
@@ -174,6 +534,7 @@ func (bot *Bot) BroadcastEvent(name EventName, event *Event) {
 	}
 }
 
+// Filters a channel through a goroutine. Prefer passing predicates to GetEventHandler, which are evaluated by the dispatcher.
 func Filter(channel chan *Event, fn func(*Event) bool) chan *Event {
 	filteredchannel := make(chan *Event, cap(channel))
 	go func() {
@@ -187,46 +548,96 @@ func Filter(channel chan *Event, fn func(*Event) bool) chan *Event {
 	return filteredchannel
 }
 
+// Merges several channels into one, the returned channel is closed once all of the channels are closed.
+func Merge(channels ...chan *Event) chan *Event {
+	mergedchannel := make(chan *Event, 10)
+	var wg sync.WaitGroup
+	wg.Add(len(channels))
+	for _, channel := range channels {
+		go func(channel chan *Event) {
+			defer wg.Done()
+			for event := range channel {
+				mergedchannel <- event
+			}
+		}(channel)
+	}
+	go func() {
+		wg.Wait()
+		close(mergedchannel)
+	}()
+	return mergedchannel
+}
+
+// Passes events that are sent by our nick.
+func IsSelf() EventPredicate {
+	return func(event *Event) bool {
+		return event.Line.Nick == event.Server.Conn.Me().Nick
+	}
+}
+
+// Passes events that were sent to us directly rather than to a room.
+func IsPrivate() EventPredicate {
+	return func(event *Event) bool {
+		return !event.Line.Public()
+	}
+}
+
+// Passes events that are fired from a server.
+func IsServer(server ServerName) EventPredicate {
+	return func(event *Event) bool {
+		return event.Server.Name == server
+	}
+}
+
+// Passes events that are fired from a room, room names are compared using the server's case mapping.
+func IsRoom(server ServerName, room RoomName) EventPredicate {
+	return func(event *Event) bool {
+		return event.Server.Name == server && SameRoom(server, event.Room, room)
+	}
+}
+
+// Passes events whose text is command, or starts with command and a space.
+func IsSimpleCommand(command string) EventPredicate {
+	return func(event *Event) bool {
+		return event.Line.Text() == command || strings.HasPrefix(event.Line.Text(), command+" ")
+	}
+}
+
 // Filters a channel to only return the events that targets our nick.
 func FilterSelf(channel chan *Event) chan *Event {
-	return Filter(channel, func(event *Event) bool {
-		return event.Line.Nick == event.Server.Conn.Me().Nick
-	})
+	return Filter(channel, IsSelf())
 }
 
 // Filters a channel to only return the events that are fired from a server.
 func FilterServer(channel chan *Event, server ServerName) chan *Event {
-	return Filter(channel, func(event *Event) bool {
-		return event.Server.Name == server
-	})
+	return Filter(channel, IsServer(server))
 }
 
 // Filters a channel to only return the events that are fired from a room.
 func FilterRoom(channel chan *Event, server ServerName, room RoomName) chan *Event {
-	return Filter(channel, func(event *Event) bool {
-		return event.Server.Name == server && event.Room == room
-	})
+	return Filter(channel, IsRoom(server, room))
 }
 
 // Filters a channel to only return the events that target our nick in a room.
 func FilterSelfRoom(channel chan *Event, server ServerName, room RoomName) chan *Event {
+	self, inRoom := IsSelf(), IsRoom(server, room)
 	return Filter(channel, func(event *Event) bool {
-		return event.Line.Nick == event.Server.Conn.Me().Nick && event.Server.Name == server && event.Room == room
+		return self(event) && inRoom(event)
 	})
 }
 
 func FilterSimpleCommand(channel chan *Event, command string) chan *Event {
-	return Filter(channel, func(event *Event) bool {
-		return event.Line.Text() == command || strings.HasPrefix(event.Line.Text(), command+" ")
-	})
+	return Filter(channel, IsSimpleCommand(command))
 }
 
 func (bot *Bot) Disconnect() {
 	bot.Lock()
 	defer bot.Unlock()
 
-	for _, remover := range bot.removers {
-		remover.Remove()
+	for _, server := range bot.servers {
+		for _, remover := range server.removers {
+			remover.Remove()
+		}
 	}
 	for _, events := range bot.events {
 		events.Close()
@@ -243,13 +654,46 @@ func (b *Bot) AddPlugin(plugin Plugin) {
 	defer b.Unlock()
 
 	b.plugins = append(b.plugins, plugin)
+	if configurable, ok := plugin.(ConfigurablePlugin); ok && configurable.Settings().Name != "" {
+		if b.named == nil {
+			b.named = make(map[string]ConfigurablePlugin)
+		}
+		b.named[configurable.Settings().Name] = configurable
+	}
 	go plugin.Init(b)
 }
 
+// Returns the running plugin with named settings called name, nil if there isn't one.
+func (b *Bot) Plugin(name string) ConfigurablePlugin {
+	b.RLock()
+	defer b.RUnlock()
+
+	return b.named[name]
+}
+
+// Returns the names of the running plugins with named settings, sorted.
+func (b *Bot) PluginNames() []string {
+	b.RLock()
+	defer b.RUnlock()
+
+	names := make([]string, 0, len(b.named))
+	for name, _ := range b.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type Plugin interface {
 	Init(bot *Bot)
 }
 
+// A plugin that exposes its settings, plugins with named settings can be found with Bot.Plugin and changed with !plugin.
+type ConfigurablePlugin interface {
+	Plugin
+	Settings() *PluginSettings
+}
+
 const (
 	ALL_SERVERS ServerName = "*"
 	ALL_ROOMS   RoomName   = "*"
@@ -260,10 +704,19 @@ const (
 // Forcing a server or room will ignore any banned state.
 // It is possible to not ban a server, but ban all the rooms. This will allow plugins to recieve server level events.
 type PluginSettings struct {
+	// Named settings are persisted and can be changed at runtime, see NewNamedPluginSettings.
+	Name string
+
 	bannedServers map[ServerName]bool
 	bannedRooms   map[ServerName]map[RoomName]bool
 	forcedServers map[ServerName]bool
 	forcedRooms   map[ServerName]map[RoomName]bool
+	// Plugins in dry run still handle events, but log what they would send or upload instead.
+	dryRun bool
+	// Disabled plugins keep running, but aren't allowed any server or room until they are enabled.
+	disabled bool
+	// Where the plugin replies in each room, see ReplyPolicy.
+	replies RoomValues
 
 	sync.RWMutex
 }
@@ -278,12 +731,22 @@ func NewPluginSettings() *PluginSettings {
 	return s
 }
 
-func (s *PluginSettings) GetEventHandler(bot *Bot, event EventName) chan *Event {
-	return Filter(bot.GetEventHandler(event), func(event *Event) bool {
-		server := event.Server.Name
-		room := event.Room
-		return (s.IsForcedServer(server) || s.IsForcedRoom(server, room)) || !(s.IsBannedServer(server) || s.IsBannedRoom(server, room))
-	})
+// Returns a channel that receives the events allowed by these settings that pass all of the predicates.
+func (s *PluginSettings) GetEventHandler(bot *Bot, event EventName, predicates ...EventPredicate) chan *Event {
+	return bot.Subscribe(event, s, predicates...)
+}
+
+// Returns true if the event's server and room are allowed, this makes PluginSettings a Subscriber.
+func (s *PluginSettings) Allows(event *Event) bool {
+	return s.IsAllowed(event.Server.Name, event.Room)
+}
+
+// Returns true if events from this server and room should be passed to the plugin.
+func (s *PluginSettings) IsAllowed(server ServerName, room RoomName) bool {
+	if s.IsDisabled() {
+		return false
+	}
+	return (s.IsForcedServer(server) || s.IsForcedRoom(server, room)) || !(s.IsBannedServer(server) || s.IsBannedRoom(server, room))
 }
 
 func (s *PluginSettings) IsBannedServer(server ServerName) bool {
@@ -356,6 +819,70 @@ func (s *PluginSettings) AddForcedRoom(server ServerName, room RoomName) {
 	s.forcedRooms[server][room] = true
 }
 
+func (s *PluginSettings) RemoveBannedServer(server ServerName) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.bannedServers, server)
+}
+
+func (s *PluginSettings) RemoveBannedRoom(server ServerName, room RoomName) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.bannedRooms[server] != nil {
+		delete(s.bannedRooms[server], room)
+	}
+}
+
+func (s *PluginSettings) RemoveForcedServer(server ServerName) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.forcedServers, server)
+}
+
+func (s *PluginSettings) RemoveForcedRoom(server ServerName, room RoomName) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.forcedRooms[server] != nil {
+		delete(s.forcedRooms[server], room)
+	}
+}
+
+// Returns true if the plugin should log what it would send or upload instead of doing it. Nil settings are never in dry run.
+func (s *PluginSettings) IsDryRun() bool {
+	if s == nil {
+		return false
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.dryRun
+}
+
+func (s *PluginSettings) SetDryRun(dryRun bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.dryRun = dryRun
+}
+
+func (s *PluginSettings) IsDisabled() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.disabled
+}
+
+func (s *PluginSettings) SetDisabled(disabled bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.disabled = disabled
+}
+
 var DefaultSettings *PluginSettings = NewPluginSettings()
 
 type SimplePluginInit func(bot *Bot, settings *PluginSettings)
@@ -379,11 +906,38 @@ func (plugin *SimplePlugin) Init(bot *Bot) {
 	plugin.init(bot, plugin.settings)
 }
 
+func (plugin *SimplePlugin) Settings() *PluginSettings {
+	return plugin.settings
+}
+
+// Joins the configured rooms, and any rooms we were in before a restart, when we connect.
+// Rooms are joined joindelay apart, joins that fail are handled by JoinFailurePlugin.
 func ConnectPlugin(bot *Bot, settings *PluginSettings) {
+	joined := make(map[ServerName]*JoinedRooms)
+	getJoined := func(server ServerName) *JoinedRooms {
+		if joined[server] == nil {
+			joined[server] = &JoinedRooms{}
+			joined[server].Load(server)
+		}
+		return joined[server]
+	}
+
+	// Closed when a server disconnects, to stop its pending joins.
+	stops := make(map[ServerName]chan bool)
+	stopJoins := func(server ServerName) {
+		if stop := stops[server]; stop != nil {
+			close(stop)
+			delete(stops, server)
+		}
+	}
+
 	joinAll := func(server *Server) {
-		for _, channel := range server.Rooms {
-			server.Conn.Join(string(channel))
+		if server.UserModes != "" {
+			server.Conn.Mode(server.Conn.Me().Nick, server.UserModes)
 		}
+		stopJoins(server.Name)
+		stops[server.Name] = make(chan bool)
+		go joinRooms(server, getJoined(server.Name).Merge(server.Rooms), stops[server.Name])
 	}
 	// If we're added after the servers are connected, we need to join.
 	for _, server := range bot.servers {
@@ -391,10 +945,50 @@ func ConnectPlugin(bot *Bot, settings *PluginSettings) {
 			joinAll(server)
 		}
 	}
-	channel := bot.GetEventHandler(client.CONNECTED)
-	for event := range channel {
-		logging.Info("Connected to", event.Server.Name)
-		joinAll(event.Server)
+
+	kickedSelf := func(event *Event) bool {
+		return len(event.Line.Args) > 1 && event.Line.Args[1] == event.Server.Conn.Me().Nick
+	}
+	connectchan := bot.GetEventHandler(client.CONNECTED)
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
+	joinchan := bot.GetEventHandler(client.JOIN, IsSelf())
+	partchan := bot.GetEventHandler(client.PART, IsSelf())
+	kickchan := bot.GetEventHandler(client.KICK, kickedSelf)
+	for {
+		select {
+		case event, ok := <-connectchan:
+			if !ok {
+				return
+			}
+			logging.Info("Connected to", event.Server.Name)
+			joinAll(event.Server)
+		case event, ok := <-disconnectchan:
+			if !ok {
+				return
+			}
+			stopJoins(event.Server.Name)
+		case event, ok := <-joinchan:
+			if !ok {
+				return
+			}
+			if rooms := getJoined(event.Server.Name); rooms.Add(event.Room) {
+				rooms.Save(event.Server.Name)
+			}
+		case event, ok := <-partchan:
+			if !ok {
+				return
+			}
+			if rooms := getJoined(event.Server.Name); rooms.Remove(event.Room) {
+				rooms.Save(event.Server.Name)
+			}
+		case event, ok := <-kickchan:
+			if !ok {
+				return
+			}
+			if rooms := getJoined(event.Server.Name); rooms.Remove(event.Room) {
+				rooms.Save(event.Server.Name)
+			}
+		}
 	}
 }
 
@@ -402,6 +996,20 @@ func DisconnectPlugin(bot *Bot, settings *PluginSettings) {
 	channel := bot.GetEventHandler(client.DISCONNECTED)
 	for event := range channel {
 		logging.Info("Disconnected from", event.Server.Name)
-		event.Server.Conn.Connect()
+		go bot.reconnect(event.Server)
+	}
+}
+
+// Replies to the CTCP requests configured in Server.CTCPReplies, VERSION, PING and TIME are handled by the client.
+func CTCPPlugin(bot *Bot, settings *PluginSettings) {
+	channel := bot.GetEventHandler(client.CTCP)
+	for event := range channel {
+		if len(event.Line.Args) == 0 {
+			continue
+		}
+		ctcp := strings.ToUpper(event.Line.Args[0])
+		if reply, ok := event.Server.CTCPReplies[ctcp]; ok {
+			event.Server.CtcpReply(event.Line.Nick, ctcp, reply)
+		}
 	}
 }
@@ -0,0 +1,29 @@
+package septapus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+func TestLineTime(t *testing.T) {
+	received := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		tags map[string]string
+		want time.Time
+	}{
+		{"server-time", map[string]string{"time": "2011-10-19T16:40:51.620Z"}, time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC)},
+		{"server-time without milliseconds", map[string]string{"time": "2011-10-19T16:40:51Z"}, time.Date(2011, 10, 19, 16, 40, 51, 0, time.UTC)},
+		{"no tags", nil, received},
+		{"other tags", map[string]string{"account": "iopred"}, received},
+		{"malformed time", map[string]string{"time": "yesterday"}, received},
+	}
+	for _, test := range tests {
+		line := &client.Line{Tags: test.tags, Nick: "iopred", Cmd: client.PRIVMSG, Args: []string{"#septapus", "lol"}, Time: received}
+		if got := lineTime(line); !got.Equal(test.want) {
+			t.Errorf("%v: lineTime() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+const (
+	bouncerPlaybackStart    = "Buffer Playback..."
+	bouncerPlaybackComplete = "Playback Complete."
+)
+
+const BATCH EventName = "BATCH"
+
+// The types of batch that wrap replayed lines, ZNC's buffer playback and IRCv3 chathistory.
+var playbackBatches = map[string]bool{"znc.in/playback": true, "chathistory": true}
+
+// Tracks buffer playback from a bouncer such as ZNC, so replayed lines are not treated as new.
+type BouncerState struct {
+	sync.Mutex
+
+	connected time.Time
+	playback  map[RoomName]bool
+	// The references of the open playback batches.
+	batches map[string]bool
+}
+
+func NewBouncerState() *BouncerState {
+	return &BouncerState{playback: make(map[RoomName]bool), batches: make(map[string]bool)}
+}
+
+// Follows the batches a server opens and closes, eg: BATCH +yXNAbvnRHTRBv znc.in/playback #septapus and BATCH -yXNAbvnRHTRBv.
+func (b *BouncerState) Batch(line *client.Line) {
+	if len(line.Args) == 0 || len(line.Args[0]) < 2 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	ref := line.Args[0][1:]
+	switch line.Args[0][0] {
+	case '+':
+		if len(line.Args) > 1 && playbackBatches[line.Args[1]] {
+			b.batches[ref] = true
+		}
+	case '-':
+		delete(b.batches, ref)
+	}
+}
+
+// Returns true if the nick belongs to the bouncer rather than a user, eg: *status, *buffextras or ***.
+func IsBouncerNick(nick string) bool {
+	return strings.HasPrefix(nick, "*")
+}
+
+// Returns true if the line is part of a buffer replay, or was sent by the bouncer itself.
+func (b *BouncerState) IsReplay(line *client.Line) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if line.Cmd == client.CONNECTED {
+		b.connected = time.Now()
+		b.playback = make(map[RoomName]bool)
+		b.batches = make(map[string]bool)
+		return false
+	}
+	if batch, ok := line.Tags["batch"]; ok && b.batches[batch] {
+		return true
+	}
+	room := RoomName(line.Target())
+	if IsBouncerNick(line.Nick) {
+		switch line.Text() {
+		case bouncerPlaybackStart:
+			b.playback[room] = true
+		case bouncerPlaybackComplete:
+			delete(b.playback, room)
+		}
+		return true
+	}
+	if b.playback[room] {
+		return true
+	}
+	// With server-time the line keeps the time it was originally sent, Line.Time is only ever when we read it.
+	sent, ok := serverTime(line)
+	return ok && !b.connected.IsZero() && sent.Before(b.connected)
+}
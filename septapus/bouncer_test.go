@@ -0,0 +1,64 @@
+package septapus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+func privmsg(nick, room, text string, tags map[string]string) *client.Line {
+	return &client.Line{Tags: tags, Nick: nick, Cmd: client.PRIVMSG, Args: []string{room, text}, Time: time.Now()}
+}
+
+func TestBouncerReplay(t *testing.T) {
+	b := NewBouncerState()
+	b.IsReplay(&client.Line{Cmd: client.CONNECTED})
+
+	if b.IsReplay(privmsg("iopred", "#septapus", "hello", nil)) {
+		t.Error("a new line without tags is a replay")
+	}
+	if b.IsReplay(privmsg("iopred", "#septapus", "hello", map[string]string{"time": time.Now().Add(time.Minute).UTC().Format(time.RFC3339)})) {
+		t.Error("a line sent after connecting is a replay")
+	}
+	if !b.IsReplay(privmsg("iopred", "#septapus", "hello", map[string]string{"time": "2011-10-19T16:40:51.620Z"})) {
+		t.Error("a line sent before connecting isn't a replay")
+	}
+
+	b.Batch(&client.Line{Cmd: string(BATCH), Args: []string{"+yXNAbvnRHTRBv", "znc.in/playback", "#septapus"}})
+	replayed := privmsg("iopred", "#septapus", "lol", map[string]string{"batch": "yXNAbvnRHTRBv"})
+	if !b.IsReplay(replayed) {
+		t.Error("a line in a playback batch isn't a replay")
+	}
+	if b.IsReplay(privmsg("iopred", "#septapus", "lol", map[string]string{"batch": "other"})) {
+		t.Error("a line in another batch is a replay")
+	}
+	b.Batch(&client.Line{Cmd: string(BATCH), Args: []string{"-yXNAbvnRHTRBv"}})
+	if b.IsReplay(replayed) {
+		t.Error("a line in a closed playback batch is a replay")
+	}
+
+	b.Batch(&client.Line{Cmd: string(BATCH), Args: []string{"+netsplit1", "netsplit", "irc.a", "irc.b"}})
+	if b.IsReplay(&client.Line{Tags: map[string]string{"batch": "netsplit1"}, Nick: "iopred", Cmd: client.QUIT, Args: []string{"irc.a irc.b"}}) {
+		t.Error("a line in a netsplit batch is a replay")
+	}
+}
+
+func TestBouncerPlaybackMessages(t *testing.T) {
+	b := NewBouncerState()
+	b.IsReplay(&client.Line{Cmd: client.CONNECTED})
+
+	if !b.IsReplay(privmsg("***", "#septapus", bouncerPlaybackStart, nil)) {
+		t.Error("the bouncer's playback start isn't a replay")
+	}
+	if !b.IsReplay(privmsg("iopred", "#septapus", "hello", nil)) {
+		t.Error("a line during playback isn't a replay")
+	}
+	if b.IsReplay(privmsg("iopred", "#other", "hello", nil)) {
+		t.Error("a line in a room without playback is a replay")
+	}
+	b.IsReplay(privmsg("***", "#septapus", bouncerPlaybackComplete, nil))
+	if b.IsReplay(privmsg("iopred", "#septapus", "hello", nil)) {
+		t.Error("a line after playback is a replay")
+	}
+}
@@ -0,0 +1,88 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+// How a server compares nicks and room names, sent in its ISUPPORT, eg: CASEMAPPING=ascii.
+const (
+	CASEMAPPING_ASCII         = "ascii"
+	CASEMAPPING_RFC1459       = "rfc1459"
+	CASEMAPPING_STRICTRFC1459 = "strict-rfc1459"
+)
+
+var (
+	caseMappings     = make(map[ServerName]string)
+	caseMappingsLock sync.RWMutex
+)
+
+// Returns a server's case mapping, rfc1459 until the server says otherwise.
+func CaseMapping(server ServerName) string {
+	caseMappingsLock.RLock()
+	defer caseMappingsLock.RUnlock()
+
+	if mapping, ok := caseMappings[server]; ok {
+		return mapping
+	}
+	return CASEMAPPING_RFC1459
+}
+
+func setCaseMapping(server ServerName, mapping string) {
+	caseMappingsLock.Lock()
+	defer caseMappingsLock.Unlock()
+
+	caseMappings[server] = mapping
+}
+
+// Returns text in lower case as a case mapping defines it. In rfc1459 []\~ are the upper case of {}|^.
+func FoldCase(mapping, text string) string {
+	switch mapping {
+	case CASEMAPPING_ASCII:
+		return strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return r + 'a' - 'A'
+			}
+			return r
+		}, text)
+	case CASEMAPPING_RFC1459, CASEMAPPING_STRICTRFC1459:
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'A' && r <= 'Z':
+				return r + 'a' - 'A'
+			case r == '[' || r == ']' || r == '\\':
+				return r + '{' - '['
+			case r == '~' && mapping == CASEMAPPING_RFC1459:
+				return '^'
+			}
+			return r
+		}, text)
+	}
+	return strings.ToLower(text)
+}
+
+// Returns the name a room is stored under, rooms that differ only in case are the same room.
+func FoldRoom(server ServerName, room RoomName) RoomName {
+	return RoomName(FoldCase(CaseMapping(server), string(room)))
+}
+
+// Returns true if two room names are the same room on a server.
+func SameRoom(server ServerName, a, b RoomName) bool {
+	return a == b || FoldRoom(server, a) == FoldRoom(server, b)
+}
+
+// Records the case mapping each server sends in its ISUPPORT.
+func CaseMappingPlugin(bot *Bot, settings *PluginSettings) {
+	channel := bot.GetEventHandler("005")
+	for event := range channel {
+		for _, arg := range event.Line.Args {
+			if strings.HasPrefix(arg, "CASEMAPPING=") {
+				mapping := strings.ToLower(strings.TrimPrefix(arg, "CASEMAPPING="))
+				setCaseMapping(event.Server.Name, mapping)
+				logging.Info("Case mapping for", event.Server.Name, mapping)
+			}
+		}
+	}
+}
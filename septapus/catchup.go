@@ -0,0 +1,72 @@
+package septapus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+var catchupOptions = NewOptions("catchup")
+
+var catchupwindow = catchupOptions.Duration("window", 30*time.Second, "How long after connecting or joining a room passive triggers, eg: comics, rpg attacks, karma and url titles, ignore its messages, so backlog replayed or backed up while we were away doesn't set them all off. 0 turns it off")
+
+// Tracks when we connected to a server and joined each room, to tell when messages are backlog rather than new.
+type CatchupState struct {
+	sync.Mutex
+
+	connected time.Time
+	joined    map[RoomName]time.Time
+}
+
+func NewCatchupState() *CatchupState {
+	return &CatchupState{joined: make(map[RoomName]time.Time)}
+}
+
+// Follows our connects and joins, called with every line before it is broadcast.
+func (c *CatchupState) Track(server *Server, line *client.Line) {
+	switch line.Cmd {
+	case client.CONNECTED:
+		c.Lock()
+		defer c.Unlock()
+
+		c.connected = time.Now()
+		c.joined = make(map[RoomName]time.Time)
+	case client.JOIN:
+		if server.Conn == nil || server.Conn.Me() == nil || line.Nick != server.Conn.Me().Nick {
+			return
+		}
+		c.Lock()
+		defer c.Unlock()
+
+		c.joined[FoldRoom(server.Name, RoomName(line.Target()))] = time.Now()
+	}
+}
+
+// Returns true if a message sent to a room at a time arrived within catchupwindow of us connecting or joining it.
+func (c *CatchupState) CatchingUp(server ServerName, room RoomName, t time.Time) bool {
+	if *catchupwindow <= 0 {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	since := c.connected
+	if joined := c.joined[FoldRoom(server, room)]; joined.After(since) {
+		since = joined
+	}
+	return !since.IsZero() && t.Before(since.Add(*catchupwindow))
+}
+
+// Returns true if the event is probably backlog, passive triggers should ignore it.
+func (server *Server) CatchingUp(event *Event) bool {
+	return server.catchup.CatchingUp(server.Name, event.Room, event.Time)
+}
+
+// Passes events that aren't backlog from just after connecting or joining, for handlers that react to what people say
+// without being asked, see catchupwindow. Commands are always answered.
+func IsSettled() EventPredicate {
+	return func(event *Event) bool {
+		return !event.Server.CatchingUp(event)
+	}
+}
@@ -0,0 +1,69 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+)
+
+var celebrateOptions = NewOptions("celebrate")
+
+var celebrate = celebrateOptions.String("rooms", "", "Comma separated list of rooms where new PRs, monster slays and comics are celebrated, eg: synirc/#septapus=on")
+
+var (
+	celebrateRooms     RoomValues
+	celebrateRoomsOnce sync.Once
+)
+
+func isCelebrateRoom(server ServerName, room RoomName) bool {
+	celebrateRoomsOnce.Do(func() {
+		celebrateRooms = ParseRoomValues(*celebrate)
+	})
+	value, ok := celebrateRooms.Get(server, room)
+	return ok && value == "on"
+}
+
+func NewCelebratePlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(CelebratePlugin, settings)
+}
+
+// Celebrates what other plugins do in the rooms that ask for it, an example of handling the events plugins emit.
+func CelebratePlugin(bot *Bot, settings *PluginSettings) {
+	inRoom := func(event *Event) bool {
+		return isCelebrateRoom(event.Server.Name, event.Room)
+	}
+	killchan := settings.GetEventHandler(bot, RPG_KILL, inRoom)
+	prchan := settings.GetEventHandler(bot, PR_NEW, inRoom)
+	comicchan := settings.GetEventHandler(bot, COMIC_CREATED, inRoom)
+	for {
+		var event *Event
+		var vars ResponseVars
+		var key string
+		var ok bool
+		select {
+		case event, ok = <-killchan:
+			if !ok {
+				return
+			}
+			kill := event.Payload.(*RPGKill)
+			key, vars = "celebrate.kill", ResponseVars{"Slayer": SafeNick(event.Server.Name, event.Room, kill.Slayer), "Monster": kill.Monster, "Fighters": len(kill.Contributions)}
+		case event, ok = <-prchan:
+			if !ok {
+				return
+			}
+			pr := event.Payload.(*PRNew)
+			key, vars = "celebrate.pr", ResponseVars{"Lift": pr.Lift.String(), "Weight": pr.Weight.String()}
+		case event, ok = <-comicchan:
+			if !ok {
+				return
+			}
+			comic := event.Payload.(*ComicCreated)
+			nicks := make([]string, len(comic.Nicks))
+			for i, nick := range comic.Nicks {
+				nicks[i] = SafeNick(event.Server.Name, event.Room, nick)
+			}
+			key, vars = "celebrate.comic", ResponseVars{"Nicks": strings.Join(nicks, ", "), "URL": comic.URL}
+		}
+		vars["Nick"] = SafeNick(event.Server.Name, event.Room, event.Line.Nick)
+		settings.Privmsg(event.Server, string(event.Room), Response(event.Server.Name, event.Room, "", key, vars))
+	}
+}
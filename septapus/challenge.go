@@ -0,0 +1,147 @@
+package septapus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var challengeOptions = NewOptions("challenge")
+
+var challenge = challengeOptions.String("servers", "", "Comma separated list of servers where commands that change a nick's data, eg: !prclear, must come from the user@host the nick first used them from, or be confirmed with a token sent to the nick, eg: efnet=on. Useful on servers without services")
+
+var confirmCommand = NewCommand("!confirm <token>").WithHelp("Confirms a challenge sent to you in a private message.")
+
+var (
+	challengeServers     RoomValues
+	challengeServersOnce sync.Once
+)
+
+func isChallengeServer(server ServerName) bool {
+	challengeServersOnce.Do(func() {
+		challengeServers = ParseRoomValues(*challenge)
+	})
+	value, ok := challengeServers.Get(server, ALL_ROOMS)
+	return ok && value == "on"
+}
+
+// The user@host a nick is bound to, and the token that moves the binding to another user@host.
+type ChallengeBinding struct {
+	Nick  string
+	Mask  string
+	Token string
+}
+
+// The bindings of every nick that has used a challenged command, saved to challenges.json.
+type ChallengeStore struct {
+	sync.Mutex
+	Servers map[ServerName]map[string]*ChallengeBinding
+}
+
+var (
+	challenges     = &ChallengeStore{}
+	challengesOnce sync.Once
+)
+
+func (store *ChallengeStore) Load() {
+	store.Lock()
+	defer store.Unlock()
+
+	if file, err := os.Open("challenges.json"); err == nil {
+		defer file.Close()
+		if err := json.NewDecoder(file).Decode(store); err != nil {
+			ReportError("challenge", "Error loading challenges", err)
+		}
+	}
+	if store.Servers == nil {
+		store.Servers = make(map[ServerName]map[string]*ChallengeBinding)
+	}
+}
+
+// Saves the store, the caller must hold the lock.
+func (store *ChallengeStore) save() {
+	if err := SaveSharedFile("challenges.json", store, "Servers"); err != nil {
+		ReportError("challenge", "Error saving challenges", err)
+	}
+}
+
+func challengeToken() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		ReportError("challenge", "Error creating token", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func lineMask(line *client.Line) string {
+	return line.Ident + "@" + line.Host
+}
+
+// Returns true if the nick sending a command that changes its data is who it claims to be. On servers with challenges
+// on, a nick is bound to the user@host it first uses one from and sent a token, from anywhere else it is asked to
+// confirm with the token and try again.
+func Verify(event *Event) bool {
+	server, nick := event.Server.Name, event.Line.Nick
+	if !isChallengeServer(server) {
+		return true
+	}
+	challengesOnce.Do(challenges.Load)
+	challenges.Lock()
+	defer challenges.Unlock()
+
+	if challenges.Servers[server] == nil {
+		challenges.Servers[server] = make(map[string]*ChallengeBinding)
+	}
+	binding := challenges.Servers[server][NameKey(nick)]
+	if binding == nil {
+		binding = &ChallengeBinding{Nick: nick, Mask: lineMask(event.Line), Token: challengeToken()}
+		challenges.Servers[server][NameKey(nick)] = binding
+		challenges.save()
+		event.Server.Privmsg(nick, Response(server, event.Room, nick, "challenge.bound", ResponseVars{"Mask": binding.Mask, "Token": binding.Token}))
+		return true
+	}
+	if binding.Mask == lineMask(event.Line) {
+		return true
+	}
+	event.Server.Privmsg(nick, Response(server, event.Room, nick, "challenge.confirm", ResponseVars{"Mask": lineMask(event.Line)}))
+	return false
+}
+
+// Moves a nick's binding to the user@host it is using now when it confirms with its token.
+func confirm(event *Event, token string) {
+	server, nick := event.Server.Name, event.Line.Nick
+	challengesOnce.Do(challenges.Load)
+	challenges.Lock()
+	defer challenges.Unlock()
+
+	binding := challenges.Servers[server][NameKey(nick)]
+	if binding == nil || binding.Token != token {
+		logging.Info("Bad challenge token from", server, nick, lineMask(event.Line))
+		event.Server.Privmsg(nick, Response(server, event.Room, nick, "challenge.badtoken", nil))
+		return
+	}
+	binding.Mask = lineMask(event.Line)
+	challenges.save()
+	event.Server.Privmsg(nick, Response(server, event.Room, nick, "challenge.confirmed", ResponseVars{"Mask": binding.Mask}))
+}
+
+// Answers !confirm <token> sent by PM on servers with challenges on.
+func ChallengePlugin(bot *Bot, settings *PluginSettings) {
+	channel := bot.HandleCommand(confirmCommand, IsPrivate())
+	for event := range channel {
+		if !isChallengeServer(event.Server.Name) {
+			continue
+		}
+		args, err := confirmCommand.Parse(event.Line.Text())
+		if err != nil {
+			event.Server.Privmsg(event.Line.Nick, err.Error())
+			continue
+		}
+		confirm(event, args.String("token"))
+	}
+}
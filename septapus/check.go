@@ -0,0 +1,308 @@
+package septapus
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/freetype-go/freetype/truetype"
+	"github.com/fluffle/goirc/client"
+)
+
+// How long a connect check waits for a server to accept our registration.
+const checkConnectTimeout = 30 * time.Second
+
+// The outcome of one check, Err is nil when it passed.
+type CheckResult struct {
+	Name string
+	Err  error
+}
+
+// Checks the configuration, assets and state directory, and connects to each server if connect is set, so problems
+// are found before the bot is started rather than while it runs. Returns every check, failed or not.
+func Check(servers []*Server, connect bool) []*CheckResult {
+	results := []*CheckResult{
+		{"config", LoadOptions()},
+		{"room options", checkRoomOptions()},
+		{"comic font", checkComicFont()},
+		{"avatars", checkAvatars()},
+		{"name packs", checkNamePacks()},
+		{"responses", checkResponses()},
+		{"state", checkState()},
+		{"store", checkStore()},
+	}
+	if connect {
+		for _, server := range servers {
+			results = append(results, &CheckResult{"connect " + string(server.Name), checkConnect(server)})
+		}
+	}
+	return results
+}
+
+// Every problem found by a check, reported together so they can all be fixed at once.
+type checkErrors []string
+
+func (errs checkErrors) Error() string {
+	return strings.Join(errs, "\n\t")
+}
+
+func (errs checkErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Checks the options that are set per room, entries without a value are otherwise ignored without a word.
+func checkRoomOptions() error {
+	errs := checkErrors{}
+	options := []struct {
+		flag  string
+		value string
+		valid func(value string) error
+	}{
+		{"responseslanguages", *languages, func(value string) error {
+			if !isLanguage(strings.ToLower(value)) {
+				return fmt.Errorf("unknown language, use one of: %v", strings.Join(LanguageNames(), ", "))
+			}
+			return nil
+		}},
+		{"localerooms", *locales, func(value string) error {
+			if localeLayouts[strings.ToLower(value)] == nil {
+				return fmt.Errorf("unknown locale")
+			}
+			return nil
+		}},
+		{"localetimezones", *timezones, func(value string) error {
+			_, err := time.LoadLocation(value)
+			return err
+		}},
+		{"rpgnamepack", *rpgnamepack, func(value string) error {
+			if GetNamePack(value).Name != value {
+				return fmt.Errorf("no name pack called %v in %v", value, *rpgnamepacks)
+			}
+			return nil
+		}},
+		{"messagesreadonly", *readonly, nil},
+		{"urltitles", *urltitles, nil},
+		{"rpgannounce", *rpgannounce, nil},
+		{"rpgkillsummary", *rpgkillsummary, nil},
+		{"mentionstyles", *mentions, nil},
+		{"joinimportant", *joinimportant, nil},
+		{"celebraterooms", *celebrate, nil},
+		{"challengeservers", *challenge, nil},
+		{"urlratelimits", *urlratelimits, positiveInt},
+		{"karmaslay", *karmaslay, positiveInt},
+		{"rpgkarmaxp", *rpgkarmaxp, positiveInt},
+		{"rpgsolocap", *rpgsolocap, positiveInt},
+		{"rpgboss", *rpgboss, func(value string) error {
+			_, err := time.ParseDuration(value)
+			return err
+		}},
+	}
+	for _, option := range options {
+		for _, mapping := range strings.Split(option.value, ",") {
+			mapping = strings.TrimSpace(mapping)
+			if mapping == "" {
+				continue
+			}
+			parts := strings.SplitN(mapping, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				errs = append(errs, fmt.Sprintf("-%v %q: expected server/#room=value", option.flag, mapping))
+				continue
+			}
+			if option.valid != nil {
+				if err := option.valid(parts[1]); err != nil {
+					errs = append(errs, fmt.Sprintf("-%v %q: %v", option.flag, mapping, err))
+				}
+			}
+		}
+	}
+	return errs.err()
+}
+
+func positiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 0 {
+		return fmt.Errorf("expected a whole number")
+	}
+	return nil
+}
+
+func checkComicFont() error {
+	data, err := ReadAsset(comicFontFile())
+	if err != nil {
+		return fmt.Errorf("%v, check -assetsdir or write the built in assets with -assetsextract", err)
+	}
+	if _, err := truetype.Parse(data); err != nil {
+		return fmt.Errorf("%v isn't a truetype font: %v", comicFontFile(), err)
+	}
+	return nil
+}
+
+func checkAvatars() error {
+	files, err := ReadAssetDir("avatars")
+	if err != nil {
+		return fmt.Errorf("%v, check -assetsdir or write the built in assets with -assetsextract", err)
+	}
+	errs := checkErrors{}
+	decoded := 0
+	for _, name := range files {
+		file, err := OpenAsset("avatars/" + name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		_, _, err = image.Decode(file)
+		file.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("avatars/%v isn't a png or jpeg: %v", name, err))
+			continue
+		}
+		decoded++
+	}
+	if decoded == 0 {
+		errs = append(errs, "no avatars, comics need at least one")
+	}
+	return errs.err()
+}
+
+func checkNamePacks() error {
+	files, err := ReadAssetDir(*rpgnamepacks)
+	if err != nil {
+		// The fantasy pack is built in, name packs are optional.
+		return nil
+	}
+	errs := checkErrors{}
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if _, err := loadNamePack(filepath.Join(*rpgnamepacks, name)); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", filepath.Join(*rpgnamepacks, name), err))
+		}
+	}
+	return errs.err()
+}
+
+// Checks the templates in the responses file and language packs parse, and replace responses the bot has.
+func checkResponses() error {
+	errs := checkErrors{}
+	checkCatalog := func(source string, catalog map[string]string) {
+		keys := make([]string, 0, len(catalog))
+		for key, _ := range catalog {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, ok := defaultResponses[key]; !ok {
+				errs = append(errs, fmt.Sprintf("%v: %v isn't a response the bot has", source, key))
+			} else if _, err := responseTemplate(catalog[key]); err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v: %v", source, key, err))
+			}
+		}
+	}
+
+	if file, err := os.Open(*responsesfile); err == nil {
+		responses := &responseFile{}
+		err := json.NewDecoder(file).Decode(responses)
+		file.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", *responsesfile, err))
+		}
+		for language, catalog := range responses.Languages {
+			checkCatalog(*responsesfile+" language "+language, catalog)
+		}
+		for room, catalog := range responses.Rooms {
+			checkCatalog(*responsesfile+" room "+room, catalog)
+		}
+	}
+
+	files, _ := ReadAssetDir(*langdir)
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		filename := filepath.Join(*langdir, name)
+		data, err := ReadAsset(filename)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		catalog := make(map[string]string)
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", filename, err))
+			continue
+		}
+		checkCatalog(filename, catalog)
+	}
+	return errs.err()
+}
+
+// Checks the store picked by -store.backend can be opened, the bolt store fails if the bot is running.
+func checkStore() error {
+	switch *storebackend {
+	case "file":
+		return nil
+	case "bolt":
+		store, err := NewBoltStore(*storepath)
+		if err != nil {
+			return fmt.Errorf("can't open %v: %v", *storepath, err)
+		}
+		return store.Close()
+	}
+	return fmt.Errorf("unknown backend %v, use file or bolt", *storebackend)
+}
+
+// Checks state can be written to and read back from the working directory, and the state directories that exist.
+func checkState() error {
+	dirs := []string{"."}
+	for _, path := range statePaths() {
+		if info, err := os.Stat(path.Path); err == nil && info.IsDir() {
+			dirs = append(dirs, path.Path)
+		}
+	}
+	errs := checkErrors{}
+	for _, dir := range dirs {
+		probe := filepath.Join(dir, ".septapus-check")
+		data := []byte(time.Now().String())
+		if err := ioutil.WriteFile(probe, data, 0644); err != nil {
+			wd, _ := os.Getwd()
+			errs = append(errs, fmt.Sprintf("can't save state in %v: %v", filepath.Join(wd, dir), err))
+			continue
+		}
+		read, err := ioutil.ReadFile(probe)
+		os.Remove(probe)
+		if err != nil || string(read) != string(data) {
+			errs = append(errs, fmt.Sprintf("state saved in %v doesn't read back: %v", dir, err))
+		}
+	}
+	return errs.err()
+}
+
+// Connects to a server with its configuration, waits for it to accept our registration, then quits.
+func checkConnect(server *Server) error {
+	config := *server.Config
+	conn := client.Client(&config)
+	connected := make(chan bool, 1)
+	conn.HandleFunc(client.CONNECTED, func(conn *client.Conn, line *client.Line) {
+		connected <- true
+	})
+	if err := conn.Connect(); err != nil {
+		return fmt.Errorf("couldn't connect to %v: %v", config.Server, err)
+	}
+	defer conn.Quit(config.QuitMessage)
+
+	select {
+	case <-connected:
+		return nil
+	case <-time.After(checkConnectTimeout):
+		return fmt.Errorf("connected to %v, but it didn't accept our registration within %v, check the nick and server password", config.Server, checkConnectTimeout)
+	}
+}
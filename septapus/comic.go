@@ -3,21 +3,22 @@ package septapus
 import (
 	"bufio"
 	"bytes"
-	"flag"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	_ "image/jpeg"
 	"image/png"
 	"io"
-	"io/ioutil"
 	"math"
 	"math/rand"
 	"mime/multipart"
-	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"code.google.com/p/draw2d/draw2d"
@@ -27,11 +28,22 @@ import (
 	"github.com/fluffle/golog/logging"
 )
 
-var comickey = flag.String("comickey", "", "Private key for uploading comics")
-var comicurl = flag.String("comicurl", "http://septapus.com/comics/comics.php", "Url to upload the generated comics")
-var comicallowrepeats = flag.Bool("comicallowrepeats", false, "Can one person laugh repeatedly to trigger comic.")
+var comicOptions = NewOptions("comic")
+
+var comickey = comicOptions.String("key", "", "Private key for uploading comics")
+var comicurl = comicOptions.String("url", "http://septapus.com/comics/comics.php", "Url to upload the generated comics")
+var comickeepdir = comicOptions.String("keepdir", "", "Directory every comic is also kept in, by server and room, for the galleries of septapus site. Comics aren't kept when empty")
+var comicgalleryurl = comicOptions.String("galleryurl", "", "Public url of the comic gallery, linked from a room's pages with the server and room as query parameters. Not linked when empty")
+var comicurls = comicOptions.String("urls", "", "Comma separated list of rooms with their own comic upload url, rooms that aren't listed use comicurl, eg: synirc/#septapus=http://example.com/comics.php")
+var comickeys = comicOptions.String("keys", "", "Comma separated list of rooms with their own comic upload key, rooms that aren't listed use comickey")
+var comicgalleries = comicOptions.String("galleries", "", "Comma separated list of rooms with the gallery their comics are uploaded to, sent with the upload so one server can keep communities separate, eg: synirc/*=synirc")
+var comicallowrepeats = comicOptions.Bool("allowrepeats", false, "Can one person laugh repeatedly to trigger comic.")
+var comicfont = comicOptions.String("font", "luxi", "Name of the comic font, loaded from fonts/<name>sr.ttf in the assets, eg: DigitalStrip2BB")
 
 const (
+	// The number of times Fit will shrink text that still doesn't fit after scaling.
+	maxFitAttempts int = 20
+
 	arrowHeight float64 = 5
 	laughRegex  string  = `(?i)\b((o*lo+l(l|o)*)|(ro+fl(l|o)*e*)|(b*a*h(h|a)+(h|a)+)|(e*he(h|e)+)|(e*ke(k|e)+)|lf*mao+)\b`
 )
@@ -43,59 +55,143 @@ const (
 )
 
 type ComicPlugin struct {
+	sync.Mutex
 	avatars   []image.Image
 	renderers []CellRenderer
 	settings  *PluginSettings
 	fontData  *draw2d.FontData
+	stats     map[ServerName]*ComicStats
+	// Scripts are made by one goroutine per room, however many times we join it.
+	instances *RoomInstances
 }
 
-func init() {
-	draw2d.SetFontFolder("fonts")
+// Returns the comic font's asset, named as draw2d names font files: name, family (s for sans) and style (r for regular).
+func comicFontFile() string {
+	return "fonts/" + *comicfont + "sr.ttf"
+}
+
+// Registers the comic font from the assets, falling back to draw2d loading it from the fonts directory.
+func loadComicFont(fontData draw2d.FontData) {
+	draw2d.SetFontFolder(assetPath("fonts"))
+	data, err := ReadAsset(comicFontFile())
+	if err != nil {
+		logging.Info("Error reading comic font", comicFontFile(), err)
+		return
+	}
+	font, err := truetype.Parse(data)
+	if err != nil {
+		ReportError("comic", "Error parsing comic font", err)
+		return
+	}
+	draw2d.RegisterFont(fontData, font)
 }
 
 func NewComicPlugin(settings *PluginSettings) *ComicPlugin {
 	if settings == nil {
 		settings = DefaultSettings
 	}
-	return &ComicPlugin{settings: settings}
+	return &ComicPlugin{settings: settings, instances: NewRoomInstances()}
 }
 
+// The number of recent lines kept in each room for !comicwith.
+const comicHistory = 50
+
+// How long a room is quiet before the conversation is over, and the next line starts a new script.
+const comicSilence = 5 * time.Minute
+
+var comicWithCommand = NewCommand("!comicwith <nicks...>").WithHelp("Makes a comic starring the nicks from the room's recent chat.")
+
 type Speaker int
 type Text string
 
+// A line recently said in a room.
+type recentLine struct {
+	Nick string
+	Text Text
+}
+
 type Message struct {
 	Speaker Speaker
 	Text    Text
+	Nick    string
 }
 
 type Script struct {
 	Messages []*Message
+	Server   ServerName
 	Room     RoomName
+	// The nick whose line set off the laughter, empty if the comic was asked for.
+	Trigger string
+}
+
+// A rendered comic, and where it came from.
+type Comic struct {
+	Image   image.Image
+	Server  ServerName
+	Room    RoomName
+	Nicks   []string
+	Trigger string
+}
+
+// Where a room's comics are uploaded to.
+type ComicTarget struct {
+	URL     string
+	Key     string
+	Gallery string
+}
+
+var (
+	comicURLs        RoomValues
+	comicKeys        RoomValues
+	comicGalleries   RoomValues
+	comicTargetsOnce sync.Once
+)
+
+// Returns the upload target for a room, falling back to the named plugin's url and key, then comicurl and comickey.
+func GetComicTarget(plugin string, server ServerName, room RoomName) *ComicTarget {
+	comicTargetsOnce.Do(func() {
+		comicURLs = ParseRoomValues(*comicurls)
+		comicKeys = ParseRoomValues(*comickeys)
+		comicGalleries = ParseRoomValues(*comicgalleries)
+	})
+	target := &ComicTarget{URL: comicOptions.Get(plugin, "url"), Key: comicOptions.Get(plugin, "key")}
+	if url, ok := comicURLs.Get(server, room); ok {
+		target.URL = url
+	}
+	if key, ok := comicKeys.Get(server, room); ok {
+		target.Key = key
+	}
+	if gallery, ok := comicGalleries.Get(server, room); ok {
+		target.Gallery = gallery
+	}
+	return target
+}
+
+func (comic *ComicPlugin) Settings() *PluginSettings {
+	return comic.settings
 }
 
 func (comic *ComicPlugin) Init(bot *Bot) {
-	joinchan := FilterSelf(comic.settings.GetEventHandler(bot, client.JOIN))
+	joinchan := comic.settings.GetEventHandler(bot, client.JOIN, IsSelf())
+	statschan := comic.settings.HandleCommand(bot, comicStatsCommand)
 	scriptchan := make(chan *Script, 100)
 	defer close(scriptchan)
-	comicchan := make(chan image.Image, 100)
+	comicchan := make(chan *Comic, 100)
 	defer close(comicchan)
 
-	var avatarFiles []os.FileInfo
-	var err error
-	if avatarFiles, err = ioutil.ReadDir("avatars"); err != nil {
+	avatarFiles, err := ReadAssetDir("avatars")
+	if err != nil {
 		logging.Error("Could not open avatars directory.")
 		return
 	}
 
 	avatars := make([]image.Image, 0)
 	for _, avatarFile := range avatarFiles {
-		if avatarFile.IsDir() {
-			continue
-		}
-		if file, err := os.Open("avatars/" + avatarFile.Name()); err == nil {
+		if file, err := OpenAsset("avatars/" + avatarFile); err == nil {
 			if avatar, _, err := image.Decode(bufio.NewReader(file)); err == nil {
 				avatars = append(avatars, avatar)
 			}
+			file.Close()
 		}
 	}
 	comic.avatars = avatars
@@ -107,35 +203,51 @@ func (comic *ComicPlugin) Init(bot *Bot) {
 		&TwoSpeakerCellRenderer{},
 	}
 
-	comic.fontData = &draw2d.FontData{"DigitalStrip2BB", draw2d.FontFamilySans, draw2d.FontStyleNormal}
+	comic.fontData = &draw2d.FontData{*comicfont, draw2d.FontFamilySans, draw2d.FontStyleNormal}
+	loadComicFont(*comic.fontData)
 
 	for {
 		select {
 		case script := <-scriptchan:
-			go comic.makeComic(comicchan, script.Messages, script.Room)
-		case image := <-comicchan:
-			go comic.uploadComic(image)
+			go comic.makeComic(comicchan, script.Messages, script.Server, script.Room, script.Trigger)
+		case c := <-comicchan:
+			go comic.uploadComic(bot, c)
 		case event, ok := <-joinchan:
 			if !ok {
 				return
 			}
-			go comic.makeScripts(scriptchan, bot, event.Server, RoomName(event.Line.Target()))
+			comic.instances.Join(event, func(event *Event, joins <-chan *Event) {
+				comic.makeScripts(scriptchan, bot, event.Server, RoomName(event.Line.Target()), joins)
+			})
+		case event, ok := <-statschan:
+			if !ok {
+				return
+			}
+			comic.Stats(event.Server.Name).StatsCommand(event, comic.settings)
 		}
 	}
 }
 
+// Compiled once, isLaugh is checked against every message. isLaugh and stripLaugh both match it against the text with
+// confusable letters folded, so "lоl" with a cyrillic о is a laugh, and is stripped, like "lol".
+var laughPattern = regexp.MustCompile(laughRegex)
+
 func isLaugh(text string) bool {
-	if regex, err := regexp.Compile(laughRegex); err == nil {
-		return regex.MatchString(strings.ToLower(text))
-	}
-	return false
+	folded, _ := foldConfusables(text)
+	return laughPattern.MatchString(folded)
 }
 
+// Returns text without its laughs, the rest of the text keeps its own letters.
 func stripLaugh(text string) string {
-	if regex, err := regexp.Compile(laughRegex); err == nil {
-		return strings.TrimSpace(regex.ReplaceAllString(text, ""))
+	folded, offsets := foldConfusables(text)
+	stripped := strings.Builder{}
+	last := 0
+	for _, match := range laughPattern.FindAllStringIndex(folded, -1) {
+		stripped.WriteString(text[last:offsets[match[0]]])
+		last = offsets[match[1]]
 	}
-	return text
+	stripped.WriteString(text[last:])
+	return strings.TrimSpace(stripped.String())
 }
 
 func randomLaugh() string {
@@ -150,14 +262,19 @@ func randomLaugh() string {
 	return "rofl"
 }
 
-func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server *Server, room RoomName) {
+func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server *Server, room RoomName, joins <-chan *Event) {
 	logging.Info("Creating comics in", server.Name, room)
 	defer logging.Info("Stopped creating comics in", server.Name, room)
 
-	// If we have heard this event, we can assume that we should be listenening to this room, don't filter through settings.
-	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
-	partchan := FilterSelfRoom(bot.GetEventHandler(client.PART), server.Name, room)
-	messagechan := FilterRoom(bot.GetEventHandler(client.PRIVMSG), server.Name, room)
+	// Comics are made for as long as we are in the room, so leaving isn't filtered through settings. Messages and commands
+	// are, so a room the plugin has just been disabled or banned in isn't drawn until it is enabled again.
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED, IsServer(server.Name))
+	partchan := bot.GetEventHandler(client.PART, IsSelf(), IsRoom(server.Name, room))
+	messagechan := comic.settings.GetEventHandler(bot, client.PRIVMSG, IsRoom(server.Name, room), IsSettled())
+	comicwithchan := comic.settings.HandleCommand(bot, comicWithCommand, IsRoom(server.Name, room))
+
+	// Recent lines from everyone, kept separately from the script so they survive resets.
+	recent := make([]*recentLine, 0, comicHistory)
 
 	var (
 		script    []*Message
@@ -167,6 +284,10 @@ func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server
 		laughs    int
 		lastLaugh string
 		timeout   bool
+		// The nick whose line set off the current laughter.
+		joke string
+		// When the last line was sent, a quiet spell between lines ends the conversation.
+		lastLine time.Time
 	)
 
 	reset := func() {
@@ -176,31 +297,38 @@ func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server
 		laughs = 0
 		lastLaugh = ""
 		timeout = false
+		joke = ""
 	}
 	reset()
-	quit := func() {
-		bot.RemoveEventHandler(disconnectchan)
-		bot.RemoveEventHandler(partchan)
-		bot.RemoveEventHandler(messagechan)
-	}
+	// On a disconnect or a part, we need to close our handlers, otherwise a second join would trigger another copy of this function.
+	// We stop straight away, so a join that arrives while stopping is left for the next copy.
+	defer bot.RemoveEventHandlers(disconnectchan, partchan, messagechan, comicwithchan)
 	for {
 		select {
-		// On a disconnect or a part, we need to close our handlers, otherwise a second join would trigger another copy of this function.
-		case _, ok := <-disconnectchan:
-			if !ok {
-				return
-			}
-			quit()
-		case _, ok := <-partchan:
-			if !ok {
-				return
-			}
-			quit()
+		case <-disconnectchan:
+			return
+		case <-server.Done():
+			return
+		case <-partchan:
+			return
+		case <-joins:
+			logging.Info("Rejoined, still creating comics in", server.Name, room)
 		case event, ok := <-messagechan:
 			if !ok {
 				return
 			}
+			// Measured by when the lines were sent rather than when we read them, so lag doesn't split a conversation.
+			if !lastLine.IsZero() && event.Time.Sub(lastLine) >= comicSilence {
+				timeout = true
+			}
+			lastLine = event.Time
 			text := event.Line.Text()
+			if !strings.HasPrefix(text, "!") && isUrl(text) == "" {
+				recent = append(recent, &recentLine{event.Line.Nick, Text(text)})
+				if len(recent) > comicHistory {
+					recent = recent[1:]
+				}
+			}
 			if strings.HasPrefix(event.Line.Text(), "!") {
 				reset()
 				break
@@ -213,6 +341,9 @@ func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server
 
 					lastLaugh = event.Line.Nick
 					if laughs <= 0 {
+						if len(script) > 0 {
+							joke = script[len(script)-1].Nick
+						}
 						if justLaugh {
 							laughs = 2
 						} else {
@@ -223,8 +354,8 @@ func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server
 					}
 
 					if laughs > 3 {
-						server.Conn.Privmsg(string(room), randomLaugh())
-						scriptchan <- &Script{script, room}
+						comic.settings.Privmsg(server, string(room), randomLaugh())
+						scriptchan <- &Script{script, server.Name, room, joke}
 						reset()
 						break
 					}
@@ -251,14 +382,68 @@ func (comic *ComicPlugin) makeScripts(scriptchan chan *Script, bot *Bot, server
 				speaker = speakers[event.Line.Nick]
 			}
 
-			script = append(script, &Message{speaker, Text(text)})
-		case <-time.After(5 * time.Minute):
-			timeout = true
+			script = append(script, &Message{speaker, Text(text), event.Line.Nick})
+		case event, ok := <-comicwithchan:
+			if !ok {
+				return
+			}
+			if script, err := comic.scriptWith(recent, event); err != nil {
+				comic.settings.Privmsg(server, string(room), err.Error())
+			} else {
+				scriptchan <- &Script{script, server.Name, room, ""}
+			}
 		}
 	}
 }
 
-func (comic *ComicPlugin) makeComic(comicchan chan image.Image, script []*Message, room RoomName) {
+func (comic *ComicPlugin) recordStats(server ServerName, script []*Message, trigger string) {
+	stats := comic.Stats(server)
+	stats.Add(script, trigger)
+	stats.Save(server)
+}
+
+// Returns a script of the recent lines said by the nicks named in a !comicwith command.
+func (comic *ComicPlugin) scriptWith(recent []*recentLine, event *Event) ([]*Message, error) {
+	args, err := comicWithCommand.Parse(event.Line.Text())
+	if err != nil {
+		return nil, err
+	}
+	nicks := make(map[string]bool)
+	for _, nick := range args.Words("nicks") {
+		nicks[NameKey(nick)] = true
+	}
+	if len(nicks) > len(comic.avatars) {
+		return nil, fmt.Errorf("There are only enough avatars for %d people.", len(comic.avatars))
+	}
+
+	script := make([]*Message, 0)
+	speakers := make(map[string]Speaker)
+	avatars := make(map[Speaker]bool)
+	for _, line := range recent {
+		key := NameKey(line.Nick)
+		if !nicks[key] {
+			continue
+		}
+		speaker, ok := speakers[key]
+		if !ok {
+			for {
+				speaker = Speaker(rand.Intn(len(comic.avatars)))
+				if !avatars[speaker] {
+					avatars[speaker] = true
+					break
+				}
+			}
+			speakers[key] = speaker
+		}
+		script = append(script, &Message{speaker, line.Text, line.Nick})
+	}
+	if len(script) == 0 {
+		return nil, errors.New("Nobody by those names has said anything recently.")
+	}
+	return script, nil
+}
+
+func (comic *ComicPlugin) makeComic(comicchan chan *Comic, script []*Message, server ServerName, room RoomName, trigger string) {
 	// Our plan can only be 3 panels long
 	maxComicLength := 3
 
@@ -294,6 +479,8 @@ func (comic *ComicPlugin) makeComic(comicchan chan image.Image, script []*Messag
 	}
 	plan := plans[rand.Intn(len(plans))]
 
+	comic.recordStats(server, script, trigger)
+
 	width := len(plan)*240 - 10
 
 	// Initialize the context.
@@ -311,14 +498,53 @@ func (comic *ComicPlugin) makeComic(comicchan chan image.Image, script []*Messag
 	}
 	DrawTextInRect(gc, color.RGBA{0xdd, 0xdd, 0xdd, 0xff}, TEXT_ALIGN_RIGHT, 0.8, "A comic by Septapus ("+string(room)+")", 0, 5, 205, float64(width-10), 20)
 
-	comicchan <- rgba
+	nicks := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, message := range script {
+		if !seen[message.Nick] {
+			seen[message.Nick] = true
+			nicks = append(nicks, message.Nick)
+		}
+	}
+	comicchan <- &Comic{rgba, server, room, nicks, trigger}
+}
+
+// Uploads a comic and emits COMIC_CREATED once it has been uploaded, a comic the server can't take yet is spooled.
+// Keeps a comic in comickeepdir, named by when it was made so the gallery can sort them.
+func keepComic(c *Comic) {
+	if *comickeepdir == "" {
+		return
+	}
+	dir := filepath.Join(*comickeepdir, roomPath(c.Server, FoldRoom(c.Server, c.Room)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		ReportError("comic", "Error creating comic directory:", err)
+		return
+	}
+	file, err := os.Create(filepath.Join(dir, time.Now().UTC().Format(comicKeepLayout)+".png"))
+	if err != nil {
+		ReportError("comic", "Error keeping comic:", err)
+		return
+	}
+	defer file.Close()
+	if err := png.Encode(file, c.Image); err != nil {
+		ReportError("comic", "Error keeping comic:", err)
+	}
 }
 
-func (comic *ComicPlugin) uploadComic(image image.Image) {
+// Kept comics are named by when they were made, in UTC.
+const comicKeepLayout = "20060102-150405.000"
+
+func (comic *ComicPlugin) uploadComic(bot *Bot, c *Comic) {
+	keepComic(c)
+	target := GetComicTarget(comic.settings.Name, c.Server, c.Room)
+	if comic.settings.SkipUpload(fmt.Sprintf("a comic from %v %v to %v", c.Server, c.Room, target.URL)) {
+		return
+	}
+
 	file, err := os.Create("comic.png")
 	defer file.Close()
 	if err != nil {
-		logging.Error("Error creating file:", err)
+		ReportError("comic", "Error creating file:", err)
 		return
 	}
 
@@ -329,35 +555,56 @@ func (comic *ComicPlugin) uploadComic(image image.Image) {
 	w := multipart.NewWriter(b)
 	defer w.Close()
 
-	if err = w.WriteField("key", *comickey); err != nil {
-		logging.Error("Error creating key:", err)
+	if err = w.WriteField("key", target.Key); err != nil {
+		ReportError("comic", "Error creating key:", err)
 		return
 	}
 
+	stats := &bytes.Buffer{}
+	if err = comic.Stats(c.Server).Render(stats); err != nil {
+		ReportError("comic", "Error rendering stats:", err)
+	} else if err = w.WriteField("stats", stats.String()); err != nil {
+		ReportError("comic", "Error creating stats:", err)
+		return
+	}
+
+	if target.Gallery != "" {
+		if err = w.WriteField("gallery", target.Gallery); err != nil {
+			ReportError("comic", "Error creating gallery:", err)
+			return
+		}
+	}
+
 	formfile, err := w.CreateFormFile("comic", "comic.png")
 	if err != nil {
-		logging.Error("Error creating form file:", err)
+		ReportError("comic", "Error creating form file:", err)
 		return
 	}
 
-	if err = png.Encode(io.MultiWriter(filewriter, formfile), image); err != nil {
-		logging.Error("Error encoding PNG:", err)
+	if err = png.Encode(io.MultiWriter(filewriter, formfile), c.Image); err != nil {
+		ReportError("comic", "Error encoding PNG:", err)
 		return
 	}
 
 	if err = filewriter.Flush(); err != nil {
-		logging.Error("Error flushing to disk:", err)
+		ReportError("comic", "Error flushing to disk:", err)
 		return
 	}
 	logging.Info("Wrote comic to disk")
 
 	w.Close()
 
-	if resp, err := http.Post(*comicurl, w.FormDataContentType(), b); err != nil {
-		logging.Error("Error posting comic to server:", err)
+	logging.Info("Uploading comic from", c.Server, c.Room, "to", target.URL)
+
+	// A comic that has to wait for the server is still uploaded, but only announced if it gets there now.
+	description := fmt.Sprintf("a comic from %v %v", c.Server, c.Room)
+	if err := SharedUploadQueue().Upload(description, "", target.URL, w.FormDataContentType(), b.Bytes()); err != nil {
 		return
-	} else {
-		defer resp.Body.Close()
+	}
+
+	if server := bot.GetServer(c.Server); server != nil {
+		text := fmt.Sprintf("A comic with %v", strings.Join(c.Nicks, ", "))
+		bot.Emit(COMIC_CREATED, server, c.Room, c.Trigger, text, time.Now(), &ComicCreated{target.URL, c.Nicks, c.Trigger})
 	}
 }
 
@@ -542,8 +789,16 @@ func Fit(dpi float64, font *truetype.Font, spacing float64, text string, width,
 		scale = height / wrapHeight
 	}
 	fontSize *= scale
-	wrapWidth *= scale
-	wrapHeight *= scale
+
+	// Line breaks can change at the scaled size, so make sure the text really fits, shrinking it further if it doesn't.
+	for i := 0; i < maxFitAttempts; i++ {
+		wrapText, _ = WrapText(dpi, font, fontSize, spacing, text, width)
+		wrapWidth, wrapHeight, _ = Bounds(dpi, font, fontSize, spacing, wrapText)
+		if wrapWidth <= width && wrapHeight <= height {
+			break
+		}
+		fontSize *= 0.9
+	}
 	return
 }
 
@@ -569,24 +824,53 @@ func wrapLine(buffer *bytes.Buffer, dpi float64, font *truetype.Font, fontSize,
 	var maxWidth float64
 	words := strings.Split(line, " ")
 	for i, word := range words {
-		if i != 0 {
-			width, _, _ = Bounds(dpi, font, fontSize, spacing, " "+word)
-		} else {
-			width, _, _ = Bounds(dpi, font, fontSize, spacing, word)
+		// Words too long for a line are broken up, each piece after the first starts a new line.
+		for j, piece := range hyphenate(dpi, font, fontSize, spacing, word, wrapWidth) {
+			if i != 0 && j == 0 {
+				width, _, _ = Bounds(dpi, font, fontSize, spacing, " "+piece)
+			} else {
+				width, _, _ = Bounds(dpi, font, fontSize, spacing, piece)
+			}
+			if width > maxWidth {
+				maxWidth = width
+			}
+			runningWidth += width
+			if (runningWidth >= wrapWidth && (i != 0 || j != 0)) || j != 0 {
+				runningWidth = width
+				buffer.WriteString("\n")
+			} else if i != 0 {
+				buffer.WriteString(" ")
+			}
+			buffer.WriteString(piece)
 		}
-		if width > maxWidth {
-			maxWidth = width
+	}
+	return maxWidth
+}
+
+// Splits a word that is wider than wrapWidth into pieces that fit, ending each piece but the last with a hyphen.
+// Pieces are never shorter than one character, so a single character wider than wrapWidth will still overflow.
+func hyphenate(dpi float64, font *truetype.Font, fontSize, spacing float64, word string, wrapWidth float64) []string {
+	pieces := make([]string, 0)
+	runes := []rune(word)
+	for {
+		if width, _, _ := Bounds(dpi, font, fontSize, spacing, string(runes)); width <= wrapWidth || len(runes) <= 1 {
+			return append(pieces, string(runes))
 		}
-		runningWidth += width
-		if runningWidth >= wrapWidth && i != 0 {
-			runningWidth = width
-			buffer.WriteString("\n")
-		} else if i != 0 {
-			buffer.WriteString(" ")
+		n := 0
+		for n < len(runes)-1 {
+			if width, _, _ := Bounds(dpi, font, fontSize, spacing, string(runes[:n+1])+"-"); width > wrapWidth {
+				break
+			}
+			n++
+		}
+		if n == 0 {
+			pieces = append(pieces, string(runes[:1]))
+			runes = runes[1:]
+		} else {
+			pieces = append(pieces, string(runes[:n])+"-")
+			runes = runes[n:]
 		}
-		buffer.WriteString(word)
 	}
-	return maxWidth
 }
 
 func InsetRectangle(x, y, width, height, inset float64) (float64, float64, float64, float64) {
@@ -601,6 +885,48 @@ func InsetRectangle4(x, y, width, height, left, right, top, bottom float64) (flo
 	return x + left, y + top, width - left - right, height - top - bottom
 }
 
+// Shrinks a rectangle so it no longer overlaps an obstacle plus a margin, keeping whichever side of the obstacle leaves the most area.
+// If there is no room on any side the rectangle is returned unchanged.
+func AvoidRectangle(x, y, width, height, oX, oY, oWidth, oHeight, margin float64) (float64, float64, float64, float64) {
+	oX, oY, oWidth, oHeight = InsetRectangle(oX, oY, oWidth, oHeight, -margin)
+	if x >= oX+oWidth || oX >= x+width || y >= oY+oHeight || oY >= y+height {
+		return x, y, width, height
+	}
+	candidates := [][4]float64{
+		{x, y, oX - x, height},
+		{oX + oWidth, y, x + width - oX - oWidth, height},
+		{x, y, width, oY - y},
+		{x, oY + oHeight, width, y + height - oY - oHeight},
+	}
+	best := -1
+	for i, c := range candidates {
+		if c[2] > 0 && c[3] > 0 && (best == -1 || c[2]*c[3] > candidates[best][2]*candidates[best][3]) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return x, y, width, height
+	}
+	c := candidates[best]
+	return c[0], c[1], c[2], c[3]
+}
+
+// Returns the point a speech bubble's tail should point to, just outside the edge of the avatar that faces the bubble.
+// Tails aim at the speaker's face, which is assumed to be a third of the way down their avatar.
+func TailTarget(bX, bY, bWidth, bHeight, aX, aY, aWidth, aHeight, gap float64) (float64, float64) {
+	faceX, faceY := aX+aWidth/2, aY+aHeight/3
+	switch {
+	case bX >= aX+aWidth:
+		return aX + aWidth + gap, faceY
+	case bX+bWidth <= aX:
+		return aX - gap, faceY
+	case bY+bHeight <= aY:
+		return faceX, aY - gap
+	default:
+		return faceX, aY + aHeight + gap
+	}
+}
+
 type CellRenderer interface {
 	// The number of text lines this Cell will render
 	Lines() int
@@ -650,13 +976,15 @@ func (c *OneSpeakerCellRenderer) Render(gc *draw2d.ImageGraphicContext, avatars
 
 	avatar := avatars[messages[0].Speaker]
 	bounds := avatar.Bounds()
-	gc.SetMatrixTransform(draw2d.NewTranslationMatrix(x+border, y+height-border-float64(bounds.Dy())))
+	avX, avY := x+border, y+height-border-float64(bounds.Dy())
+	gc.SetMatrixTransform(draw2d.NewTranslationMatrix(avX, avY))
 	gc.DrawImage(avatar)
 	gc.SetMatrixTransform(draw2d.NewIdentityMatrix())
 
 	bX, bY, bWidth, bHeight := InsetRectangle4(x, y, width, height, border, border, border, border+float64(bounds.Dy())+arrowHeight*2)
 
-	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, bX+rand.Float64()*float64(bounds.Dx()), bY+bHeight+arrowHeight)
+	pointX, pointY := TailTarget(bX, bY, bWidth, bHeight, avX, avY, float64(bounds.Dx()), float64(bounds.Dy()), arrowHeight/2)
+	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, pointX, pointY)
 	DrawTextInRect(gc, image.Black, TEXT_ALIGN_CENTER, 0.8, string(messages[0].Text), arrowHeight, bX, bY, bWidth, bHeight)
 }
 
@@ -689,7 +1017,8 @@ func (c *FlippedOneSpeakerCellRenderer) Render(gc *draw2d.ImageGraphicContext, a
 
 	bX, bY, bWidth, bHeight := InsetRectangle4(x, y, width, height, border, border, border+float64(bounds.Dy())+arrowHeight*2, border)
 
-	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, bX+rand.Float64()*float64(bounds.Dx()), bY-arrowHeight)
+	pointX, pointY := TailTarget(bX, bY, bWidth, bHeight, x+border, y+border, float64(bounds.Dx()), float64(bounds.Dy()), arrowHeight/2)
+	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, pointX, pointY)
 	DrawTextInRect(gc, image.Black, TEXT_ALIGN_CENTER, 0.8, string(messages[0].Text), arrowHeight, bX, bY, bWidth, bHeight)
 }
 
@@ -716,16 +1045,26 @@ func (c *TwoSpeakerCellRenderer) Render(gc *draw2d.ImageGraphicContext, avatars
 	flipped := rand.Float64() >= 0.5
 	// get a rectangle for half the area
 	aX, aY, aWidth, aHeight := InsetRectangle4(x, y, width, height, 0, 0, 0, height/2)
+
+	// Place both avatars first, tall avatars can spill into the other half, so each bubble needs to avoid both of them.
+	avatarRects := make([][4]float64, 2)
 	for i := 0; i < 2; i++ {
+		bounds := avatars[messages[i].Speaker].Bounds()
+		avWidth, avHeight := float64(bounds.Dx()), float64(bounds.Dy())
+		halfY := aY + aHeight*float64(i)
+		if flipped != (i == 1) {
+			avatarRects[i] = [4]float64{aX + aWidth - border - avWidth, halfY + aHeight - border - avHeight, avWidth, avHeight}
+		} else {
+			avatarRects[i] = [4]float64{aX + border, halfY + border, avWidth, avHeight}
+		}
+	}
 
+	for i := 0; i < 2; i++ {
 		avatar := avatars[messages[i].Speaker]
 		bounds := avatar.Bounds()
+		av := avatarRects[i]
 
-		if flipped {
-			gc.SetMatrixTransform(draw2d.NewTranslationMatrix(aX+aWidth-border-float64(bounds.Dx()), aY+aHeight-border-float64(bounds.Dy())))
-		} else {
-			gc.SetMatrixTransform(draw2d.NewTranslationMatrix(aX+border, aY+border))
-		}
+		gc.SetMatrixTransform(draw2d.NewTranslationMatrix(av[0], av[1]))
 		gc.DrawImage(avatar)
 		gc.SetMatrixTransform(draw2d.NewIdentityMatrix())
 
@@ -735,12 +1074,12 @@ func (c *TwoSpeakerCellRenderer) Render(gc *draw2d.ImageGraphicContext, avatars
 			bX += aWidth - bWidth - (bX - x) - border
 		}
 
-		arrowX := -arrowHeight * 2
-		if flipped {
-			arrowX = bWidth + arrowHeight*2
+		for _, other := range avatarRects {
+			bX, bY, bWidth, bHeight = AvoidRectangle(bX, bY, bWidth, bHeight, other[0], other[1], other[2], other[3], arrowHeight)
 		}
 
-		DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, bX+arrowX, bY+rand.Float64()*float64(bounds.Dx()))
+		pointX, pointY := TailTarget(bX, bY, bWidth, bHeight, av[0], av[1], av[2], av[3], arrowHeight/2)
+		DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, pointX, pointY)
 		DrawTextInRect(gc, image.Black, TEXT_ALIGN_CENTER, 0.8, string(messages[i].Text), 10, bX, bY, bWidth, bHeight)
 
 		flipped = !flipped
@@ -771,18 +1110,21 @@ func (c *OneSpeakerMonologueCellRenderer) Render(gc *draw2d.ImageGraphicContext,
 
 	avatar := avatars[messages[0].Speaker]
 	bounds := avatar.Bounds()
-	gc.SetMatrixTransform(draw2d.NewTranslationMatrix(x+border, y+height-border-float64(bounds.Dy())))
+	avX, avY, avWidth, avHeight := x+border, y+height-border-float64(bounds.Dy()), float64(bounds.Dx()), float64(bounds.Dy())
+	gc.SetMatrixTransform(draw2d.NewTranslationMatrix(avX, avY))
 	gc.DrawImage(avatar)
 	gc.SetMatrixTransform(draw2d.NewIdentityMatrix())
 
 	bX, bY, bWidth, bHeight := InsetRectangle4(x, y, width, height, border, border, border, border+float64(bounds.Dy())+arrowHeight*2)
 
-	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, bX+rand.Float64()*float64(bounds.Dx()), bY+bHeight+arrowHeight)
+	pointX, pointY := TailTarget(bX, bY, bWidth, bHeight, avX, avY, avWidth, avHeight, arrowHeight/2)
+	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, pointX, pointY)
 	DrawTextInRect(gc, image.Black, TEXT_ALIGN_CENTER, 0.8, string(messages[0].Text), arrowHeight, bX, bY, bWidth, bHeight)
 
 	bX, bY, bWidth, bHeight = InsetRectangle4(x, y, width, height, border+float64(bounds.Dx())+arrowHeight*3, border, y+height-border*2-float64(bounds.Dy()), border)
 
-	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, bX-arrowHeight*2, bY+rand.Float64()*float64(bounds.Dy()))
+	pointX, pointY = TailTarget(bX, bY, bWidth, bHeight, avX, avY, avWidth, avHeight, arrowHeight/2)
+	DrawSpeech(gc, 2, border, bX, bY, bWidth, bHeight, pointX, pointY)
 	DrawTextInRect(gc, image.Black, TEXT_ALIGN_CENTER, 0.8, string(messages[1].Text), arrowHeight, bX, bY, bWidth, bHeight)
 
 }
@@ -0,0 +1,91 @@
+package septapus
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIsLaugh(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"lol", true},
+		{"LOL", true},
+		{"that is hilarious hahaha", true},
+		{"rofl", true},
+		{"lmao", true},
+		{"lоl", true},  // cyrillic о
+		{"ｌｏｌ", true},  // fullwidth
+		{"hеhе", true}, // cyrillic е
+		{"ΗΑΗΑ", true}, // greek capitals
+		{"lollipop", false},
+		{"hello", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isLaugh(test.text); got != test.want {
+			t.Errorf("isLaugh(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestStripLaugh(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"lol", ""},
+		{"LOL that's great", "that's great"},
+		{"that's great lоl", "that's great"},
+		{"ｌｏｌ ｎｏ", "ｎｏ"},
+		{"naïve haha café", "naïve  café"},
+		{"no laughing here", "no laughing here"},
+	}
+	for _, test := range tests {
+		if got := stripLaugh(test.text); got != test.want {
+			t.Errorf("stripLaugh(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func FuzzLaugh(f *testing.F) {
+	for _, seed := range []string{"lol", "lоl haha", "ｌｏｌ", "naïve haha café", "\xff\xfelol", strings.Repeat("ha", 300)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		stripped := stripLaugh(text)
+		if !isLaugh(text) && stripped != strings.TrimSpace(text) {
+			t.Errorf("stripLaugh(%q) = %q, but it isn't a laugh", text, stripped)
+		}
+		if len(stripped) > len(text) {
+			t.Errorf("stripLaugh(%q) = %q, longer than the text", text, stripped)
+		}
+	})
+}
+
+// A line from a busy room, most lines aren't laughs.
+const benchmarkLine = "did anyone see the stream last night? https://www.youtube.com/watch?v=dQw4w9WgXcQ was wild"
+
+func BenchmarkIsLaugh(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		isLaugh(benchmarkLine)
+	}
+}
+
+// Compiles the pattern for every line, as isLaugh did before it was compiled once, for comparison.
+func BenchmarkIsLaughCompiledEachLine(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		regexp.MustCompile(laughRegex).MatchString(benchmarkLine)
+	}
+}
+
+func BenchmarkStripLaugh(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stripLaugh("lol " + benchmarkLine + " haha")
+	}
+}
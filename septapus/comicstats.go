@@ -0,0 +1,215 @@
+package septapus
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var comicStatsCommand = NewCommand("!comicstats [nick]").WithHelp("Shows the funniest people in comics, or how many comics a nick has appeared in.")
+
+// How many people are listed in each comic stats ranking.
+const comicStatsTop = 3
+
+// How funny someone has been on a server.
+type ComicStat struct {
+	Nick string
+	// The number of comics their lines have appeared in.
+	Appearances int
+	// The number of comics started by people laughing at one of their lines.
+	Triggers int
+}
+
+type ComicStats struct {
+	sync.RWMutex
+	Comics int
+	Stats  map[string]*ComicStat
+}
+
+func (stats *ComicStats) get(nick string) *ComicStat {
+	key := NameKey(nick)
+	stat := stats.Stats[key]
+	if stat == nil {
+		stat = &ComicStat{Nick: nick}
+		stats.Stats[key] = stat
+	}
+	stat.Nick = nick
+	return stat
+}
+
+// Records a comic, trigger is the nick whose line set off the laughter, or empty if the comic was asked for.
+func (stats *ComicStats) Add(script []*Message, trigger string) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	stats.Comics++
+	seen := make(map[string]bool)
+	for _, message := range script {
+		key := NameKey(message.Nick)
+		if message.Nick == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		stats.get(message.Nick).Appearances++
+	}
+	if trigger != "" {
+		stats.get(trigger).Triggers++
+	}
+}
+
+// Sorts people by a stat, most first.
+type comicRanking struct {
+	stats []*ComicStat
+	value func(*ComicStat) int
+}
+
+func (r comicRanking) Len() int      { return len(r.stats) }
+func (r comicRanking) Swap(i, j int) { r.stats[i], r.stats[j] = r.stats[j], r.stats[i] }
+func (r comicRanking) Less(i, j int) bool {
+	if r.value(r.stats[i]) == r.value(r.stats[j]) {
+		return NameKey(r.stats[i].Nick) < NameKey(r.stats[j].Nick)
+	}
+	return r.value(r.stats[i]) > r.value(r.stats[j])
+}
+
+// Returns the people with the highest value, most first.
+func (stats *ComicStats) top(value func(*ComicStat) int) []*ComicStat {
+	sorted := make([]*ComicStat, 0, len(stats.Stats))
+	for _, stat := range stats.Stats {
+		if value(stat) > 0 {
+			sorted = append(sorted, stat)
+		}
+	}
+	sort.Sort(comicRanking{sorted, value})
+	if len(sorted) > comicStatsTop {
+		sorted = sorted[:comicStatsTop]
+	}
+	return sorted
+}
+
+func appearances(stat *ComicStat) int { return stat.Appearances }
+func triggers(stat *ComicStat) int    { return stat.Triggers }
+
+func rankingString(stats []*ComicStat, value func(*ComicStat) int, server ServerName, room RoomName) string {
+	parts := make([]string, len(stats))
+	for i, stat := range stats {
+		parts[i] = fmt.Sprintf("%v (%d)", SafeNick(server, room, stat.Nick), value(stat))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (stats *ComicStats) StatsCommand(event *Event, settings *PluginSettings) {
+	args, err := comicStatsCommand.Parse(event.Line.Text())
+	if err != nil {
+		settings.Reply(event, err.Error())
+		return
+	}
+
+	stats.RLock()
+	defer stats.RUnlock()
+
+	server, room := event.Server.Name, event.Room
+	if args.Has("nick") {
+		stat := stats.Stats[NameKey(args.String("nick"))]
+		if stat == nil {
+			settings.Reply(event, fmt.Sprintf("%v hasn't been in any comics.", SafeNick(server, room, args.String("nick"))))
+			return
+		}
+		settings.Reply(event, fmt.Sprintf("%v has appeared in %d comics and set off %d.", SafeNick(server, room, stat.Nick), stat.Appearances, stat.Triggers))
+		return
+	}
+	if stats.Comics == 0 {
+		settings.Reply(event, "No comics have been made yet.")
+		return
+	}
+	settings.Reply(event, fmt.Sprintf("%d comics. Funniest people: %v. Biggest laugh triggers: %v.", stats.Comics, rankingString(stats.top(appearances), appearances, server, room), rankingString(stats.top(triggers), triggers, server, room)))
+}
+
+var comicStatsTemplate = template.Must(template.New("root").Parse(comicStatsTemplateSource))
+
+// A fragment uploaded with every comic, so the gallery can show it.
+const comicStatsTemplateSource = `<div class="comicstats">
+	<h2>{{.Comics}} comics</h2>
+	<h3>Funniest people</h3>
+	<ol>{{range .Appearances}}<li>{{.Nick}} ({{.Appearances}})</li>{{end}}</ol>
+	<h3>Biggest laugh triggers</h3>
+	<ol>{{range .Triggers}}<li>{{.Nick}} ({{.Triggers}})</li>{{end}}</ol>
+</div>
+`
+
+// Writes the stats section for the comic gallery.
+func (stats *ComicStats) Render(w io.Writer) error {
+	stats.RLock()
+	defer stats.RUnlock()
+
+	return comicStatsTemplate.Execute(w, struct {
+		Comics      int
+		Appearances []*ComicStat
+		Triggers    []*ComicStat
+	}{stats.Comics, stats.top(appearances), stats.top(triggers)})
+}
+
+func (stats *ComicStats) Load(server ServerName) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	filename := "comicstats/" + string(server) + ".json"
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(stats); err != nil {
+			ReportError("comic", "Error loading comic stats", server, err)
+		} else {
+			logging.Info("Loaded comic stats for", server)
+		}
+	} else {
+		logging.Info("Error loading file", server, filename, err)
+	}
+	if stats.Stats == nil {
+		stats.Stats = make(map[string]*ComicStat)
+	}
+}
+
+func (stats *ComicStats) Save(server ServerName) {
+	stats.RLock()
+	defer stats.RUnlock()
+
+	filename := "comicstats/" + string(server) + ".json"
+
+	if file, err := os.Create(filename); err == nil {
+		defer file.Close()
+		enc := json.NewEncoder(file)
+		if err := enc.Encode(stats); err != nil {
+			ReportError("comic", "Error saving comic stats", server, err)
+		} else {
+			logging.Info("Saved comic stats", server)
+		}
+	} else {
+		logging.Info("Error creating file", server, filename, err)
+	}
+}
+
+// Returns the comic stats for a server, loading them the first time.
+func (comic *ComicPlugin) Stats(server ServerName) *ComicStats {
+	comic.Lock()
+	defer comic.Unlock()
+
+	if comic.stats == nil {
+		comic.stats = make(map[ServerName]*ComicStats)
+	}
+	stats := comic.stats[server]
+	if stats == nil {
+		stats = &ComicStats{}
+		stats.Load(server)
+		comic.stats[server] = stats
+	}
+	return stats
+}
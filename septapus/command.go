@@ -0,0 +1,227 @@
+package septapus
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type ArgType int
+
+const (
+	ARG_STRING ArgType = iota
+	ARG_INT
+)
+
+// The longest command text parsed, an IRC line can't be longer.
+const maxCommandLength = 512
+
+type commandToken struct {
+	literal  string
+	name     string
+	argType  ArgType
+	optional bool
+	rest     bool
+}
+
+type commandPattern struct {
+	source string
+	tokens []*commandToken
+}
+
+// A Command declares the grammar of a command as one or more patterns, eg:
+//
+//	NewCommand("!pr <nick> [lift]")
+//	NewCommand("!alias define <name> <expansion...>", "!alias remove <name>", "!alias list")
+//
+// Words are literals (used for subcommands), <name> is a required argument, [name] is an optional argument.
+// Arguments can be typed with <name:int>, and the last argument can take the rest of the line with <name...>.
+type Command struct {
+	Name string
+	// What the command does, shown by !help.
+	Help     string
+	patterns []*commandPattern
+}
+
+// Creates a command from its patterns, it panics if a pattern is malformed.
+func NewCommand(patterns ...string) *Command {
+	command := &Command{}
+	for _, source := range patterns {
+		fields := strings.Fields(source)
+		if len(fields) == 0 {
+			panic("septapus: empty command pattern")
+		}
+		if command.Name == "" {
+			command.Name = fields[0]
+		} else if command.Name != fields[0] {
+			panic("septapus: command patterns must share a name: " + source)
+		}
+		pattern := &commandPattern{source: source}
+		optional := false
+		for i, field := range fields[1:] {
+			token := &commandToken{}
+			switch {
+			case strings.HasPrefix(field, "<") && strings.HasSuffix(field, ">"):
+				if optional {
+					panic("septapus: required argument after optional argument: " + source)
+				}
+			case strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]"):
+				token.optional = true
+				optional = true
+			default:
+				if optional {
+					panic("septapus: literal after optional argument: " + source)
+				}
+				token.literal = field
+				pattern.tokens = append(pattern.tokens, token)
+				continue
+			}
+			name := field[1 : len(field)-1]
+			if strings.HasSuffix(name, "...") {
+				if i != len(fields)-2 {
+					panic("septapus: rest argument must be last: " + source)
+				}
+				token.rest = true
+				name = strings.TrimSuffix(name, "...")
+			}
+			if parts := strings.SplitN(name, ":", 2); len(parts) == 2 {
+				name = parts[0]
+				switch parts[1] {
+				case "int":
+					token.argType = ARG_INT
+				case "string":
+					token.argType = ARG_STRING
+				default:
+					panic("septapus: unknown argument type: " + source)
+				}
+			}
+			token.name = name
+			pattern.tokens = append(pattern.tokens, token)
+		}
+		command.patterns = append(command.patterns, pattern)
+	}
+	return command
+}
+
+func (command *Command) Usage() string {
+	patterns := make([]string, len(command.patterns))
+	for i, pattern := range command.patterns {
+		patterns[i] = pattern.source
+	}
+	return strings.Join(patterns, ", ")
+}
+
+// Sets what the command does, shown by !help after its usage.
+func (command *Command) WithHelp(help string) *Command {
+	command.Help = help
+	return command
+}
+
+// Returns true if text invokes this command, regardless of whether its arguments are valid.
+func (command *Command) Matches(text string) bool {
+	return text == command.Name || strings.HasPrefix(text, command.Name+" ")
+}
+
+// Parses text against each pattern, returning the arguments of the first pattern that matches.
+func (command *Command) Parse(text string) (*CommandArgs, error) {
+	if !command.Matches(text) {
+		return nil, fmt.Errorf("Not a %v command.", command.Name)
+	}
+	if len(text) > maxCommandLength {
+		return nil, fmt.Errorf("Bad command, it can be at most %d bytes.", maxCommandLength)
+	}
+	fields := strings.Fields(text)[1:]
+	for _, pattern := range command.patterns {
+		if args := pattern.match(fields); args != nil {
+			return args, nil
+		}
+	}
+	return nil, errors.New("Bad command: " + command.Usage())
+}
+
+func (pattern *commandPattern) match(fields []string) *CommandArgs {
+	args := &CommandArgs{Pattern: pattern.source, values: make(map[string]string)}
+	i := 0
+	for _, token := range pattern.tokens {
+		if i >= len(fields) {
+			if token.optional {
+				continue
+			}
+			return nil
+		}
+		if token.literal != "" {
+			if !strings.EqualFold(token.literal, fields[i]) {
+				return nil
+			}
+			i++
+			continue
+		}
+		value := fields[i]
+		if token.rest {
+			value = strings.Join(fields[i:], " ")
+			i = len(fields)
+		} else {
+			i++
+		}
+		if token.argType == ARG_INT {
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil
+			}
+		}
+		args.values[token.name] = value
+	}
+	if i != len(fields) {
+		return nil
+	}
+	return args
+}
+
+type CommandArgs struct {
+	// The pattern that matched, useful to tell subcommands apart.
+	Pattern string
+	values  map[string]string
+}
+
+func (args *CommandArgs) Has(name string) bool {
+	_, ok := args.values[name]
+	return ok
+}
+
+func (args *CommandArgs) String(name string) string {
+	return args.values[name]
+}
+
+func (args *CommandArgs) Int(name string) int {
+	value, _ := strconv.Atoi(args.values[name])
+	return value
+}
+
+func (args *CommandArgs) Words(name string) []string {
+	return strings.Fields(args.values[name])
+}
+
+// Passes events whose text matches a regex.
+func IsRegex(regex *regexp.Regexp) EventPredicate {
+	return func(event *Event) bool {
+		return regex.MatchString(event.Line.Text())
+	}
+}
+
+// Passes events that invoke a command.
+func IsCommand(command *Command) EventPredicate {
+	return func(event *Event) bool {
+		return command.Matches(event.Line.Text())
+	}
+}
+
+// Filters a channel to only return the events whose text matches a regex.
+func FilterRegex(channel chan *Event, regex *regexp.Regexp) chan *Event {
+	return Filter(channel, IsRegex(regex))
+}
+
+// Filters a channel to only return the events that invoke a command.
+func FilterCommand(channel chan *Event, command *Command) chan *Event {
+	return Filter(channel, IsCommand(command))
+}
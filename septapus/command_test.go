@@ -0,0 +1,28 @@
+package septapus
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func FuzzCommandParse(f *testing.F) {
+	command := NewCommand("!rpgguild create <name...>", "!rpgguild info [name...]", "!rpgguild leave", "!rpgguild give <nick> <amount:int>")
+	for _, seed := range []string{"!rpgguild create The Guild", "!rpgguild info", "!rpgguild leave now", "!rpgguild give iopred 10", "!rpgguild give iopred ten", "!rpgguild " + strings.Repeat("x", 600), "!rpgguild\tleave"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		args, err := command.Parse(text)
+		if err != nil {
+			return
+		}
+		if len(text) > maxCommandLength {
+			t.Errorf("Parse accepted %d bytes", len(text))
+		}
+		if args.Pattern == "!rpgguild give <nick> <amount:int>" {
+			if _, err := strconv.Atoi(args.String("amount")); err != nil {
+				t.Errorf("Parse(%q) accepted amount %q", text, args.String("amount"))
+			}
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package septapus
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+// A plain flag rather than an option, as options are read from it.
+var configfile = flag.String("config", "", "Json file of plugin options, keyed by namespace or named plugin, eg: {\"rpg\": {\"key\": \"secret\"}, \"comic-freenode\": {\"url\": \"http://example.com/comics.php\"}}. It can also list the servers to connect to and which plugins run where, see Config")
+
+// Options a plugin declares, each option is also a flag named namespace+name, eg: the rpg namespace's key is -rpgkey.
+//
+// LoadOptions resolves every option once the flags are parsed, a flag set on the command line wins over the
+// environment (SEPTAPUS_RPG_KEY), which wins over the config file, which wins over the default.
+// Named plugins can override a namespace's option with their own environment variable or config section, see Get.
+type Options struct {
+	Namespace string
+	names     []string
+}
+
+// The sections of the config file that aren't plugin options, eg:
+//
+//	{
+//		"servers": [{"Name": "synirc", "Host": "irc.synirc.net", "Nick": "Septapus", "Rooms": ["#septapus"]}],
+//		"plugins": {"comic": {"BannedServers": ["freenode"]}, "twitch": {"Disabled": true}},
+//		"rpg": {"key": "secret"}
+//	}
+type Config struct {
+	Servers []*ServerConfig          `json:"servers"`
+	Plugins map[string]*PluginConfig `json:"plugins"`
+}
+
+// A server to connect to, Ident and RealName default to the nick.
+type ServerConfig struct {
+	Name     ServerName
+	Host     string
+	Password string
+	// Connects over TLS, on port 6697 if the host doesn't give one.
+	SSL                bool
+	InsecureSkipVerify bool
+	CertFile           string
+	KeyFile            string
+	Nick               string
+	Ident              string
+	RealName           string
+	Rooms              []string
+	Bouncer            bool
+	Version            string
+	QuitMessage        string
+	UserModes          string
+	CTCPReplies        map[string]string
+	// Identifies to services with NickServ, or SASL PLAIN when SASL is set.
+	ServicesPassword string
+	ServicesAccount  string
+	SASL             bool
+}
+
+// Where a named plugin runs. Rooms are server/#room, bans and forces are added to the plugin's saved settings.
+type PluginConfig struct {
+	Disabled      bool
+	BannedServers []ServerName
+	BannedRooms   []string
+	ForcedServers []ServerName
+	ForcedRooms   []string
+	// Where the plugin replies to messages in each room, room, pm or notice, keyed like room options, eg:
+	// {"synirc/#septapus": "notice", "*/*": "room"}.
+	Replies map[string]string
+}
+
+var (
+	optionNamespaces []*Options
+	configValues     map[string]map[string]interface{}
+	config           = &Config{}
+	optionsLock      sync.RWMutex
+)
+
+// Creates the options for a namespace, namespaces are usually the plugin's flag prefix, eg: rpg.
+func NewOptions(namespace string) *Options {
+	optionsLock.Lock()
+	defer optionsLock.Unlock()
+
+	options := &Options{Namespace: namespace}
+	optionNamespaces = append(optionNamespaces, options)
+	return options
+}
+
+func (options *Options) flagName(name string) string {
+	return options.Namespace + name
+}
+
+func (options *Options) add(name string) string {
+	optionsLock.Lock()
+	defer optionsLock.Unlock()
+
+	options.names = append(options.names, name)
+	return options.flagName(name)
+}
+
+func (options *Options) String(name, value, usage string) *string {
+	return flag.String(options.add(name), value, usage)
+}
+
+func (options *Options) Int(name string, value int, usage string) *int {
+	return flag.Int(options.add(name), value, usage)
+}
+
+func (options *Options) Bool(name string, value bool, usage string) *bool {
+	return flag.Bool(options.add(name), value, usage)
+}
+
+func (options *Options) Float64(name string, value float64, usage string) *float64 {
+	return flag.Float64(options.add(name), value, usage)
+}
+
+func (options *Options) Duration(name string, value time.Duration, usage string) *time.Duration {
+	return flag.Duration(options.add(name), value, usage)
+}
+
+// Returns the environment variable for an option, eg: SEPTAPUS_RPG_KEY.
+func optionEnv(namespace, name string) string {
+	env := strings.ToUpper("septapus_" + namespace + "_" + name)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, env)
+}
+
+// Returns an option's value from the environment or the config file, and false if neither has it.
+func configValue(namespace, name string) (string, bool) {
+	if value, ok := os.LookupEnv(optionEnv(namespace, name)); ok {
+		return value, true
+	}
+	optionsLock.RLock()
+	defer optionsLock.RUnlock()
+
+	if value, ok := configValues[namespace][name]; ok {
+		if str, ok := value.(string); ok {
+			return str, true
+		}
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
+
+// Loads the config file and resolves every option that wasn't set on the command line. Call after flag.Parse.
+func LoadOptions() error {
+	if *configfile != "" {
+		file, err := os.Open(*configfile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sections := make(map[string]json.RawMessage)
+		if err := json.NewDecoder(file).Decode(&sections); err != nil {
+			return fmt.Errorf("Error loading %v: %v", *configfile, err)
+		}
+		loaded := &Config{}
+		values := make(map[string]map[string]interface{})
+		for name, section := range sections {
+			var err error
+			switch name {
+			case "servers":
+				err = json.Unmarshal(section, &loaded.Servers)
+			case "plugins":
+				err = json.Unmarshal(section, &loaded.Plugins)
+			default:
+				options := make(map[string]interface{})
+				err = json.Unmarshal(section, &options)
+				values[name] = options
+			}
+			if err != nil {
+				return fmt.Errorf("Error loading %v %v: %v", *configfile, name, err)
+			}
+		}
+		for i, server := range loaded.Servers {
+			if server.Name == "" || server.Host == "" || server.Nick == "" {
+				return fmt.Errorf("Error loading %v: server %d needs a Name, Host and Nick", *configfile, i+1)
+			}
+		}
+		optionsLock.Lock()
+		configValues = values
+		config = loaded
+		optionsLock.Unlock()
+		logging.Info("Loaded config", *configfile)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	optionsLock.RLock()
+	namespaces := optionNamespaces
+	optionsLock.RUnlock()
+
+	for _, options := range namespaces {
+		for _, name := range options.names {
+			if set[options.flagName(name)] {
+				continue
+			}
+			if value, ok := configValue(options.Namespace, name); ok {
+				if err := flag.Set(options.flagName(name), value); err != nil {
+					return fmt.Errorf("Bad value for %v %v: %v", options.Namespace, name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns an option's value for a named plugin, eg: comic-freenode. The plugin's own environment variable or config
+// section wins, otherwise it is the namespace's resolved value.
+func (options *Options) Get(plugin, name string) string {
+	if plugin != "" && plugin != options.Namespace {
+		if value, ok := configValue(plugin, name); ok {
+			return value
+		}
+	}
+	if f := flag.Lookup(options.flagName(name)); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// Returns the servers listed in the config file, nil if it doesn't list any. Returns an error if a server's client
+// certificate can't be loaded.
+func ConfiguredServers() ([]*Server, error) {
+	optionsLock.RLock()
+	defer optionsLock.RUnlock()
+
+	if len(config.Servers) == 0 {
+		return nil, nil
+	}
+	servers := make([]*Server, len(config.Servers))
+	for i, c := range config.Servers {
+		ident, realname := c.Ident, c.RealName
+		if ident == "" {
+			ident = c.Nick
+		}
+		if realname == "" {
+			realname = c.Nick
+		}
+		options := DefaultServerOptions(realname)
+		if c.Version != "" {
+			options.Version = c.Version
+		}
+		if c.QuitMessage != "" {
+			options.QuitMessage = c.QuitMessage
+		}
+		options.UserModes = c.UserModes
+		options.ServicesPassword = c.ServicesPassword
+		options.ServicesAccount = c.ServicesAccount
+		options.SASL = c.SASL
+		for ctcp, reply := range c.CTCPReplies {
+			options.CTCPReplies[ctcp] = reply
+		}
+		server := NewServerWithOptions(string(c.Name), c.Host, c.Nick, ident, realname, c.Rooms, options)
+		server.Config.Pass = c.Password
+		if c.SSL {
+			if err := server.UseTLS(&TLSOptions{c.InsecureSkipVerify, c.CertFile, c.KeyFile}); err != nil {
+				return nil, err
+			}
+		}
+		server.Bouncer = c.Bouncer
+		servers[i] = server
+	}
+	return servers, nil
+}
+
+// Returns false if the config file turns a named plugin off.
+func PluginEnabled(name string) bool {
+	optionsLock.RLock()
+	defer optionsLock.RUnlock()
+
+	plugin := config.Plugins[name]
+	return plugin == nil || !plugin.Disabled
+}
+
+// Adds the bans and forces the config file gives a named plugin to its settings.
+func (s *PluginSettings) applyConfig() {
+	optionsLock.RLock()
+	plugin := config.Plugins[s.Name]
+	optionsLock.RUnlock()
+
+	if plugin == nil {
+		return
+	}
+	room := func(room string) (ServerName, RoomName) {
+		parts := strings.SplitN(room, "/", 2)
+		if len(parts) != 2 {
+			ReportError("config", "Bad room for plugin, use server/#room:", s.Name, room)
+			return "", ""
+		}
+		return ServerName(parts[0]), RoomName(parts[1])
+	}
+	for _, server := range plugin.BannedServers {
+		s.AddBannedServer(server)
+	}
+	for _, r := range plugin.BannedRooms {
+		if server, room := room(r); server != "" {
+			s.AddBannedRoom(server, room)
+		}
+	}
+	for _, server := range plugin.ForcedServers {
+		s.AddForcedServer(server)
+	}
+	for _, r := range plugin.ForcedRooms {
+		if server, room := room(r); server != "" {
+			s.AddForcedRoom(server, room)
+		}
+	}
+	s.SetReplies(plugin.Replies)
+}
@@ -0,0 +1,43 @@
+package septapus
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Letters from other scripts that are drawn like latin ones, so text like "lоl" with a cyrillic о still reads as the
+// latin word it imitates. Fullwidth latin letters are folded by foldConfusables itself.
+var confusables = map[rune]rune{
+	// Cyrillic.
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'ѕ': 's', 'т': 't', 'у': 'y', 'х': 'x', 'с': 'c', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'Һ': 'H', 'І': 'I', 'Ј': 'J', 'К': 'K', 'Ӏ': 'l', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'Ѕ': 'S', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'С': 'C',
+	// Greek.
+	'α': 'a', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Χ': 'X',
+	// Letterlike symbols.
+	'ℓ': 'l', 'ℎ': 'h', '\u212a': 'K', 'ⅼ': 'l',
+}
+
+// Returns text with confusable letters replaced by the latin letters they imitate, and for each byte of the result the
+// offset in text of the letter it came from, with len(text) appended so a match's end can be looked up too.
+func foldConfusables(text string) (string, []int) {
+	folded := strings.Builder{}
+	folded.Grow(len(text))
+	offsets := make([]int, 0, len(text)+1)
+	for i, r := range text {
+		if latin, ok := confusables[r]; ok {
+			r = latin
+		} else if r >= 'Ａ' && r <= 'Ｚ' {
+			r = 'A' + r - 'Ａ'
+		} else if r >= 'ａ' && r <= 'ｚ' {
+			r = 'a' + r - 'ａ'
+		}
+		folded.WriteRune(r)
+		for j := utf8.RuneLen(r); j > 0; j-- {
+			offsets = append(offsets, i)
+		}
+	}
+	return folded.String(), append(offsets, len(text))
+}
@@ -0,0 +1,263 @@
+package septapus
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sort"
+	"sync/atomic"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var controlOptions = NewOptions("control")
+
+var controladdr = controlOptions.String("addr", "", "Address to serve the JSON-RPC control API on, eg: localhost:7070. Only a loopback address is served without control.token")
+var controltoken = controlOptions.String("token", "", "Token a connection to the control API has to give to Control.Auth before calling anything else. Without one the API is only served on loopback addresses")
+
+// The control API, served as JSON-RPC 1.0 over TCP on controladdr. Methods are called as Control.<Method>, eg:
+//
+//	{"method": "Control.Auth", "params": [{"Token": "hunter2"}], "id": 1}
+//	{"method": "Control.Send", "params": [{"Server": "synirc", "Target": "#septapus", "Text": "Hello"}], "id": 2}
+//
+// Each connection has its own Control. With control.token set a connection has to call Control.Auth first, without it
+// only connections from the same machine are accepted.
+type Control struct {
+	bot *Bot
+	rpg *RPGPlugin
+	// Set once the connection has given the token, calls on a connection can run at once.
+	authed int32
+}
+
+type ControlAuth struct {
+	Token string
+}
+
+type ControlServer struct {
+	Name      ServerName
+	Connected bool
+	// The rooms we are in now, and the rooms the server was configured with.
+	Rooms      []RoomName
+	Configured []RoomName
+}
+
+type ControlRoom struct {
+	Server ServerName
+	Room   RoomName
+}
+
+type ControlMessage struct {
+	Server ServerName
+	Target string
+	Text   string
+}
+
+type ControlLifter struct {
+	Server ServerName
+	Nick   string
+}
+
+type ControlCharacter struct {
+	Name  string
+	Level int64
+	XP    int64
+}
+
+type ControlGame struct {
+	Server     ServerName
+	Room       RoomName
+	Monster    string
+	Health     int64
+	MaxHealth  int64
+	Characters []*ControlCharacter
+}
+
+type ControlPlugin struct {
+	rpg *RPGPlugin
+}
+
+// Creates the control API plugin, rpg may be nil if the rpg plugin isn't running.
+func NewControlPlugin(rpg *RPGPlugin) Plugin {
+	return &ControlPlugin{rpg}
+}
+
+// Returns true if addr is a loopback address, eg: localhost:7070 or 127.0.0.1:7070. A host that is a name is looked up.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+func (plugin *ControlPlugin) Init(bot *Bot) {
+	if *controladdr == "" {
+		return
+	}
+	if *controltoken == "" && !isLoopback(*controladdr) {
+		ReportError("control", "Not serving the control API on", *controladdr, "it isn't a loopback address and control.token isn't set")
+		return
+	}
+	listener, err := net.Listen("tcp", *controladdr)
+	if err != nil {
+		ReportError("control", "Error listening", *controladdr, err)
+		return
+	}
+	logging.Info("Serving control API on", *controladdr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			ReportError("control", "Error accepting", err)
+			return
+		}
+		if *controltoken == "" && !isLoopback(conn.RemoteAddr().String()) {
+			logging.Warn("Refused control API connection from", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		server := rpc.NewServer()
+		if err := server.RegisterName("Control", &Control{bot: bot, rpg: plugin.rpg}); err != nil {
+			ReportError("control", "Error registering control API", err)
+			conn.Close()
+			continue
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Returns an error unless the connection has given the token, or there isn't one.
+func (control *Control) authorized() error {
+	if *controltoken != "" && atomic.LoadInt32(&control.authed) == 0 {
+		return errors.New("Not authorized, call Control.Auth with control.token first.")
+	}
+	return nil
+}
+
+// Authorizes the connection if the token is control.token.
+func (control *Control) Auth(args *ControlAuth, reply *bool) error {
+	if *controltoken == "" || subtle.ConstantTimeCompare([]byte(args.Token), []byte(*controltoken)) != 1 {
+		return errors.New("Wrong token.")
+	}
+	atomic.StoreInt32(&control.authed, 1)
+	*reply = true
+	return nil
+}
+
+// Lists the servers, the rooms we are in and the rooms they were configured with.
+func (control *Control) Servers(args *struct{}, reply *[]*ControlServer) error {
+	if err := control.authorized(); err != nil {
+		return err
+	}
+	servers := make([]*ControlServer, 0)
+	for _, server := range control.bot.Servers() {
+		servers = append(servers, &ControlServer{server.Name, server.Conn != nil && server.Conn.Connected(), server.LiveRooms(), server.Rooms})
+	}
+	*reply = servers
+	return nil
+}
+
+// Lists the named plugins, the names used by !plugin.
+func (control *Control) Plugins(args *struct{}, reply *[]string) error {
+	if err := control.authorized(); err != nil {
+		return err
+	}
+	*reply = control.bot.PluginNames()
+	return nil
+}
+
+// Sends a message to a room or nick.
+func (control *Control) Send(args *ControlMessage, reply *bool) error {
+	if err := control.authorized(); err != nil {
+		return err
+	}
+	server := control.bot.GetServer(args.Server)
+	if server == nil || server.Conn == nil || !server.Conn.Connected() {
+		return fmt.Errorf("Not connected to %v.", args.Server)
+	}
+	if args.Target == "" || args.Text == "" {
+		return errors.New("A target and text are required.")
+	}
+	server.Privmsg(args.Target, args.Text)
+	*reply = true
+	return nil
+}
+
+// Returns the current monster and the characters of a room's rpg, best first.
+func (control *Control) RPG(args *ControlRoom, reply *ControlGame) error {
+	if err := control.authorized(); err != nil {
+		return err
+	}
+	if control.rpg == nil {
+		return errors.New("The rpg plugin isn't running.")
+	}
+	game := control.rpg.runningGame(args.Server, args.Room)
+	if game == nil {
+		return fmt.Errorf("No game running in %v %v.", args.Server, args.Room)
+	}
+
+	game.RLock()
+	defer game.RUnlock()
+
+	reply.Server = game.Server
+	reply.Room = game.Room
+	if game.Monster != nil {
+		reply.Monster = game.monsterName()
+		reply.Health = game.Monster.Health
+		reply.MaxHealth = game.Monster.MaxHealth
+	}
+	characters := make(Characters, 0, len(game.Characters))
+	for _, char := range game.Characters {
+		characters = append(characters, char)
+	}
+	sort.Sort(characters)
+	for _, char := range characters {
+		reply.Characters = append(reply.Characters, &ControlCharacter{char.Name, char.Level, char.XP})
+	}
+	return nil
+}
+
+// Returns a nick's lifts, private lifters included.
+func (control *Control) PR(args *ControlLifter, reply *Lifter) error {
+	if err := control.authorized(); err != nil {
+		return err
+	}
+	prs := GetPRS(args.Server)
+	if prs == nil {
+		return fmt.Errorf("The pr plugin isn't running on %v.", args.Server)
+	}
+	prs.RLock()
+	defer prs.RUnlock()
+
+	lifter := prs.GetLifter(args.Nick, false)
+	if lifter == nil {
+		return fmt.Errorf("No lifts for %v.", args.Nick)
+	}
+	// The reply is encoded after the lock is released, so it gets its own copy of the lifts.
+	data, err := json.Marshal(lifter)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, reply)
+}
+
+// Saves every running rpg game and every server's prs, returning what was saved.
+func (control *Control) Save(args *struct{}, reply *[]string) error {
+	if err := control.authorized(); err != nil {
+		return err
+	}
+	*reply = SaveAll(control.bot, control.rpg)
+	return nil
+}
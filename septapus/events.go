@@ -0,0 +1,85 @@
+package septapus
+
+import (
+	"time"
+
+	client "github.com/fluffle/goirc/client"
+)
+
+// Plugins tell each other what they have done by emitting events, named in upper case without spaces so they can't
+// clash with a server's commands and numerics. An emitted event looks like a PRIVMSG to the room it happened in, the
+// nick that did it and a line of text describing it, so anything that handles messages, eg: hooks, can handle it too.
+// Its Payload holds the typed details, one of the types below, eg:
+//
+//	for event := range bot.GetEventHandler(RPG_KILL) {
+//		kill := event.Payload.(*RPGKill)
+//		...
+//	}
+//
+// Broadcasting waits for every handler to take the event, so a plugin emitting an event while handling another
+// plugin's event should emit it in a goroutine, in case that plugin is waiting for it.
+//
+// Every emitted event is listed here with its payload.
+const (
+	// Broadcast when a monster is defeated, with an *RPGKill.
+	RPG_KILL EventName = "RPGKILL"
+	// Broadcast when a lifter sets a new PR, with a *PRNew.
+	PR_NEW EventName = "PRNEW"
+	// Broadcast when a comic has been uploaded, with a *ComicCreated.
+	COMIC_CREATED EventName = "COMICCREATED"
+	// Broadcast when a nick is given karma, with a *KarmaGiven.
+	KARMA EventName = "KARMA"
+	// Broadcast before each attempt to reconnect to a server, with a *Reconnecting. The room and nick are empty.
+	RECONNECTING EventName = "RECONNECTING"
+)
+
+// A monster slain in a room's rpg.
+type RPGKill struct {
+	Monster    string
+	Difficulty float64
+	// The name of the character that dealt the killing blow.
+	Slayer string
+	// The share of the damage dealt by each character that fought the monster, by name.
+	Contributions map[string]float64
+	Born          time.Time
+}
+
+// A lift that beat a lifter's previous best.
+type PRNew struct {
+	Lift   LiftName
+	Reps   int
+	Weight *Weight
+}
+
+// A comic made from a room's conversation.
+type ComicCreated struct {
+	// Where the comic was uploaded to.
+	URL string
+	// The nicks that appear in the comic.
+	Nicks []string
+	// The nick that set the comic off.
+	Trigger string
+}
+
+// Karma given to a nick in a room.
+type KarmaGiven struct {
+	Nick string
+	// The nick that gave the karma, empty when it was earned, eg: by slaying a monster.
+	From   string
+	Points int
+	// The nick's karma in the room now.
+	Karma int
+}
+
+// An attempt to reconnect to a server that will be made after Delay.
+type Reconnecting struct {
+	// Starts at 1 for the first attempt after a disconnect.
+	Attempt int
+	Delay   time.Duration
+}
+
+// Broadcasts an event a plugin emits, nick did what text describes in room on server at when.
+func (bot *Bot) Emit(name EventName, server *Server, room RoomName, nick, text string, when time.Time, payload interface{}) {
+	line := &client.Line{Nick: nick, Cmd: string(name), Args: []string{string(room), text}, Time: when}
+	bot.BroadcastEvent(name, &Event{Server: server, Room: room, Line: line, Time: when, Payload: payload})
+}
@@ -0,0 +1,88 @@
+package septapus
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the next event on channel, or nil if none arrives soon.
+func nextEvent(channel chan *Event) *Event {
+	select {
+	case event := <-channel:
+		return event
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestEmit(t *testing.T) {
+	bot := &Bot{}
+	server := &Server{Name: "synirc"}
+	kills := bot.GetEventHandler(RPG_KILL)
+	defer bot.RemoveEventHandler(kills)
+
+	when := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	kill := &RPGKill{Monster: "Grue", Difficulty: 1.5, Slayer: "iopred", Contributions: map[string]float64{"iopred": 1}}
+	go bot.Emit(RPG_KILL, server, "#septapus", "iopred", "iopred slew Grue", when, kill)
+
+	event := nextEvent(kills)
+	if event == nil {
+		t.Fatal("the emitted event wasn't handled")
+	}
+	if event.Server != server || event.Room != "#septapus" || !event.Time.Equal(when) {
+		t.Errorf("event is from %v %v at %v, want synirc #septapus at %v", event.Server.Name, event.Room, event.Time, when)
+	}
+	if event.Line.Cmd != string(RPG_KILL) || event.Line.Nick != "iopred" || event.Line.Text() != "iopred slew Grue" || event.Line.Target() != "#septapus" {
+		t.Errorf("event line is %v %v %q to %v, want it to look like a message to the room", event.Line.Cmd, event.Line.Nick, event.Line.Text(), event.Line.Target())
+	}
+	if payload, ok := event.Payload.(*RPGKill); !ok || payload != kill {
+		t.Errorf("event payload is %#v, want the *RPGKill", event.Payload)
+	}
+}
+
+func TestEmitOnlyReachesItsHandlers(t *testing.T) {
+	bot := &Bot{}
+	server := &Server{Name: "synirc"}
+	here := bot.GetEventHandler(PR_NEW, IsRoom("synirc", "#septapus"))
+	elsewhere := bot.GetEventHandler(PR_NEW, IsRoom("synirc", "#other"))
+	comics := bot.GetEventHandler(COMIC_CREATED)
+	defer bot.RemoveEventHandlers(here, elsewhere, comics)
+
+	pr := &PRNew{Lift: Squat, Reps: 5, Weight: &Weight{Value: 100, Unit: UNIT_KGS}}
+	go bot.Emit(PR_NEW, server, "#SEPTAPUS", "iopred", "iopred squatted 5x100kgs", time.Now(), pr)
+
+	event := nextEvent(here)
+	if event == nil {
+		t.Fatal("the emitted event wasn't handled in its room")
+	}
+	if event.Payload.(*PRNew) != pr {
+		t.Errorf("event payload is %#v, want the *PRNew", event.Payload)
+	}
+	select {
+	case event := <-elsewhere:
+		t.Errorf("a handler for another room got %v", event.Line.Cmd)
+	case event := <-comics:
+		t.Errorf("a handler for another event got %v", event.Line.Cmd)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHookMatchesEmittedEvent(t *testing.T) {
+	hook := &Hook{Event: KARMA, Room: "#septapus", Regex: "iopred"}
+	if err := hook.Init(); err != nil {
+		t.Fatal(err)
+	}
+	given := &KarmaGiven{Nick: "iopred", From: "septapus", Points: 1, Karma: 10}
+	payload := &HookPayload{KARMA, "synirc", "#septapus", "septapus", "septapus gave iopred karma", time.Now(), given}
+	if !hook.Matches(payload) {
+		t.Error("a hook for the event doesn't match it")
+	}
+	body, err := hook.Body(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"Data":{"Nick":"iopred","From":"septapus","Points":1,"Karma":10}`; !strings.Contains(string(body), want) {
+		t.Errorf("hook body %s doesn't include the payload %s", body, want)
+	}
+}
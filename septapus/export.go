@@ -0,0 +1,126 @@
+package septapus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// The first file in an export, describing what wrote it.
+const exportManifestName = "septapus-export.json"
+
+// Bumped when the layout of exports changes, not when saves change, saves carry their own versions.
+const exportFormat = 1
+
+type ExportManifest struct {
+	Format   int
+	Created  time.Time
+	OS       string
+	Versions map[string]int
+}
+
+// Writes all persisted state to a portable archive, for moving the bot to another host.
+// The bot should not be running, or its latest state may not be saved yet.
+func ExportState(filename string) error {
+	manifest, err := json.MarshalIndent(&ExportManifest{exportFormat, time.Now(), runtime.GOOS, SaveVersions()}, "", "\t")
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := writeStateArchive(file, map[string][]byte{exportManifestName: manifest}); err != nil {
+		file.Close()
+		os.Remove(filename)
+		return err
+	}
+	return file.Close()
+}
+
+// Checks an export can be loaded by this build, saves newer than this build knows how to read are refused.
+// Older saves are upgraded by their migrations when the plugins load them.
+func (manifest *ExportManifest) Check() error {
+	if manifest.Format > exportFormat {
+		return fmt.Errorf("Export format %d is newer than this build reads, %d.", manifest.Format, exportFormat)
+	}
+	versions := SaveVersions()
+	names := make([]string, 0, len(manifest.Versions))
+	for name, _ := range manifest.Versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if latest, ok := versions[name]; ok && manifest.Versions[name] > latest {
+			return fmt.Errorf("The exported %v saves are version %d, newer than this build reads, %d. Upgrade before importing.", name, manifest.Versions[name], latest)
+		}
+	}
+	return nil
+}
+
+func readExportManifest(filename string) (*ExportManifest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest *ExportManifest
+	errFound := errors.New("found")
+	err = readStateArchive(file, func(name string, r io.Reader) error {
+		if name != exportManifestName {
+			return errors.New("Not a septapus export, it has no manifest.")
+		}
+		manifest = &ExportManifest{}
+		if err := json.NewDecoder(r).Decode(manifest); err != nil {
+			return err
+		}
+		return errFound
+	})
+	if err == errFound {
+		return manifest, nil
+	}
+	if err == nil {
+		err = errors.New("Not a septapus export, it is empty.")
+	}
+	return nil, err
+}
+
+// Replaces the persisted state with an export, the current state is backed up first.
+// The bot should not be running, or it will save over the imported state.
+func ImportState(filename string) error {
+	manifest, err := readExportManifest(filename)
+	if err != nil {
+		return err
+	}
+	if err := manifest.Check(); err != nil {
+		return err
+	}
+	if _, err := CreateBackup(BACKUP_PRERESTORE); err != nil {
+		return fmt.Errorf("Not importing, the current state couldn't be backed up: %v", err)
+	}
+	for _, path := range statePaths() {
+		if err := os.RemoveAll(path.Path); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readStateArchive(file, func(name string, r io.Reader) error {
+		if name == exportManifestName {
+			_, err := io.Copy(ioutil.Discard, r)
+			return err
+		}
+		return extractStateFile(name, r)
+	})
+}
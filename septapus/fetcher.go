@@ -0,0 +1,166 @@
+package septapus
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var fetchOptions = NewOptions("fetch")
+
+var fetchhostlimit = fetchOptions.Int("hostlimit", 2, "Most requests made to the same host at once by url previews and feed watchers")
+var fetchttl = fetchOptions.Duration("ttl", 10*time.Minute, "How long fetched pages are cached before they are checked again")
+var fetchtimeout = fetchOptions.Duration("timeout", 10*time.Second, "How long to wait for a fetched page")
+
+// Sent with every request, robots.txt rules for this agent are followed.
+const fetchUserAgent = "septapus"
+
+// The most bytes read from a fetched page, titles and api responses are well within this.
+const maxFetchBytes = 1 << 20
+
+// The most responses kept in the cache, the oldest is dropped first.
+const maxFetchCache = 500
+
+// A fetched page, URL is where it ended up after redirects. Cached is true if it came from the cache without a request or after a 304.
+type FetchResponse struct {
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Cached     bool
+}
+
+type fetchCacheEntry struct {
+	response *FetchResponse
+	fetched  time.Time
+	expires  time.Time
+}
+
+// Fetches pages for plugins that talk to the web, so every plugin shares connections, limits and the cache.
+// Each host gets at most fetchhostlimit requests at once, responses are cached and revalidated with ETag and Last-Modified.
+type Fetcher struct {
+	sync.Mutex
+	client *http.Client
+	hosts  map[string]chan bool
+	cache  map[string]*fetchCacheEntry
+}
+
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		client: &http.Client{Timeout: *fetchtimeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: *fetchhostlimit}},
+		hosts:  make(map[string]chan bool),
+		cache:  make(map[string]*fetchCacheEntry),
+	}
+}
+
+var (
+	fetcher     *Fetcher
+	fetcherOnce sync.Once
+)
+
+// Returns the fetcher shared by every plugin.
+func SharedFetcher() *Fetcher {
+	fetcherOnce.Do(func() {
+		fetcher = NewFetcher()
+	})
+	return fetcher
+}
+
+// Fetches a url, using the cache for up to fetchttl.
+func (f *Fetcher) Get(url string) (*FetchResponse, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.Do(req, *fetchttl)
+}
+
+// Makes a request, a cached response younger than ttl is returned without a request. A ttl of 0 always revalidates.
+func (f *Fetcher) Do(req *http.Request, ttl time.Duration) (*FetchResponse, error) {
+	if req.Method != "GET" {
+		return nil, errors.New("Only GET requests can be fetched.")
+	}
+	key := req.URL.String()
+
+	f.Lock()
+	entry := f.cache[key]
+	f.Unlock()
+
+	if entry != nil {
+		if time.Now().Before(entry.expires) {
+			return &FetchResponse{entry.response.URL, entry.response.StatusCode, entry.response.Header, entry.response.Body, true}, nil
+		}
+		if etag := entry.response.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.response.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", fetchUserAgent)
+	}
+
+	slot := f.host(req.URL.Host)
+	slot <- true
+	defer func() { <-slot }()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		f.store(key, &fetchCacheEntry{entry.response, now, now.Add(ttl)})
+		return &FetchResponse{entry.response.URL, entry.response.StatusCode, entry.response.Header, entry.response.Body, true}, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchBytes))
+	if err != nil {
+		return nil, err
+	}
+	response := &FetchResponse{resp.Request.URL.String(), resp.StatusCode, resp.Header, body, false}
+	if resp.StatusCode == http.StatusOK {
+		f.store(key, &fetchCacheEntry{response, now, now.Add(ttl)})
+	}
+	return response, nil
+}
+
+// Returns the channel limiting requests to a host.
+func (f *Fetcher) host(host string) chan bool {
+	f.Lock()
+	defer f.Unlock()
+
+	slot := f.hosts[host]
+	if slot == nil {
+		limit := *fetchhostlimit
+		if limit < 1 {
+			limit = 1
+		}
+		slot = make(chan bool, limit)
+		f.hosts[host] = slot
+	}
+	return slot
+}
+
+func (f *Fetcher) store(key string, entry *fetchCacheEntry) {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, ok := f.cache[key]; !ok && len(f.cache) >= maxFetchCache {
+		oldest := ""
+		for k, e := range f.cache {
+			if oldest == "" || e.fetched.Before(f.cache[oldest].fetched) {
+				oldest = k
+			}
+		}
+		delete(f.cache, oldest)
+	}
+	f.cache[key] = entry
+}
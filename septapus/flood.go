@@ -0,0 +1,144 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var floodOptions = NewOptions("flood")
+
+var floodrate = floodOptions.Float64("rate", 0.5, "Messages a second sent to each server once the burst is used up, 0 to send straight away and leave flood control to the connection")
+var floodburst = floodOptions.Int("burst", 5, "Messages sent to a server at once before flood.rate applies")
+var floodqueue = floodOptions.Int("queue", 200, "Most messages waiting to be sent to each server, further messages are dropped")
+
+// The longest line a server accepts, including the prefix it adds and the trailing CRLF.
+const ircLineLength = 512
+
+// Assumed for our host while the server hasn't told us it, the longest a hostname can be.
+const maxHostLength = 63
+
+// Refills at rate tokens a second up to burst, each message takes one.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Takes a token, returns how long to wait before using it. Tokens taken early are paid back by later waits.
+func (bucket *TokenBucket) Take(now time.Time) time.Duration {
+	if !bucket.last.IsZero() {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+	}
+	bucket.last = now
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+type queuedMessage struct {
+	target string
+	hold   bool
+	write  func()
+}
+
+// Sends a server's messages in order, no faster than flood.rate after a burst of flood.burst.
+type SendQueue struct {
+	sync.Mutex
+
+	messages chan *queuedMessage
+}
+
+func NewSendQueue() *SendQueue {
+	return &SendQueue{}
+}
+
+// Queues a message, the queue is started by the first one. Returns false if the queue is full.
+func (queue *SendQueue) add(server *Server, message *queuedMessage) bool {
+	queue.Lock()
+	if queue.messages == nil {
+		queue.messages = make(chan *queuedMessage, *floodqueue)
+		go queue.run(server, NewTokenBucket(*floodrate, *floodburst))
+	}
+	queue.Unlock()
+
+	select {
+	case queue.messages <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (queue *SendQueue) run(server *Server, bucket *TokenBucket) {
+	for message := range queue.messages {
+		if wait := bucket.Take(time.Now()); wait > 0 {
+			time.Sleep(wait)
+		}
+		server.write(message.target, message.hold, message.write)
+	}
+}
+
+// Returns the longest text that can follow a command to target in one line, once the server adds our prefix.
+func (server *Server) textLength(command, target string) int {
+	nick, ident, host := server.Config.Me.Nick, server.Config.Me.Ident, ""
+	if server.Conn != nil && server.Conn.Me() != nil {
+		me := server.Conn.Me()
+		nick, ident, host = me.Nick, me.Ident, me.Host
+	}
+	if host == "" {
+		host = strings.Repeat(".", maxHostLength)
+	}
+	// :nick!ident@host COMMAND target :text\r\n
+	used := len(":"+nick+"!"+ident+"@"+host+" ") + len(command+" "+target+" :") + len("\r\n")
+	return ircLineLength - used
+}
+
+// Splits text into pieces of at most length bytes, at the last space that fits where there is one, never inside a
+// UTF-8 character.
+func splitText(text string, length int) []string {
+	if length < utf8.UTFMax {
+		length = utf8.UTFMax
+	}
+	pieces := make([]string, 0, 1)
+	for len(text) > length {
+		end := length
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		if space := strings.LastIndex(text[:end], " "); space > 0 {
+			end = space
+		}
+		pieces = append(pieces, strings.TrimRight(text[:end], " "))
+		text = strings.TrimLeft(text[end:], " ")
+	}
+	if text != "" || len(pieces) == 0 {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
+
+// Splits a message in a style so each piece fits in a line to target.
+func (server *Server) splitMessage(style MessageStyle, target, text string) []string {
+	switch style {
+	case MESSAGE_NOTICE:
+		return splitText(text, server.textLength("NOTICE", target))
+	case MESSAGE_ACTION:
+		return splitText(text, server.textLength("PRIVMSG", target)-len("\x01ACTION \x01"))
+	}
+	return splitText(text, server.textLength("PRIVMSG", target))
+}
@@ -0,0 +1,311 @@
+package septapus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var githubOptions = NewOptions("github")
+
+var githubrepos = githubOptions.String("repos", "", "Comma separated list of repositories to watch, eg: owner/repo=server/#room")
+var githubinterval = githubOptions.Duration("interval", 5*time.Minute, "How often to poll watched GitHub repositories")
+var githubtoken = githubOptions.String("token", "", "Optional GitHub API token used when polling repositories")
+var githubreleasetemplate = githubOptions.String("releasetemplate", "", "Template used to announce GitHub releases in every room, when empty the github.release response is used")
+var githubtagtemplate = githubOptions.String("tagtemplate", "", "Template used to announce GitHub tags in every room, when empty the github.tag response is used")
+var githubissuetemplate = githubOptions.String("issuetemplate", "", "Template used to announce GitHub issues in every room, when empty the github.issue response is used")
+
+type GitHubTarget struct {
+	Server ServerName
+	Room   RoomName
+}
+
+// An announcement for a server the bot hasn't added yet, eg: at startup, or while another shard holds it.
+type gitHubPending struct {
+	Announcement *GitHubAnnouncement
+	Queued       time.Time
+}
+
+type GitHubAnnouncement struct {
+	// What was announced, release, tag or issue.
+	Kind   string
+	Repo   string
+	Name   string
+	URL    string
+	User   string
+	Number int
+}
+
+// Returns the announcement in a room's language, or from the kind's template if one is set.
+func (announcement *GitHubAnnouncement) Message(server ServerName, room RoomName) (string, error) {
+	source := map[string]string{"release": *githubreleasetemplate, "tag": *githubtagtemplate, "issue": *githubissuetemplate}[announcement.Kind]
+	if source != "" {
+		return executeGitHubTemplate(source, announcement)
+	}
+	return Response(server, room, "", "github."+announcement.Kind, ResponseVars{"Repo": announcement.Repo, "Name": announcement.Name, "URL": announcement.URL, "User": announcement.User, "Number": announcement.Number}), nil
+}
+
+type gitHubRelease struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	TagName string `json:"tag_name"`
+	URL     string `json:"html_url"`
+	Author  struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+type gitHubTag struct {
+	Name string `json:"name"`
+}
+
+type gitHubIssue struct {
+	Number      int    `json:"number"`
+	Title       string `json:"title"`
+	URL         string `json:"html_url"`
+	PullRequest *struct {
+		URL string `json:"url"`
+	} `json:"pull_request"`
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+type GitHubWatcher struct {
+	Repo    string
+	Targets []*GitHubTarget
+
+	seeded   bool
+	releases map[int64]bool
+	tags     map[string]bool
+	issues   map[int]bool
+}
+
+func NewGitHubWatcher(repo string) *GitHubWatcher {
+	return &GitHubWatcher{
+		Repo:     repo,
+		releases: make(map[int64]bool),
+		tags:     make(map[string]bool),
+		issues:   make(map[int]bool),
+	}
+}
+
+// Parses a list of owner/repo=server/#room mappings. A repository may be mapped to several rooms.
+func ParseGitHubWatchers(str string) ([]*GitHubWatcher, error) {
+	watchers := make([]*GitHubWatcher, 0)
+	byRepo := make(map[string]*GitHubWatcher)
+	for _, mapping := range strings.Split(str, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad repository mapping: %v", mapping)
+		}
+		target := strings.SplitN(parts[1], "/", 2)
+		if len(target) != 2 || target[0] == "" || target[1] == "" {
+			return nil, fmt.Errorf("Bad repository target: %v", parts[1])
+		}
+		watcher := byRepo[parts[0]]
+		if watcher == nil {
+			watcher = NewGitHubWatcher(parts[0])
+			byRepo[parts[0]] = watcher
+			watchers = append(watchers, watcher)
+		}
+		watcher.Targets = append(watcher.Targets, &GitHubTarget{ServerName(target[0]), RoomName(target[1])})
+	}
+	return watchers, nil
+}
+
+func gitHubGet(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", "https://api.github.com/repos/"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if *githubtoken != "" {
+		req.Header.Set("Authorization", "token "+*githubtoken)
+	}
+	// Always revalidated, GitHub doesn't count 304 responses against the rate limit.
+	resp, err := SharedFetcher().Do(req, 0)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %v for %v", resp.StatusCode, path)
+	}
+	return json.Unmarshal(resp.Body, v)
+}
+
+// Polls the repository, returning the announcements for anything that has not been seen before.
+// The first poll only records the current state so a restart does not flood the channel.
+// Nothing is marked seen unless every fetch succeeds, so a failed poll is announced in full by the next one.
+func (watcher *GitHubWatcher) Poll() ([]*GitHubAnnouncement, error) {
+	var releases []*gitHubRelease
+	if err := gitHubGet(watcher.Repo+"/releases", &releases); err != nil {
+		return nil, err
+	}
+	var tags []*gitHubTag
+	if err := gitHubGet(watcher.Repo+"/tags", &tags); err != nil {
+		return nil, err
+	}
+	var issues []*gitHubIssue
+	if err := gitHubGet(watcher.Repo+"/issues?state=all&sort=created", &issues); err != nil {
+		return nil, err
+	}
+
+	announcements := make([]*GitHubAnnouncement, 0)
+	announce := func(announcement *GitHubAnnouncement) {
+		if watcher.seeded {
+			announcements = append(announcements, announcement)
+		}
+	}
+
+	for i := len(releases) - 1; i >= 0; i-- {
+		release := releases[i]
+		if watcher.releases[release.ID] {
+			continue
+		}
+		watcher.releases[release.ID] = true
+		name := release.Name
+		if name == "" {
+			name = release.TagName
+		}
+		announce(&GitHubAnnouncement{"release", watcher.Repo, name, release.URL, release.Author.Login, 0})
+	}
+
+	for i := len(tags) - 1; i >= 0; i-- {
+		tag := tags[i]
+		if watcher.tags[tag.Name] {
+			continue
+		}
+		watcher.tags[tag.Name] = true
+		announce(&GitHubAnnouncement{"tag", watcher.Repo, tag.Name, "https://github.com/" + watcher.Repo + "/releases/tag/" + tag.Name, "", 0})
+	}
+
+	for i := len(issues) - 1; i >= 0; i-- {
+		issue := issues[i]
+		if issue.PullRequest != nil || watcher.issues[issue.Number] {
+			continue
+		}
+		watcher.issues[issue.Number] = true
+		announce(&GitHubAnnouncement{"issue", watcher.Repo, issue.Title, issue.URL, issue.User.Login, issue.Number})
+	}
+
+	watcher.seeded = true
+	return announcements, nil
+}
+
+func executeGitHubTemplate(source string, announcement *GitHubAnnouncement) (string, error) {
+	t, err := template.New("github").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, announcement); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func NewGitHubPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(GitHubPlugin, settings)
+}
+
+func GitHubPlugin(bot *Bot, settings *PluginSettings) {
+	watchers, err := ParseGitHubWatchers(*githubrepos)
+	if err != nil {
+		logging.Error("Error parsing github repositories:", err)
+		return
+	}
+	if len(watchers) == 0 {
+		return
+	}
+
+	// Announcements are marked seen when they are polled, so they are kept until they can be delivered. Messages to a
+	// disconnected server wait in its outbox, and messages to a server that hasn't been added wait here until it
+	// connects, both for up to outboxsize messages and outboxttl.
+	pending := make(map[GitHubTarget][]gitHubPending)
+	deliver := func(target GitHubTarget, announcements []gitHubPending) {
+		if !settings.IsAllowed(target.Server, target.Room) {
+			return
+		}
+		server := bot.GetServer(target.Server)
+		if server == nil {
+			pending[target] = append(pending[target], announcements...)
+			if *outboxsize <= 0 {
+				delete(pending, target)
+			} else if len(pending[target]) > *outboxsize {
+				pending[target] = pending[target][len(pending[target])-*outboxsize:]
+			}
+			return
+		}
+		for _, announcement := range announcements {
+			if time.Since(announcement.Queued) >= *outboxttl {
+				continue
+			}
+			message, err := announcement.Announcement.Message(target.Server, target.Room)
+			if err != nil {
+				ReportError("github", "Error executing github template:", err)
+				continue
+			}
+			settings.Privmsg(server, string(target.Room), message)
+		}
+	}
+
+	poll := func() {
+		for _, watcher := range watchers {
+			announcements, err := watcher.Poll()
+			if err != nil {
+				ReportError("github", "Error polling github repository", watcher.Repo, err)
+				continue
+			}
+			queued := make([]gitHubPending, len(announcements))
+			for i, announcement := range announcements {
+				queued[i] = gitHubPending{announcement, time.Now()}
+			}
+			for _, target := range watcher.Targets {
+				deliver(*target, queued)
+			}
+		}
+	}
+
+	// Closed when the bot shuts down.
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
+	defer bot.RemoveEventHandler(disconnectchan)
+	connectchan := bot.GetEventHandler(client.CONNECTED)
+	defer bot.RemoveEventHandler(connectchan)
+
+	ticker := time.NewTicker(*githubinterval)
+	defer ticker.Stop()
+
+	poll()
+	for {
+		select {
+		case _, ok := <-disconnectchan:
+			if !ok {
+				return
+			}
+		case event, ok := <-connectchan:
+			if !ok {
+				return
+			}
+			for target, announcements := range pending {
+				if target.Server == event.Server.Name {
+					delete(pending, target)
+					deliver(target, announcements)
+				}
+			}
+		case <-ticker.C:
+			poll()
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package septapus
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var highlightOptions = NewOptions("highlight")
+
+var highlightowner = highlightOptions.String("owner", "", "Nick of the owner, messages mentioning it are forwarded when the owner is not in the room")
+var highlightkeywords = highlightOptions.String("keywords", "", "Comma separated list of keywords that are forwarded when the owner is not in the room")
+var highlightsmtp = highlightOptions.String("smtp", "", "SMTP server used to email highlights, eg: smtp.gmail.com:587")
+var highlightsmtpuser = highlightOptions.String("smtpuser", "", "SMTP username used to email highlights")
+var highlightsmtppass = highlightOptions.String("smtppass", "", "SMTP password used to email highlights")
+var highlightemail = highlightOptions.String("email", "", "Address to email highlights to")
+var pushovertoken = highlightOptions.String("pushovertoken", "", "Pushover application token used to send highlights")
+var pushoveruser = highlightOptions.String("pushoveruser", "", "Pushover user key to send highlights to")
+var telegramtoken = highlightOptions.String("telegramtoken", "", "Telegram bot token used to send highlights")
+var telegramchat = highlightOptions.String("telegramchat", "", "Telegram chat id to send highlights to")
+var highlighttimeout = highlightOptions.Duration("timeout", 10*time.Second, "How long a notification service has to take a highlight before it is given up on")
+var highlightqueue = highlightOptions.Int("queue", 20, "Most highlights waiting to be forwarded, more are dropped while the notification services are slow")
+
+var (
+	notificationClient     *http.Client
+	notificationClientOnce sync.Once
+)
+
+// A highlight waiting to be forwarded to the notifiers.
+type Highlight struct {
+	Subject, Message string
+}
+
+type Notifier interface {
+	Notify(subject, message string) error
+}
+
+type EmailNotifier struct {
+	Server, User, Password, To string
+}
+
+// Sends the email like smtp.SendMail, but gives up after highlighttimeout.
+func (n *EmailNotifier) Notify(subject, message string) error {
+	host := strings.Split(n.Server, ":")[0]
+	conn, err := net.DialTimeout("tcp", n.Server, *highlighttimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(*highlighttimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if err := c.Auth(smtp.PlainAuth("", n.User, n.Password, host)); err != nil {
+		return err
+	}
+	if err := c.Mail(n.User); err != nil {
+		return err
+	}
+	if err := c.Rcpt(n.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "To: %v\r\nSubject: %v\r\n\r\n%v\r\n", n.To, subject, message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+type PushoverNotifier struct {
+	Token, User string
+}
+
+func (n *PushoverNotifier) Notify(subject, message string) error {
+	return postNotification("https://api.pushover.net/1/messages.json", url.Values{"token": {n.Token}, "user": {n.User}, "title": {subject}, "message": {message}})
+}
+
+type TelegramNotifier struct {
+	Token, Chat string
+}
+
+func (n *TelegramNotifier) Notify(subject, message string) error {
+	return postNotification("https://api.telegram.org/bot"+n.Token+"/sendMessage", url.Values{"chat_id": {n.Chat}, "text": {subject + "\n" + message}})
+}
+
+// Posts to a notification service, giving up after highlighttimeout.
+func postNotification(endpoint string, values url.Values) error {
+	notificationClientOnce.Do(func() {
+		notificationClient = &http.Client{Timeout: *highlighttimeout}
+	})
+	resp, err := notificationClient.PostForm(endpoint, values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status from notification service: %v", resp.Status)
+	}
+	return nil
+}
+
+// Returns a notifier for every service configured through flags.
+func ConfiguredNotifiers() []Notifier {
+	notifiers := make([]Notifier, 0)
+	if *highlightsmtp != "" && *highlightemail != "" {
+		notifiers = append(notifiers, &EmailNotifier{*highlightsmtp, *highlightsmtpuser, *highlightsmtppass, *highlightemail})
+	}
+	if *pushovertoken != "" && *pushoveruser != "" {
+		notifiers = append(notifiers, &PushoverNotifier{*pushovertoken, *pushoveruser})
+	}
+	if *telegramtoken != "" && *telegramchat != "" {
+		notifiers = append(notifiers, &TelegramNotifier{*telegramtoken, *telegramchat})
+	}
+	return notifiers
+}
+
+// Returns true if text contains the owner's nick or any of the keywords, ignoring case.
+func IsHighlight(text, owner string, keywords []string) bool {
+	text = strings.ToLower(text)
+	if owner != "" && strings.Contains(text, strings.ToLower(owner)) {
+		return true
+	}
+	for _, keyword := range keywords {
+		if keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewHighlightPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(HighlightPlugin, settings)
+}
+
+func HighlightPlugin(bot *Bot, settings *PluginSettings) {
+	notifiers := ConfiguredNotifiers()
+	if len(notifiers) == 0 || (*highlightowner == "" && *highlightkeywords == "") {
+		return
+	}
+	keywords := strings.Split(*highlightkeywords, ",")
+	for i, keyword := range keywords {
+		keywords[i] = strings.TrimSpace(keyword)
+	}
+
+	// Highlights are forwarded one at a time, so slow services can't pile up requests, and dropped when too many are waiting.
+	highlights := make(chan *Highlight, *highlightqueue)
+	defer close(highlights)
+	go func() {
+		for highlight := range highlights {
+			for _, notifier := range notifiers {
+				if err := notifier.Notify(highlight.Subject, highlight.Message); err != nil {
+					ReportError("highlight", "Error forwarding highlight:", err)
+				}
+			}
+		}
+	}()
+
+	presence := NewPresence()
+	handlers := []chan *Event{settings.GetEventHandler(bot, client.PRIVMSG)}
+	for _, name := range PresenceEvents() {
+		handlers = append(handlers, settings.GetEventHandler(bot, name))
+	}
+
+	for event := range Merge(handlers...) {
+		if event.Line.Cmd != client.PRIVMSG {
+			presence.Handle(event)
+			continue
+		}
+		if !event.Line.Public() || NameKey(event.Line.Nick) == NameKey(*highlightowner) || event.Server.CatchingUp(event) {
+			continue
+		}
+		if *highlightowner != "" && presence.IsPresent(event.Server.Name, event.Room, *highlightowner) {
+			continue
+		}
+		text := event.Line.Text()
+		if !IsHighlight(text, *highlightowner, keywords) {
+			continue
+		}
+		highlight := &Highlight{fmt.Sprintf("Highlight in %v/%v", event.Server.Name, event.Room), fmt.Sprintf("<%v> %v", event.Line.Nick, text)}
+		select {
+		case highlights <- highlight:
+		default:
+			logging.Info("Highlight queue full, dropping highlight in", event.Server.Name, event.Room)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package septapus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var hooksOptions = NewOptions("hooks")
+
+var hooksfile = hooksOptions.String("file", "hooks.json", "File containing the list of external hooks to run on events")
+
+var hookstimeout = hooksOptions.Duration("timeout", 10*time.Second, "Longest a hook's post or command may take before it is abandoned")
+var hooksworkers = hooksOptions.Int("workers", 4, "Number of hooks fired at once, the rest wait in the queue")
+var hooksqueue = hooksOptions.Int("queue", 100, "Most hooks waiting to be fired, hooks are dropped while the queue is full")
+
+// A hook is fired for every event with a matching name, server, room and text.
+// When a URL is set the payload is POSTed to it, when a Command is set it is run with the payload on stdin.
+// Payload is an optional text/template executed with a HookPayload, the default is the JSON encoded HookPayload.
+type Hook struct {
+	Event   EventName
+	Server  ServerName
+	Room    RoomName
+	Regex   string
+	URL     string
+	Command []string
+	Payload string
+
+	regex   *regexp.Regexp
+	payload *template.Template
+}
+
+type HookPayload struct {
+	Event  EventName
+	Server ServerName
+	Room   RoomName
+	Nick   string
+	Text   string
+	Time   time.Time
+	// The payload of an event a plugin emits, see events.go.
+	Data interface{} `json:",omitempty"`
+}
+
+func (hook *Hook) Init() error {
+	if hook.Regex != "" {
+		regex, err := regexp.Compile(hook.Regex)
+		if err != nil {
+			return err
+		}
+		hook.regex = regex
+	}
+	if hook.Payload != "" {
+		payload, err := template.New(string(hook.Event)).Parse(hook.Payload)
+		if err != nil {
+			return err
+		}
+		hook.payload = payload
+	}
+	return nil
+}
+
+func (hook *Hook) Matches(payload *HookPayload) bool {
+	if hook.Server != "" && hook.Server != ALL_SERVERS && hook.Server != payload.Server {
+		return false
+	}
+	if hook.Room != "" && hook.Room != ALL_ROOMS && !SameRoom(payload.Server, hook.Room, payload.Room) {
+		return false
+	}
+	return hook.regex == nil || hook.regex.MatchString(payload.Text)
+}
+
+func (hook *Hook) Body(payload *HookPayload) ([]byte, error) {
+	if hook.payload == nil {
+		return json.Marshal(payload)
+	}
+	b := &bytes.Buffer{}
+	if err := hook.payload.Execute(b, payload); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Posts the payload to the hook's URL and runs its command, each is abandoned after the timeout.
+func (hook *Hook) Fire(client *http.Client, payload *HookPayload, timeout time.Duration) {
+	body, err := hook.Body(payload)
+	if err != nil {
+		ReportError("hooks", "Error creating hook payload:", err)
+		return
+	}
+	if hook.URL != "" {
+		if resp, err := client.Post(hook.URL, "application/json", bytes.NewReader(body)); err != nil {
+			ReportError("hooks", "Error posting hook:", err)
+		} else {
+			resp.Body.Close()
+		}
+	}
+	if len(hook.Command) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(body)
+		if err := cmd.Run(); err != nil {
+			ReportError("hooks", "Error running hook command:", err)
+		}
+	}
+}
+
+func LoadHooks(filename string) ([]*Hook, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hooks []*Hook
+	if err := json.NewDecoder(file).Decode(&hooks); err != nil {
+		return nil, err
+	}
+	for _, hook := range hooks {
+		if err := hook.Init(); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
+
+func NewHooksPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(HooksPlugin, settings)
+}
+
+func HooksPlugin(bot *Bot, settings *PluginSettings) {
+	hooks, err := LoadHooks(*hooksfile)
+	if err != nil {
+		logging.Info("Error loading hooks", *hooksfile, err)
+		return
+	}
+
+	byEvent := make(map[EventName][]*Hook)
+	for _, hook := range hooks {
+		byEvent[hook.Event] = append(byEvent[hook.Event], hook)
+	}
+
+	// Hooks are fired by a few workers, so a burst of events can't start unbounded posts and commands.
+	type firing struct {
+		hook    *Hook
+		payload *HookPayload
+	}
+	queue := make(chan firing, *hooksqueue)
+	client := &http.Client{Timeout: *hookstimeout}
+	for i := 0; i < *hooksworkers; i++ {
+		go func() {
+			for f := range queue {
+				f.hook.Fire(client, f.payload, *hookstimeout)
+			}
+		}()
+	}
+
+	for name, eventHooks := range byEvent {
+		go func(name EventName, eventHooks []*Hook) {
+			channel := settings.GetEventHandler(bot, name)
+			for event := range channel {
+				payload := &HookPayload{name, event.Server.Name, event.Room, event.Line.Nick, event.Line.Text(), event.Time, event.Payload}
+				for _, hook := range eventHooks {
+					if !hook.Matches(payload) {
+						continue
+					}
+					select {
+					case queue <- firing{hook, payload}:
+					default:
+						ReportError("hooks", "Hook queue is full, dropping", name, "hook for", payload.Server, payload.Room)
+					}
+				}
+			}
+		}(name, eventHooks)
+	}
+}
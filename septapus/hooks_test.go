@@ -0,0 +1,25 @@
+package septapus
+
+import "testing"
+
+func TestHookMatches(t *testing.T) {
+	hook := &Hook{Event: "PRIVMSG", Server: "synirc", Room: "#Septapus", Regex: "^!deploy"}
+	if err := hook.Init(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		payload *HookPayload
+		want    bool
+	}{
+		{&HookPayload{Server: "synirc", Room: "#septapus", Text: "!deploy now"}, true},
+		{&HookPayload{Server: "synirc", Room: "#SEPTAPUS", Text: "!deploy"}, true},
+		{&HookPayload{Server: "synirc", Room: "#other", Text: "!deploy"}, false},
+		{&HookPayload{Server: "freenode", Room: "#septapus", Text: "!deploy"}, false},
+		{&HookPayload{Server: "synirc", Room: "#septapus", Text: "deploy"}, false},
+	}
+	for _, test := range tests {
+		if got := hook.Matches(test.payload); got != test.want {
+			t.Errorf("Matches(%v %v %q) = %v, want %v", test.payload.Server, test.payload.Room, test.payload.Text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package septapus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var imageOptions = NewOptions("image")
+
+var imagetesseract = imageOptions.String("tesseract", "", "Path to tesseract, text in shared images is read with it and sent with the preview, eg: /usr/bin/tesseract")
+var imageapi = imageOptions.String("api", "", "Url of a service that describes images, the image is POSTed to it and the response body should be a short description. Used instead of tesseract")
+var imageapikey = imageOptions.String("apikey", "", "Bearer token sent to the image description service")
+var imagelength = imageOptions.Int("length", 200, "Longest image text or description sent")
+
+// How long tesseract or the description service gets for an image.
+const imageTextTimeout = 30 * time.Second
+
+// Returns true if shared images are described.
+func ImageTextEnabled() bool {
+	return *imageapi != "" || *imagetesseract != ""
+}
+
+// Returns a short description of an image, or the text in it, ready to send with the preview.
+// Returns an empty string if the url isn't an image or nothing could be read from it.
+func DescribeImage(link string) (string, error) {
+	if !ImageTextEnabled() {
+		return "", nil
+	}
+	if *urlrobots && !RobotsAllowed(link) {
+		return "", ErrDisallowed
+	}
+	resp, err := SharedFetcher().Get(link)
+	if err != nil {
+		return "", err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "image/") {
+		return "", nil
+	}
+	// The fetcher stops reading at maxFetchBytes, a truncated image can't be read.
+	if len(resp.Body) >= maxFetchBytes {
+		return "", errors.New("Image too large to describe.")
+	}
+
+	prefix := "Image: "
+	var text string
+	if *imageapi != "" {
+		text, err = describeImageAPI(resp.Body, contentType)
+	} else {
+		prefix = "Image text: "
+		text, err = readImageText(resp.Body)
+	}
+	if err != nil {
+		return "", err
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return "", nil
+	}
+	if runes := []rune(text); *imagelength > 3 && len(runes) > *imagelength {
+		text = string(runes[:*imagelength-3]) + "..."
+	}
+	return prefix + text, nil
+}
+
+// Posts an image to the description service.
+func describeImageAPI(image []byte, contentType string) (string, error) {
+	req, err := http.NewRequest("POST", *imageapi, bytes.NewReader(image))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if *imageapikey != "" {
+		req.Header.Set("Authorization", "Bearer "+*imageapikey)
+	}
+	client := &http.Client{Timeout: imageTextTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected status from image service: %v", resp.Status)
+	}
+	return string(body), nil
+}
+
+// Reads the text in an image with tesseract, the image is passed on stdin.
+func readImageText(image []byte) (string, error) {
+	cmd := exec.Command(*imagetesseract, "stdin", "stdout")
+	cmd.Stdin = bytes.NewReader(image)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return "", err
+		}
+	case <-time.After(imageTextTimeout):
+		cmd.Process.Kill()
+		return "", errors.New("Timed out reading image text.")
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,80 @@
+package septapus
+
+import (
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+// Tracks the goroutine a plugin runs for each room it joins, so rejoining a room, eg: after a kick, doesn't start a
+// second copy that plays with the same save file.
+type RoomInstances struct {
+	sync.Mutex
+	joins map[ServerName]map[RoomName]chan *Event
+}
+
+func NewRoomInstances() *RoomInstances {
+	return &RoomInstances{joins: make(map[ServerName]map[RoomName]chan *Event)}
+}
+
+// Runs start for a self join, unless it is already running in the room, then the running instance is sent the join
+// on its joins channel instead. A join sent while the instance is stopping starts it again once it has stopped.
+// Nothing is started for a server that has been removed, instances are counted in Server.running until they stop.
+func (instances *RoomInstances) Join(event *Event, start func(event *Event, joins <-chan *Event)) {
+	server := event.Server.Name
+	room := FoldRoom(server, RoomName(event.Line.Target()))
+
+	instances.Lock()
+	defer instances.Unlock()
+
+	if event.Server.ctx.Err() != nil {
+		return
+	}
+
+	if joins := instances.joins[server][room]; joins != nil {
+		select {
+		case joins <- event:
+		default:
+			// A join is already waiting, the instance only needs to hear about one.
+		}
+		return
+	}
+	if instances.joins[server] == nil {
+		instances.joins[server] = make(map[RoomName]chan *Event)
+	}
+	joins := make(chan *Event, 1)
+	instances.joins[server][room] = joins
+	event.Server.running.Add(1)
+	go func() {
+		defer event.Server.running.Done()
+		instances.run(server, room, event, joins, start)
+	}()
+}
+
+// Returns true if an instance is running in the room.
+func (instances *RoomInstances) Running(server ServerName, room RoomName) bool {
+	instances.Lock()
+	defer instances.Unlock()
+
+	return instances.joins[server][FoldRoom(server, room)] != nil
+}
+
+func (instances *RoomInstances) run(server ServerName, room RoomName, event *Event, joins chan *Event, start func(event *Event, joins <-chan *Event)) {
+	for {
+		start(event, joins)
+
+		instances.Lock()
+		select {
+		case event = <-joins:
+		default:
+			event = nil
+		}
+		if event == nil || event.Server.ctx.Err() != nil {
+			delete(instances.joins[server], room)
+			instances.Unlock()
+			return
+		}
+		instances.Unlock()
+		logging.Info("Joined again while stopping, restarting in", server, room)
+	}
+}
@@ -0,0 +1,206 @@
+package septapus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var joinOptions = NewOptions("join")
+
+var joindelay = joinOptions.Duration("delay", time.Second, "Delay between each room joined after connecting, so joining many rooms doesn't trip the server's flood limits")
+var joinimportant = joinOptions.String("important", "", "Comma separated list of rooms joined before the others after connecting, eg: synirc/#septapus=on")
+var joinretries = joinOptions.Int("retries", 5, "How many times a join that fails because the room is full, invite only or keyed is retried")
+var joinretrydelay = joinOptions.Duration("retrydelay", time.Minute, "Delay before a failed join is retried, doubled after each retry")
+
+var servicesOptions = NewOptions("services")
+
+var servicespasswords = servicesOptions.String("passwords", "", "Comma separated list of the NickServ password for our nick on each server, eg: synirc=secret. We identify with it each time we connect, before joining rooms, and rooms we are banned from or that need a registered nick are retried after identifying and asking ChanServ to invite or unban us")
+var servicessasl = servicesOptions.String("sasl", "", "Comma separated list of servers we log in to with SASL PLAIN while connecting, instead of identifying to NickServ afterwards, eg: synirc=on")
+
+// Numerics for joins that fail. Full, invite only and keyed rooms can let us in later, the rest need services or a person.
+const (
+	ERR_CHANNELISFULL  = "471"
+	ERR_INVITEONLYCHAN = "473"
+	ERR_BANNEDFROMCHAN = "474"
+	ERR_BADCHANNELKEY  = "475"
+	ERR_NEEDREGGEDNICK = "477"
+)
+
+const nickServ = "NickServ"
+
+var (
+	joinImportantRooms RoomValues
+	servicesPasswords  RoomValues
+	servicesSASL       RoomValues
+	joinOptionsOnce    sync.Once
+)
+
+func isImportantRoom(server ServerName, room RoomName) bool {
+	joinOptionsOnce.Do(parseJoinOptions)
+	value, ok := joinImportantRooms.Get(server, room)
+	return ok && value == "on"
+}
+
+// Returns our NickServ password on a server, or an empty string if we don't have one.
+func servicesPassword(server ServerName) string {
+	joinOptionsOnce.Do(parseJoinOptions)
+	password, _ := servicesPasswords.Get(server, ALL_ROOMS)
+	return password
+}
+
+// Returns true if -services.sasl has us log in to a server with SASL.
+func servicesUseSASL(server ServerName) bool {
+	joinOptionsOnce.Do(parseJoinOptions)
+	value, _ := servicesSASL.Get(server, ALL_ROOMS)
+	return value == "on"
+}
+
+func parseJoinOptions() {
+	joinImportantRooms = ParseRoomValues(*joinimportant)
+	servicesPasswords = ParseRoomValues(*servicespasswords)
+	servicesSASL = ParseRoomValues(*servicessasl)
+}
+
+// Rooms to join, the important rooms first and the rest in the order they were given.
+type joinOrder struct {
+	server ServerName
+	rooms  []RoomName
+}
+
+func (j joinOrder) Len() int      { return len(j.rooms) }
+func (j joinOrder) Swap(a, b int) { j.rooms[a], j.rooms[b] = j.rooms[b], j.rooms[a] }
+func (j joinOrder) Less(a, b int) bool {
+	return isImportantRoom(j.server, j.rooms[a]) && !isImportantRoom(j.server, j.rooms[b])
+}
+
+// Joins rooms one at a time, joindelay apart, important rooms first, once we have identified to services.
+// Stops early if stop is closed.
+func joinRooms(server *Server, rooms []RoomName, stop chan bool) {
+	if !server.waitForServices(stop) {
+		return
+	}
+	ordered := make([]RoomName, len(rooms))
+	copy(ordered, rooms)
+	sort.Stable(joinOrder{server.Name, ordered})
+	for i, room := range ordered {
+		if i > 0 {
+			select {
+			case <-time.After(*joindelay):
+			case <-stop:
+				return
+			}
+		}
+		server.Conn.Join(string(room))
+	}
+}
+
+// Retries a failed join after a delay that doubles with each attempt, unless stop is closed first.
+func retryJoin(server *Server, room RoomName, attempt int, stop chan bool) {
+	delay := *joinretrydelay << uint(attempt-1)
+	logging.Info("Retrying join", server.Name, room, "attempt", attempt, "in", delay)
+	select {
+	case <-time.After(delay):
+		server.Conn.Join(string(room))
+	case <-stop:
+	}
+}
+
+// Retries joins that can succeed later and tells the owner about rooms we can't get into. With a services password
+// we identify and ask ChanServ to invite or unban us first, so rooms we are banned from or that need a registered nick
+// are retried too.
+func JoinFailurePlugin(bot *Bot, settings *PluginSettings) {
+	// Closed when a server disconnects, to stop its pending retries.
+	stops := make(map[ServerName]chan bool)
+	retries := make(map[ServerName]map[RoomName]int)
+	identified := make(map[ServerName]bool)
+	reset := func(server ServerName) {
+		if stop := stops[server]; stop != nil {
+			close(stop)
+		}
+		stops[server] = make(chan bool)
+		retries[server] = make(map[RoomName]int)
+		delete(identified, server)
+	}
+
+	failed := func(event *Event) {
+		if len(event.Line.Args) < 2 {
+			return
+		}
+		server, room, reason := event.Server, RoomName(event.Line.Args[1]), event.Line.Text()
+		if stops[server.Name] == nil {
+			reset(server.Name)
+		}
+		key := FoldRoom(server.Name, room)
+		attempt := retries[server.Name][key] + 1
+		retries[server.Name][key] = attempt
+		logging.Info("Couldn't join", server.Name, room, reason)
+
+		password := server.servicesPassword()
+		switch event.Line.Cmd {
+		case ERR_BANNEDFROMCHAN, ERR_NEEDREGGEDNICK:
+			if password == "" {
+				if attempt == 1 {
+					NotifyOwner(fmt.Sprintf("Can't join %v %v: %v", server.Name, room, reason))
+				}
+				return
+			}
+		}
+		if attempt > *joinretries {
+			if attempt == *joinretries+1 {
+				NotifyOwner(fmt.Sprintf("Gave up joining %v %v after %d attempts: %v", server.Name, room, attempt, reason))
+			}
+			return
+		}
+		if password != "" {
+			if !identified[server.Name] && !server.LoggedIn() {
+				server.Privmsg(nickServ, "IDENTIFY "+password)
+				identified[server.Name] = true
+			}
+			switch event.Line.Cmd {
+			case ERR_BANNEDFROMCHAN:
+				server.Privmsg(chanServ, "UNBAN "+string(room))
+			case ERR_INVITEONLYCHAN:
+				server.Privmsg(chanServ, "INVITE "+string(room))
+			}
+		}
+		go retryJoin(server, room, attempt, stops[server.Name])
+	}
+
+	failures := make(chan *Event)
+	for _, numeric := range []EventName{ERR_CHANNELISFULL, ERR_INVITEONLYCHAN, ERR_BANNEDFROMCHAN, ERR_BADCHANNELKEY, ERR_NEEDREGGEDNICK} {
+		go func(channel chan *Event) {
+			for event := range channel {
+				failures <- event
+			}
+		}(bot.GetEventHandler(numeric))
+	}
+	connectchan := bot.GetEventHandler(client.CONNECTED)
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
+	joinchan := bot.GetEventHandler(client.JOIN, IsSelf())
+	for {
+		select {
+		case event, ok := <-connectchan:
+			if !ok {
+				return
+			}
+			reset(event.Server.Name)
+		case event, ok := <-disconnectchan:
+			if !ok {
+				return
+			}
+			reset(event.Server.Name)
+		case event, ok := <-joinchan:
+			if !ok {
+				return
+			}
+			delete(retries[event.Server.Name], FoldRoom(event.Server.Name, event.Room))
+		case event := <-failures:
+			failed(event)
+		}
+	}
+}
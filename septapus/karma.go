@@ -0,0 +1,217 @@
+package septapus
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var karmaOptions = NewOptions("karma")
+
+var karmaslay = karmaOptions.String("slay", "", "Comma separated list of rooms and the karma given to whoever slays a monster in the room's rpg, eg: synirc/#septapus=1")
+var karmacooldown = karmaOptions.Duration("cooldown", 10*time.Minute, "How long before someone can give the same nick karma again in a room")
+
+var karmaCommand = NewCommand("!karma [nick]").WithHelp("Shows your karma or a nick's, give karma with nick++.")
+
+// Gives a nick karma, eg: iopred++
+var karmaRegex = regexp.MustCompile(`^([^\s+]+)\+\+$`)
+
+var (
+	karmaSlayRooms     RoomValues
+	karmaSlayRoomsOnce sync.Once
+)
+
+// Returns the karma given for slaying a monster in a room, 0 if slays don't give karma.
+func karmaForSlay(server ServerName, room RoomName) int {
+	karmaSlayRoomsOnce.Do(func() {
+		karmaSlayRooms = ParseRoomValues(*karmaslay)
+	})
+	value, _ := karmaSlayRooms.Get(server, room)
+	karma, _ := strconv.Atoi(value)
+	return karma
+}
+
+type NickKarma struct {
+	Nick  string
+	Karma int
+}
+
+// The karma of every nick in every room, saved to karma.json.
+type KarmaStore struct {
+	sync.RWMutex
+	Rooms map[ServerName]map[RoomName]map[string]*NickKarma
+}
+
+func NewKarmaStore() *KarmaStore {
+	return &KarmaStore{Rooms: make(map[ServerName]map[RoomName]map[string]*NickKarma)}
+}
+
+func (store *KarmaStore) Load() {
+	store.Lock()
+	defer store.Unlock()
+
+	if file, err := os.Open("karma.json"); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(store); err != nil {
+			ReportError("karma", "Error loading karma", err)
+		} else {
+			logging.Info("Loaded karma")
+		}
+	}
+	if store.Rooms == nil {
+		store.Rooms = make(map[ServerName]map[RoomName]map[string]*NickKarma)
+	}
+}
+
+func (store *KarmaStore) Save() {
+	store.Lock()
+	defer store.Unlock()
+
+	if err := SaveSharedFile("karma.json", store, "Rooms"); err != nil {
+		ReportError("karma", "Error saving karma", err)
+	}
+}
+
+// Returns a nick's karma in a room.
+func (store *KarmaStore) Get(server ServerName, room RoomName, nick string) int {
+	store.RLock()
+	defer store.RUnlock()
+
+	if karma := store.Rooms[server][FoldRoom(server, room)][NameKey(nick)]; karma != nil {
+		return karma.Karma
+	}
+	return 0
+}
+
+// Gives a nick karma in a room and returns its new karma.
+func (store *KarmaStore) Give(server ServerName, room RoomName, nick string, points int) int {
+	store.Lock()
+	defer store.Unlock()
+
+	room = FoldRoom(server, room)
+	if store.Rooms[server] == nil {
+		store.Rooms[server] = make(map[RoomName]map[string]*NickKarma)
+	}
+	if store.Rooms[server][room] == nil {
+		store.Rooms[server][room] = make(map[string]*NickKarma)
+	}
+	karma := store.Rooms[server][room][NameKey(nick)]
+	if karma == nil {
+		karma = &NickKarma{Nick: nick}
+		store.Rooms[server][room][NameKey(nick)] = karma
+	}
+	karma.Karma += points
+	return karma.Karma
+}
+
+// Returns who is behind a nick, their services account if they are logged in to one, so karma can't be given to
+// yourself or given again from or to another nick.
+func karmaIdentity(server *Server, nick string) string {
+	if account, known := server.Account(nick); known && account != "" {
+		return "account " + FoldCase(CaseMapping(server.Name), account)
+	}
+	return "nick " + NameKey(nick)
+}
+
+// When each giver last gave karma to each nick in each room, for karmacooldown.
+type KarmaCooldowns struct {
+	given map[string]time.Time
+}
+
+func NewKarmaCooldowns() *KarmaCooldowns {
+	return &KarmaCooldowns{given: make(map[string]time.Time)}
+}
+
+// Returns true if the giver can give the receiver karma in the room now, and starts the cooldown if so.
+func (cooldowns *KarmaCooldowns) Give(server ServerName, room RoomName, giver, receiver string, now time.Time) bool {
+	for key, given := range cooldowns.given {
+		if now.Sub(given) >= *karmacooldown {
+			delete(cooldowns.given, key)
+		}
+	}
+	key := strings.Join([]string{string(server), string(FoldRoom(server, room)), giver, receiver}, " ")
+	if _, ok := cooldowns.given[key]; ok {
+		return false
+	}
+	cooldowns.given[key] = now
+	return true
+}
+
+func NewKarmaPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(KarmaPlugin, settings)
+}
+
+// Counts the karma nicks give each other in a room with nick++, and gives karma for slaying monsters in rooms where
+// karmaslay is set. Emits KARMA whenever karma is given.
+func KarmaPlugin(bot *Bot, settings *PluginSettings) {
+	store := NewKarmaStore()
+	store.Load()
+
+	give := func(server *Server, room RoomName, nick, from string, points int) {
+		karma := store.Give(server.Name, room, nick, points)
+		store.Save()
+		// The rpg may be broadcasting a kill to us while it waits for karma, so don't wait for it to take the event.
+		go bot.Emit(KARMA, server, room, nick, nick+"++", time.Now(), &KarmaGiven{nick, from, points, karma})
+	}
+
+	plusplus := func(event *Event) bool {
+		return karmaRegex.MatchString(strings.TrimSpace(event.Line.Text()))
+	}
+	givechan := settings.GetEventHandler(bot, client.PRIVMSG, plusplus, IsSettled())
+	karmachan := settings.HandleCommand(bot, karmaCommand)
+	killchan := settings.GetEventHandler(bot, RPG_KILL)
+	cooldowns := NewKarmaCooldowns()
+	for {
+		select {
+		case event, ok := <-givechan:
+			if !ok {
+				return
+			}
+			if !event.Line.Public() {
+				continue
+			}
+			nick := karmaRegex.FindStringSubmatch(strings.TrimSpace(event.Line.Text()))[1]
+			giver, receiver := karmaIdentity(event.Server, event.Line.Nick), karmaIdentity(event.Server, nick)
+			if NameKey(nick) == NameKey(event.Line.Nick) || giver == receiver {
+				continue
+			}
+			if !cooldowns.Give(event.Server.Name, event.Room, giver, receiver, event.Time) {
+				continue
+			}
+			give(event.Server, event.Room, nick, event.Line.Nick, 1)
+		case event, ok := <-karmachan:
+			if !ok {
+				return
+			}
+			args, err := karmaCommand.Parse(event.Line.Text())
+			if err != nil {
+				event.Server.Privmsg(event.Line.Nick, err.Error())
+				continue
+			}
+			if !event.Line.Public() {
+				continue
+			}
+			nick := args.String("nick")
+			if nick == "" {
+				nick = event.Line.Nick
+			}
+			karma := store.Get(event.Server.Name, event.Room, nick)
+			settings.Reply(event, Response(event.Server.Name, event.Room, event.Line.Nick, "karma.karma", ResponseVars{"Nick": SafeNick(event.Server.Name, event.Room, nick), "Karma": karma}))
+		case event, ok := <-killchan:
+			if !ok {
+				return
+			}
+			if points := karmaForSlay(event.Server.Name, event.Room); points > 0 {
+				give(event.Server, event.Room, event.Payload.(*RPGKill).Slayer, "", points)
+			}
+		}
+	}
+}
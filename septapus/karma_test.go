@@ -0,0 +1,68 @@
+package septapus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKarmaIdentity(t *testing.T) {
+	server := &Server{Name: "synirc", accounts: NewAccounts()}
+	server.accounts.set("iopred", "iopred")
+	server.accounts.set("iopred_", "IOPRED")
+	server.accounts.set("guest", "")
+
+	// Nicks logged in to the same account are the same giver, whatever their nick.
+	if a, b := karmaIdentity(server, "iopred"), karmaIdentity(server, "iopred_"); a != b {
+		t.Errorf("karmaIdentity(iopred) = %v, karmaIdentity(iopred_) = %v, want the same account", a, b)
+	}
+	// Nicks that aren't logged in, or that we don't know yet, fall back to their nick.
+	if a, b := karmaIdentity(server, "guest"), karmaIdentity(server, "GUEST"); a != b {
+		t.Errorf("karmaIdentity(guest) = %v, karmaIdentity(GUEST) = %v, want the same nick", a, b)
+	}
+	if a, b := karmaIdentity(server, "stranger"), karmaIdentity(server, "iopred"); a == b {
+		t.Errorf("karmaIdentity(stranger) = karmaIdentity(iopred) = %v", a)
+	}
+}
+
+func TestKarmaCooldowns(t *testing.T) {
+	old := *karmacooldown
+	defer func() { *karmacooldown = old }()
+	*karmacooldown = 10 * time.Minute
+
+	cooldowns := NewKarmaCooldowns()
+	now := time.Now()
+	if !cooldowns.Give("synirc", "#septapus", "nick a", "nick b", now) {
+		t.Fatalf("First karma was refused")
+	}
+	if cooldowns.Give("synirc", "#Septapus", "nick a", "nick b", now.Add(time.Minute)) {
+		t.Errorf("Karma was given again during the cooldown")
+	}
+	if !cooldowns.Give("synirc", "#septapus", "nick a", "nick c", now.Add(time.Minute)) {
+		t.Errorf("Karma to someone else was refused")
+	}
+	if !cooldowns.Give("synirc", "#other", "nick a", "nick b", now.Add(time.Minute)) {
+		t.Errorf("Karma in another room was refused")
+	}
+	if !cooldowns.Give("synirc", "#septapus", "nick a", "nick b", now.Add(*karmacooldown)) {
+		t.Errorf("Karma was refused after the cooldown")
+	}
+}
+
+func TestCharacterKarmaXP(t *testing.T) {
+	character := &Character{}
+	if got := character.KarmaXP(300, 500, "2026-10-18"); got != 300 {
+		t.Errorf("KarmaXP(300) = %v, want 300", got)
+	}
+	if got := character.KarmaXP(300, 500, "2026-10-18"); got != 200 {
+		t.Errorf("KarmaXP(300) over the cap = %v, want 200", got)
+	}
+	if got := character.KarmaXP(300, 500, "2026-10-18"); got != 0 {
+		t.Errorf("KarmaXP(300) at the cap = %v, want 0", got)
+	}
+	if got := character.KarmaXP(300, 500, "2026-10-19"); got != 300 {
+		t.Errorf("KarmaXP(300) the next day = %v, want 300", got)
+	}
+	if got := character.KarmaXP(1000, 0, "2026-10-19"); got != 1000 {
+		t.Errorf("KarmaXP(1000) with no cap = %v, want 1000", got)
+	}
+}
@@ -0,0 +1,231 @@
+package septapus
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var langOptions = NewOptions("lang")
+
+var langdir = langOptions.String("dir", "lang", "Asset directory of language packs, each <language>.json is a catalog of response keys to templates, eg: lang/de.json")
+
+var langCommand = NewCommand("!lang room <language>", "!lang <language>", "!lang").WithHelp("Shows or sets the language the bot talks to you in, or to the whole room.")
+
+// The language the bot's own responses are written in.
+const DEFAULT_LANGUAGE = "en"
+
+var (
+	languagePacks     = make(map[string]map[string]string)
+	languagePacksOnce sync.Once
+)
+
+// Loads the language packs in langdir, the responses file's languages are merged over them.
+func loadLanguagePacks() {
+	languagePacksOnce.Do(func() {
+		files, err := ReadAssetDir(*langdir)
+		if err != nil {
+			logging.Info("Error loading language packs", *langdir, err)
+			return
+		}
+		for _, name := range files {
+			if !strings.HasSuffix(name, ".json") {
+				continue
+			}
+			filename := filepath.Join(*langdir, name)
+			data, err := ReadAsset(filename)
+			if err != nil {
+				ReportError("lang", "Error reading language pack", filename, err)
+				continue
+			}
+			catalog := make(map[string]string)
+			if err := json.Unmarshal(data, &catalog); err != nil {
+				ReportError("lang", "Error loading language pack", filename, err)
+				continue
+			}
+			language := strings.ToLower(strings.TrimSuffix(filepath.Base(filename), ".json"))
+			languagePacks[language] = catalog
+			logging.Info("Loaded language pack", language)
+		}
+	})
+}
+
+// Returns a language's template for a response, from the responses file or its language pack.
+func languageResponse(language, key string) (string, bool) {
+	loadResponses()
+	loadLanguagePacks()
+
+	language = strings.ToLower(language)
+	if source, ok := responseFileData.Languages[language][key]; ok {
+		return source, true
+	}
+	source, ok := languagePacks[language][key]
+	return source, ok
+}
+
+// Returns the names of the languages responses can be shown in, sorted.
+func LanguageNames() []string {
+	loadResponses()
+	loadLanguagePacks()
+
+	names := map[string]bool{DEFAULT_LANGUAGE: true}
+	for language, _ := range languagePacks {
+		names[language] = true
+	}
+	for language, _ := range responseFileData.Languages {
+		names[strings.ToLower(language)] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for language, _ := range names {
+		sorted = append(sorted, language)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func isLanguage(language string) bool {
+	for _, name := range LanguageNames() {
+		if name == language {
+			return true
+		}
+	}
+	return false
+}
+
+// The languages chosen with !lang, saved to languages.json.
+type savedLanguages struct {
+	sync.RWMutex
+	Rooms map[ServerName]map[RoomName]string
+	Users map[ServerName]map[string]string
+}
+
+var (
+	chosenLanguages     = &savedLanguages{Rooms: make(map[ServerName]map[RoomName]string), Users: make(map[ServerName]map[string]string)}
+	chosenLanguagesOnce sync.Once
+)
+
+func loadChosenLanguages() {
+	chosenLanguagesOnce.Do(chosenLanguages.Load)
+}
+
+func (saved *savedLanguages) Load() {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if file, err := os.Open("languages.json"); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(saved); err != nil {
+			ReportError("lang", "Error loading languages", err)
+		} else {
+			logging.Info("Loaded languages")
+		}
+	}
+	if saved.Rooms == nil {
+		saved.Rooms = make(map[ServerName]map[RoomName]string)
+	}
+	if saved.Users == nil {
+		saved.Users = make(map[ServerName]map[string]string)
+	}
+}
+
+func (saved *savedLanguages) Save() {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if err := SaveSharedFile("languages.json", saved, "Rooms", "Users"); err != nil {
+		ReportError("lang", "Error saving languages", err)
+	}
+}
+
+func (saved *savedLanguages) SetRoom(server ServerName, room RoomName, language string) {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if saved.Rooms[server] == nil {
+		saved.Rooms[server] = make(map[RoomName]string)
+	}
+	saved.Rooms[server][room] = language
+}
+
+func (saved *savedLanguages) SetUser(server ServerName, nick, language string) {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if saved.Users[server] == nil {
+		saved.Users[server] = make(map[string]string)
+	}
+	saved.Users[server][NameKey(nick)] = language
+}
+
+// Returns the language responses are shown in to a nick in a room. A nick's own language wins over the room's
+// chosen with !lang, which wins over -responseslanguages. Nick and room may be empty.
+func GetLanguage(server ServerName, room RoomName, nick string) string {
+	loadResponses()
+	loadChosenLanguages()
+
+	chosenLanguages.RLock()
+	defer chosenLanguages.RUnlock()
+
+	if nick != "" {
+		if language := chosenLanguages.Users[server][NameKey(nick)]; language != "" {
+			return language
+		}
+	}
+	if room != "" {
+		if language := chosenLanguages.Rooms[server][room]; language != "" {
+			return language
+		}
+	}
+	if language, ok := languageRooms.Get(server, room); ok && language != "" {
+		return strings.ToLower(language)
+	}
+	return DEFAULT_LANGUAGE
+}
+
+func NewLangPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(LangPlugin, settings)
+}
+
+// Lets nicks choose the language the bot talks to them in with !lang <language>, admins can set a room's with !lang room <language>.
+func LangPlugin(bot *Bot, settings *PluginSettings) {
+	loadChosenLanguages()
+
+	channel := settings.HandleCommand(bot, langCommand)
+	for event := range channel {
+		server, room, nick := event.Server.Name, event.Room, event.Line.Nick
+		args, err := langCommand.Parse(event.Line.Text())
+		if err != nil {
+			event.Server.Privmsg(nick, err.Error())
+			continue
+		}
+		languages := strings.Join(LanguageNames(), ", ")
+		if !args.Has("language") {
+			event.Server.Privmsg(nick, Response(server, room, nick, "lang.current", ResponseVars{"Language": GetLanguage(server, room, nick), "Languages": languages}))
+			continue
+		}
+		language := strings.ToLower(args.String("language"))
+		if !isLanguage(language) {
+			event.Server.Privmsg(nick, Response(server, room, nick, "lang.unknown", ResponseVars{"Languages": languages}))
+			continue
+		}
+		if args.Pattern == "!lang room <language>" {
+			if !event.Line.Public() || !IsAdmin(event) {
+				event.Server.Privmsg(nick, Response(server, room, nick, "lang.adminonly", nil))
+				continue
+			}
+			chosenLanguages.SetRoom(server, room, language)
+			chosenLanguages.Save()
+			settings.Reply(event, Response(server, room, "", "lang.room", ResponseVars{"Language": language}))
+			continue
+		}
+		chosenLanguages.SetUser(server, nick, language)
+		chosenLanguages.Save()
+		event.Server.Privmsg(nick, Response(server, room, nick, "lang.user", ResponseVars{"Language": language}))
+	}
+}
@@ -0,0 +1,73 @@
+package septapus
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The fields and commands a template refers to, a translation has to keep both.
+var templateReferencePattern = regexp.MustCompile(`\.[A-Z][A-Za-z]*|![a-z]+`)
+
+func templateReferences(source string) []string {
+	seen := make(map[string]bool)
+	references := make([]string, 0)
+	for _, reference := range templateReferencePattern.FindAllString(source, -1) {
+		if !seen[reference] {
+			seen[reference] = true
+			references = append(references, reference)
+		}
+	}
+	sort.Strings(references)
+	return references
+}
+
+func TestLanguagePacks(t *testing.T) {
+	files, err := ReadAssetDir(*langdir)
+	if err != nil {
+		t.Fatalf("ReadAssetDir(%v) = %v", *langdir, err)
+	}
+	packs := 0
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		packs++
+		filename := filepath.Join(*langdir, name)
+		data, err := ReadAsset(filename)
+		if err != nil {
+			t.Errorf("ReadAsset(%v) = %v", filename, err)
+			continue
+		}
+		catalog := make(map[string]string)
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			t.Errorf("%v isn't a catalog: %v", filename, err)
+			continue
+		}
+		for key, source := range defaultResponses {
+			translation, ok := catalog[key]
+			if !ok {
+				t.Errorf("%v is missing %v", filename, key)
+				continue
+			}
+			if _, err := responseTemplate(translation); err != nil {
+				t.Errorf("%v: %v: %v", filename, key, err)
+			}
+			if want, got := templateReferences(source), templateReferences(translation); !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: %v refers to %v, want %v", filename, key, got, want)
+			}
+		}
+		for key, _ := range catalog {
+			if _, ok := defaultResponses[key]; !ok {
+				t.Errorf("%v: %v isn't a response the bot has", filename, key)
+			}
+		}
+	}
+	if packs == 0 {
+		t.Errorf("No language packs in %v", *langdir)
+	}
+}
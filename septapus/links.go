@@ -0,0 +1,418 @@
+package septapus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var linksOptions = NewOptions("links")
+
+var linksdigest = linksOptions.String("digest", "", "Comma separated list of rooms and how often the top links are posted, daily or weekly, eg: synirc/#septapus=weekly")
+var linksdigesthour = linksOptions.Int("digesthour", 9, "Hour of the day digests are posted, in the room's timezone. Weekly digests are posted on Mondays")
+var linksdigestsize = linksOptions.Int("digestsize", 5, "Most links in a digest")
+var linksdigestpage = linksOptions.Bool("digestpage", false, "Paste digests as a page and post the link, if pasting is set up")
+
+var linksCommand = NewCommand("!links [period]").WithHelp("Lists the room's most shared links of the day, or the week with !links week.")
+
+// How long a reaction after a link counts as karma for it.
+const linkReactionWindow = 10 * time.Minute
+
+// Links with no shares or reactions for this long are forgotten, long enough for a weekly digest.
+const linkHistoryAge = 8 * 24 * time.Hour
+
+// Messages that count as karma for the last link shared in a room.
+var linkReactions = map[string]bool{"+1": true, "++": true, "nice": true, "^": true, "this": true}
+
+type LinkEvent struct {
+	Nick string
+	Time time.Time
+}
+
+// A link shared in a room, every share and karma reaction is kept until the link is forgotten.
+type Link struct {
+	URL       string
+	Shares    []*LinkEvent
+	Reactions []*LinkEvent
+}
+
+// Returns true if nick has reacted to the link, or shared it.
+func (link *Link) reacted(nick string) bool {
+	for _, events := range [][]*LinkEvent{link.Shares, link.Reactions} {
+		for _, e := range events {
+			if NameKey(e.Nick) == NameKey(nick) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the time the link was last shared or reacted to.
+func (link *Link) LastSeen() time.Time {
+	last := time.Time{}
+	for _, events := range [][]*LinkEvent{link.Shares, link.Reactions} {
+		for _, e := range events {
+			if e.Time.After(last) {
+				last = e.Time
+			}
+		}
+	}
+	return last
+}
+
+func countSince(events []*LinkEvent, since time.Time) int {
+	count := 0
+	for _, e := range events {
+		if !e.Time.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// A link's shares and karma within a digest period.
+type RankedLink struct {
+	URL     string
+	Shares  int
+	Karma   int
+	Title   string
+	Ordinal int
+}
+
+func (link *RankedLink) Score() int {
+	return link.Shares + link.Karma
+}
+
+func (link *RankedLink) String() string {
+	name := link.URL
+	if link.Title != "" {
+		name = link.Title + " - " + link.URL
+	}
+	return fmt.Sprintf("%d. %v (%s, %s)", link.Ordinal, name, plural(link.Shares, "share"), plural(link.Karma, "+1"))
+}
+
+func plural(count int, name string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %v", name)
+	}
+	return fmt.Sprintf("%d %vs", count, name)
+}
+
+type RankedLinks []*RankedLink
+
+func (l RankedLinks) Len() int {
+	return len(l)
+}
+
+func (l RankedLinks) Less(i, j int) bool {
+	if l[i].Score() == l[j].Score() {
+		return l[i].Shares > l[j].Shares
+	}
+	return l[i].Score() > l[j].Score()
+}
+
+func (l RankedLinks) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+type RoomLinks struct {
+	Links      map[string]*Link
+	LastDigest time.Time
+	// The link most recently shared in the room, reactions count towards it.
+	last string
+}
+
+// The links shared in every room, saved to links.json.
+type LinkHistory struct {
+	sync.RWMutex
+	Rooms map[ServerName]map[RoomName]*RoomLinks
+}
+
+func NewLinkHistory() *LinkHistory {
+	return &LinkHistory{Rooms: make(map[ServerName]map[RoomName]*RoomLinks)}
+}
+
+func (history *LinkHistory) Load() {
+	history.Lock()
+	defer history.Unlock()
+
+	if file, err := os.Open("links.json"); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(history); err != nil {
+			ReportError("links", "Error loading links", err)
+		} else {
+			logging.Info("Loaded links")
+		}
+	}
+	if history.Rooms == nil {
+		history.Rooms = make(map[ServerName]map[RoomName]*RoomLinks)
+	}
+}
+
+func (history *LinkHistory) Save() {
+	history.Lock()
+	defer history.Unlock()
+
+	if err := SaveSharedFile("links.json", history, "Rooms"); err != nil {
+		ReportError("links", "Error saving links", err)
+	}
+}
+
+// Returns a room's links, creating them if needed. The caller must hold the lock.
+func (history *LinkHistory) room(server ServerName, room RoomName) *RoomLinks {
+	if history.Rooms[server] == nil {
+		history.Rooms[server] = make(map[RoomName]*RoomLinks)
+	}
+	links := history.Rooms[server][room]
+	if links == nil {
+		links = &RoomLinks{Links: make(map[string]*Link)}
+		history.Rooms[server][room] = links
+	}
+	return links
+}
+
+// Returns the key a link is stored under, so trivially different urls count as reposts.
+func linkKey(url string) string {
+	if i := strings.Index(url, "#"); i != -1 {
+		url = url[:i]
+	}
+	url = strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://")
+	return strings.ToLower(strings.TrimPrefix(strings.TrimRight(url, "/"), "www."))
+}
+
+// Records a link shared in a room.
+func (history *LinkHistory) Share(server ServerName, room RoomName, nick, url string, now time.Time) {
+	history.Lock()
+	defer history.Unlock()
+
+	links := history.room(server, room)
+	key := linkKey(url)
+	link := links.Links[key]
+	if link == nil {
+		link = &Link{URL: url}
+		links.Links[key] = link
+	}
+	link.Shares = append(link.Shares, &LinkEvent{nick, now})
+	links.last = key
+}
+
+// Records a reaction to the last link shared in a room, returns false if there is no recent link or the nick has already reacted.
+func (history *LinkHistory) React(server ServerName, room RoomName, nick string, now time.Time) bool {
+	history.Lock()
+	defer history.Unlock()
+
+	links := history.room(server, room)
+	link := links.Links[links.last]
+	if link == nil || link.reacted(nick) || now.Sub(link.LastSeen()) > linkReactionWindow {
+		return false
+	}
+	link.Reactions = append(link.Reactions, &LinkEvent{nick, now})
+	return true
+}
+
+// Returns the best links shared in a room since a time, at most count.
+func (history *LinkHistory) Top(server ServerName, room RoomName, since time.Time, count int) RankedLinks {
+	history.RLock()
+	defer history.RUnlock()
+
+	ranked := make(RankedLinks, 0)
+	if links := history.Rooms[server][room]; links != nil {
+		for _, link := range links.Links {
+			r := &RankedLink{URL: link.URL, Shares: countSince(link.Shares, since), Karma: countSince(link.Reactions, since)}
+			if r.Shares > 0 {
+				ranked = append(ranked, r)
+			}
+		}
+	}
+	sort.Sort(ranked)
+	if len(ranked) > count {
+		ranked = ranked[:count]
+	}
+	for i, link := range ranked {
+		link.Ordinal = i + 1
+	}
+	return ranked
+}
+
+// Forgets links that haven't been seen for linkHistoryAge.
+func (history *LinkHistory) Prune(now time.Time) {
+	history.Lock()
+	defer history.Unlock()
+
+	for _, rooms := range history.Rooms {
+		for _, links := range rooms {
+			for key, link := range links.Links {
+				if now.Sub(link.LastSeen()) > linkHistoryAge {
+					delete(links.Links, key)
+				}
+			}
+		}
+	}
+}
+
+// Returns when the digest after last is due, digests are posted at linksdigesthour, on Mondays for weekly digests.
+func nextDigest(last time.Time, period string, location *time.Location) time.Time {
+	last = last.In(location)
+	next := time.Date(last.Year(), last.Month(), last.Day(), *linksdigesthour, 0, 0, 0, location)
+	for !next.After(last) || (period == "weekly" && next.Weekday() != time.Monday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Returns how far back a digest period goes.
+func digestSince(period string, now time.Time) time.Time {
+	if period == "weekly" {
+		return now.AddDate(0, 0, -7)
+	}
+	return now.AddDate(0, 0, -1)
+}
+
+// Returns the lines of a digest, fetching the title of each link.
+func digestLines(server ServerName, room RoomName, period string, links RankedLinks) []string {
+	vars := ResponseVars{"Room": room}
+	if len(links) == 0 {
+		return []string{Response(server, room, "", "links.none."+period, vars)}
+	}
+	lines := []string{Response(server, room, "", "links.top."+period, vars)}
+	for _, link := range links {
+		if title, err := FetchTitle(link.URL); err == nil {
+			link.Title = title
+		}
+		lines = append(lines, link.String())
+	}
+	return lines
+}
+
+// Posts a digest to a room, as a page if linksdigestpage is set.
+func postDigest(server *Server, settings *PluginSettings, room RoomName, lines []string) {
+	if *linksdigestpage && PasteEnabled() && !settings.SkipUpload("a links digest for "+string(room)) {
+		if link, err := Paste(strings.Join(lines, "\n")); err == nil {
+			settings.Privmsg(server, string(room), lines[0]+" "+link)
+			return
+		} else {
+			ReportError("links", "Error pasting digest:", err)
+		}
+	}
+	if settings.IsDryRun() {
+		// PrivmsgLines would paste or send them.
+		for _, line := range lines {
+			settings.Privmsg(server, string(room), line)
+		}
+		return
+	}
+	PrivmsgLines(server, string(room), lines)
+}
+
+// Posts the digests that are due, the first time a room is seen its digest is scheduled rather than posted.
+func (history *LinkHistory) postDigests(bot *Bot, settings *PluginSettings, digests RoomValues, now time.Time) {
+	type due struct {
+		server ServerName
+		room   RoomName
+		period string
+	}
+	pending := make([]*due, 0)
+
+	history.Lock()
+	for server, rooms := range history.Rooms {
+		for room, links := range rooms {
+			period, _ := digests.Get(server, room)
+			if period != "daily" && period != "weekly" {
+				continue
+			}
+			if links.LastDigest.IsZero() {
+				links.LastDigest = now
+				continue
+			}
+			if now.Before(nextDigest(links.LastDigest, period, GetTimeFormat(server, room, "").Location)) {
+				continue
+			}
+			links.LastDigest = now
+			pending = append(pending, &due{server, room, period})
+		}
+	}
+	history.Unlock()
+
+	for _, d := range pending {
+		if !settings.IsAllowed(d.server, d.room) {
+			continue
+		}
+		server := bot.GetServer(d.server)
+		if server == nil || server.Conn == nil || !server.Conn.Connected() {
+			continue
+		}
+		postDigest(server, settings, d.room, digestLines(d.server, d.room, d.period, history.Top(d.server, d.room, digestSince(d.period, now), *linksdigestsize)))
+	}
+}
+
+func NewLinksPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(LinksPlugin, settings)
+}
+
+// Keeps a history of the links shared in each room, and posts the most shared and +1'd links daily or weekly.
+// !links [day|week] shows the current top links.
+func LinksPlugin(bot *Bot, settings *PluginSettings) {
+	history := NewLinkHistory()
+	history.Load()
+	defer history.Save()
+
+	digests := ParseRoomValues(*linksdigest)
+
+	channel := settings.GetEventHandler(bot, client.PRIVMSG)
+	linkschan := settings.HandleCommand(bot, linksCommand)
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-channel:
+			if !ok {
+				return
+			}
+			if IsPrivate()(event) || event.Server.CatchingUp(event) {
+				continue
+			}
+			text := strings.TrimSpace(event.Line.Text())
+			if url := isUrl(text); url != "" {
+				history.Share(event.Server.Name, event.Room, event.Line.Nick, url, time.Now())
+			} else if linkReactions[strings.ToLower(text)] {
+				history.React(event.Server.Name, event.Room, event.Line.Nick, time.Now())
+			}
+		case event, ok := <-linkschan:
+			if !ok {
+				return
+			}
+			args, err := linksCommand.Parse(event.Line.Text())
+			if err != nil {
+				event.Server.Privmsg(event.Line.Nick, err.Error())
+				continue
+			}
+			period := "daily"
+			switch strings.ToLower(args.String("period")) {
+			case "", "day", "daily", "today":
+			case "week", "weekly":
+				period = "weekly"
+			default:
+				event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, "links.badperiod", nil))
+				continue
+			}
+			now := time.Now()
+			settings.ReplyLines(event, digestLines(event.Server.Name, event.Room, period, history.Top(event.Server.Name, event.Room, digestSince(period, now), *linksdigestsize)))
+		case <-ticker.C:
+			now := time.Now()
+			history.postDigests(bot, settings, digests, now)
+			history.Prune(now)
+			history.Save()
+		}
+	}
+}
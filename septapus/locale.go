@@ -0,0 +1,202 @@
+package septapus
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var localeOptions = NewOptions("locale")
+
+var locales = localeOptions.String("rooms", "", "Comma separated list of the locale dates are shown in for each room, eg: synirc/#septapus=en-gb,*/*=iso. Nicks can choose their own with !locale")
+var timezones = localeOptions.String("timezones", "", "Comma separated list of the timezone times are shown in for each room, eg: synirc/#septapus=Europe/London. Rooms default to the local timezone")
+
+var localeCommand = NewCommand("!locale <locale> [timezone]", "!locale").WithHelp("Shows or sets how dates and times are shown to you.")
+
+// Layouts used to show dates and times, see time.Format.
+type Locale struct {
+	Date string
+	Time string
+}
+
+// The default matches the format used before locales were added.
+const DEFAULT_LOCALE = "default"
+
+var localeLayouts = map[string]*Locale{
+	DEFAULT_LOCALE: &Locale{"02 Jan 2006", "15:04 MST"},
+	"en-us":        &Locale{"Jan 2, 2006", "3:04 PM MST"},
+	"en-gb":        &Locale{"2 Jan 2006", "15:04 MST"},
+	"iso":          &Locale{"2006-01-02", "15:04 MST"},
+	"de":           &Locale{"02.01.2006", "15:04 MST"},
+	"fr":           &Locale{"02/01/2006", "15:04 MST"},
+	"ja":           &Locale{"2006/01/02", "15:04 MST"},
+}
+
+// How times are shown to a room or nick.
+type TimeFormat struct {
+	Locale   *Locale
+	Location *time.Location
+}
+
+var DefaultTimeFormat = &TimeFormat{localeLayouts[DEFAULT_LOCALE], time.Local}
+
+func (format *TimeFormat) Date(t time.Time) string {
+	return t.In(format.Location).Format(format.Locale.Date)
+}
+
+func (format *TimeFormat) Time(t time.Time) string {
+	return t.In(format.Location).Format(format.Locale.Time)
+}
+
+// A nick's own locale and timezone, empty values fall back to the room's.
+type UserLocale struct {
+	Locale   string `json:",omitempty"`
+	Timezone string `json:",omitempty"`
+}
+
+type userLocales struct {
+	sync.RWMutex
+	Users map[ServerName]map[string]*UserLocale
+}
+
+var (
+	localeRooms   RoomValues
+	timezoneRooms RoomValues
+	savedLocales  = &userLocales{Users: make(map[ServerName]map[string]*UserLocale)}
+	localesOnce   sync.Once
+)
+
+func loadLocales() {
+	localesOnce.Do(func() {
+		localeRooms = ParseRoomValues(*locales)
+		timezoneRooms = ParseRoomValues(*timezones)
+		savedLocales.Load()
+	})
+}
+
+func (saved *userLocales) Load() {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if file, err := os.Open("locales.json"); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(saved); err != nil {
+			ReportError("locale", "Error loading locales", err)
+		} else {
+			logging.Info("Loaded locales")
+		}
+	}
+	if saved.Users == nil {
+		saved.Users = make(map[ServerName]map[string]*UserLocale)
+	}
+}
+
+func (saved *userLocales) Save() {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if err := SaveSharedFile("locales.json", saved, "Users"); err != nil {
+		ReportError("locale", "Error saving locales", err)
+	}
+}
+
+func (saved *userLocales) get(server ServerName, nick string) *UserLocale {
+	saved.RLock()
+	defer saved.RUnlock()
+
+	return saved.Users[server][NameKey(nick)]
+}
+
+func (saved *userLocales) set(server ServerName, nick string, user *UserLocale) {
+	saved.Lock()
+	defer saved.Unlock()
+
+	if saved.Users[server] == nil {
+		saved.Users[server] = make(map[string]*UserLocale)
+	}
+	saved.Users[server][NameKey(nick)] = user
+}
+
+// Returns how times are shown to a nick in a room, the nick's own settings win over the room's. Nick may be empty.
+func GetTimeFormat(server ServerName, room RoomName, nick string) *TimeFormat {
+	loadLocales()
+
+	locale, _ := localeRooms.Get(server, room)
+	timezone, _ := timezoneRooms.Get(server, room)
+	if nick != "" {
+		if user := savedLocales.get(server, nick); user != nil {
+			if user.Locale != "" {
+				locale = user.Locale
+			}
+			if user.Timezone != "" {
+				timezone = user.Timezone
+			}
+		}
+	}
+
+	format := &TimeFormat{DefaultTimeFormat.Locale, DefaultTimeFormat.Location}
+	if layouts := localeLayouts[strings.ToLower(locale)]; layouts != nil {
+		format.Locale = layouts
+	}
+	if timezone != "" {
+		if location, err := time.LoadLocation(timezone); err == nil {
+			format.Location = location
+		}
+	}
+	return format
+}
+
+// Returns the names of the known locales, sorted.
+func LocaleNames() []string {
+	names := make([]string, 0, len(localeLayouts))
+	for name, _ := range localeLayouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewLocalePlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(LocalePlugin, settings)
+}
+
+// Lets nicks choose how dates and times are shown to them with !locale <locale> [timezone].
+func LocalePlugin(bot *Bot, settings *PluginSettings) {
+	loadLocales()
+
+	channel := settings.HandleCommand(bot, localeCommand)
+	for event := range channel {
+		args, err := localeCommand.Parse(event.Line.Text())
+		if err != nil {
+			event.Server.Privmsg(event.Line.Nick, err.Error())
+			continue
+		}
+		server, nick := event.Server.Name, event.Line.Nick
+		if args.Has("locale") {
+			locale := strings.ToLower(args.String("locale"))
+			if localeLayouts[locale] == nil {
+				event.Server.Privmsg(nick, Response(server, event.Room, nick, "locale.unknown", ResponseVars{"Locales": strings.Join(LocaleNames(), ", ")}))
+				continue
+			}
+			user := &UserLocale{Locale: locale}
+			if args.Has("timezone") {
+				if _, err := time.LoadLocation(args.String("timezone")); err != nil {
+					event.Server.Privmsg(nick, Response(server, event.Room, nick, "locale.badtimezone", nil))
+					continue
+				}
+				user.Timezone = args.String("timezone")
+			}
+			savedLocales.set(server, nick, user)
+			savedLocales.Save()
+		}
+		format := GetTimeFormat(server, event.Room, nick)
+		now := time.Now()
+		event.Server.Privmsg(nick, Response(server, event.Room, nick, "locale.current", ResponseVars{"Date": format.Date(now), "Time": format.Time(now), "Choices": strings.Join(LocaleNames(), "|")}))
+	}
+}
@@ -0,0 +1,63 @@
+package septapus
+
+import (
+	"sync"
+)
+
+var mentionOptions = NewOptions("mention")
+
+var mentions = mentionOptions.String("styles", "", "Comma separated list of how nicks are mentioned in public announcements, eg: server/#room=zwsp,*/*=mangle. Styles are none, zwsp and mangle")
+
+type MentionStyle string
+
+const (
+	MENTION_NONE   MentionStyle = "none"
+	MENTION_ZWSP   MentionStyle = "zwsp"
+	MENTION_MANGLE MentionStyle = "mangle"
+)
+
+const zeroWidthSpace = "\u200b"
+
+var mangles = map[rune]rune{'a': '4', 'A': '4', 'e': '3', 'E': '3', 'i': '1', 'I': '1', 'o': '0', 'O': '0'}
+
+var (
+	mentionStyles     RoomValues
+	mentionStylesOnce sync.Once
+)
+
+// Returns the mention style configured for a room, the most specific configuration wins.
+func GetMentionStyle(server ServerName, room RoomName) MentionStyle {
+	mentionStylesOnce.Do(func() {
+		mentionStyles = ParseRoomValues(*mentions)
+	})
+	if style, ok := mentionStyles.Get(server, room); ok {
+		return MentionStyle(style)
+	}
+	return MENTION_NONE
+}
+
+// Formats a nick so that mentioning it does not highlight the user.
+func FormatMention(style MentionStyle, nick string) string {
+	runes := []rune(nick)
+	if len(runes) < 2 {
+		return nick
+	}
+	switch style {
+	case MENTION_MANGLE:
+		for i, r := range runes {
+			if mangled, ok := mangles[r]; ok {
+				runes[i] = mangled
+				return string(runes)
+			}
+		}
+		fallthrough
+	case MENTION_ZWSP:
+		return string(runes[:1]) + zeroWidthSpace + string(runes[1:])
+	}
+	return nick
+}
+
+// Formats a nick for a public announcement in a room, using the room's configured mention style.
+func SafeNick(server ServerName, room RoomName, nick string) string {
+	return FormatMention(GetMentionStyle(server, room), nick)
+}
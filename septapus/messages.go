@@ -0,0 +1,163 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var rpgannounce = rpgOptions.String("announce", "", "Comma separated list of rooms and how rpg announcements are sent, privmsg, notice or action, eg: synirc/#septapus=notice,*/*=privmsg")
+var messagesOptions = NewOptions("messages")
+
+var readonly = messagesOptions.String("readonly", "", "Comma separated list of rooms the bot never speaks in, plugins still track them, eg: synirc/#quiet=on")
+var urltitles = urlOptions.String("titles", "", "Comma separated list of rooms and how url titles are sent, privmsg, notice or action, eg: synirc/#septapus=notice,*/*=privmsg")
+
+// How a message is sent, chatty plugins can use notices to avoid highlighting people.
+type MessageStyle int
+
+const (
+	MESSAGE_PRIVMSG MessageStyle = iota
+	MESSAGE_NOTICE
+	MESSAGE_ACTION
+)
+
+var (
+	rpgAnnounceStyles RoomValues
+	urlTitleStyles    RoomValues
+	readOnlyRooms     RoomValues
+	messageStylesOnce sync.Once
+)
+
+func parseMessageStyle(value string) MessageStyle {
+	switch value {
+	case "notice":
+		return MESSAGE_NOTICE
+	case "action":
+		return MESSAGE_ACTION
+	}
+	return MESSAGE_PRIVMSG
+}
+
+func messageStyles() {
+	messageStylesOnce.Do(func() {
+		rpgAnnounceStyles = ParseRoomValues(*rpgannounce)
+		urlTitleStyles = ParseRoomValues(*urltitles)
+		readOnlyRooms = ParseRoomValues(*readonly)
+	})
+}
+
+// Returns true if the bot should never speak in a room. Private messages are always allowed.
+func IsReadOnly(server ServerName, target string) bool {
+	if !strings.HasPrefix(target, "#") && !strings.HasPrefix(target, "&") {
+		return false
+	}
+	messageStyles()
+	value, ok := readOnlyRooms.Get(server, RoomName(target))
+	return ok && value == "on"
+}
+
+// Returns how rpg announcements are sent in a room, privmsg unless rpgannounce says otherwise.
+func RPGAnnounceStyle(server ServerName, room RoomName) MessageStyle {
+	messageStyles()
+	value, _ := rpgAnnounceStyles.Get(server, room)
+	return parseMessageStyle(value)
+}
+
+// Returns how url titles are sent in a room, privmsg unless urltitles says otherwise.
+func URLTitleStyle(server ServerName, room RoomName) MessageStyle {
+	messageStyles()
+	value, _ := urlTitleStyles.Get(server, room)
+	return parseMessageStyle(value)
+}
+
+// Sends a message to a room or nick.
+func (server *Server) Privmsg(target, text string) {
+	server.Send(MESSAGE_PRIVMSG, target, text)
+}
+
+// Sends a notice to a room or nick, clients don't highlight notices.
+func (server *Server) Notice(target, text string) {
+	server.Send(MESSAGE_NOTICE, target, text)
+}
+
+// Sends an action to a room or nick, eg: /me waves.
+func (server *Server) Action(target, text string) {
+	server.Send(MESSAGE_ACTION, target, text)
+}
+
+// Sends a message in a style to a room or nick. While disconnected the message is held and sent after reconnecting, see outboxsize.
+func (server *Server) Send(style MessageStyle, target, text string) {
+	server.sendStyle(style, target, text, true)
+}
+
+func (server *Server) sendStyle(style MessageStyle, target, text string, hold bool) {
+	for _, text := range server.splitMessage(style, target, text) {
+		text := text
+		switch style {
+		case MESSAGE_NOTICE:
+			server.send(target, hold, func() { server.Conn.Notice(target, text) })
+		case MESSAGE_ACTION:
+			server.send(target, hold, func() { server.Conn.Action(target, text) })
+		default:
+			server.send(target, hold, func() { server.Conn.Privmsg(target, text) })
+		}
+	}
+}
+
+// Replies to a CTCP request, eg: SOURCE. Replies are never held, they are stale by the time we reconnect.
+func (server *Server) CtcpReply(target, ctcp, reply string) {
+	server.send(target, false, func() { server.Conn.CtcpReply(target, ctcp, reply) })
+}
+
+// Every message the bot sends goes through here, so they can be rate limited and filtered in one place.
+// Messages are queued and sent no faster than flood.rate, or written straight away when it is 0.
+func (server *Server) send(target string, hold bool, write func()) {
+	if IsReadOnly(server.Name, target) {
+		logging.Debug("Not speaking in read only room", server.Name, target)
+		return
+	}
+	if *floodrate <= 0 {
+		server.write(target, hold, write)
+		return
+	}
+	if !server.queue.add(server, &queuedMessage{target, hold, write}) {
+		logging.Info("Send queue full, dropping message to", server.Name, target)
+	}
+}
+
+// Writes a message to the connection. Messages that can't be sent yet are held in the outbox if hold is set,
+// otherwise dropped.
+func (server *Server) write(target string, hold bool, write func()) {
+	if server.outbox.Hold(server.Conn != nil && server.Conn.Connected(), target) {
+		if hold {
+			server.outbox.Add(target, write)
+		} else {
+			logging.Debug("Not connected, dropping message to", server.Name, target)
+		}
+		return
+	}
+	write()
+}
+
+// Sends a message for a plugin, or logs it if the plugin is in dry run.
+func (s *PluginSettings) Send(server *Server, style MessageStyle, target, text string) {
+	if s.IsDryRun() {
+		logging.Info("Dry run", s.Name, "would send to", server.Name, target, text)
+		return
+	}
+	server.sendStyle(style, target, text, !isUnbuffered(s.Name))
+}
+
+func (s *PluginSettings) Privmsg(server *Server, target, text string) {
+	s.Send(server, MESSAGE_PRIVMSG, target, text)
+}
+
+// Returns true if the plugin is in dry run and should skip an upload, logging what would have been uploaded.
+func (s *PluginSettings) SkipUpload(what string) bool {
+	if !s.IsDryRun() {
+		return false
+	}
+	logging.Info("Dry run", s.Name, "would upload", what)
+	return true
+}
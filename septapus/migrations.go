@@ -0,0 +1,77 @@
+package septapus
+
+import (
+	"fmt"
+
+	"github.com/fluffle/golog/logging"
+)
+
+// A single upgrade of a save, from the version before it to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(save interface{}) error
+}
+
+// An ordered list of migrations for one kind of save, eg: rpg games or prs.
+// Saves store the version they were written with, and only the migrations after that version are run when they are loaded.
+// Saves from before versioning was added have version 0.
+type Migrations struct {
+	name       string
+	migrations []*Migration
+}
+
+// Every kind of save's migrations, by name.
+var allMigrations = make(map[string]*Migrations)
+
+func NewMigrations(name string) *Migrations {
+	migrations := &Migrations{name: name}
+	allMigrations[name] = migrations
+	return migrations
+}
+
+// Returns the version each kind of save is written with, by name.
+func SaveVersions() map[string]int {
+	versions := make(map[string]int)
+	for name, migrations := range allMigrations {
+		versions[name] = migrations.Latest()
+	}
+	return versions
+}
+
+// Adds a migration, versions must be added in increasing order.
+func (migrations *Migrations) Add(version int, description string, migrate func(save interface{}) error) {
+	if version <= migrations.Latest() {
+		panic(fmt.Sprintf("%v migration %d added after version %d", migrations.name, version, migrations.Latest()))
+	}
+	migrations.migrations = append(migrations.migrations, &Migration{version, description, migrate})
+}
+
+// Returns the version new saves are written with.
+func (migrations *Migrations) Latest() int {
+	if len(migrations.migrations) == 0 {
+		return 0
+	}
+	return migrations.migrations[len(migrations.migrations)-1].Version
+}
+
+// Runs every migration newer than version on save, updating version as each one succeeds.
+// Returns true if any migrations were run, and an error if one fails or the save is newer than the latest migration.
+func (migrations *Migrations) Run(save interface{}, version *int) (bool, error) {
+	if *version > migrations.Latest() {
+		return false, fmt.Errorf("%v save version %d is newer than the latest version %d", migrations.name, *version, migrations.Latest())
+	}
+	migrated := false
+	for _, migration := range migrations.migrations {
+		if migration.Version <= *version {
+			continue
+		}
+		if err := migration.Migrate(save); err != nil {
+			return migrated, fmt.Errorf("%v migration %d (%v) failed: %v", migrations.name, migration.Version, migration.Description, err)
+		}
+		logging.Info("Migrated", migrations.name, "save to version", migration.Version, migration.Description)
+		*version = migration.Version
+		migrated = true
+	}
+	return migrated, nil
+}
@@ -0,0 +1,153 @@
+package septapus
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var rpgnamepacks = rpgOptions.String("namepacks", "namepacks", "Asset directory of json name packs for the rpg, the file name is the name of the pack, eg: namepacks/scifi.json")
+var rpgnamepack = rpgOptions.String("namepack", "", "Comma separated list of the name pack used in each room, eg: synirc/#septapus=scifi,*/*=fantasy. Rooms default to fantasy")
+var rpgbannedwords = rpgOptions.String("bannedwords", "", "Comma separated list of words that are removed from every name pack")
+
+// The built in name pack, which fills in any names missing from other packs.
+const defaultNamePack = "fantasy"
+
+// The names used to generate monsters and items. Any list left empty in a pack file uses the fantasy pack's list.
+type NamePack struct {
+	Name          string
+	MonsterNames  []string
+	MonsterSmall  []string
+	MonsterLarge  []string
+	MonsterUnique []string
+	MonsterRare   []string
+	// Indexed by slot, eg: SLOT_WEAPON.
+	ItemNames [][]string
+	Prefixes  []string
+	Suffixes  []string
+	Uniques   []string
+	// Names containing any of these words are removed from the pack.
+	BannedWords []string
+	// Old item names containing these are renamed when migrating characters.
+	BannedItemNames [][]string
+	BannedPrefixes  []string
+}
+
+var (
+	namePacks         = make(map[string]*NamePack)
+	namePacksOnce     sync.Once
+	namePackRooms     RoomValues
+	namePackRoomsOnce sync.Once
+)
+
+// Returns the names in list that do not contain a banned word.
+func filterBanned(list []string, banned []string) []string {
+	if len(banned) == 0 {
+		return list
+	}
+	filtered := make([]string, 0, len(list))
+	for _, name := range list {
+		lower := strings.ToLower(name)
+		ok := true
+		for _, word := range banned {
+			if word != "" && strings.Contains(lower, strings.ToLower(word)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// Filters banned words out of the pack, then fills any empty lists from fallback.
+func (pack *NamePack) complete(fallback *NamePack, banned []string) {
+	banned = append(banned, pack.BannedWords...)
+	lists := []*[]string{&pack.MonsterNames, &pack.MonsterSmall, &pack.MonsterLarge, &pack.MonsterUnique, &pack.MonsterRare, &pack.Prefixes, &pack.Suffixes, &pack.Uniques}
+	fallbacks := [][]string{fallback.MonsterNames, fallback.MonsterSmall, fallback.MonsterLarge, fallback.MonsterUnique, fallback.MonsterRare, fallback.Prefixes, fallback.Suffixes, fallback.Uniques}
+	for i, list := range lists {
+		*list = filterBanned(*list, banned)
+		if len(*list) == 0 {
+			*list = fallbacks[i]
+		}
+	}
+	if pack.ItemNames == nil {
+		pack.ItemNames = make([][]string, NUM_SLOTS)
+	}
+	for slot := 0; slot < NUM_SLOTS; slot++ {
+		if slot >= len(pack.ItemNames) {
+			pack.ItemNames = append(pack.ItemNames, nil)
+		}
+		pack.ItemNames[slot] = filterBanned(pack.ItemNames[slot], banned)
+		if len(pack.ItemNames[slot]) == 0 {
+			pack.ItemNames[slot] = fallback.ItemNames[slot]
+		}
+	}
+}
+
+func loadNamePack(filename string) (*NamePack, error) {
+	file, err := OpenAsset(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pack := &NamePack{}
+	if err := json.NewDecoder(file).Decode(pack); err != nil {
+		return nil, err
+	}
+	pack.Name = strings.TrimSuffix(filepath.Base(filename), ".json")
+	return pack, nil
+}
+
+func loadNamePacks() {
+	banned := strings.Split(*rpgbannedwords, ",")
+	for i, word := range banned {
+		banned[i] = strings.TrimSpace(word)
+	}
+
+	fantasy := namePacks[defaultNamePack]
+	fantasy.complete(fantasy, banned)
+
+	files, err := ReadAssetDir(*rpgnamepacks)
+	if err != nil {
+		logging.Info("Error loading name packs", *rpgnamepacks, err)
+		return
+	}
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		pack, err := loadNamePack(filepath.Join(*rpgnamepacks, name))
+		if err != nil {
+			ReportError("rpg", "Error loading name pack", name, err)
+			continue
+		}
+		pack.complete(fantasy, banned)
+		namePacks[pack.Name] = pack
+		logging.Info("Loaded name pack", pack.Name)
+	}
+}
+
+// Returns the name pack with this name, or the fantasy pack if there is no pack with this name.
+func GetNamePack(name string) *NamePack {
+	namePacksOnce.Do(loadNamePacks)
+	if pack := namePacks[name]; pack != nil {
+		return pack
+	}
+	return namePacks[defaultNamePack]
+}
+
+// Returns the name pack configured for this game's room.
+func (game *Game) NamePack() *NamePack {
+	namePackRoomsOnce.Do(func() {
+		namePackRooms = ParseRoomValues(*rpgnamepack)
+	})
+	name, _ := namePackRooms.Get(game.Server, game.Room)
+	return GetNamePack(name)
+}
@@ -0,0 +1,61 @@
+package septapus
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNamePacks(t *testing.T) {
+	for _, name := range []string{"scifi", "meme"} {
+		filename := filepath.Join(*rpgnamepacks, name+".json")
+		pack, err := loadNamePack(filename)
+		if err != nil {
+			t.Errorf("loadNamePack(%v) = %v", filename, err)
+			continue
+		}
+		// A shipped pack names everything itself rather than falling back to fantasy names.
+		lists := map[string][]string{
+			"MonsterNames":  pack.MonsterNames,
+			"MonsterSmall":  pack.MonsterSmall,
+			"MonsterLarge":  pack.MonsterLarge,
+			"MonsterUnique": pack.MonsterUnique,
+			"MonsterRare":   pack.MonsterRare,
+			"Prefixes":      pack.Prefixes,
+			"Suffixes":      pack.Suffixes,
+			"Uniques":       pack.Uniques,
+		}
+		for list, names := range lists {
+			if len(names) == 0 {
+				t.Errorf("%v has no %v", filename, list)
+			}
+		}
+		// Unique monsters join two different parts.
+		if len(pack.MonsterUnique) < 2 {
+			t.Errorf("%v has %d MonsterUnique, want at least 2", filename, len(pack.MonsterUnique))
+		}
+		if len(pack.ItemNames) != NUM_SLOTS {
+			t.Errorf("%v has ItemNames for %d slots, want %d", filename, len(pack.ItemNames), NUM_SLOTS)
+		}
+		for slot, names := range pack.ItemNames {
+			if len(names) == 0 {
+				t.Errorf("%v has no ItemNames for %v", filename, slotNames[slot])
+			}
+		}
+
+		if got := GetNamePack(name); got.Name != name {
+			t.Errorf("GetNamePack(%v).Name = %v", name, got.Name)
+		}
+	}
+}
+
+func TestNamePackBannedWords(t *testing.T) {
+	pack := &NamePack{MonsterNames: []string{"Drone", "Bad Drone"}, BannedWords: []string{"bad"}}
+	fallback := GetNamePack(defaultNamePack)
+	pack.complete(fallback, nil)
+	if len(pack.MonsterNames) != 1 || pack.MonsterNames[0] != "Drone" {
+		t.Errorf("MonsterNames = %v, want [Drone]", pack.MonsterNames)
+	}
+	if len(pack.Prefixes) == 0 || len(pack.ItemNames) != NUM_SLOTS {
+		t.Errorf("Empty lists weren't filled from %v", fallback.Name)
+	}
+}
@@ -0,0 +1,110 @@
+package septapus
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var netsplitOptions = NewOptions("netsplit")
+
+var netsplitwindow = netsplitOptions.Duration("window", 2*time.Minute, "How long a server is treated as split after the last netsplit quit or rejoin, games pause while their server is split")
+
+// The quit message of a netsplit names the two servers that split, eg: irc.example.net hub.example.net, some networks hide them as *.net *.split.
+var netsplitQuit = regexp.MustCompile(`^[\w*-]+(\.[\w*-]+)+ [\w*-]+(\.[\w*-]+)+$`)
+
+// Returns true if a quit message is from a netsplit rather than the user quitting.
+func IsNetsplitQuit(message string) bool {
+	return netsplitQuit.MatchString(message)
+}
+
+// Tracks the nicks lost in a netsplit, a split lasts until they have all rejoined or nothing has happened for netsplitwindow.
+type NetsplitState struct {
+	sync.Mutex
+
+	last  time.Time
+	split map[string]bool
+}
+
+func NewNetsplitState() *NetsplitState {
+	return &NetsplitState{split: make(map[string]bool)}
+}
+
+// Returns true if the quit started or continued a netsplit.
+func (n *NetsplitState) Quit(nick, message string, now time.Time) bool {
+	if !IsNetsplitQuit(message) {
+		return false
+	}
+	n.Lock()
+	defer n.Unlock()
+
+	n.expire(now)
+	n.last = now
+	n.split[NameKey(nick)] = true
+	return true
+}
+
+// Returns true if the nick was lost in the netsplit and has now rejoined.
+func (n *NetsplitState) Join(nick string, now time.Time) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	n.expire(now)
+	key := NameKey(nick)
+	if !n.split[key] {
+		return false
+	}
+	delete(n.split, key)
+	n.last = now
+	return true
+}
+
+// Returns true while a netsplit is in progress.
+func (n *NetsplitState) Active(now time.Time) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	n.expire(now)
+	return len(n.split) > 0
+}
+
+func (n *NetsplitState) expire(now time.Time) {
+	if len(n.split) > 0 && now.Sub(n.last) >= *netsplitwindow {
+		n.split = make(map[string]bool)
+	}
+}
+
+// Returns true while the server is in a netsplit, the quits and rejoins it causes shouldn't be treated as people coming and going.
+func (server *Server) InNetsplit() bool {
+	return server.netsplit.Active(time.Now())
+}
+
+// Follows the quits and joins on every server to tell when a server is in a netsplit.
+func NetsplitPlugin(bot *Bot, settings *PluginSettings) {
+	quitchan := bot.GetEventHandler(client.QUIT)
+	joinchan := bot.GetEventHandler(client.JOIN)
+	for {
+		select {
+		case event, ok := <-quitchan:
+			if !ok {
+				return
+			}
+			split := event.Server.netsplit
+			wasActive := split.Active(time.Now())
+			if split.Quit(event.Line.Nick, event.Line.Text(), time.Now()) && !wasActive {
+				logging.Info("Netsplit on", event.Server.Name, event.Line.Text())
+			}
+		case event, ok := <-joinchan:
+			if !ok {
+				return
+			}
+			split := event.Server.netsplit
+			if split.Join(event.Line.Nick, time.Now()) && !split.Active(time.Now()) {
+				logging.Info("Netsplit over on", event.Server.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package septapus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+const chanServ = "ChanServ"
+
+type Ban struct {
+	Room    RoomName
+	Mask    string
+	Expires time.Time
+}
+
+type Bans struct {
+	sync.RWMutex
+	Bans []*Ban
+	// Rooms we have operator status in.
+	opped map[RoomName]bool
+}
+
+func (bans *Bans) Load(server ServerName) {
+	bans.Lock()
+	defer bans.Unlock()
+
+	filename := "bans/" + string(server) + ".json"
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(bans); err != nil {
+			ReportError("ops", "Error loading bans", server, err)
+		} else {
+			logging.Info("Loaded bans for", server)
+		}
+	} else {
+		logging.Info("Error loading file", server, filename, err)
+	}
+	bans.opped = make(map[RoomName]bool)
+}
+
+func (bans *Bans) Save(server ServerName) {
+	bans.Lock()
+	defer bans.Unlock()
+
+	filename := "bans/" + string(server) + ".json"
+
+	if file, err := os.Create(filename); err == nil {
+		defer file.Close()
+		enc := json.NewEncoder(file)
+		if err := enc.Encode(bans); err != nil {
+			ReportError("ops", "Error saving bans", server, err)
+		} else {
+			logging.Info("Saved bans", server)
+		}
+	} else {
+		logging.Info("Error creating file", server, filename, err)
+	}
+}
+
+func (bans *Bans) Add(ban *Ban) {
+	bans.Lock()
+	defer bans.Unlock()
+
+	bans.Bans = append(bans.Bans, ban)
+}
+
+// Removes and returns all bans that have expired.
+func (bans *Bans) Expired() []*Ban {
+	bans.Lock()
+	defer bans.Unlock()
+
+	expired := make([]*Ban, 0)
+	remaining := make([]*Ban, 0)
+	now := time.Now()
+	for _, ban := range bans.Bans {
+		if !ban.Expires.IsZero() && ban.Expires.Before(now) {
+			expired = append(expired, ban)
+		} else {
+			remaining = append(remaining, ban)
+		}
+	}
+	bans.Bans = remaining
+	return expired
+}
+
+func (bans *Bans) SetOpped(room RoomName, opped bool) {
+	bans.Lock()
+	defer bans.Unlock()
+
+	bans.opped[room] = opped
+}
+
+func (bans *Bans) IsOpped(room RoomName) bool {
+	bans.RLock()
+	defer bans.RUnlock()
+
+	return bans.opped[room]
+}
+
+// Turns a nick into a ban mask, masks are returned unchanged.
+func BanMask(target string) string {
+	if strings.ContainsAny(target, "!@") {
+		return target
+	}
+	return target + "!*@*"
+}
+
+// Sets a mode on a nick, if we are not opped ChanServ is asked to do it for us.
+func setMode(server *Server, bans *Bans, room RoomName, mode, chanServCommand, target string) {
+	if bans.IsOpped(room) {
+		server.Conn.Mode(string(room), mode, target)
+	} else {
+		server.Privmsg(chanServ, fmt.Sprintf("%v %v %v", chanServCommand, room, target))
+	}
+}
+
+// A channel mode set or unset on a nick or mask by a MODE line.
+type ModeChange struct {
+	Adding bool
+	Mode   rune
+	Arg    string
+}
+
+// Returns the changes to channel modes that take a nick or mask in a MODE line, eg: +ov iopred septapus.
+func ModeChanges(line *client.Line) []ModeChange {
+	changes := make([]ModeChange, 0)
+	if len(line.Args) < 3 {
+		return changes
+	}
+	adding := true
+	arg := 2
+	for _, c := range line.Args[1] {
+		switch c {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case 'o', 'v', 'b', 'k', 'h', 'q', 'a', 'e', 'I':
+			if arg < len(line.Args) {
+				changes = append(changes, ModeChange{adding, c, line.Args[arg]})
+				arg++
+			}
+		case 'l':
+			if adding {
+				arg++
+			}
+		}
+	}
+	return changes
+}
+
+// Tracks our own operator status from NAMES replies and MODE changes.
+func updateOpped(bans *Bans, event *Event) {
+	me := event.Server.Conn.Me().Nick
+	switch EventName(event.Line.Cmd) {
+	case NAMES:
+		if len(event.Line.Args) < 4 {
+			return
+		}
+		for _, nick := range strings.Fields(event.Line.Args[3]) {
+			if strings.TrimLeft(nick, "~&@%+") == me {
+				bans.SetOpped(RoomName(event.Line.Args[2]), strings.ContainsAny(nick[:1], "~&@"))
+			}
+		}
+	case client.MODE:
+		for _, change := range ModeChanges(event.Line) {
+			if change.Mode == 'o' && change.Arg == me {
+				bans.SetOpped(event.Room, change.Adding)
+			}
+		}
+	}
+}
+
+func NewOpsPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(OpsPlugin, settings)
+}
+
+func OpsPlugin(bot *Bot, settings *PluginSettings) {
+	// One listener runs for each connected server, closing its channel stops it. A listener is stopped when its server
+	// disconnects, and before another is started for the server, so commands and timed unbans only happen once.
+	listeners := make(map[ServerName]chan bool)
+	stop := func(name ServerName) {
+		if listener := listeners[name]; listener != nil {
+			close(listener)
+			delete(listeners, name)
+		}
+	}
+	start := func(server *Server) {
+		stop(server.Name)
+		listener := make(chan bool)
+		listeners[server.Name] = listener
+		go OpsListener(bot, settings, server, listener)
+	}
+
+	connectchan := bot.GetEventHandler(client.CONNECTED)
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
+	defer bot.RemoveEventHandlers(connectchan, disconnectchan)
+
+	bot.RLock()
+	for _, server := range bot.servers {
+		if server.Conn.Connected() {
+			start(server)
+		}
+	}
+	bot.RUnlock()
+
+	for {
+		select {
+		case event, ok := <-connectchan:
+			if !ok {
+				return
+			}
+			start(event.Server)
+		case event, ok := <-disconnectchan:
+			if !ok {
+				return
+			}
+			stop(event.Server.Name)
+		}
+	}
+}
+
+var (
+	opCommand    = NewCommand("!op [nick]").WithHelp("Admins only, gives a nick operator status in the room, you by default.")
+	voiceCommand = NewCommand("!voice [nick]").WithHelp("Admins only, voices a nick in the room, you by default.")
+	banCommand   = NewCommand("!ban <nick> [duration]").WithHelp("Admins only, bans a nick or mask from the room, for a while if given a duration, eg: 10m, 2h.")
+	unbanCommand = NewCommand("!unban <nick>").WithHelp("Admins only, unbans a nick or mask from the room.")
+)
+
+// Runs the ops commands and timed unbans for a server until stop is closed or the server is removed.
+func OpsListener(bot *Bot, settings *PluginSettings, server *Server, stop <-chan bool) {
+	bans := &Bans{}
+	bans.Load(server.Name)
+
+	opchan := settings.HandleCommand(bot, opCommand, IsServer(server.Name))
+	voicechan := settings.HandleCommand(bot, voiceCommand, IsServer(server.Name))
+	banchan := settings.HandleCommand(bot, banCommand, IsServer(server.Name))
+	unbanchan := settings.HandleCommand(bot, unbanCommand, IsServer(server.Name))
+	mode := settings.GetEventHandler(bot, client.MODE, IsServer(server.Name))
+	names := settings.GetEventHandler(bot, NAMES, IsServer(server.Name))
+	modechan := Merge(mode, names)
+	// Removing the handlers closes them, the merge is drained until it closes too.
+	defer func() {
+		bot.RemoveEventHandlers(opchan, voicechan, banchan, unbanchan, mode, names)
+		for _ = range modechan {
+		}
+	}()
+
+	unbanticker := time.NewTicker(30 * time.Second)
+	defer unbanticker.Stop()
+
+	// Commands must be used in a room by an admin, the target defaults to the sender.
+	target := func(event *Event) (string, []string, bool) {
+		fields := strings.Fields(event.Line.Text())
+		if !event.Line.Public() || !IsAdmin(event) {
+			return "", nil, false
+		}
+		if len(fields) < 2 {
+			return event.Line.Nick, nil, true
+		}
+		return fields[1], fields[2:], true
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-server.Done():
+			return
+		case event, ok := <-opchan:
+			if !ok {
+				return
+			}
+			if nick, _, ok := target(event); ok {
+				setMode(server, bans, event.Room, "+o", "OP", nick)
+			}
+		case event, ok := <-voicechan:
+			if !ok {
+				return
+			}
+			if nick, _, ok := target(event); ok {
+				setMode(server, bans, event.Room, "+v", "VOICE", nick)
+			}
+		case event, ok := <-banchan:
+			if !ok {
+				return
+			}
+			nick, args, ok := target(event)
+			if !ok || nick == event.Line.Nick {
+				break
+			}
+			ban := &Ban{Room: event.Room, Mask: BanMask(nick)}
+			if len(args) > 0 {
+				duration, err := time.ParseDuration(args[0])
+				if err != nil {
+					server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "ops.banusage", nil))
+					break
+				}
+				ban.Expires = time.Now().Add(duration)
+			}
+			setMode(server, bans, event.Room, "+b", "BAN", ban.Mask)
+			if !ban.Expires.IsZero() {
+				bans.Add(ban)
+				bans.Save(server.Name)
+			}
+		case event, ok := <-unbanchan:
+			if !ok {
+				return
+			}
+			if nick, _, ok := target(event); ok && nick != event.Line.Nick {
+				setMode(server, bans, event.Room, "-b", "UNBAN", BanMask(nick))
+			}
+		case event, ok := <-modechan:
+			if !ok {
+				return
+			}
+			updateOpped(bans, event)
+		case <-unbanticker.C:
+			expired := bans.Expired()
+			for _, ban := range expired {
+				setMode(server, bans, ban.Room, "-b", "UNBAN", ban.Mask)
+			}
+			if len(expired) > 0 {
+				bans.Save(server.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var outboxOptions = NewOptions("outbox")
+
+var outboxsize = outboxOptions.Int("size", 100, "Most messages held for each server while it is disconnected, they are sent once it reconnects and rejoins the room. 0 drops them")
+var outboxttl = outboxOptions.Duration("ttl", 10*time.Minute, "How long a held message is worth sending, older messages are dropped")
+var nobuffer = outboxOptions.String("nobuffer", "", "Comma separated list of plugins whose messages are dropped while disconnected rather than sent late, for output that is only useful straight away, eg: url,twitch")
+
+var (
+	unbufferedPlugins     map[string]bool
+	unbufferedPluginsOnce sync.Once
+)
+
+// Returns true if a plugin's messages shouldn't be held while disconnected.
+func isUnbuffered(plugin string) bool {
+	unbufferedPluginsOnce.Do(func() {
+		unbufferedPlugins = make(map[string]bool)
+		for _, name := range strings.Split(*nobuffer, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				unbufferedPlugins[name] = true
+			}
+		}
+	})
+	return unbufferedPlugins[plugin]
+}
+
+func isRoomTarget(target string) bool {
+	return strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&")
+}
+
+type heldMessage struct {
+	target string
+	write  func()
+	held   time.Time
+}
+
+// Holds the messages sent to a server while it is disconnected. Messages to nicks are sent once it reconnects,
+// messages to a room once it has rejoined the room, so they aren't sent to a room we aren't in yet.
+type Outbox struct {
+	sync.Mutex
+
+	messages []*heldMessage
+	// The rooms we are in, and the rooms we were in when we disconnected that we haven't rejoined yet.
+	joined    map[RoomName]bool
+	rejoining map[RoomName]time.Time
+}
+
+func NewOutbox() *Outbox {
+	return &Outbox{joined: make(map[RoomName]bool), rejoining: make(map[RoomName]time.Time)}
+}
+
+// Returns true if a message to target can't be sent yet.
+func (outbox *Outbox) Hold(connected bool, target string) bool {
+	if !connected {
+		return true
+	}
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	return outbox.holding(target)
+}
+
+// Returns true if target is a room we haven't rejoined since we reconnected, the outbox must be locked.
+func (outbox *Outbox) holding(target string) bool {
+	if !isRoomTarget(target) {
+		return false
+	}
+	disconnected, ok := outbox.rejoining[RoomName(target)]
+	return ok && time.Since(disconnected) < *outboxttl
+}
+
+// Holds a message until it can be sent, the oldest message is dropped when the outbox is full.
+func (outbox *Outbox) Add(target string, write func()) {
+	if *outboxsize <= 0 {
+		return
+	}
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	if len(outbox.messages) >= *outboxsize {
+		logging.Info("Outbox full, dropping message to", outbox.messages[0].target)
+		outbox.messages = outbox.messages[1:]
+	}
+	outbox.messages = append(outbox.messages, &heldMessage{target, write, time.Now()})
+}
+
+// Removes and returns the held messages that ready accepts, in the order they were sent. Messages that have expired are dropped.
+// The outbox is locked while ready is called.
+func (outbox *Outbox) take(ready func(target string) bool) []*heldMessage {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	taken := make([]*heldMessage, 0)
+	kept := make([]*heldMessage, 0, len(outbox.messages))
+	for _, message := range outbox.messages {
+		switch {
+		case time.Since(message.held) >= *outboxttl:
+		case ready(message.target):
+			taken = append(taken, message)
+		default:
+			kept = append(kept, message)
+		}
+	}
+	outbox.messages = kept
+	return taken
+}
+
+func (outbox *Outbox) disconnected() {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	now := time.Now()
+	for room, _ := range outbox.joined {
+		outbox.rejoining[room] = now
+	}
+	outbox.joined = make(map[RoomName]bool)
+}
+
+func (outbox *Outbox) join(room RoomName) {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	outbox.joined[room] = true
+	delete(outbox.rejoining, room)
+}
+
+func (outbox *Outbox) leave(room RoomName) {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	delete(outbox.joined, room)
+	delete(outbox.rejoining, room)
+}
+
+// Returns true if we are in a room on the server.
+func (server *Server) InRoom(room RoomName) bool {
+	server.outbox.Lock()
+	defer server.outbox.Unlock()
+
+	for joined, _ := range server.outbox.joined {
+		if SameRoom(server.Name, joined, room) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends the held messages that can now be sent.
+func (server *Server) flushOutbox(ready func(target string) bool) {
+	messages := server.outbox.take(ready)
+	if len(messages) > 0 {
+		logging.Info("Sending held messages to", server.Name, len(messages))
+	}
+	for _, message := range messages {
+		server.send(message.target, false, message.write)
+	}
+}
+
+// Follows connects, joins and parts to know when each server's held messages can be sent.
+func OutboxPlugin(bot *Bot, settings *PluginSettings) {
+	kickedSelf := func(event *Event) bool {
+		return len(event.Line.Args) > 1 && event.Line.Args[1] == event.Server.Conn.Me().Nick
+	}
+	connectchan := bot.GetEventHandler(client.CONNECTED)
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
+	joinchan := bot.GetEventHandler(client.JOIN, IsSelf())
+	partchan := bot.GetEventHandler(client.PART, IsSelf())
+	kickchan := bot.GetEventHandler(client.KICK, kickedSelf)
+	for {
+		select {
+		case event, ok := <-connectchan:
+			if !ok {
+				return
+			}
+			// Messages to rooms we are about to rejoin wait for the join.
+			outbox := event.Server.outbox
+			event.Server.flushOutbox(func(target string) bool {
+				return !outbox.holding(target)
+			})
+		case event, ok := <-disconnectchan:
+			if !ok {
+				return
+			}
+			event.Server.outbox.disconnected()
+		case event, ok := <-joinchan:
+			if !ok {
+				return
+			}
+			room := event.Line.Target()
+			event.Server.outbox.join(RoomName(room))
+			event.Server.flushOutbox(func(target string) bool {
+				return target == room
+			})
+		case event, ok := <-partchan:
+			if !ok {
+				return
+			}
+			event.Server.outbox.leave(RoomName(event.Line.Target()))
+		case event, ok := <-kickchan:
+			if !ok {
+				return
+			}
+			event.Server.outbox.leave(RoomName(event.Line.Target()))
+		}
+	}
+}
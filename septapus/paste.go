@@ -0,0 +1,141 @@
+package septapus
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var pasteOptions = NewOptions("paste")
+
+var pasteurl = pasteOptions.String("url", "", "Url of a paste service to post long responses to, the response body should be the link to the paste")
+var pastekey = pasteOptions.String("key", "", "Private key for posting to the paste service")
+var pasteaddr = pasteOptions.String("addr", "", "Address to serve long responses from when no paste url is set, eg: :8080")
+var pastebaseurl = pasteOptions.String("baseurl", "http://localhost:8080", "Public url of the built in paste server")
+var pastelines = pasteOptions.Int("lines", 5, "Responses with more lines than this are pasted instead of sent to the channel")
+var pastetimeout = pasteOptions.Duration("timeout", 10*time.Second, "How long to wait for the paste service before sending a response as normal")
+
+const maxPastes = 500
+
+type PasteServer struct {
+	sync.RWMutex
+
+	pastes map[string]string
+	order  []string
+	start  sync.Once
+}
+
+var (
+	httpMux   = http.NewServeMux()
+	httpStart sync.Once
+
+	pasteClient     *http.Client
+	pasteClientOnce sync.Once
+)
+
+// Serves a handler from the built in http server on pasteaddr, returns false if there is no address to serve from.
+func HandleHTTP(pattern string, handler http.Handler) bool {
+	if *pasteaddr == "" {
+		return false
+	}
+	httpMux.Handle(pattern, handler)
+	httpStart.Do(func() {
+		go func() {
+			if err := http.ListenAndServe(*pasteaddr, httpMux); err != nil {
+				ReportError("http", "Error serving http:", err)
+			}
+		}()
+	})
+	return true
+}
+
+var pasteServer = &PasteServer{pastes: make(map[string]string)}
+
+func (p *PasteServer) Add(text string) string {
+	p.Lock()
+	defer p.Unlock()
+
+	id := fmt.Sprintf("%08x", rand.Uint32())
+	for p.pastes[id] != "" {
+		id = fmt.Sprintf("%08x", rand.Uint32())
+	}
+	p.pastes[id] = text
+	p.order = append(p.order, id)
+	if len(p.order) > maxPastes {
+		delete(p.pastes, p.order[0])
+		p.order = p.order[1:]
+	}
+	return id
+}
+
+func (p *PasteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.RLock()
+	text, ok := p.pastes[strings.TrimPrefix(r.URL.Path, "/paste/")]
+	p.RUnlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(text))
+}
+
+func (p *PasteServer) Paste(text string) (string, error) {
+	if *pasteaddr == "" {
+		return "", errors.New("No paste service configured.")
+	}
+	p.start.Do(func() {
+		HandleHTTP("/paste/", p)
+	})
+	return strings.TrimRight(*pastebaseurl, "/") + "/paste/" + p.Add(text), nil
+}
+
+// Pastes text to the configured paste service, or the built in server, and returns a link to it.
+func Paste(text string) (string, error) {
+	if *pasteurl == "" {
+		return pasteServer.Paste(text)
+	}
+	pasteClientOnce.Do(func() {
+		pasteClient = &http.Client{Timeout: *pastetimeout}
+	})
+	resp, err := pasteClient.PostForm(*pasteurl, url.Values{"key": {*pastekey}, "content": {text}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected status from paste service: %v", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func PasteEnabled() bool {
+	return *pasteurl != "" || *pasteaddr != ""
+}
+
+// Sends lines to target, if there are too many lines they are pasted and a link is sent instead.
+// If pasting is not configured or fails the lines are sent as normal.
+func PrivmsgLines(server *Server, target string, lines []string) {
+	if len(lines) > *pastelines && PasteEnabled() {
+		if link, err := Paste(strings.Join(lines, "\n")); err == nil {
+			server.Privmsg(target, link)
+			return
+		} else {
+			ReportError("paste", "Error pasting response:", err)
+		}
+	}
+	for _, line := range lines {
+		server.Privmsg(target, line)
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	client "github.com/fluffle/goirc/client"
-	"html"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
 type youTubeVideo struct {
@@ -32,51 +31,126 @@ type youTubeVideo struct {
 }
 
 const (
-	UrlRegex     string = `(\s|^)(http://|https://|www.)(.*?)(\s|$)`
-	YouTubeRegex string = `(\s|^)(http://|https://)?(www.)?(youtube.com/watch\?v=|youtu.be/)(.*?)(\s|$|\&|#)`
+	UrlRegex     string = `(\s|^)(http://|https://|www\.)(.*?)(\s|$)`
+	YouTubeRegex string = `(\s|^)(http://|https://)?(www\.)?(youtube\.com/watch\?v=|youtu\.be/)(.*?)(\s|$|\&|#)`
 )
 
+// The longest link previewed, longer ones are more likely junk than a page worth fetching.
+const maxURLLength = 2048
+
+// Compiled once, these are checked against every message.
+var (
+	urlPattern     = regexp.MustCompile(UrlRegex)
+	youTubePattern = regexp.MustCompile(YouTubeRegex)
+	titlePattern   = regexp.MustCompile(`<title>(.*?)</title>`)
+)
+
+// Returns the submatches of the first YouTube link in text, the video id second to last, or nil if there isn't one with
+// a valid id.
 func isYouTubeURL(text string) []string {
-	if regex, err := regexp.Compile(YouTubeRegex); err == nil {
-		if regex.MatchString(text) {
-			return regex.FindStringSubmatch(text)
-		}
+	matches := youTubePattern.FindStringSubmatch(text)
+	if matches == nil || !youTubeIDPattern.MatchString(matches[len(matches)-2]) {
+		return nil
 	}
-	return nil
+	return matches
 }
 
+// Returns the first link in text, or an empty string if there isn't one that could be fetched.
 func isUrl(text string) string {
-	if regex, err := regexp.Compile(UrlRegex); err == nil {
-		url := strings.TrimSpace(regex.FindString(text))
-		if url != "" {
-			if strings.Index(url, "http") != 0 {
-				url = "http://" + url
-			}
-			return url
+	link := strings.TrimSpace(urlPattern.FindString(text))
+	if link == "" || len(link) > maxURLLength || strings.IndexFunc(link, unicode.IsControl) != -1 {
+		return ""
+	}
+	if strings.Index(link, "http") != 0 {
+		link = "http://" + link
+	}
+	if parsed, err := url.Parse(link); err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	return link
+}
+
+var (
+	titleCommand = NewCommand("!title <url>").WithHelp("Shows the title of a link.")
+	ytCommand    = NewCommand("!yt <video>").WithHelp("Shows a YouTube video's title and views, from its link or id.")
+)
+
+var youTubeIDPattern = regexp.MustCompile(`^[\w-]{11}$`)
+
+// Returns true if text asks for a preview, the passive previews leave these to the commands.
+func isPreviewCommand(text string) bool {
+	return titleCommand.Matches(text) || ytCommand.Matches(text)
+}
+
+// Returns the preview of a YouTube video.
+func YouTubePreview(id string) (string, error) {
+	resp, err := SharedFetcher().Get(fmt.Sprintf("https://gdata.youtube.com/feeds/api/videos/%s?v=2&alt=json", id))
+	if err != nil {
+		return "", err
+	}
+	var data youTubeVideo
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s - %s views (%s likes, %s dislikes)", data.Entry.Info.Title.Text, data.Entry.Statistics.Views, data.Entry.Rating.Likes, data.Entry.Rating.Dislikes), nil
+}
+
+// Returns the preview of a link, its title or the text in an image. Empty if there is nothing to show, an error if the
+// link couldn't be fetched.
+func URLPreview(url string) (string, error) {
+	title, err := FetchTitle(url)
+	switch err {
+	case nil:
+	case ErrLoginRequired:
+		return *urlloginfallback, nil
+	case ErrDisallowed:
+		return "", nil
+	default:
+		return "", err
+	}
+	if title == "" && ImageTextEnabled() {
+		if title, err = DescribeImage(url); err != nil {
+			ReportError("url", "Error describing image:", url, err)
+			return "", err
 		}
 	}
-	return ""
+	return title, nil
 }
 
 func NewYouTubePlugin(settings *PluginSettings) Plugin {
 	return NewSimplePlugin(YouTubePlugin, settings)
 }
 
+// Previews YouTube links as they are posted, and on demand with !yt <url|id>.
 func YouTubePlugin(bot *Bot, settings *PluginSettings) {
+	settings.DescribeCommand(bot, ytCommand)
 	channel := settings.GetEventHandler(bot, client.PRIVMSG)
 	for event := range channel {
-		matches := isYouTubeURL(event.Line.Text())
-		if matches != nil {
-			id := matches[len(matches)-2]
-			url := fmt.Sprintf("https://gdata.youtube.com/feeds/api/videos/%s?v=2&alt=json", id)
-			if resp, err := http.Get(url); err == nil {
-				defer resp.Body.Close()
-				if contents, err := ioutil.ReadAll(resp.Body); err == nil {
-					var data youTubeVideo
-					if err := json.Unmarshal(contents, &data); err == nil {
-						event.Server.Conn.Privmsg(event.Line.Target(), fmt.Sprintf("%s - %s views (%s likes, %s dislikes)", data.Entry.Info.Title.Text, data.Entry.Statistics.Views, data.Entry.Rating.Likes, data.Entry.Rating.Dislikes))
-					}
-				}
+		text := event.Line.Text()
+		id := ""
+		if ytCommand.Matches(text) {
+			args, err := ytCommand.Parse(text)
+			if err != nil {
+				event.Server.Privmsg(event.Line.Nick, err.Error())
+				continue
+			}
+			video := args.String("video")
+			if matches := isYouTubeURL(video); matches != nil {
+				id = matches[len(matches)-2]
+			} else if youTubeIDPattern.MatchString(video) {
+				id = video
+			} else {
+				event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, "yt.badvideo", nil))
+				continue
+			}
+		} else if matches := isYouTubeURL(text); matches != nil && !isPreviewCommand(text) && !event.Server.CatchingUp(event) {
+			id = matches[len(matches)-2]
+		}
+		if id != "" {
+			if preview, err := CachedYouTubePreview(event.Server.Name, event.Room, id); err == nil {
+				event.Server.Send(URLTitleStyle(event.Server.Name, event.Room), event.Line.Target(), preview)
+			} else if err == ErrRateLimited && ytCommand.Matches(text) {
+				event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, "title.ratelimited", nil))
 			}
 		}
 	}
@@ -86,26 +160,45 @@ func NewURLPlugin(settings *PluginSettings) Plugin {
 	return NewSimplePlugin(URLPlugin, settings)
 }
 
+// Previews links as they are posted, and on demand with !title <url>.
 func URLPlugin(bot *Bot, settings *PluginSettings) {
+	settings.DescribeCommand(bot, titleCommand)
 	channel := settings.GetEventHandler(bot, client.PRIVMSG)
 	for event := range channel {
-		url := isUrl(event.Line.Text())
+		text := event.Line.Text()
+		url := ""
+		if titleCommand.Matches(text) {
+			args, err := titleCommand.Parse(text)
+			if err != nil {
+				event.Server.Privmsg(event.Line.Nick, err.Error())
+				continue
+			}
+			if url = isUrl(args.String("url")); url == "" {
+				event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, "title.badurl", nil))
+				continue
+			}
+			// YouTube previews have more to show than the page title.
+			if matches := isYouTubeURL(url); matches != nil {
+				if preview, err := CachedYouTubePreview(event.Server.Name, event.Room, matches[len(matches)-2]); err == nil {
+					event.Server.Send(URLTitleStyle(event.Server.Name, event.Room), event.Line.Target(), preview)
+					continue
+				}
+			}
+		} else if !isPreviewCommand(text) && !event.Server.CatchingUp(event) {
+			if url = isUrl(text); url != "" && isYouTubeURL(url) != nil {
+				url = ""
+			}
+		}
 		if url != "" {
-			if isYouTubeURL(url) == nil {
-				if resp, err := http.Get(url); err == nil {
-					defer resp.Body.Close()
-					if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
-						if content, err := ioutil.ReadAll(resp.Body); err == nil {
-							contents := string(content)
-							contents = html.UnescapeString(strings.Replace(contents, "\n", "", -1))
-							if regex, err := regexp.Compile(`<title>(.*?)</title>`); err == nil {
-								if regex.MatchString(contents) {
-									event.Server.Conn.Privmsg(event.Line.Target(), strings.TrimSpace(regex.FindStringSubmatch(contents)[1]))
-								}
-							}
-						}
-					}
+			preview, err := CachedURLPreview(event.Server.Name, event.Room, url)
+			if preview != "" {
+				event.Server.Send(URLTitleStyle(event.Server.Name, event.Room), event.Line.Target(), preview)
+			} else if err == ErrRateLimited {
+				if titleCommand.Matches(text) {
+					event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, "title.ratelimited", nil))
 				}
+			} else if titleCommand.Matches(text) {
+				event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, "title.none", nil))
 			}
 		}
 	}
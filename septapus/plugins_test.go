@@ -0,0 +1,62 @@
+package septapus
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func FuzzIsUrl(f *testing.F) {
+	for _, seed := range []string{"http://septapus.com", "look www.example.com/page here", "https://", "http://\x00evil", "http://[::1", "https://exаmple.com", "http://" + strings.Repeat("a", 3000) + ".com"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		link := isUrl(text)
+		if link == "" {
+			return
+		}
+		if len(link) > maxURLLength+len("http://") || strings.IndexFunc(link, unicode.IsControl) != -1 {
+			t.Errorf("isUrl(%q) = %q", text, link)
+		}
+		if parsed, err := url.Parse(link); err != nil || parsed.Hostname() == "" {
+			t.Errorf("isUrl(%q) = %q, which has no host", text, link)
+		}
+	})
+}
+
+func FuzzIsYouTubeURL(f *testing.F) {
+	for _, seed := range []string{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtu.be/dQw4w9WgXcQ#t=1", "youtube.com/watch?v=short", "youtubexcom/watch?v=dQw4w9WgXcQ", "https://youtu.be/" + strings.Repeat("a", 500)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		matches := isYouTubeURL(text)
+		if matches != nil && !youTubeIDPattern.MatchString(matches[len(matches)-2]) {
+			t.Errorf("isYouTubeURL(%q) has id %q", text, matches[len(matches)-2])
+		}
+	})
+}
+
+func BenchmarkIsUrl(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		isUrl(benchmarkLine)
+	}
+}
+
+func BenchmarkIsYouTubeURL(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		isYouTubeURL(benchmarkLine)
+	}
+}
+
+// Checks a line with every pattern a busy room's messages go through.
+func BenchmarkMessagePatterns(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		isLaugh(benchmarkLine)
+		isUrl(benchmarkLine)
+		isYouTubeURL(benchmarkLine)
+	}
+}
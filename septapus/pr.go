@@ -1,11 +1,9 @@
 package septapus
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fluffle/golog/logging"
-	"os"
 	"regexp"
 	"sort"
 	"strconv"
@@ -16,6 +14,31 @@ import (
 	client "github.com/fluffle/goirc/client"
 )
 
+var prOptions = NewOptions("pr")
+
+var prrooms = prOptions.String("rooms", "", "Comma separated list of rooms that answer PR commands, rooms that aren't listed are on, eg: synirc/#offtopic=off,*/*=on")
+
+var (
+	prRooms     RoomValues
+	prRoomsOnce sync.Once
+)
+
+// Returns true if PR commands are answered in a room, and meets can be run there.
+func PREnabled(server ServerName, room RoomName) bool {
+	prRoomsOnce.Do(func() {
+		prRooms = ParseRoomValues(*prrooms)
+	})
+	value, ok := prRooms.Get(server, room)
+	return !ok || value != "off"
+}
+
+// Passes private messages, and public messages from rooms with PR commands enabled.
+func IsPRRoom(server ServerName) EventPredicate {
+	return func(event *Event) bool {
+		return !event.Line.Public() || PREnabled(server, event.Room)
+	}
+}
+
 type OldPRs map[string]*string
 
 type LiftName string
@@ -72,42 +95,39 @@ func (weight *Weight) Compare(other *Weight) int {
 var lbsRegex string = "lbs|lb"
 var kgsRegex string = "kgs|kg"
 var unitRegex string = lbsRegex + "|" + kgsRegex
-var weightRegex string = "^([0-9]+)" + unitRegex + "$"
+var weightRegex string = "^([0-9]+)(" + unitRegex + ")$"
 
+// Compiled once, weights are parsed for every lift.
+var weightPattern = regexp.MustCompile(weightRegex)
+
+// The heaviest weight and most reps a lift can have, in either unit. Anything more is a typo.
+const (
+	maxWeight = 10000
+	maxReps   = 1000
+)
+
+// The longest lifts accepted at once, and the longest single lift or weight, eg: 5x5x100kgs.
+const (
+	maxLiftsLength = 200
+	maxLiftLength  = 32
+)
+
+// Returns the weight, or a weight with an undefined unit if str isn't a weight.
 func NewWeight(str string) (*Weight, error) {
 	weight := &Weight{}
-	if wRegex, err := regexp.Compile(weightRegex); err == nil {
-		if wRegex.MatchString(str) {
-			if lRegex, err := regexp.Compile(lbsRegex); err == nil {
-				if lRegex.MatchString(str) {
-					weight.Unit = UNIT_LBS
-				}
-			} else {
-				return nil, err
-			}
-			if kRegex, err := regexp.Compile(kgsRegex); err == nil {
-				if kRegex.MatchString(str) {
-					weight.Unit = UNIT_KGS
-				}
-			} else {
-				return nil, err
-			}
-			if weight.Unit == UNIT_UNDEFINED {
-				return nil, errors.New("Undefined unit. Use lbs or kgs.")
-			}
-			if uRegex, err := regexp.Compile(unitRegex); err == nil {
-				str := uRegex.ReplaceAllString(str, "")
-				if value, err := strconv.Atoi(str); err == nil {
-					weight.Value = value
-				} else {
-					return nil, err
-				}
-			} else {
-				return nil, err
-			}
+	if len(str) > maxLiftLength {
+		return weight, nil
+	}
+	if match := weightPattern.FindStringSubmatch(str); match != nil {
+		weight.Unit = UNIT_LBS
+		if strings.HasPrefix(match[2], "kg") {
+			weight.Unit = UNIT_KGS
 		}
-	} else {
-		return nil, err
+		value, err := strconv.Atoi(match[1])
+		if err != nil || value < 1 || value > maxWeight {
+			return nil, fmt.Errorf("Bad weight, use 1 to %d.", maxWeight)
+		}
+		weight.Value = value
 	}
 	return weight, nil
 }
@@ -126,10 +146,15 @@ type Lift struct {
 }
 
 func (lift *Lift) String() string {
+	return lift.Format(DefaultTimeFormat)
+}
+
+// Returns the lift with its date shown in a locale, eg: 5x100kgs (02 Jan 2006).
+func (lift *Lift) Format(format *TimeFormat) string {
 	if lift.Reps < 2 {
-		return fmt.Sprintf("%v (%v)", lift.Weight.String(), lift.Date.Format("02 Jan 2006"))
+		return fmt.Sprintf("%v (%v)", lift.Weight.String(), format.Date(lift.Date))
 	}
-	return fmt.Sprintf("%dx%v (%v)", lift.Reps, lift.Weight.String(), lift.Date.Format("02 Jan 2006"))
+	return fmt.Sprintf("%dx%v (%v)", lift.Reps, lift.Weight.String(), format.Date(lift.Date))
 }
 
 func NewLift(liftNameString string, liftString string) (*Lift, error) {
@@ -142,6 +167,9 @@ func NewLift(liftNameString string, liftString string) (*Lift, error) {
 	}
 	lift.Name = liftName
 
+	if len(liftString) > maxLiftLength {
+		return nil, errors.New("Bad lift, it is too long. eg: 100kg, 5x100kg, 5x5x100kg")
+	}
 	parts := strings.Split(liftString, "x")
 
 	if len(parts) == 1 {
@@ -156,11 +184,16 @@ func NewLift(liftNameString string, liftString string) (*Lift, error) {
 		} else {
 			return nil, err
 		}
-		if reps, err := strconv.Atoi(parts[0]); err == nil {
+		if reps, err := strconv.Atoi(parts[0]); err == nil && reps >= 1 && reps <= maxReps {
 			lift.Reps = reps
 		} else {
-			return nil, err
+			return nil, fmt.Errorf("Bad number of reps, use 1 to %d.", maxReps)
 		}
+	} else {
+		return nil, errors.New("Bad lift, use weight, repsxweight or setsxrepsxweight. eg: 100kg, 5x100kg, 5x5x100kg")
+	}
+	if !lift.Weight.IsValid() {
+		return nil, errors.New("Bad weight, use kgs or lbs. eg: 100kg, 225lbs")
 	}
 	if lift.Name == BodyWeight && lift.Reps != 0 {
 		return nil, errors.New("Cannot set reps for your bodyweight.")
@@ -168,6 +201,46 @@ func NewLift(liftNameString string, liftString string) (*Lift, error) {
 	return lift, nil
 }
 
+// The most sets that can be added at once.
+const maxSets = 20
+
+// Parses comma separated lifts, each can be weight, repsxweight or setsxrepsxweight, every set is stored as its own lift.
+func NewLifts(liftNameString string, liftsString string) (Lifts, error) {
+	if len(liftsString) > maxLiftsLength {
+		return nil, errors.New("Too many lifts at once.")
+	}
+	lifts := make(Lifts, 0)
+	for _, entry := range strings.Split(liftsString, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		sets := 1
+		if strings.Count(entry, "x") == 2 {
+			parts := strings.SplitN(entry, "x", 2)
+			var err error
+			if sets, err = strconv.Atoi(parts[0]); err != nil || sets < 1 || sets > maxSets {
+				return nil, fmt.Errorf("Bad number of sets, use 1 to %d.", maxSets)
+			}
+			entry = parts[1]
+		}
+		if len(lifts)+sets > maxSets {
+			return nil, fmt.Errorf("Too many sets at once, add at most %d.", maxSets)
+		}
+		for i := 0; i < sets; i++ {
+			lift, err := NewLift(liftNameString, entry)
+			if err != nil {
+				return nil, err
+			}
+			lifts = append(lifts, lift)
+		}
+	}
+	if len(lifts) == 0 {
+		return nil, errors.New("No lifts given.")
+	}
+	return lifts, nil
+}
+
 func (lift *Lift) Compare(other *Lift) int {
 	if comparison := lift.Weight.Compare(other.Weight); comparison != 0 {
 		return comparison
@@ -196,10 +269,29 @@ func (l Lifts) Less(i, j int) bool {
 type Lifter struct {
 	Nick string
 	// PRName is the string, but must be string for unmarshalling.
-	Lifts     map[string]Lifts
+	Lifts map[string]Lifts
+	// Private lifters' numbers are only shown to themselves, and left out of rankings and meet results.
+	Private bool
+	// Weights given without a unit use the lifter's preferred unit, set with !prwizard.
+	Unit Unit `json:",omitempty"`
+	// The services account that owns the lifter, only its nicks can change the lifter's lifts.
+	Account   string `json:",omitempty"`
 	bestLifts map[string]*Lift
 }
 
+// Returns true if nick is allowed to see the lifter's numbers.
+func (lifter *Lifter) VisibleTo(nick string) bool {
+	return !lifter.Private || NameKey(nick) == NameKey(lifter.Nick)
+}
+
+// Returns where replies about the lifter should go, private lifters are only answered by PM.
+func (lifter *Lifter) ReplyTarget(line *client.Line) string {
+	if lifter.Private {
+		return line.Nick
+	}
+	return line.Target()
+}
+
 func (lifter *Lifter) CalculateBest() {
 	if lifter.bestLifts == nil {
 		lifter.bestLifts = make(map[string]*Lift)
@@ -227,24 +319,35 @@ func (lifter *Lifter) Best(liftName LiftName) *Lift {
 
 type PRS struct {
 	sync.RWMutex
-	OldPRs  map[string]OldPRs `json:"PRMaps"`
+	Version int
+	OldPRs  map[string]OldPRs `json:"PRMaps,omitempty"`
 	Lifters map[string]*Lifter
+	// Open meets, and the results of closed ones, kept separate from training lifts.
+	Meets       map[RoomName]*Meet
+	MeetHistory []*MeetRecord
 }
 
-func (prs *PRS) Migrate() {
-	prs.Lock()
-	defer prs.Unlock()
+// Upgrades old pr saves, add new migrations to the end when the save format changes.
+var prMigrations = NewMigrations("pr")
 
-	prs.Lifters = make(map[string]*Lifter)
-	for nick, prMap := range prs.OldPRs {
-		lifter := prs.GetLifter(nick, true)
-		for liftName, pr := range prMap {
-			if lift, err := NewLift(liftName, *pr); err == nil {
-				lifter.AddLift(lift)
+func init() {
+	prMigrations.Add(1, "convert pr maps to lifters", func(save interface{}) error {
+		prs := save.(*PRS)
+		if prs.OldPRs == nil {
+			return nil
+		}
+		prs.Lifters = make(map[string]*Lifter)
+		for nick, prMap := range prs.OldPRs {
+			lifter := prs.GetLifter(nick, true)
+			for liftName, pr := range prMap {
+				if lift, err := NewLift(liftName, *pr); err == nil {
+					lifter.AddLift(lift)
+				}
 			}
 		}
-	}
-	prs.OldPRs = nil
+		prs.OldPRs = nil
+		return nil
+	})
 }
 
 func (prs *PRS) Init() {
@@ -258,6 +361,9 @@ func (prs *PRS) Init() {
 			lifter.CalculateBest()
 		}
 	}
+	if prs.Meets == nil {
+		prs.Meets = make(map[RoomName]*Meet)
+	}
 }
 
 func (prs *PRS) GetLifter(nick string, create bool) (lifter *Lifter) {
@@ -274,6 +380,28 @@ func (prs *PRS) GetLifter(nick string, create bool) (lifter *Lifter) {
 	return
 }
 
+// Returns the lifter a nick records lifts as: the lifter its services account owns, otherwise the nick's own lifter
+// unless another account owns it, which a logged in nick claims. Returns false if the nick can't change any lifter.
+func (prs *PRS) OwnLifter(server *Server, nick string, create bool) (*Lifter, bool) {
+	if account, _ := server.Account(nick); account != "" {
+		for _, lifter := range prs.Lifters {
+			if lifter.Account != "" && SameAccount(server.Name, lifter.Account, account) {
+				return lifter, true
+			}
+		}
+	}
+	lifter := prs.GetLifter(nick, create)
+	if lifter == nil {
+		return nil, true
+	}
+	allowed, owner := server.Owns(lifter.Account, nick)
+	if !allowed {
+		return nil, false
+	}
+	lifter.Account = owner
+	return lifter, true
+}
+
 func (lifter *Lifter) AddLift(lift *Lift) {
 	key := string(lift.Name)
 	lifter.Lifts[key] = append(lifter.Lifts[key], lift)
@@ -286,7 +414,7 @@ func (lifter *Lifter) AddLift(lift *Lift) {
 	}
 }
 
-func (lifter *Lifter) List() string {
+func (lifter *Lifter) List(format *TimeFormat) string {
 	str := ""
 	if lifter.Lifts == nil {
 		return str
@@ -295,38 +423,37 @@ func (lifter *Lifter) List() string {
 		if len(str) != 0 {
 			str += ", "
 		}
-		str += lift.Name.String() + ": " + lift.String()
+		str += lift.Name.String() + ": " + lift.Format(format)
 	}
 	return str
 }
 
-func (lifter *Lifter) ListLift(liftName LiftName, cap bool) string {
-	str := ""
+// Returns the history of a lift, capped to the last 3 on one line, otherwise with each lift on its own line so a long
+// history can be pasted.
+func (lifter *Lifter) ListLift(liftName LiftName, cap bool, format *TimeFormat) []string {
 	if !liftName.IsValid() {
-		return str
+		return nil
 	}
 	key := string(liftName)
 	total := len(lifter.Lifts[key])
 	if total == 0 {
-		return str
+		return nil
 	}
 	count := total
-	switch {
-	case count > 3 && cap:
+	if count > 3 && cap {
 		count = 3
-		str += fmt.Sprintf("Last 3 %vs: ", liftName.String())
-	case count == 1:
-		str += fmt.Sprintf("%v:", liftName.String())
-	default:
-		str += fmt.Sprintf("Last %d %vs: ", count, liftName.String())
 	}
+	lifts := make([]string, 0, count)
 	for i := total - count; i < total; i++ {
-		str += lifter.Lifts[key][i].String()
-		if i+1 < total {
-			str += ", "
-		}
+		lifts = append(lifts, lifter.Lifts[key][i].Format(format))
 	}
-	return str
+	switch {
+	case count == 1:
+		return []string{fmt.Sprintf("%v: %v", liftName.String(), lifts[0])}
+	case cap:
+		return []string{fmt.Sprintf("Last %d %vs: %v", count, liftName.String(), strings.Join(lifts, ", "))}
+	}
+	return append([]string{fmt.Sprintf("Last %d %vs:", count, liftName.String())}, lifts...)
 }
 
 const (
@@ -355,6 +482,16 @@ var liftNames = map[LiftName]string{
 	BodyWeight:  "Body Weight",
 }
 
+var (
+	prCommand        = NewCommand("!pr <nick> [lift]").WithHelp("Shows a nick's personal records, or one lift's.")
+	prHistoryCommand = NewCommand("!prhistory <nick> <lift>").WithHelp("Shows the history of a nick's lift.")
+	prAddCommand     = NewCommand("!pradd <lift> <weight...>").WithHelp("Adds lifts, eg: !pradd squat 100kg 3x5.")
+	prClearCommand   = NewCommand("!prclear <lift>").WithHelp("Removes your records for a lift.")
+	prRankCommand    = NewCommand("!prrank <lift> <nick> <nicks...>").WithHelp("Ranks nicks by a lift.")
+	prHelpCommand    = NewCommand("!prhelp").WithHelp("Explains the pr commands.")
+	prPrivateCommand = NewCommand("!prprivate <setting>", "!prprivate").WithHelp("Shows or sets whether your lifts are hidden from others.")
+)
+
 func NewPRPlugin(settings *PluginSettings) Plugin {
 	return NewSimplePlugin(PRPlugin, settings)
 }
@@ -377,12 +514,27 @@ func PRListener(bot *Bot, settings *PluginSettings, server *Server) {
 
 	defer prs.Save(server.Name)
 
-	prchan := FilterSimpleCommand(FilterServer(settings.GetEventHandler(bot, client.PRIVMSG), server.Name), "!pr")
-	prhistorychan := FilterSimpleCommand(FilterServer(settings.GetEventHandler(bot, client.PRIVMSG), server.Name), "!prhistory")
-	praddchan := FilterSimpleCommand(FilterServer(settings.GetEventHandler(bot, client.PRIVMSG), server.Name), "!pradd")
-	prclearchan := FilterSimpleCommand(FilterServer(settings.GetEventHandler(bot, client.PRIVMSG), server.Name), "!prclear")
-	prrankchan := FilterSimpleCommand(FilterServer(settings.GetEventHandler(bot, client.PRIVMSG), server.Name), "!prrank")
-	prhelpchan := FilterSimpleCommand(FilterServer(settings.GetEventHandler(bot, client.PRIVMSG), server.Name), "!prhelp")
+	prchan := settings.HandleCommand(bot, prCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prhistorychan := settings.HandleCommand(bot, prHistoryCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	praddchan := settings.HandleCommand(bot, prAddCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prclearchan := settings.HandleCommand(bot, prClearCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prrankchan := settings.HandleCommand(bot, prRankCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prhelpchan := settings.HandleCommand(bot, prHelpCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prmeetchan := settings.HandleCommand(bot, prMeetCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prprivatechan := settings.HandleCommand(bot, prPrivateCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	prwizardchan := settings.HandleCommand(bot, prWizardCommand, IsServer(server.Name), IsPRRoom(server.Name))
+	// Answers to the wizard, private messages that aren't commands.
+	answerchan := settings.GetEventHandler(bot, client.PRIVMSG, IsServer(server.Name), IsPrivate(), func(event *Event) bool {
+		return !strings.HasPrefix(event.Line.Text(), "!")
+	})
+	wizards := make(map[string]*PRWizard)
+
+	ServeMeets(server.Name, prs)
+	meetticker := time.NewTicker(10 * time.Second)
+	defer meetticker.Stop()
+	// A ticker rather than time.After, the meet ticker would otherwise keep putting the save off.
+	saveticker := time.NewTicker(1 * time.Minute)
+	defer saveticker.Stop()
 
 	for {
 		select {
@@ -391,19 +543,21 @@ func PRListener(bot *Bot, settings *PluginSettings, server *Server) {
 				return
 			}
 
-			fields := strings.Fields(event.Line.Text())
+			args, err := prCommand.Parse(event.Line.Text())
 			message := ""
+			target := event.Line.Target()
 
-			if len(fields) == 2 || len(fields) == 3 {
-				lifter := prs.GetLifter(fields[1], false)
+			if err == nil {
+				lifter := prs.GetLifter(args.String("nick"), false)
 
-				if lifter != nil {
-					if len(fields) == 2 {
-						message = lifter.List()
+				if lifter != nil && lifter.VisibleTo(event.Line.Nick) {
+					target = lifter.ReplyTarget(event.Line)
+					if !args.Has("lift") {
+						message = lifter.List(GetTimeFormat(server.Name, event.Room, event.Line.Nick))
 					} else {
-						if liftName := LiftName(strings.ToLower(fields[2])); liftName.IsValid() {
+						if liftName := LiftName(strings.ToLower(args.String("lift"))); liftName.IsValid() {
 							if lift := lifter.Best(liftName); lift != nil {
-								message = liftName.String() + ": " + lift.String()
+								message = liftName.String() + ": " + lift.Format(GetTimeFormat(server.Name, event.Room, event.Line.Nick))
 							}
 						} else {
 							message = "Bad lift. !prhelp to get a list of valid lifts."
@@ -419,110 +573,135 @@ func PRListener(bot *Bot, settings *PluginSettings, server *Server) {
 				}
 
 			}
-			if message != "" {
-				server.Conn.Privmsg(event.Line.Target(), message)
+			if message != "" && target == event.Line.Target() {
+				settings.Reply(event, message)
+			} else if message != "" {
+				server.Privmsg(target, message)
 			} else {
-				server.Conn.Privmsg(event.Line.Nick, "Bad command: !pr <nick> [lift]")
+				server.Privmsg(event.Line.Nick, err.Error())
 			}
 		case event, ok := <-prhistorychan:
 			if !ok {
 				return
 			}
 
-			fields := strings.Fields(event.Line.Text())
-			message := ""
-			if len(fields) == 3 {
-				lifter := prs.GetLifter(fields[1], false)
-				if lifter != nil {
-					liftName := LiftName(strings.ToLower(fields[2]))
+			args, err := prHistoryCommand.Parse(event.Line.Text())
+			var lines []string
+			target := event.Line.Target()
+			if err == nil {
+				lifter := prs.GetLifter(args.String("nick"), false)
+				if lifter != nil && lifter.VisibleTo(event.Line.Nick) {
+					target = lifter.ReplyTarget(event.Line)
+					liftName := LiftName(strings.ToLower(args.String("lift")))
 					if liftName.IsValid() {
-						message = lifter.ListLift(liftName, event.Line.Target() != event.Line.Nick)
+						// The whole history is only sent in a private message, long histories are pasted.
+						lines = lifter.ListLift(liftName, event.Line.Target() != event.Line.Nick, GetTimeFormat(server.Name, event.Room, event.Line.Nick))
 					} else {
-						message = "Bad lift. !prhelp to get a list of valid lifts."
+						lines = []string{"Bad lift. !prhelp to get a list of valid lifts."}
 					}
-					if message == "" {
-						message = "No lifts for that nick."
+					if len(lines) == 0 {
+						lines = []string{"No lifts for that nick."}
 						break
 					}
 				} else {
-					message = "Bad Nick."
+					lines = []string{"Bad Nick."}
 					break
 				}
 			}
-			if message != "" {
-				server.Conn.Privmsg(event.Line.Target(), message)
+			if len(lines) != 0 && target == event.Line.Target() {
+				settings.ReplyLines(event, lines)
+			} else if len(lines) != 0 {
+				PrivmsgLines(server, target, lines)
 			} else {
-				server.Conn.Privmsg(event.Line.Nick, "Bad command: !pr <nick> <lift>")
+				server.Privmsg(event.Line.Nick, err.Error())
 			}
 		case event, ok := <-praddchan:
 			if !ok {
 				return
 			}
 
-			fields := strings.Fields(event.Line.Text())
-			if len(fields) == 3 {
-				lifter := prs.GetLifter(event.Line.Nick, true)
-				lift, err := NewLift(fields[1], fields[2])
+			if args, err := prAddCommand.Parse(event.Line.Text()); err == nil {
+				lifter, owned := prs.OwnLifter(server, event.Line.Nick, true)
+				if !owned {
+					server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "pr.notowner", ResponseVars{"Nick": event.Line.Nick}))
+					break
+				}
+				lifts, err := NewLifts(args.String("lift"), lifter.WithUnit(args.String("weight")))
 				if err == nil {
-					lifter.AddLift(lift)
+					for _, lift := range lifts {
+						lifter.AddLift(lift)
+					}
+					// Report the best of the new lifts, it is the only one that can be a PR.
+					sort.Sort(lifts)
+					lift := lifts[0]
+					vars := ResponseVars{"Count": len(lifts), "Lift": lift.Name.String(), "Weight": lift.Format(GetTimeFormat(server.Name, event.Room, event.Line.Nick))}
 					if lift == lifter.Best(lift.Name) {
-						server.Conn.Privmsg(event.Line.Nick, fmt.Sprintf("Added lift, New PR!! %v: %v", lift.Name.String(), lift.String()))
+						server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "pr.newpr", vars))
+						if lifter.Private {
+							break
+						}
+						bot.Emit(PR_NEW, server, event.Room, event.Line.Nick, fmt.Sprintf("%v: %v", lift.Name.String(), lift.String()), lift.Date, &PRNew{lift.Name, lift.Reps, lift.Weight})
 					} else {
-						server.Conn.Privmsg(event.Line.Nick, fmt.Sprintf("Added lift, %v: %v", lift.Name.String(), lift.String()))
+						server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "pr.added", vars))
 					}
 					break
 				} else {
-					server.Conn.Privmsg(event.Line.Nick, err.Error())
+					server.Privmsg(event.Line.Nick, err.Error())
 					break
 				}
 			} else {
-				server.Conn.Privmsg(event.Line.Nick, "Bad command. !prset [lift] [weight]")
+				server.Privmsg(event.Line.Nick, err.Error())
 			}
 		case event, ok := <-prclearchan:
 			if !ok {
 				return
 			}
 
-			fields := strings.Fields(event.Line.Text())
-			if len(fields) == 2 {
-				lifter := prs.GetLifter(event.Line.Nick, false)
+			if args, err := prClearCommand.Parse(event.Line.Text()); err == nil {
+				if !Verify(event) {
+					break
+				}
+				lifter, owned := prs.OwnLifter(server, event.Line.Nick, false)
+				if !owned {
+					server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "pr.notowner", ResponseVars{"Nick": event.Line.Nick}))
+					break
+				}
 
 				if lifter != nil {
-					liftName := LiftName(strings.ToLower(fields[1]))
+					liftName := LiftName(strings.ToLower(args.String("lift")))
 					key := string(liftName)
 					if !liftName.IsValid() {
-						server.Conn.Privmsg(event.Line.Nick, "Bad lift. !prhelp to get a list of valid lifts.")
+						server.Privmsg(event.Line.Nick, "Bad lift. !prhelp to get a list of valid lifts.")
 						break
 					} else if lifter.Lifts[key] == nil {
-						server.Conn.Privmsg(event.Line.Nick, "No PR's found")
+						server.Privmsg(event.Line.Nick, "No PR's found")
 						break
 					} else {
 						delete(lifter.Lifts, key)
 						delete(lifter.bestLifts, key)
 					}
 				} else {
-					server.Conn.Privmsg(event.Line.Nick, "No PR's found.")
+					server.Privmsg(event.Line.Nick, "No PR's found.")
 				}
 
 			} else {
-				server.Conn.Privmsg(event.Line.Nick, "Bad command. !prclear [lift]")
+				server.Privmsg(event.Line.Nick, err.Error())
 			}
 		case event, ok := <-prrankchan:
 			if !ok {
 				return
 			}
-			fields := strings.Fields(event.Line.Text())
-			if len(fields) >= 4 {
-				liftName := LiftName(strings.ToLower(fields[1]))
+			if args, err := prRankCommand.Parse(event.Line.Text()); err == nil {
+				liftName := LiftName(strings.ToLower(args.String("lift")))
 				if !liftName.IsValid() {
 					break
 				}
-				people := fields[2:]
+				people := append([]string{args.String("nick")}, args.Words("nicks")...)
 				if len(people) > 0 {
 					liftToLifter := make(map[*Lift]*Lifter)
 					bests := make(Lifts, 0)
 					for _, name := range people {
-						if lifter := prs.GetLifter(name, false); lifter != nil {
+						if lifter := prs.GetLifter(name, false); lifter != nil && !lifter.Private {
 							if best := lifter.Best(liftName); best != nil {
 								liftToLifter[best] = lifter
 								bests = append(bests, best)
@@ -536,10 +715,10 @@ func PRListener(bot *Bot, settings *PluginSettings, server *Server) {
 							if len(msg) != 0 {
 								msg += ", "
 							}
-							msg += fmt.Sprintf("%v (%v)", liftToLifter[lift].Nick, lift.Weight.String())
+							msg += fmt.Sprintf("%v (%v)", SafeNick(server.Name, event.Room, liftToLifter[lift].Nick), lift.Weight.String())
 						}
 						msg = fmt.Sprintf("%v: %v", liftName.String(), msg)
-						server.Conn.Privmsg(event.Line.Target(), msg)
+						settings.Reply(event, msg)
 					}
 				}
 			}
@@ -556,15 +735,102 @@ func PRListener(bot *Bot, settings *PluginSettings, server *Server) {
 				}
 				message += liftName.String()
 			}
-			server.Conn.Privmsg(event.Line.Nick, "Commands:")
-			server.Conn.Privmsg(event.Line.Nick, "!pr <nick> [lift] - Prints the all the PR's for a nick, or just the chosen lift.")
-			server.Conn.Privmsg(event.Line.Nick, "!pradd [lift] [weight] - Sets a PR for a lift.")
-			server.Conn.Privmsg(event.Line.Nick, "!prrank <lift> <nick> <othernick> [nick,] - Ranks a list of nicks on their PR's.")
-			server.Conn.Privmsg(event.Line.Nick, "!prhistory <nick> <lift> - Prints the PR history for a nick's lift.")
-			server.Conn.Privmsg(event.Line.Nick, "!prclear [lift] - Clears all PR's for a lift.")
-			server.Conn.Privmsg(event.Line.Nick, "Valid lifts: "+message)
-			server.Conn.Privmsg(event.Line.Nick, "Valid weights can be in kgs or lbs with optional reps. eg: 1kg, 100lbs, 32x225lbs, 1x25kgs")
-		case <-time.After(1 * time.Minute):
+			PrivmsgLines(server, event.Line.Nick, []string{
+				"Commands:",
+				"!pr <nick> [lift] - Prints the all the PR's for a nick, or just the chosen lift.",
+				"!pradd [lift] [weight] - Sets a PR for a lift, separate several lifts with commas. eg: !pradd squat 5x5x100kg, 3x110kg",
+				"!prrank <lift> <nick> <othernick> [nick,] - Ranks a list of nicks on their PR's.",
+				"!prhistory <nick> <lift> - Prints the PR history for a nick's lift.",
+				"!prclear [lift] - Clears all PR's for a lift.",
+				"!prprivate [on|off] - Only show your lifts to you, and leave them out of rankings.",
+				"!prwizard - Sets up your units, bodyweight and lifts by answering a few questions in PM.",
+				"!prmeet open [minutes] - Opens a meet in the room, lifts are ranked by Wilks when it closes.",
+				"!prmeet weighin <bodyweight> <m|f>, !prmeet lift <lift> <weight>, !prmeet close - Enter, lift in and close a meet.",
+				"Valid lifts: " + message,
+				"Valid weights can be in kgs or lbs with optional reps and sets. eg: 1kg, 100lbs, 32x225lbs, 1x25kgs, 5x5x100kg",
+			})
+		case event, ok := <-prmeetchan:
+			if !ok {
+				return
+			}
+			prs.Lock()
+			target, lines := prs.MeetCommand(event)
+			prs.Unlock()
+			PrivmsgLines(server, target, lines)
+		case event, ok := <-prprivatechan:
+			if !ok {
+				return
+			}
+			args, err := prPrivateCommand.Parse(event.Line.Text())
+			if err != nil {
+				server.Privmsg(event.Line.Nick, err.Error())
+				break
+			}
+			setting := strings.ToLower(args.String("setting"))
+			if setting != "" && setting != "on" && setting != "off" {
+				server.Privmsg(event.Line.Nick, "Bad setting, use !prprivate on or !prprivate off.")
+				break
+			}
+			lifter, owned := prs.OwnLifter(server, event.Line.Nick, true)
+			if !owned {
+				server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "pr.notowner", ResponseVars{"Nick": event.Line.Nick}))
+				break
+			}
+			if setting != "" {
+				lifter.Private = setting == "on"
+			}
+			if lifter.Private {
+				server.Privmsg(event.Line.Nick, "Your lifts are private, they are only sent to you and are left out of rankings.")
+			} else {
+				server.Privmsg(event.Line.Nick, "Your lifts are public.")
+			}
+		case event, ok := <-prwizardchan:
+			if !ok {
+				return
+			}
+			key := NameKey(event.Line.Nick)
+			if args, err := prWizardCommand.Parse(event.Line.Text()); err != nil {
+				server.Privmsg(event.Line.Nick, err.Error())
+			} else if args.Pattern == "!prwizard cancel" {
+				delete(wizards, key)
+				server.Privmsg(event.Line.Nick, "Wizard cancelled, anything you answered has been kept.")
+			} else if lifter, owned := prs.OwnLifter(server, event.Line.Nick, true); !owned {
+				server.Privmsg(event.Line.Nick, Response(server.Name, event.Room, event.Line.Nick, "pr.notowner", ResponseVars{"Nick": event.Line.Nick}))
+			} else {
+				wizard := &PRWizard{Server: server.Name, Nick: event.Line.Nick, Lifter: lifter.Nick}
+				wizards[key] = wizard
+				PrivmsgLines(server, event.Line.Nick, []string{
+					"Welcome! Answer a few questions here to set up your PR's, !prwizard cancel to stop.",
+					wizard.Question(),
+				})
+			}
+		case event, ok := <-answerchan:
+			if !ok {
+				return
+			}
+			key := NameKey(event.Line.Nick)
+			wizard := wizards[key]
+			if wizard == nil {
+				break
+			}
+			prs.Lock()
+			lines := wizard.Answer(prs, event.Line.Text())
+			prs.Unlock()
+			if wizard.Done() {
+				delete(wizards, key)
+			}
+			PrivmsgLines(server, event.Line.Nick, lines)
+		case <-meetticker.C:
+			prs.Lock()
+			for _, room := range prs.ExpiredMeets() {
+				lines := prs.CloseMeet(server.Name, room)
+				// The room may have been banned or turned off while the meet was open, the results are still kept in the history.
+				if settings.IsAllowed(server.Name, room) && PREnabled(server.Name, room) {
+					PrivmsgLines(server, string(room), lines)
+				}
+			}
+			prs.Unlock()
+		case <-saveticker.C:
 			prs.Save(server.Name)
 		}
 	}
@@ -574,23 +840,20 @@ func PRListener(bot *Bot, settings *PluginSettings, server *Server) {
 func (prs *PRS) Load(server ServerName) {
 	prs.Lock()
 
-	filename := "prs/" + string(server) + ".json"
-
-	if file, err := os.Open(filename); err == nil {
-		defer file.Close()
-		dec := json.NewDecoder(file)
-		if err := dec.Decode(prs); err != nil {
-			logging.Info("Error loading prs", server, err)
-		} else {
-			logging.Info("Loaded prs for", server)
-		}
+	if err := SharedStore().Get("prs", string(server), prs); err == nil {
+		logging.Info("Loaded prs for", server)
+	} else if err == ErrNotFound {
+		logging.Info("No saved prs for", server)
 	} else {
-		logging.Info("Error loading file", server, filename, err)
+		ReportError("pr", "Error loading prs", server, err)
+	}
+	migrated, err := prMigrations.Run(prs, &prs.Version)
+	if err != nil {
+		ReportError("pr", "Error migrating prs", server, err)
 	}
 	prs.Unlock()
 	prs.Init()
-	if prs.OldPRs != nil {
-		prs.Migrate()
+	if migrated {
 		prs.Save(server)
 	}
 }
@@ -599,17 +862,9 @@ func (prs *PRS) Save(server ServerName) {
 	prs.Lock()
 	defer prs.Unlock()
 
-	filename := "prs/" + string(server) + ".json"
-
-	if file, err := os.Create(filename); err == nil {
-		defer file.Close()
-		enc := json.NewEncoder(file)
-		if err := enc.Encode(prs); err != nil {
-			logging.Info("Error saving prs", server, err)
-		} else {
-			logging.Info("Saved prs", server)
-		}
+	if err := SharedStore().Put("prs", string(server), prs); err != nil {
+		ReportError("pr", "Error saving prs", server, err)
 	} else {
-		logging.Info("Error creating file", server, filename, err)
+		logging.Info("Saved prs", server)
 	}
 }
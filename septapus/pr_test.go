@@ -0,0 +1,48 @@
+package septapus
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzNewWeight(f *testing.F) {
+	for _, seed := range []string{"100kg", "225lbs", "100lbkg", "0kg", "99999999999999999999kg", "-5kg", "１００kg", strings.Repeat("9", 40) + "lbs"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, str string) {
+		weight, err := NewWeight(str)
+		if err != nil || !weight.IsValid() {
+			return
+		}
+		if weight.Value < 1 || weight.Value > maxWeight {
+			t.Errorf("NewWeight(%q) = %v", str, weight.Value)
+		}
+	})
+}
+
+func FuzzNewLift(f *testing.F) {
+	for _, seed := range []string{"100kg", "5x100kg", "5x5x100kg", "0x100kg", "5x", "x", "xxx", "5x100lbkg", "1001x100kg"} {
+		f.Add("squat", seed)
+	}
+	f.Add("bodyweight", "5x80kg")
+	f.Add("squat", "100kg")
+	f.Fuzz(func(t *testing.T, name, str string) {
+		lift, err := NewLift(name, str)
+		if err != nil {
+			return
+		}
+		if !lift.Name.IsValid() || !lift.Weight.IsValid() {
+			t.Errorf("NewLift(%q, %q) = %v, an invalid lift", name, str, lift)
+		}
+		if lift.Reps < 0 || lift.Reps > maxReps {
+			t.Errorf("NewLift(%q, %q) has %d reps", name, str, lift.Reps)
+		}
+	})
+}
+
+func BenchmarkNewWeight(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewWeight("225lbs")
+	}
+}
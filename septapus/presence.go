@@ -0,0 +1,152 @@
+package septapus
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// RPL_NAMREPLY, sent in response to a NAMES or JOIN.
+const NAMES EventName = "353"
+
+// Presence tracks which nicks are in each room, from JOIN, PART, KICK, QUIT, NICK and NAMES events, and which of them
+// are operators, from NAMES and MODE events.
+type Presence struct {
+	sync.RWMutex
+
+	rooms map[ServerName]map[RoomName]map[string]string
+	ops   map[ServerName]map[RoomName]map[string]bool
+}
+
+func NewPresence() *Presence {
+	return &Presence{
+		rooms: make(map[ServerName]map[RoomName]map[string]string),
+		ops:   make(map[ServerName]map[RoomName]map[string]bool),
+	}
+}
+
+func (p *Presence) room(server ServerName, room RoomName) map[string]string {
+	if p.rooms[server] == nil {
+		p.rooms[server] = make(map[RoomName]map[string]string)
+	}
+	if p.rooms[server][room] == nil {
+		p.rooms[server][room] = make(map[string]string)
+	}
+	return p.rooms[server][room]
+}
+
+func (p *Presence) add(server ServerName, room RoomName, nick string) {
+	p.room(server, room)[NameKey(nick)] = nick
+}
+
+func (p *Presence) remove(server ServerName, room RoomName, nick string) {
+	delete(p.room(server, room), NameKey(nick))
+	p.setOp(server, room, nick, false)
+}
+
+func (p *Presence) setOp(server ServerName, room RoomName, nick string, op bool) {
+	if !op {
+		if p.ops[server] != nil {
+			delete(p.ops[server][room], NameKey(nick))
+		}
+		return
+	}
+	if p.ops[server] == nil {
+		p.ops[server] = make(map[RoomName]map[string]bool)
+	}
+	if p.ops[server][room] == nil {
+		p.ops[server][room] = make(map[string]bool)
+	}
+	p.ops[server][room][NameKey(nick)] = true
+}
+
+// Returns the events that need to be passed to Handle to keep presence up to date.
+func PresenceEvents() []EventName {
+	return []EventName{client.JOIN, client.PART, client.KICK, client.QUIT, client.NICK, client.MODE, NAMES}
+}
+
+func (p *Presence) Handle(event *Event) {
+	p.Lock()
+	defer p.Unlock()
+
+	server := event.Server.Name
+	line := event.Line
+	switch EventName(line.Cmd) {
+	case client.JOIN:
+		p.add(server, event.Room, line.Nick)
+	case client.PART:
+		if line.Nick == event.Server.Conn.Me().Nick {
+			delete(p.rooms[server], event.Room)
+			delete(p.ops[server], event.Room)
+			return
+		}
+		p.remove(server, event.Room, line.Nick)
+	case client.KICK:
+		if len(line.Args) > 1 {
+			p.remove(server, event.Room, line.Args[1])
+		}
+	case client.QUIT:
+		for room, _ := range p.rooms[server] {
+			p.remove(server, room, line.Nick)
+		}
+	case client.NICK:
+		for room, nicks := range p.rooms[server] {
+			if _, ok := nicks[NameKey(line.Nick)]; ok {
+				op := p.ops[server] != nil && p.ops[server][room][NameKey(line.Nick)]
+				p.remove(server, room, line.Nick)
+				p.add(server, room, line.Text())
+				p.setOp(server, room, line.Text(), op)
+			}
+		}
+	case NAMES:
+		if len(line.Args) < 4 {
+			return
+		}
+		room := RoomName(line.Args[2])
+		for _, nick := range strings.Fields(line.Args[3]) {
+			name := strings.TrimLeft(nick, "~&@%+")
+			p.add(server, room, name)
+			p.setOp(server, room, name, strings.ContainsAny(nick[:len(nick)-len(name)], "~&@"))
+		}
+	case client.MODE:
+		for _, change := range ModeChanges(line) {
+			if change.Mode == 'o' {
+				p.setOp(server, event.Room, change.Arg, change.Adding)
+			}
+		}
+	}
+}
+
+func (p *Presence) IsPresent(server ServerName, room RoomName, nick string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.rooms[server] == nil || p.rooms[server][room] == nil {
+		return false
+	}
+	_, ok := p.rooms[server][room][NameKey(nick)]
+	return ok
+}
+
+// Returns true if the nick is an operator in the room.
+func (p *Presence) IsOp(server ServerName, room RoomName, nick string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.ops[server] != nil && p.ops[server][room][NameKey(nick)]
+}
+
+// Returns the nicks present in a room.
+func (p *Presence) Nicks(server ServerName, room RoomName) []string {
+	p.RLock()
+	defer p.RUnlock()
+
+	nicks := make([]string, 0)
+	if p.rooms[server] != nil {
+		for _, nick := range p.rooms[server][room] {
+			nicks = append(nicks, nick)
+		}
+	}
+	return nicks
+}
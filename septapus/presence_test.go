@@ -0,0 +1,41 @@
+package septapus
+
+import (
+	"testing"
+
+	"github.com/fluffle/goirc/client"
+)
+
+func TestPresenceOps(t *testing.T) {
+	p := NewPresence()
+	server := &Server{Name: "synirc"}
+	handle := func(room RoomName, line *client.Line) {
+		p.Handle(&Event{Server: server, Room: room, Line: line})
+	}
+
+	handle("#septapus", &client.Line{Cmd: string(NAMES), Args: []string{"septapus", "=", "#septapus", "@iopred +voiced ~owner plain"}})
+	for nick, want := range map[string]bool{"iopred": true, "voiced": false, "owner": true, "plain": false} {
+		if got := p.IsOp("synirc", "#septapus", nick); got != want {
+			t.Errorf("after NAMES, IsOp(%v) = %v, want %v", nick, got, want)
+		}
+	}
+
+	handle("#septapus", &client.Line{Nick: "owner", Cmd: client.MODE, Args: []string{"#septapus", "+o-o+b", "plain", "iopred", "*!*@spam"}})
+	if !p.IsOp("synirc", "#septapus", "plain") || p.IsOp("synirc", "#septapus", "iopred") {
+		t.Error("MODE +o-o didn't op plain and deop iopred")
+	}
+
+	handle("", &client.Line{Nick: "plain", Cmd: client.NICK, Args: []string{"renamed"}})
+	if p.IsOp("synirc", "#septapus", "plain") || !p.IsOp("synirc", "#septapus", "renamed") {
+		t.Error("an op who changed nick isn't an op under the new nick")
+	}
+
+	handle("", &client.Line{Nick: "renamed", Cmd: client.QUIT, Args: []string{"bye"}})
+	handle("#septapus", &client.Line{Nick: "renamed", Cmd: client.JOIN, Args: []string{"#septapus"}})
+	if p.IsOp("synirc", "#septapus", "renamed") {
+		t.Error("an op who quit and rejoined is still an op")
+	}
+	if p.IsOp("synirc", "#other", "owner") {
+		t.Error("an op in one room is an op in another")
+	}
+}
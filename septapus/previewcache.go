@@ -0,0 +1,145 @@
+package septapus
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var urlpreviewttl = urlOptions.Duration("previewttl", time.Hour, "How long a link's title or a video's preview is remembered, so a link pasted again isn't fetched again")
+var urlratelimit = urlOptions.Int("ratelimit", 10, "Most links fetched to preview in a room each minute, previews that are remembered don't count. 0 for no limit")
+var urlratelimits = urlOptions.String("ratelimits", "", "Comma separated list of rooms and the most links fetched to preview in them each minute, rooms that aren't listed use urlratelimit, eg: synirc/#septapus=3")
+
+// Returned instead of a preview when a room has fetched too many links this minute.
+var ErrRateLimited = errors.New("Too many links previewed, try again in a minute.")
+
+// The most previews remembered, the oldest is dropped first.
+const maxPreviewCache = 1000
+
+type previewCacheEntry struct {
+	preview string
+	expires time.Time
+}
+
+// Remembers link and video previews, and how many links each room has fetched in the last minute.
+type PreviewCache struct {
+	sync.Mutex
+	previews map[string]*previewCacheEntry
+	// The order previews were added, to drop the oldest.
+	order   []string
+	fetches map[ServerName]map[RoomName][]time.Time
+}
+
+func NewPreviewCache() *PreviewCache {
+	return &PreviewCache{previews: make(map[string]*previewCacheEntry), fetches: make(map[ServerName]map[RoomName][]time.Time)}
+}
+
+var (
+	previewCache     *PreviewCache
+	previewCacheOnce sync.Once
+	rateLimits       RoomValues
+)
+
+// Returns the preview cache shared by the url and YouTube plugins.
+func SharedPreviewCache() *PreviewCache {
+	previewCacheOnce.Do(func() {
+		previewCache = NewPreviewCache()
+		rateLimits = ParseRoomValues(*urlratelimits)
+	})
+	return previewCache
+}
+
+// Returns the most links a room can fetch each minute, 0 for no limit.
+func roomRateLimit(server ServerName, room RoomName) int {
+	if value, ok := rateLimits.Get(server, room); ok {
+		if limit, err := strconv.Atoi(value); err == nil {
+			return limit
+		}
+	}
+	return *urlratelimit
+}
+
+// Returns true if a room can fetch another link this minute, counting the fetch.
+func (cache *PreviewCache) allow(server ServerName, room RoomName, now time.Time) bool {
+	limit := roomRateLimit(server, room)
+	if limit <= 0 {
+		return true
+	}
+	cache.Lock()
+	defer cache.Unlock()
+
+	room = FoldRoom(server, room)
+	if cache.fetches[server] == nil {
+		cache.fetches[server] = make(map[RoomName][]time.Time)
+	}
+	recent := make([]time.Time, 0, limit)
+	for _, fetched := range cache.fetches[server][room] {
+		if now.Sub(fetched) < time.Minute {
+			recent = append(recent, fetched)
+		}
+	}
+	if len(recent) >= limit {
+		cache.fetches[server][room] = recent
+		return false
+	}
+	cache.fetches[server][room] = append(recent, now)
+	return true
+}
+
+func (cache *PreviewCache) get(key string, now time.Time) (string, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	entry := cache.previews[key]
+	if entry == nil || now.After(entry.expires) {
+		return "", false
+	}
+	return entry.preview, true
+}
+
+func (cache *PreviewCache) put(key, preview string, now time.Time) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if _, ok := cache.previews[key]; !ok {
+		cache.order = append(cache.order, key)
+	}
+	cache.previews[key] = &previewCacheEntry{preview, now.Add(*urlpreviewttl)}
+	for len(cache.order) > maxPreviewCache {
+		delete(cache.previews, cache.order[0])
+		cache.order = cache.order[1:]
+	}
+}
+
+// Returns a remembered preview, or fetches it if the room hasn't fetched too many links this minute.
+// Errors aren't remembered, a link that failed is fetched again next time.
+func (cache *PreviewCache) Preview(server ServerName, room RoomName, key string, fetch func() (string, error)) (string, error) {
+	now := time.Now()
+	if preview, ok := cache.get(key, now); ok {
+		return preview, nil
+	}
+	if !cache.allow(server, room, now) {
+		return "", ErrRateLimited
+	}
+	preview, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	cache.put(key, preview, now)
+	return preview, nil
+}
+
+// Returns the preview of a YouTube video, remembered for urlpreviewttl.
+func CachedYouTubePreview(server ServerName, room RoomName, id string) (string, error) {
+	return SharedPreviewCache().Preview(server, room, "youtube:"+id, func() (string, error) {
+		return YouTubePreview(id)
+	})
+}
+
+// Returns the preview of a link, remembered for urlpreviewttl.
+func CachedURLPreview(server ServerName, room RoomName, url string) (string, error) {
+	return SharedPreviewCache().Preview(server, room, "url:"+url, func() (string, error) {
+		return URLPreview(url)
+	})
+}
@@ -0,0 +1,420 @@
+package septapus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var prmeetduration = prOptions.Duration("meetduration", time.Hour, "How long a meet stays open when no duration is given")
+
+// The longest a meet can be opened for, in minutes.
+const maxMeetMinutes = 24 * 60
+
+var prMeetCommand = NewCommand("!prmeet open [minutes:int]", "!prmeet weighin <bodyweight> <sex>", "!prmeet lift <lift> <weight>", "!prmeet close", "!prmeet").WithHelp("Runs a meet in the room, open it, weigh in, enter lifts and close it to see the results.")
+
+// A lifter's entry in a meet, meet lifts are kept separate from their training lifts.
+type MeetEntry struct {
+	Nick       string
+	Sex        string
+	BodyWeight *Weight
+	// The best single for each lift, keyed by lift name.
+	Lifts map[string]*Lift
+}
+
+// An open meet in a room.
+type Meet struct {
+	Room    RoomName
+	Opener  string
+	Opened  time.Time
+	Closes  time.Time
+	Entries map[string]*MeetEntry
+}
+
+// A lifter's placing in a closed meet.
+type MeetResult struct {
+	Place      int
+	Nick       string
+	Sex        string
+	BodyWeight float64
+	Total      float64
+	Wilks      float64
+	Lifts      []string
+}
+
+// A closed meet, unranked entries didn't weigh in.
+type MeetRecord struct {
+	Room     RoomName
+	Opened   time.Time
+	Closed   time.Time
+	Results  []*MeetResult
+	Unranked []string
+}
+
+// Returns the day the meet closed, in the room's locale.
+func (record *MeetRecord) ClosedDate(server ServerName) string {
+	return GetTimeFormat(server, record.Room, "").Date(record.Closed)
+}
+
+type MeetResults []*MeetResult
+
+func (r MeetResults) Len() int           { return len(r) }
+func (r MeetResults) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r MeetResults) Less(i, j int) bool { return r[i].Wilks > r[j].Wilks }
+
+// Returns the Wilks coefficient for a bodyweight in kgs, sex is m or f.
+func WilksCoefficient(sex string, bodyWeight float64) float64 {
+	coefficients := []float64{-216.0475144, 16.2606339, -0.002388645, -0.00113732, 7.01863e-06, -1.291e-08}
+	min, max := 40.0, 201.9
+	if sex == "f" {
+		coefficients = []float64{594.31747775582, -27.23842536447, 0.82112226871, -0.00930733913, 4.731582e-05, -9.054e-08}
+		min, max = 26.51, 154.53
+	}
+	bodyWeight = math.Max(min, math.Min(max, bodyWeight))
+	denominator := 0.0
+	for i, coefficient := range coefficients {
+		denominator += coefficient * math.Pow(bodyWeight, float64(i))
+	}
+	return 500 / denominator
+}
+
+func kgs(weight *Weight) float64 {
+	return weight.Normalise() / 2.20462
+}
+
+func (meet *Meet) entry(nick string) *MeetEntry {
+	key := NameKey(nick)
+	entry := meet.Entries[key]
+	if entry == nil {
+		entry = &MeetEntry{Nick: nick, Lifts: make(map[string]*Lift)}
+		meet.Entries[key] = entry
+	}
+	return entry
+}
+
+func (meet *Meet) WeighIn(nick, bodyWeight, sex string) error {
+	sex = strings.ToLower(sex)
+	if sex != "m" && sex != "f" {
+		return errors.New("Sex must be m or f, it is needed for Wilks.")
+	}
+	weight, err := NewWeight(bodyWeight)
+	if err != nil {
+		return err
+	}
+	if !weight.IsValid() {
+		return errors.New("Bad bodyweight. eg: 80kg, 180lbs")
+	}
+	entry := meet.entry(nick)
+	entry.Sex = sex
+	entry.BodyWeight = weight
+	return nil
+}
+
+// Adds a single to a lifter's entry, returns true if it is their best for the lift.
+func (meet *Meet) AddLift(nick, liftName, weight string) (*Lift, bool, error) {
+	lift, err := NewLift(strings.ToLower(liftName), weight)
+	if err != nil {
+		return nil, false, err
+	}
+	if lift.Name == BodyWeight {
+		return nil, false, errors.New("Use !prmeet weighin for your bodyweight.")
+	}
+	if lift.Reps > 1 || !lift.Weight.IsValid() {
+		return nil, false, errors.New("Meet lifts are singles. eg: 140kg")
+	}
+	entry := meet.entry(nick)
+	key := string(lift.Name)
+	if best := entry.Lifts[key]; best != nil && best.Weight.Compare(lift.Weight) >= 0 {
+		return lift, false, nil
+	}
+	entry.Lifts[key] = lift
+	return lift, true, nil
+}
+
+// Ranks the entries by Wilks, private lifters are left out of the results.
+func (meet *Meet) Close(private func(nick string) bool) *MeetRecord {
+	record := &MeetRecord{Room: meet.Room, Opened: meet.Opened, Closed: time.Now()}
+	results := make(MeetResults, 0)
+	for _, entry := range meet.Entries {
+		if len(entry.Lifts) == 0 || private(entry.Nick) {
+			continue
+		}
+		if entry.BodyWeight == nil {
+			record.Unranked = append(record.Unranked, entry.Nick)
+			continue
+		}
+		result := &MeetResult{Nick: entry.Nick, Sex: entry.Sex, BodyWeight: kgs(entry.BodyWeight)}
+		for _, lift := range entry.Lifts {
+			result.Total += kgs(lift.Weight)
+			result.Lifts = append(result.Lifts, lift.Name.String()+": "+lift.Weight.String())
+		}
+		sort.Strings(result.Lifts)
+		result.Wilks = result.Total * WilksCoefficient(entry.Sex, result.BodyWeight)
+		results = append(results, result)
+	}
+	sort.Sort(results)
+	for i, result := range results {
+		result.Place = i + 1
+	}
+	sort.Strings(record.Unranked)
+	record.Results = results
+	return record
+}
+
+func (record *MeetRecord) Lines(server ServerName) []string {
+	if len(record.Results) == 0 {
+		return []string{"The meet closed with no ranked lifters."}
+	}
+	lines := []string{"Meet results:"}
+	for _, result := range record.Results {
+		lines = append(lines, fmt.Sprintf("%d. %v: %.2f Wilks, %.1fkg total at %.1fkg (%v)", result.Place, SafeNick(server, record.Room, result.Nick), result.Wilks, result.Total, result.BodyWeight, strings.Join(result.Lifts, ", ")))
+	}
+	if len(record.Unranked) > 0 {
+		lines = append(lines, fmt.Sprintf("Unranked, no weigh in: %v", strings.Join(record.Unranked, ", ")))
+	}
+	if url := MeetsURL(server); url != "" {
+		lines = append(lines, "Meet history: "+url)
+	}
+	return lines
+}
+
+// Handles !prmeet in a room, returning where to reply and what to say. The prs lock must be held.
+// Weigh ins and lifts from private lifters are only confirmed to them.
+func (prs *PRS) MeetCommand(event *Event) (string, []string) {
+	args, err := prMeetCommand.Parse(event.Line.Text())
+	if err != nil {
+		return event.Line.Nick, []string{err.Error()}
+	}
+	if !event.Line.Public() {
+		return event.Line.Nick, []string{"Meets are held in rooms."}
+	}
+	room := RoomName(event.Line.Target())
+	meet := prs.Meets[room]
+	nick := event.Line.Nick
+	target := string(room)
+	if lifter := prs.GetLifter(nick, false); lifter != nil {
+		target = lifter.ReplyTarget(event.Line)
+	}
+
+	if strings.HasPrefix(args.Pattern, "!prmeet open") {
+		if meet != nil {
+			return string(room), []string{fmt.Sprintf("A meet is already open, it closes at %v.", GetTimeFormat(event.Server.Name, room, nick).Time(meet.Closes))}
+		}
+		duration := *prmeetduration
+		if args.Has("minutes") {
+			minutes := args.Int("minutes")
+			if minutes < 1 || minutes > maxMeetMinutes {
+				return string(room), []string{fmt.Sprintf("Meets can be open for 1 to %d minutes.", maxMeetMinutes)}
+			}
+			duration = time.Duration(minutes) * time.Minute
+		}
+		now := time.Now()
+		prs.Meets[room] = &Meet{Room: room, Opener: nick, Opened: now, Closes: now.Add(duration), Entries: make(map[string]*MeetEntry)}
+		return string(room), []string{fmt.Sprintf("A meet is open for %v! Weigh in with !prmeet weighin <bodyweight> <m|f>, then submit singles with !prmeet lift <lift> <weight>. Ranked by Wilks.", duration)}
+	}
+
+	if meet == nil {
+		return string(room), []string{"There is no meet open, start one with !prmeet open [minutes]."}
+	}
+
+	switch {
+	case strings.HasPrefix(args.Pattern, "!prmeet weighin"):
+		if err := meet.WeighIn(nick, args.String("bodyweight"), args.String("sex")); err != nil {
+			return target, []string{err.Error()}
+		}
+		return target, []string{fmt.Sprintf("%v weighed in at %v.", nick, meet.entry(nick).BodyWeight)}
+	case strings.HasPrefix(args.Pattern, "!prmeet lift"):
+		lift, best, err := meet.AddLift(nick, args.String("lift"), args.String("weight"))
+		if err != nil {
+			return target, []string{err.Error()}
+		}
+		if !best {
+			return target, []string{fmt.Sprintf("%v: %v %v is not better than your best.", nick, lift.Name.String(), lift.Weight)}
+		}
+		return target, []string{fmt.Sprintf("%v: %v %v, good lift!", nick, lift.Name.String(), lift.Weight)}
+	case strings.HasPrefix(args.Pattern, "!prmeet close"):
+		if NameKey(nick) != NameKey(meet.Opener) && !IsAdmin(event) {
+			return string(room), []string{"Only the person who opened the meet can close it early."}
+		}
+		return string(room), prs.CloseMeet(event.Server.Name, room)
+	}
+	return string(room), []string{fmt.Sprintf("Meet opened by %v, %d entrants, closes at %v.", meet.Opener, len(meet.Entries), GetTimeFormat(event.Server.Name, room, nick).Time(meet.Closes))}
+}
+
+// Closes a meet and archives the results, returning the announcement. The prs lock must be held.
+func (prs *PRS) CloseMeet(server ServerName, room RoomName) []string {
+	meet := prs.Meets[room]
+	if meet == nil {
+		return nil
+	}
+	delete(prs.Meets, room)
+	record := meet.Close(func(nick string) bool {
+		lifter := prs.GetLifter(nick, false)
+		return lifter != nil && lifter.Private
+	})
+	prs.MeetHistory = append(prs.MeetHistory, record)
+	return record.Lines(server)
+}
+
+// Returns the rooms with meets that should have closed. The prs lock must be held.
+func (prs *PRS) ExpiredMeets() []RoomName {
+	rooms := make([]RoomName, 0)
+	now := time.Now()
+	for room, meet := range prs.Meets {
+		if now.After(meet.Closes) {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+var (
+	meetServers     = make(map[ServerName]*PRS)
+	meetServersLock sync.Mutex
+	meetHandlerOnce sync.Once
+	meetHandled     bool
+)
+
+// Serves the meet history for a server at /pr/meets/<server>.html, if the built in http server is running.
+func ServeMeets(server ServerName, prs *PRS) {
+	meetServersLock.Lock()
+	meetServers[server] = prs
+	meetServersLock.Unlock()
+
+	meetHandlerOnce.Do(func() {
+		meetHandled = HandleHTTP("/pr/meets/", http.HandlerFunc(serveMeetHistory))
+	})
+}
+
+// Returns the prs for a server that is running the PR plugin, or nil.
+func GetPRS(server ServerName) *PRS {
+	meetServersLock.Lock()
+	defer meetServersLock.Unlock()
+
+	return meetServers[server]
+}
+
+// Returns the url of a server's meet history, or an empty string if it isn't served.
+func MeetsURL(server ServerName) string {
+	if !meetHandled {
+		return ""
+	}
+	return strings.TrimRight(*pastebaseurl, "/") + "/pr/meets/" + string(server) + ".html"
+}
+
+func serveMeetHistory(w http.ResponseWriter, r *http.Request) {
+	server := ServerName(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/pr/meets/"), ".html"))
+
+	prs := GetPRS(server)
+	if prs == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := prs.WriteMeetHistory(w, server); err != nil {
+		ReportError("pr", "Error executing meet history template:", err)
+	}
+}
+
+// Writes the meet history page for a server, newest first.
+func (prs *PRS) WriteMeetHistory(w io.Writer, server ServerName) error {
+	prs.RLock()
+	defer prs.RUnlock()
+
+	// Leaving out anyone who has made their lifts private since.
+	history := make([]*MeetRecord, len(prs.MeetHistory))
+	for i, record := range prs.MeetHistory {
+		public := *record
+		public.Results = make([]*MeetResult, 0, len(record.Results))
+		for _, result := range record.Results {
+			if lifter := prs.GetLifter(result.Nick, false); lifter == nil || !lifter.Private {
+				public.Results = append(public.Results, result)
+			}
+		}
+		history[len(history)-1-i] = &public
+	}
+	return meetHistoryTemplate.Execute(w, &WebPage{
+		Title: "Septapus meets: " + string(server),
+		Nav:   RoomNav(server, "", "Meets"),
+		Data: struct {
+			Server ServerName
+			Meets  []*MeetRecord
+		}{server, history},
+	})
+}
+
+// A lifter's best lifts, for the lifters page.
+type lifterProfile struct {
+	Nick string
+	Best string
+}
+
+// Writes the best lifts of every public lifter on a server, by nick.
+func (prs *PRS) WriteLifters(w io.Writer, server ServerName) error {
+	prs.RLock()
+	defer prs.RUnlock()
+
+	format := GetTimeFormat(server, "", "")
+	profiles := make([]*lifterProfile, 0, len(prs.Lifters))
+	for _, lifter := range prs.Lifters {
+		if best := lifter.List(format); !lifter.Private && best != "" {
+			profiles = append(profiles, &lifterProfile{lifter.Nick, best})
+		}
+	}
+	sort.Sort(lifterProfiles(profiles))
+	return liftersTemplate.Execute(w, &WebPage{
+		Title: "Septapus lifters: " + string(server),
+		Nav:   RoomNav(server, "", "Lifters"),
+		Data: struct {
+			Server  ServerName
+			Lifters []*lifterProfile
+		}{server, profiles},
+	})
+}
+
+type lifterProfiles []*lifterProfile
+
+func (l lifterProfiles) Len() int           { return len(l) }
+func (l lifterProfiles) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+func (l lifterProfiles) Less(a, b int) bool { return NameKey(l[a].Nick) < NameKey(l[b].Nick) }
+
+var liftersTemplate = NewWebTemplate(liftersTemplateSource)
+
+const liftersTemplateSource = `{{define "content"}}
+		<h1>Lifters on {{.Server}}</h1>
+		<table class="lifters">
+			<tr><th>Nick</th><th>Best Lifts</th></tr>
+			{{range .Lifters}}
+			<tr><td class="name">{{.Nick}}</td><td>{{.Best}}</td></tr>
+			{{else}}
+			<tr><td colspan="2">No lifts yet.</td></tr>
+			{{end}}
+		</table>
+{{end}}
+`
+
+var meetHistoryTemplate = NewWebTemplate(meetHistoryTemplateSource)
+
+const meetHistoryTemplateSource = `{{define "content"}}
+		<h1>Meets on {{.Server}}</h1>
+		{{range .Meets}}
+		<h2>{{.Room}}, {{.ClosedDate $.Server}}</h2>
+		<table class="meet">
+			<tr><th>Place</th><th>Nick</th><th>Wilks</th><th>Total</th><th>Bodyweight</th><th>Lifts</th></tr>
+			{{range .Results}}
+			<tr><td>{{.Place}}</td><td>{{.Nick}}</td><td>{{printf "%.2f" .Wilks}}</td><td>{{printf "%.1f" .Total}}kg</td><td>{{printf "%.1f" .BodyWeight}}kg</td><td>{{range .Lifts}}{{.}}<br>{{end}}</td></tr>
+			{{end}}
+		</table>
+		{{if .Unranked}}<p>Unranked, no weigh in: {{range .Unranked}}{{.}} {{end}}</p>{{end}}
+		{{else}}
+		<p>No meets yet.</p>
+		{{end}}
+{{end}}
+`
@@ -0,0 +1,112 @@
+package septapus
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var prWizardCommand = NewCommand("!prwizard cancel", "!prwizard").WithHelp("Walks you through adding lifts in a private message.")
+
+// The lifts the wizard asks for, in order.
+var wizardLifts = []LiftName{Squat, Bench, Deadlift, Ohp}
+
+// Matches a weight, repsxweight or setsxrepsxweight without a unit.
+var bareWeightPattern = regexp.MustCompile("^([0-9]+x){0,2}[0-9]+$")
+
+const (
+	wizardUnits = iota
+	wizardBodyWeight
+	wizardFirstLift
+)
+
+// A newcomer's progress through !prwizard, answered by PM. Wizards are not persisted.
+type PRWizard struct {
+	Server ServerName
+	Nick   string
+	// The lifter answers are recorded for, the nick's own unless its services account owns another.
+	Lifter string
+	step   int
+}
+
+// Returns the unit for a name, eg: kg or lbs.
+func ParseUnit(str string) Unit {
+	switch str {
+	case "kg", "kgs":
+		return UNIT_KGS
+	case "lb", "lbs":
+		return UNIT_LBS
+	}
+	return UNIT_UNDEFINED
+}
+
+// Returns weights with the lifter's preferred unit added to any entry that is missing one, eg: 5x100, 110 -> 5x100kg, 110kg.
+func (lifter *Lifter) WithUnit(weights string) string {
+	if lifter.Unit == UNIT_UNDEFINED {
+		return weights
+	}
+	entries := strings.Split(weights, ",")
+	for i, entry := range entries {
+		if entry = strings.TrimSpace(entry); bareWeightPattern.MatchString(entry) {
+			entries[i] = entry + lifter.Unit.String()
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// Returns the question for the wizard's current step.
+func (wizard *PRWizard) Question() string {
+	switch {
+	case wizard.step == wizardUnits:
+		return "Do you lift in kg or lbs?"
+	case wizard.step == wizardBodyWeight:
+		return "What is your bodyweight? Say skip to leave it out."
+	}
+	liftName := wizardLifts[wizard.step-wizardFirstLift]
+	return fmt.Sprintf("What is your best %v? eg: 100, 5x100 or 5x5x100. Say skip if you don't do it.", liftName.String())
+}
+
+// Returns true when every question has been answered.
+func (wizard *PRWizard) Done() bool {
+	return wizard.step >= wizardFirstLift+len(wizardLifts)
+}
+
+// Applies an answer to the lifter, returning the lines to reply with. The prs lock must be held.
+func (wizard *PRWizard) Answer(prs *PRS, text string) []string {
+	if wizard.Done() {
+		return nil
+	}
+	text = strings.ToLower(strings.TrimSpace(text))
+	lifter := prs.GetLifter(wizard.Lifter, true)
+	lines := make([]string, 0)
+	switch {
+	case wizard.step == wizardUnits:
+		unit := ParseUnit(text)
+		if unit == UNIT_UNDEFINED {
+			return []string{"Please answer kg or lbs.", wizard.Question()}
+		}
+		lifter.Unit = unit
+		lines = append(lines, fmt.Sprintf("Weights without a unit will be %v.", unit.String()))
+	case text == "skip":
+	default:
+		liftName := BodyWeight
+		if wizard.step != wizardBodyWeight {
+			liftName = wizardLifts[wizard.step-wizardFirstLift]
+		}
+		lifts, err := NewLifts(string(liftName), lifter.WithUnit(text))
+		if err != nil {
+			return []string{err.Error(), wizard.Question()}
+		}
+		for _, lift := range lifts {
+			lifter.AddLift(lift)
+		}
+		if best := lifter.Best(liftName); best != nil {
+			lines = append(lines, fmt.Sprintf("%v: %v", liftName.String(), best.Format(GetTimeFormat(wizard.Server, RoomName(wizard.Nick), wizard.Nick))))
+		}
+	}
+	wizard.step++
+	if wizard.Done() {
+		return append(lines, "All done! Add new lifts with !pradd <lift> <weight>, and see them with !pr "+wizard.Nick+".")
+	}
+	return append(lines, wizard.Question())
+}
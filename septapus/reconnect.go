@@ -0,0 +1,51 @@
+package septapus
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var reconnectOptions = NewOptions("reconnect")
+
+var reconnectdelay = reconnectOptions.Duration("delay", 5*time.Second, "Delay before reconnecting to a server we were disconnected from, doubled after each failed attempt")
+var reconnectmaxdelay = reconnectOptions.Duration("maxdelay", 10*time.Minute, "Longest delay between attempts to reconnect to a server")
+var reconnectretries = reconnectOptions.Int("retries", 0, "How many attempts to reconnect to a server are made before giving up, 0 to never give up")
+
+// Returns a delay between half of delay and delay at random, so servers that dropped together aren't all retried together.
+func reconnectJitter(delay time.Duration) time.Duration {
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Reconnects to a server with exponential backoff until it connects, it is removed from the bot, or reconnectretries
+// attempts fail. RECONNECTING is broadcast before each attempt. Does nothing if the server is already reconnecting.
+func (bot *Bot) reconnect(server *Server) {
+	if !atomic.CompareAndSwapInt32(&server.reconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&server.reconnecting, 0)
+
+	delay := *reconnectdelay
+	for attempt := 1; *reconnectretries <= 0 || attempt <= *reconnectretries; attempt++ {
+		wait := reconnectJitter(delay)
+		logging.Info("Reconnecting to", server.Name, "attempt", attempt, "in", wait)
+		bot.Emit(RECONNECTING, server, "", "", fmt.Sprintf("Reconnecting to %v in %v, attempt %d.", server.Name, DurationString(wait), attempt), time.Now(), &Reconnecting{attempt, wait})
+		time.Sleep(wait)
+
+		if bot.GetServer(server.Name) != server || server.Conn.Connected() {
+			return
+		}
+		err := server.Conn.Connect()
+		if err == nil {
+			return
+		}
+		logging.Info("Error reconnecting to", server.Name, err)
+		if delay *= 2; delay > *reconnectmaxdelay {
+			delay = *reconnectmaxdelay
+		}
+	}
+	NotifyOwner(fmt.Sprintf("Gave up reconnecting to %v after %d attempts.", server.Name, *reconnectretries))
+}
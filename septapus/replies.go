@@ -0,0 +1,88 @@
+package septapus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where a plugin's replies to a message in a room go, replies to private messages always go back to the nick.
+type ReplyPolicy int
+
+const (
+	// Reply in the room the message was sent to.
+	REPLY_ROOM ReplyPolicy = iota
+	// Reply in a private message to the nick that sent it.
+	REPLY_PM
+	// Reply in a notice to the nick that sent it, which most clients show in the room without highlighting.
+	REPLY_NOTICE
+)
+
+var replyPolicyNames = []string{"room", "pm", "notice"}
+
+func parseReplyPolicy(value string) (ReplyPolicy, error) {
+	for i, name := range replyPolicyNames {
+		if strings.EqualFold(value, name) {
+			return ReplyPolicy(i), nil
+		}
+	}
+	return REPLY_ROOM, fmt.Errorf("unknown reply policy %q, use one of: %v", value, strings.Join(replyPolicyNames, ", "))
+}
+
+func (policy ReplyPolicy) String() string {
+	return replyPolicyNames[policy]
+}
+
+// Sets where the plugin replies in the rooms the config file gives, see PluginConfig.Replies.
+func (s *PluginSettings) SetReplies(replies map[string]string) {
+	values := make([]string, 0, len(replies))
+	for room, policy := range replies {
+		if _, err := parseReplyPolicy(policy); err != nil {
+			ReportError("config", "Bad reply policy for plugin", s.Name, room, err)
+			continue
+		}
+		values = append(values, room+"="+policy)
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	s.replies = ParseRoomValues(strings.Join(values, ","))
+}
+
+// Returns where the plugin replies in a room, in the room unless the config file says otherwise.
+func (s *PluginSettings) ReplyPolicy(server ServerName, room RoomName) ReplyPolicy {
+	s.RLock()
+	defer s.RUnlock()
+
+	value, _ := s.replies.Get(server, room)
+	policy, _ := parseReplyPolicy(value)
+	return policy
+}
+
+// Replies to a message, wherever the plugin's reply policy for its room says.
+func (s *PluginSettings) Reply(event *Event, text string) {
+	s.ReplyLines(event, []string{text})
+}
+
+// Replies to a message with several lines, long replies are pasted like PrivmsgLines.
+func (s *PluginSettings) ReplyLines(event *Event, lines []string) {
+	target := event.Line.Target()
+	style := MESSAGE_PRIVMSG
+	if isRoomTarget(target) {
+		switch s.ReplyPolicy(event.Server.Name, event.Room) {
+		case REPLY_PM:
+			target = event.Line.Nick
+		case REPLY_NOTICE:
+			target, style = event.Line.Nick, MESSAGE_NOTICE
+		}
+	}
+	if len(lines) > *pastelines && PasteEnabled() && !s.SkipUpload("a reply to "+event.Line.Nick) {
+		if link, err := Paste(strings.Join(lines, "\n")); err == nil {
+			lines = []string{link}
+		} else {
+			ReportError("paste", "Error pasting response:", err)
+		}
+	}
+	for _, line := range lines {
+		s.Send(event.Server, style, target, line)
+	}
+}
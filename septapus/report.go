@@ -0,0 +1,117 @@
+package septapus
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var reportOptions = NewOptions("report")
+
+var reporttarget = reportOptions.String("target", "", "Where to report repeated plugin errors, a nick or status channel on a server, eg: synirc/iopred or synirc/#septapus-status")
+var reportthreshold = reportOptions.Int("threshold", 3, "Number of errors from the same source within reportwindow before it is reported")
+var reportwindow = reportOptions.Duration("window", 10*time.Minute, "Window that errors are counted over")
+var reportinterval = reportOptions.Duration("interval", 1*time.Hour, "Minimum time between reports for the same source")
+
+type errorSource struct {
+	failures []time.Time
+	reported time.Time
+}
+
+// Counts errors per source and queues a report when a source fails repeatedly.
+type ErrorReporter struct {
+	sync.Mutex
+
+	sources map[string]*errorSource
+	reports chan string
+}
+
+func NewErrorReporter() *ErrorReporter {
+	return &ErrorReporter{
+		sources: make(map[string]*errorSource),
+		reports: make(chan string, 10),
+	}
+}
+
+var errorReporter = NewErrorReporter()
+
+func (r *ErrorReporter) Report(source, message string) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	s := r.sources[source]
+	if s == nil {
+		s = &errorSource{}
+		r.sources[source] = s
+	}
+	failures := make([]time.Time, 0, len(s.failures)+1)
+	for _, failure := range s.failures {
+		if now.Sub(failure) < *reportwindow {
+			failures = append(failures, failure)
+		}
+	}
+	s.failures = append(failures, now)
+
+	if len(s.failures) < *reportthreshold || now.Sub(s.reported) < *reportinterval {
+		return
+	}
+	s.reported = now
+	report := fmt.Sprintf("%v has failed %v times in %v, last error: %v", source, len(s.failures), DurationString(*reportwindow), message)
+	select {
+	case r.reports <- report:
+	default:
+		logging.Warn("Dropped error report:", report)
+	}
+}
+
+// Logs an error from a plugin, if the source keeps failing the owner is messaged by the ReportPlugin.
+func ReportError(source, message string, args ...interface{}) {
+	logging.Error(message, args...)
+	errorReporter.Report(source, strings.TrimSpace(fmt.Sprintln(append([]interface{}{message}, args...)...)))
+}
+
+// Tells the owner about a problem that needs a person to fix it straight away, rather than after it repeats.
+func NotifyOwner(message string) {
+	logging.Warn(message)
+	select {
+	case errorReporter.reports <- message:
+	default:
+		logging.Warn("Dropped owner notification:", message)
+	}
+}
+
+// Parses a report target, eg: synirc/iopred.
+func parseReportTarget(target string) (ServerName, string, error) {
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Bad report target: %v", target)
+	}
+	return ServerName(parts[0]), parts[1], nil
+}
+
+func NewReportPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(ReportPlugin, settings)
+}
+
+func ReportPlugin(bot *Bot, settings *PluginSettings) {
+	if *reporttarget == "" {
+		return
+	}
+	serverName, target, err := parseReportTarget(*reporttarget)
+	if err != nil {
+		logging.Error("Error parsing report target:", err)
+		return
+	}
+	for report := range errorReporter.reports {
+		server := bot.GetServer(serverName)
+		if server == nil || !server.Conn.Connected() {
+			logging.Warn("Unable to send error report:", report)
+			continue
+		}
+		server.Privmsg(target, report)
+	}
+}
@@ -0,0 +1,252 @@
+package septapus
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"text/template"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var responsesOptions = NewOptions("responses")
+
+var responsesfile = responsesOptions.String("file", "responses.json", "File of response templates that replace the bot's own, by language and room")
+var languages = responsesOptions.String("languages", "", "Comma separated list of the language each room's responses are in, eg: synirc/#septapus=de. Languages are defined in the responses file or langdir, rooms can change theirs with !lang room")
+
+// Values for the named placeholders in a response, eg: {{.Monster}}.
+type ResponseVars map[string]interface{}
+
+// The bot's own responses in English, any of these can be replaced in the responses file or a language pack.
+var defaultResponses = map[string]string{
+	"alias.defined":          "Defined !{{.Name}} as {{.Expansion}}",
+	"alias.list":             "Aliases: {{.Aliases}}",
+	"alias.none":             "No aliases in {{.Room}}",
+	"alias.oponly":           "Only room operators and admins can define or remove aliases.",
+	"alias.recursive":        "!{{.Name}} expands into itself, or into too many nested aliases.",
+	"alias.removed":          "Removed !{{.Name}}",
+	"alias.reserved":         "Cannot redefine !{{.Name}}.",
+	"alias.toomany":          "!{{.Name}} expands into more than {{.Max}} commands.",
+	"alias.unknown":          "No alias named !{{.Name}}",
+	"alias.usage":            "Bad command: !alias define <name> <command>[; command], !alias remove <name>, !alias list",
+	"away.away":              "{{.Nick}} is away: {{.Reason}} ({{.Duration}})",
+	"away.back":              "Welcome back, you were away for {{.Duration}}.",
+	"away.reason":            "Away",
+	"away.set":               "You are now marked as away: {{.Reason}}",
+	"backup.cancelled":       "Restore cancelled.",
+	"backup.done":            "Backed up as {{.ID}}, roll back to it with !restore {{.ID}}",
+	"backup.error":           "Error backing up: {{.Error}}",
+	"backup.listerror":       "Error listing backups: {{.Error}}",
+	"backup.nocancel":        "No restore to cancel.",
+	"backup.none":            "No backups.",
+	"backup.staged":          "Restart the bot to restore {{.ID}}, the current state will be backed up first. !restore cancel to cancel.",
+	"backup.stageerror":      "Error staging the restore of {{.ID}}: {{.Error}}",
+	"backup.unknown":         "No backup {{.ID}}.",
+	"celebrate.comic":        "{{.Nicks}} made it into a comic! {{.URL}}",
+	"celebrate.kill":         "{{.Slayer}} slayed {{.Monster}}{{if gt .Fighters 1}} with {{.Fighters}} heroes fighting{{end}}!",
+	"celebrate.pr":           "Congratulations {{.Nick}} on a new {{.Lift}} PR of {{.Weight}}!",
+	"challenge.badtoken":     "That isn't your token.",
+	"challenge.bound":        "Commands that change your data are now only accepted from {{.Mask}}. To use them from somewhere else, /msg me !confirm {{.Token}} first. Keep the token secret.",
+	"challenge.confirm":      "You are using this nick from {{.Mask}}, not where you usually use it. Confirm it's you with /msg me !confirm <token>, then try again.",
+	"challenge.confirmed":    "Confirmed, commands that change your data are now accepted from {{.Mask}}.",
+	"github.issue":           "[{{.Repo}}] New issue #{{.Number}} by {{.User}}: {{.Name}} {{.URL}}",
+	"github.release":         "[{{.Repo}}] New release {{.Name}}: {{.URL}}",
+	"github.tag":             "[{{.Repo}}] New tag {{.Name}}",
+	"karma.karma":            "{{.Nick}} has {{.Karma}} karma.",
+	"lang.adminonly":         "Only admins can change a room's language, in the room.",
+	"lang.current":           "Responses are shown to you in {{.Language}}. Change it with !lang <language>, one of: {{.Languages}}.",
+	"lang.room":              "This room's responses are now in {{.Language}}.",
+	"lang.unknown":           "Unknown language, use one of: {{.Languages}}.",
+	"lang.user":              "Responses are now shown to you in {{.Language}}.",
+	"links.badperiod":        "Bad period, use !links day or !links week.",
+	"links.none.daily":       "No links shared in {{.Room}} today.",
+	"links.none.weekly":      "No links shared in {{.Room}} this week.",
+	"links.top.daily":        "Top links in {{.Room}} today:",
+	"links.top.weekly":       "Top links in {{.Room}} this week:",
+	"locale.badtimezone":     "Unknown timezone, use a name like Europe/London or America/New_York.",
+	"locale.current":         "Dates are shown to you as {{.Date}} {{.Time}}. Change it with !locale <{{.Choices}}> [timezone].",
+	"locale.unknown":         "Unknown locale, use one of: {{.Locales}}",
+	"ops.banusage":           "Bad command: !ban <nick|mask> [duration, eg: 10m, 2h]",
+	"pr.added":               "{{if gt .Count 1}}Added {{.Count}} lifts{{else}}Added lift{{end}}, {{.Lift}}: {{.Weight}}",
+	"pr.newpr":               "{{if gt .Count 1}}Added {{.Count}} lifts{{else}}Added lift{{end}}, New PR!! {{.Lift}}: {{.Weight}}",
+	"pr.notowner":            "{{.Nick}}'s lifts belong to another services account, log in to it to change them.",
+	"rpg.approaching":        "You see {{.Monster}} approaching.",
+	"rpg.badbet":             "You can bet between 1 and {{.Gold}} gold.",
+	"rpg.bossdrop":           "The world boss {{.Monster}} dropped {{.Item}} for you in {{.Room}}!",
+	"rpg.bossslain":          "{{.Slayer}} slayed the world boss {{.Monster}} with a raid of {{.Raid}}, every member gets a rare drop!",
+	"rpg.bossspawn":          "A world boss has appeared in {{.Room}}: {{.Monster}}! Everyone who fights it gains {{.XP}}x xp and a rare drop.",
+	"rpg.broke":              "Your {{.Item}} broke in {{.Room}}, it adds nothing to fights until you repair it with !rpgrepair for {{.Cost}} gold.",
+	"rpg.cantrepair":         "You can't afford to repair {{.Items}}.",
+	"rpg.classchosen":        "{{.Name}} is now a {{.Class}} in {{.Room}}.",
+	"rpg.classes":            "Classes: {{.Classes}}.",
+	"rpg.earned":             "You earned {{.Achievement}} in {{.Room}}!",
+	"rpg.eventend":           "{{.Name}} has ended in {{.Room}}.",
+	"rpg.eventstart":         "{{.Name}} has started in {{.Room}}! Kills give {{.Multiplier}} xp for the next {{.End}}.",
+	"rpg.gamblelost":         "{{.Nick}} bet {{.Bet}} gold and lost, now has {{.Gold}} gold.",
+	"rpg.gamblewon":          "{{.Nick}} bet {{.Bet}} gold and won, now has {{.Gold}} gold.",
+	"rpg.guildexists":        "There is already a guild called {{.Guild}} in {{.Room}}.",
+	"rpg.guildfounded":       "{{.Name}} founded {{.Guild}} in {{.Room}}.",
+	"rpg.guildfull":          "{{.Guild}} is full, guilds can have at most {{.Max}} members.",
+	"rpg.guildinfo":          "{{.Guild}}, founded by {{.Founder}} on {{.Founded}}, has {{.Count}} members: {{.Members}}",
+	"rpg.guildjoined":        "{{.Name}} joined {{.Guild}} in {{.Room}}.",
+	"rpg.guildleft":          "{{.Name}} left {{.Guild}}.",
+	"rpg.guildmissing":       "There is no guild called {{.Guild}} in {{.Room}}.",
+	"rpg.guildnamechars":     "Guild names can only have letters, numbers, spaces, ', _ and -.",
+	"rpg.guildnamelength":    "Guild names can be at most {{.Max}} characters.",
+	"rpg.helped":             "You helped {{.Slayer}} slay {{.Monster}} in {{.Room}}, dealt {{.Damage}}% of the damage and gained {{.XP}} xp.",
+	"rpg.inguild":            "You are already in {{.Guild}}",
+	"rpg.karma":              "{{.From}} gave you karma in {{.Room}}, you gained {{.XP}} xp.",
+	"rpg.killsummary":        "{{.Slayer}} slayed {{.Monster}} with a raid of {{.Raid}}.{{if .Loot}} Notable loot: {{.Loot}}.{{end}}",
+	"rpg.levelled":           "You just levelled up in {{.Room}} to level {{.Level}}!",
+	"rpg.nocharacter":        "You have no character in {{.Room}}",
+	"rpg.nocharacterfor":     "{{.Nick}} has no character in {{.Room}}.",
+	"rpg.nogold":             "You have no gold to bet, slay monsters to earn some.",
+	"rpg.noguild":            "No such guild in {{.Room}}",
+	"rpg.nothingtorepair":    "Nothing needs repairing.",
+	"rpg.notinguild":         "You aren't in a guild in {{.Room}}",
+	"rpg.repaircost":         "{{.Item}} ({{.Cost}} gold)",
+	"rpg.repaired":           "Repaired {{.Items}} for {{.Spent}} gold, you have {{.Gold}} gold left.",
+	"rpg.slayed":             "You just slayed {{.Monster}} in {{.Room}}, dealt {{.Damage}}% of the damage and gained {{.XP}} xp.",
+	"rpg.topcharacter":       "{{.Rank}}. {{.Name}}, level {{.Level}} ({{.XP}} xp)",
+	"rpg.topcharacters":      "Top characters in {{.Room}}:",
+	"rpg.topguild":           "{{.Rank}}. {{.Guild}}, {{.Levels}} levels across {{.Count}} members",
+	"rpg.topguilds":          "Top guilds in {{.Room}}:",
+	"rpg.topnocharacters":    "No characters have levelled in {{.Room}} yet.",
+	"rpg.topnoguilds":        "There are no guilds in {{.Room}} yet.",
+	"rpg.tradeaccepted":      "{{.Name}} accepted your trade in {{.Room}}, you now have {{.Got}}.",
+	"rpg.tradecalledoff":     "The trade has been called off: {{.Problem}}",
+	"rpg.tradechanged":       "The items in that trade have changed, it has been called off.",
+	"rpg.traded":             "You traded your {{.Gave}} for {{.Name}}'s {{.Got}}.",
+	"rpg.tradedeclined":      "Declined the trade.",
+	"rpg.tradedeclinedyours": "{{.Name}} declined your trade in {{.Room}}.",
+	"rpg.tradelevels":        "{{.Name}} is {{.Levels}} levels from you, you can only trade with characters within {{.Max}} levels.",
+	"rpg.tradenoitem":        "You have no {{.Slot}} item to trade.",
+	"rpg.tradenone":          "Nobody has offered you a trade in {{.Room}}",
+	"rpg.tradeoffer":         "{{.Name}} offers you their {{.Gave}} for your {{.Got}} in {{.Room}}. Reply in {{.Room}} with !rpgtrade accept or !rpgtrade decline.",
+	"rpg.tradeoffered":       "Offered {{.Name}} your {{.Gave}} for their {{.Got}}, they have {{.Timeout}} to accept.",
+	"rpg.tradeself":          "You can't trade with yourself.",
+	"rpg.tradetheirnoitem":   "{{.Name}} has no {{.Slot}} item to trade.",
+	"rpg.unknownclass":       "Unknown class, choose one of: {{.Classes}}.",
+	"rpg.unknownslot":        "Unknown slot, expected one of: {{.Slots}}",
+	"settings.all.disable":   "{{.Plugin}}: disabled everywhere",
+	"settings.all.enable":    "{{.Plugin}}: enabled everywhere",
+	"settings.baddryrun":     "Bad setting, use on or off.",
+	"settings.dryrun.off":    "{{.Plugin}}: dry run off",
+	"settings.dryrun.on":     "{{.Plugin}}: dry run on",
+	"settings.notrunning":    "{{.Plugin}} isn't running, it was turned off in the config file.",
+	"settings.pluginoff":     "{{.Plugin}} (disabled)",
+	"settings.plugins":       "Plugins: {{.Plugins}}",
+	"settings.room.ban":      "{{.Plugin}}: banned in {{.Room}} on {{.Server}}",
+	"settings.room.disable":  "{{.Plugin}}: disabled in {{.Room}} on {{.Server}}",
+	"settings.room.enable":   "{{.Plugin}}: enabled in {{.Room}} on {{.Server}}",
+	"settings.room.force":    "{{.Plugin}}: forced on in {{.Room}} on {{.Server}}",
+	"settings.room.unban":    "{{.Plugin}}: unbanned in {{.Room}} on {{.Server}}",
+	"settings.room.unforce":  "{{.Plugin}}: no longer forced on in {{.Room}} on {{.Server}}",
+	"settings.roomrequired":  "A room is required in a private message.",
+	"settings.unknown":       "No plugin named {{.Plugin}}",
+	"title.badurl":           "Bad url.",
+	"title.none":             "No title for that url.",
+	"title.ratelimited":      "Too many links previewed here, try again in a minute.",
+	"whois.nosuchnick":       "No one is using the nick {{.Nick}}.",
+	"whois.summary":          "{{.Nick}} ({{.User}}@{{.Host}}){{if .Account}} is logged in as {{.Account}},{{end}} on {{.Server}}, idle {{.Idle}}{{if .Shared}}, shares {{.Shared}} with me{{end}}.",
+	"yt.badvideo":            "Bad video, use a YouTube url or video id.",
+	"ytsub.adminonly":        "Only admins can change a room's YouTube subscriptions, in the room.",
+	"ytsub.already":          "Already subscribed to {{.Channel}}.",
+	"ytsub.badfeed":          "Couldn't read that channel's videos: {{.Error}}",
+	"ytsub.list":             "YouTube subscriptions: {{.Subscriptions}}",
+	"ytsub.none":             "No YouTube subscriptions here.",
+	"ytsub.notsubscribed":    "Not subscribed to {{.Channel}}.",
+	"ytsub.subscribed":       "Subscribed to {{.Channel}}, new videos will be announced here.",
+	"ytsub.unsubscribed":     "Unsubscribed from {{.Channel}}.",
+}
+
+// The responses file, eg:
+//
+//	{
+//		"Languages": {"de": {"pr.newpr": "Neuer PR!! {{.Lift}}: {{.Weight}}"}},
+//		"Rooms": {"synirc/#septapus": {"rpg.slayed": "{{.Monster}} is no more."}}
+//	}
+//
+// A room's own responses win over its language's, anything not replaced uses the bot's own. Languages can also be
+// shipped as language packs, see langdir.
+type responseFile struct {
+	Languages map[string]map[string]string
+	Rooms     map[string]map[string]string
+}
+
+var (
+	responseTemplates = make(map[string]*template.Template)
+	responseFileData  = &responseFile{}
+	languageRooms     RoomValues
+	responsesOnce     sync.Once
+	responsesLock     sync.Mutex
+)
+
+func loadResponses() {
+	responsesOnce.Do(func() {
+		languageRooms = ParseRoomValues(*languages)
+		if file, err := os.Open(*responsesfile); err == nil {
+			defer file.Close()
+			if err := json.NewDecoder(file).Decode(responseFileData); err != nil {
+				ReportError("responses", "Error loading responses", err)
+			} else {
+				logging.Info("Loaded responses")
+			}
+		}
+	})
+}
+
+// Returns the parsed template for a response, templates are parsed once.
+func responseTemplate(source string) (*template.Template, error) {
+	responsesLock.Lock()
+	defer responsesLock.Unlock()
+
+	if t := responseTemplates[source]; t != nil {
+		return t, nil
+	}
+	t, err := template.New("response").Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	responseTemplates[source] = t
+	return t, nil
+}
+
+func executeResponse(source string, vars ResponseVars) (string, error) {
+	t, err := responseTemplate(source)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Returns a response for a nick in a room, using the room's template or the template for the nick's language if there is one.
+// A broken custom template is reported and the bot's own is used instead. Nick may be empty for responses to the whole room.
+func Response(server ServerName, room RoomName, nick string, key string, vars ResponseVars) string {
+	loadResponses()
+
+	sources := make([]string, 0, 3)
+	if source, ok := responseFileData.Rooms[string(server)+"/"+string(room)][key]; ok {
+		sources = append(sources, source)
+	}
+	if language := GetLanguage(server, room, nick); language != DEFAULT_LANGUAGE {
+		if source, ok := languageResponse(language, key); ok {
+			sources = append(sources, source)
+		}
+	}
+	sources = append(sources, defaultResponses[key])
+
+	for _, source := range sources {
+		if text, err := executeResponse(source, vars); err == nil {
+			return text
+		} else {
+			ReportError("responses", "Error executing response", key, err)
+		}
+	}
+	return ""
+}
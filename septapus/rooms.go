@@ -0,0 +1,176 @@
+package septapus
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+// The rooms we are in on a server, persisted so rooms joined at runtime are rejoined after a restart. Rooms are kept
+// by NameKey, rooms that differ only in case are the same room.
+type JoinedRooms struct {
+	sync.RWMutex
+	Rooms map[RoomName]bool
+}
+
+func joinedRoom(room RoomName) RoomName {
+	return RoomName(NameKey(string(room)))
+}
+
+func (rooms *JoinedRooms) Add(room RoomName) bool {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	room = joinedRoom(room)
+	if rooms.Rooms[room] {
+		return false
+	}
+	rooms.Rooms[room] = true
+	return true
+}
+
+func (rooms *JoinedRooms) Remove(room RoomName) bool {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	room = joinedRoom(room)
+	if !rooms.Rooms[room] {
+		return false
+	}
+	delete(rooms.Rooms, room)
+	return true
+}
+
+// Returns the joined rooms merged with the configured rooms, configured rooms first.
+func (rooms *JoinedRooms) Merge(configured []RoomName) []RoomName {
+	rooms.RLock()
+	defer rooms.RUnlock()
+
+	merged := make([]RoomName, 0, len(configured)+len(rooms.Rooms))
+	seen := make(map[RoomName]bool)
+	for _, room := range configured {
+		if !seen[joinedRoom(room)] {
+			seen[joinedRoom(room)] = true
+			merged = append(merged, room)
+		}
+	}
+	joined := make([]string, 0, len(rooms.Rooms))
+	for room, _ := range rooms.Rooms {
+		if !seen[room] {
+			joined = append(joined, string(room))
+		}
+	}
+	sort.Strings(joined)
+	for _, room := range joined {
+		merged = append(merged, RoomName(room))
+	}
+	return merged
+}
+
+func (rooms *JoinedRooms) Load(server ServerName) {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	filename := "rooms/" + string(server) + ".json"
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(rooms); err != nil {
+			ReportError("rooms", "Error loading rooms", server, err)
+		} else {
+			logging.Info("Loaded rooms for", server)
+		}
+	} else {
+		logging.Info("Error loading file", server, filename, err)
+	}
+	if rooms.Rooms == nil {
+		rooms.Rooms = make(map[RoomName]bool)
+	}
+	// Older saves kept rooms as they were joined.
+	for room, _ := range rooms.Rooms {
+		if key := joinedRoom(room); key != room {
+			delete(rooms.Rooms, room)
+			rooms.Rooms[key] = true
+		}
+	}
+}
+
+func (rooms *JoinedRooms) Save(server ServerName) {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	filename := "rooms/" + string(server) + ".json"
+
+	if file, err := os.Create(filename); err == nil {
+		defer file.Close()
+		enc := json.NewEncoder(file)
+		if err := enc.Encode(rooms); err != nil {
+			ReportError("rooms", "Error saving rooms", server, err)
+		} else {
+			logging.Info("Saved rooms", server)
+		}
+	} else {
+		logging.Info("Error creating file", server, filename, err)
+	}
+}
+
+// The rooms we are in right now on a server, followed from our own joins, parts and kicks.
+type LiveRooms struct {
+	sync.RWMutex
+	// By folded name, with the name the room was joined as.
+	rooms map[RoomName]RoomName
+}
+
+func NewLiveRooms() *LiveRooms {
+	return &LiveRooms{rooms: make(map[RoomName]RoomName)}
+}
+
+// Follows our joins, parts and kicks, called with every line before it is broadcast.
+func (live *LiveRooms) Track(server *Server, line *client.Line) {
+	if line.Cmd != client.DISCONNECTED && (server.Conn == nil || server.Conn.Me() == nil) {
+		return
+	}
+	live.Lock()
+	defer live.Unlock()
+
+	switch line.Cmd {
+	case client.DISCONNECTED:
+		live.rooms = make(map[RoomName]RoomName)
+	case client.JOIN, client.PART:
+		if len(line.Args) == 0 || NameKey(line.Nick) != NameKey(server.Conn.Me().Nick) {
+			return
+		}
+		room := RoomName(line.Args[0])
+		if line.Cmd == client.JOIN {
+			live.rooms[FoldRoom(server.Name, room)] = room
+		} else {
+			delete(live.rooms, FoldRoom(server.Name, room))
+		}
+	case client.KICK:
+		if len(line.Args) > 1 && NameKey(line.Args[1]) == NameKey(server.Conn.Me().Nick) {
+			delete(live.rooms, FoldRoom(server.Name, RoomName(line.Args[0])))
+		}
+	}
+}
+
+// Returns the rooms we are in on the server, sorted.
+func (server *Server) LiveRooms() []RoomName {
+	server.live.RLock()
+	defer server.live.RUnlock()
+
+	names := make([]string, 0, len(server.live.rooms))
+	for _, room := range server.live.rooms {
+		names = append(names, string(room))
+	}
+	sort.Strings(names)
+	rooms := make([]RoomName, len(names))
+	for i, name := range names {
+		rooms[i] = RoomName(name)
+	}
+	return rooms
+}
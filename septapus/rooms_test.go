@@ -0,0 +1,28 @@
+package septapus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinedRoomsCase(t *testing.T) {
+	rooms := &JoinedRooms{Rooms: make(map[RoomName]bool)}
+	if !rooms.Add("#Septapus") {
+		t.Errorf("Add(#Septapus) = false, want true")
+	}
+	if rooms.Add("#septapus") {
+		t.Errorf("Add(#septapus) = true after #Septapus, want false")
+	}
+	rooms.Add("#go-nuts")
+
+	// A configured room isn't joined twice because it was joined in another case.
+	if merged, want := rooms.Merge([]RoomName{"#SEPTAPUS"}), []RoomName{"#SEPTAPUS", "#go-nuts"}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("Merge() = %v, want %v", merged, want)
+	}
+	if !rooms.Remove("#SEPTAPUS") {
+		t.Errorf("Remove(#SEPTAPUS) = false, want true")
+	}
+	if merged, want := rooms.Merge(nil), []RoomName{"#go-nuts"}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("Merge() = %v, want %v", merged, want)
+	}
+}
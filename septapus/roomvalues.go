@@ -0,0 +1,38 @@
+package septapus
+
+import "strings"
+
+// Values configured per room from a flag, eg: server/#room=value,server=value,*/*=value.
+type RoomValues map[ServerName]map[RoomName]string
+
+func ParseRoomValues(str string) RoomValues {
+	values := make(RoomValues)
+	for _, mapping := range strings.Split(str, ",") {
+		parts := strings.SplitN(strings.TrimSpace(mapping), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		target := strings.SplitN(parts[0], "/", 2)
+		server, room := ServerName(target[0]), ALL_ROOMS
+		if len(target) == 2 {
+			room = RoomName(target[1])
+		}
+		if values[server] == nil {
+			values[server] = make(map[RoomName]string)
+		}
+		values[server][room] = parts[1]
+	}
+	return values
+}
+
+// Returns the value configured for a room, the most specific configuration wins.
+func (values RoomValues) Get(server ServerName, room RoomName) (string, bool) {
+	for _, s := range []ServerName{server, ALL_SERVERS} {
+		for _, r := range []RoomName{room, ALL_ROOMS} {
+			if value, ok := values[s][r]; ok {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,168 @@
+package septapus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/client"
+)
+
+var helpCommand = NewCommand("!help [command]").WithHelp("Lists the commands you can use here, or how to use one of them.")
+
+// A handler of a command, the command is available wherever the subscriber and predicates would let it through.
+type commandRoute struct {
+	command    *Command
+	subscriber Subscriber
+	predicates []EventPredicate
+	channel    chan *Event
+}
+
+// Keeps the commands plugins handle and where they handle them, so !help can list the ones available in each room.
+type CommandRouter struct {
+	sync.RWMutex
+	routes []*commandRoute
+}
+
+func (router *CommandRouter) add(route *commandRoute) {
+	router.Lock()
+	defer router.Unlock()
+
+	router.routes = append(router.routes, route)
+}
+
+func (router *CommandRouter) remove(channel chan *Event) {
+	router.Lock()
+	defer router.Unlock()
+
+	for i, route := range router.routes {
+		if route.channel == channel {
+			router.routes = append(router.routes[:i], router.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns true if the command would be handled if it was used where the event happened, by whoever sent it.
+func (route *commandRoute) handles(event *Event) bool {
+	line := *event.Line
+	line.Args = []string{event.Line.Args[0], route.command.Name}
+	probe := *event
+	probe.Line = &line
+	if route.subscriber != nil && !route.subscriber.Allows(&probe) {
+		return false
+	}
+	for _, predicate := range route.predicates {
+		if !predicate(&probe) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the commands available where an event happened, by name.
+func (router *CommandRouter) Available(event *Event) []*Command {
+	router.RLock()
+	defer router.RUnlock()
+
+	available := make(map[string]*Command)
+	for _, route := range router.routes {
+		if available[route.command.Name] == nil && route.handles(event) {
+			available[route.command.Name] = route.command
+		}
+	}
+	commands := make([]*Command, 0, len(available))
+	for _, command := range available {
+		commands = append(commands, command)
+	}
+	sort.Sort(commandsByName(commands))
+	return commands
+}
+
+// Returns true if a plugin handles a command named name, eg: !help.
+func (router *CommandRouter) Has(name string) bool {
+	router.RLock()
+	defer router.RUnlock()
+
+	for _, route := range router.routes {
+		if route.command.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type commandsByName []*Command
+
+func (c commandsByName) Len() int           { return len(c) }
+func (c commandsByName) Swap(a, b int)      { c[a], c[b] = c[b], c[a] }
+func (c commandsByName) Less(a, b int) bool { return c[a].Name < c[b].Name }
+
+// Returns the commands plugins have registered with HandleCommand.
+func (bot *Bot) Commands() *CommandRouter {
+	return &bot.commands
+}
+
+// Returns a channel of the messages that invoke a command and pass the predicates, and lists the command in !help
+// wherever it would be handled. Remove it with RemoveEventHandler like any other handler.
+func (bot *Bot) HandleCommand(command *Command, predicates ...EventPredicate) chan *Event {
+	return bot.handleCommand(nil, command, predicates)
+}
+
+// Returns a channel of the messages that invoke a command in the servers and rooms the settings allow, see
+// Bot.HandleCommand.
+func (s *PluginSettings) HandleCommand(bot *Bot, command *Command, predicates ...EventPredicate) chan *Event {
+	return bot.handleCommand(s, command, predicates)
+}
+
+// Lists a command in !help wherever the settings and predicates allow it, for plugins that handle it among their
+// other messages.
+func (s *PluginSettings) DescribeCommand(bot *Bot, command *Command, predicates ...EventPredicate) {
+	bot.commands.add(&commandRoute{command, s, predicates, nil})
+}
+
+func (bot *Bot) handleCommand(subscriber Subscriber, command *Command, predicates []EventPredicate) chan *Event {
+	channel := bot.Subscribe(client.PRIVMSG, subscriber, append(append([]EventPredicate{}, predicates...), IsCommand(command))...)
+	bot.commands.add(&commandRoute{command, subscriber, predicates, channel})
+	return channel
+}
+
+// Answers !help with the commands available where it was used, or the usage of one of them.
+func HelpPlugin(bot *Bot, settings *PluginSettings) {
+	channel := bot.HandleCommand(helpCommand)
+	for event := range channel {
+		args, err := helpCommand.Parse(event.Line.Text())
+		if err != nil {
+			event.Server.Privmsg(event.Line.Nick, err.Error())
+			continue
+		}
+		commands := bot.Commands().Available(event)
+		if !args.Has("command") {
+			names := make([]string, len(commands))
+			for i, command := range commands {
+				names[i] = command.Name
+			}
+			event.Server.Privmsg(event.Line.Nick, "Commands here: "+strings.Join(names, " ")+". Use !help <command> to see how to use one.")
+			continue
+		}
+		name := args.String("command")
+		if !strings.HasPrefix(name, "!") {
+			name = "!" + name
+		}
+		found := false
+		for _, command := range commands {
+			if strings.EqualFold(command.Name, name) {
+				lines := []string{"Usage: " + command.Usage()}
+				if command.Help != "" {
+					lines = append(lines, command.Help)
+				}
+				PrivmsgLines(event.Server, event.Line.Nick, lines)
+				found = true
+				break
+			}
+		}
+		if !found {
+			event.Server.Privmsg(event.Line.Nick, name+" isn't a command here.")
+		}
+	}
+}
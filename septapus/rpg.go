@@ -2,18 +2,18 @@ package septapus
 
 import (
 	"bytes"
-	"encoding/json"
-	"flag"
+	"context"
 	"fmt"
 	"html/template"
 	"image"
 	"image/color"
+	"io"
 	"math"
 	"math/rand"
 	"mime/multipart"
-	"net/http"
-	"os"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,9 +23,21 @@ import (
 	"github.com/iopred/septapus/hsv"
 )
 
-var rpgkey = flag.String("rpgkey", "", "Private key for uploading rpg information")
-var rpgurl = flag.String("rpgurl", "http://septapus.com/rpg/rpg.php", "Url to upload the generated rpg information")
-var rpgallowrepeats = flag.Bool("rpgallowrepeats", false, "Can one person chat repeatedly to fight monsters.")
+var rpgOptions = NewOptions("rpg")
+
+var rpgkey = rpgOptions.String("key", "", "Private key for uploading rpg information")
+var rpgurl = rpgOptions.String("url", "http://septapus.com/rpg/rpg.php", "Url to upload the generated rpg information")
+var rpgallowrepeats = rpgOptions.Bool("allowrepeats", false, "Can one person chat repeatedly to fight monsters.")
+var rpgxpmodel = rpgOptions.String("xpmodel", XP_MODEL_DAMAGE, "How xp is shared in a raid, average: full xp for beating the average message count, damage: xp weighted by damage dealt")
+var rpgkarmaxp = rpgOptions.String("karmaxp", "", "Comma separated list of rooms and the xp a character gains for each karma its nick is given in the room, eg: synirc/#septapus=50")
+var rpgkarmaxpcap = rpgOptions.Int("karmaxpcap", 500, "Most xp a character gains a day from karma in a room, 0 for no cap")
+var rpgkillsummary = rpgOptions.String("killsummary", "", "Comma separated list of rooms that are told who slayed each monster, the raid size and notable loot in one line instead of private messages, eg: synirc/#septapus=on,*/*=off")
+var rpgxpfloor = rpgOptions.Float64("xpfloor", 0.25, "Minimum fraction of the full xp a raid member receives with the damage xp model")
+
+const (
+	XP_MODEL_AVERAGE = "average"
+	XP_MODEL_DAMAGE  = "damage"
+)
 
 const (
 	SLOT_WEAPON = iota
@@ -42,21 +54,51 @@ const (
 	ITEM_UNIQUE
 )
 
+var slotNames = []string{"weapon", "head", "body"}
+var rarityNames = []string{"junk", "normal", "magic", "rare", "unique"}
+
+var rpgListenCommand = NewCommand("!rpglisten <room> <listening>", "!rpglisten <listening>").WithHelp("Turns private messages about your character's kills on or off with true or false, in a private message give the room.")
+var rpgStatsCommand = NewCommand("!rpgstats [room]").WithHelp("Shows the room's monster and raid, in a private message give the room.")
+var rpgFightCommand = NewCommand("!rpgfight <nick>").WithHelp("Fights another character in the room.")
+var rpgMeCommand = NewCommand("!rpgme").WithHelp("Shows your character's level, xp and items, with a link to it on the game page.")
+var rpgItemCommand = NewCommand("!rpgitem <nick> [slot]").WithHelp("Shows a character's items.")
+var rpgCompareCommand = NewCommand("!rpgcompare <nick>").WithHelp("Compares your character with a nick's and each one's chance to hit.")
+
 type Item struct {
 	Name   string
 	Level  int64
 	Rarity int64
+	// Worn down by kills, see Character.Wear.
+	Durability int64
 }
 
 type Character struct {
 	Name         string
 	XP           int64
 	Level        int64
+	Gold         int64
 	Items        Items
 	OldItems     Items
 	Listening    bool
+	Alert        int64     // Health percentage to be alerted at when listening, 0 for no alerts.
+	Wounded      time.Time // Xp gained is reduced until this time, after failing to defend a counterattack.
 	Achievements AchievementsEarned
-	stats        Stats
+	// The services account that owns the character, only its nicks can play as it.
+	Account string `json:",omitempty"`
+	// The day, in the room's timezone, and xp gained that day from monsters fought alone, for -rpg.solocap.
+	SoloDay   string `json:",omitempty"`
+	SoloToday int64  `json:",omitempty"`
+	// The day, in the room's timezone, and xp gained that day from karma, for -rpg.karmaxpcap.
+	KarmaDay   string `json:",omitempty"`
+	KarmaToday int64  `json:",omitempty"`
+	// Set with !rpgbio, shown on the character's detail panel. Title is the earned reward title shown after its name.
+	Bio   string `json:",omitempty"`
+	Title string `json:",omitempty"`
+	// Picked with !rpgclass, see CharacterClass.
+	Class string `json:",omitempty"`
+	// The key of the guild the character is in, see Game.Guilds.
+	Guild string `json:",omitempty"`
+	stats Stats
 }
 
 type Stat int64
@@ -77,6 +119,7 @@ const (
 	STAT_RARE_DEFEATED
 	STAT_DKP
 	STAT_DMP
+	STAT_TOURNAMENTS_WON
 )
 
 type Goal struct {
@@ -102,6 +145,7 @@ type Achievement struct {
 	Name        string
 	Description string
 	Goals       Goals
+	Reward      *Reward
 }
 
 func NewAchievement(id AchievementID, group AchievementGroup, name, description string, goals ...*Goal) *Achievement {
@@ -133,12 +177,16 @@ func (achievement *Achievement) addGoal(goal *Goal) {
 type Achievements []*Achievement
 type AchievementsEarned map[AchievementID]time.Time
 
-func (achievements *Achievements) check(stats Stats, achievementsEarned AchievementsEarned) {
+// Marks any newly satisfied achievements as earned, and returns them.
+func (achievements *Achievements) check(stats Stats, achievementsEarned AchievementsEarned) []*Achievement {
+	earned := make([]*Achievement, 0)
 	for _, achievement := range *achievements {
 		if achievementsEarned[achievement.ID].IsZero() && achievement.isSatisfied(stats) {
 			achievementsEarned[achievement.ID] = time.Now()
+			earned = append(earned, achievement)
 		}
 	}
+	return earned
 }
 
 func (achievements *Achievements) add(achievement *Achievement) {
@@ -167,6 +215,10 @@ type Monster struct {
 	Slayed     string
 	Born       time.Time
 	Died       time.Time
+	// Damage dealt by each character, monsters from before this was tracked only have Characters.
+	Damage map[string]int64
+	// Set for world bosses, see -rpg.boss.
+	Boss bool `json:",omitempty"`
 }
 
 type Monsters []*Monster
@@ -177,25 +229,46 @@ func (m Monsters) Less(i, j int) bool { return m[i].MaxHealth > m[j].MaxHealth }
 
 type Game struct {
 	sync.RWMutex
-	Server     ServerName
-	Room       RoomName
-	Characters map[string]*Character
-	Monster    *Monster
-	Defeated   Monsters
-	Last       string
+	Version     int
+	Server      ServerName
+	Room        RoomName
+	Characters  map[string]*Character
+	Monster     *Monster
+	Defeated    Monsters
+	Tournaments TournamentResults
+	// Scheduled and running xp events, ended events are dropped once they are announced.
+	Events XPEvents `json:",omitempty"`
+	// When the next world boss spawns, and the monster the current boss interrupted.
+	NextBoss    time.Time `json:",omitempty"`
+	Interrupted *Monster  `json:",omitempty"`
+	// The latest trades between characters, see -rpg.tradehistory.
+	Trades []*Trade `json:",omitempty"`
+	// Guilds formed with !rpgguild, by the key of their name.
+	Guilds map[string]*Guild `json:",omitempty"`
+	Last   string
+
+	tournament *tournament
+	// Trades waiting to be accepted, by the key of the character they were offered to.
+	trades map[string]*tradeOffer
+	flavor *flavor
+	alerts *alerts
+	live   *liveView
+	// The rpg plugin's settings, nil for games loaded outside of a running room.
+	settings *PluginSettings
 }
 
 type RPGPlugin struct {
+	sync.Mutex
 	settings *PluginSettings
+	// The running games, used when transferring characters.
+	games map[ServerName]map[RoomName]*Game
+	// One game runs per room, however many times we join it.
+	instances *RoomInstances
+	// Recent kills from every game.
+	kills *KillFeed
 }
 
 var (
-	monsterNames  []string
-	monsterSmall  []string
-	monsterLarge  []string
-	monsterUnique []string
-	monsterRare   []string
-
 	healthColors []color.Color
 	healthRatios []float64
 	levelColors  []color.Color
@@ -204,23 +277,16 @@ var (
 	raidRatios   []float64
 	itemColors   []color.Color
 
-	itemNames [][]string
-	prefixes  []string
-	suffixes  []string
-	uniques   []string
-
-	bannedItemNames [][]string
-	bannedPrefixes  []string
-
 	achievements Achievements
 )
 
 func init() {
-	monsterSmall = []string{"Tiny", "Small", "Weak", "Infected", "Sick", "Fragile", "Impaired", "Blind"}
-	monsterLarge = []string{"Large", "Giant", "Huge", "Epic", "King", "Champion", "Queen", "Master", "Lord"}
-	monsterUnique = []string{"blood", "death", "rot", "sneeze", "pus", "spit", "puke", "burn", "shot", "rend", "slice", "maim", "boil", "singe", "taunt", "scab", "scratch"}
-	monsterNames = []string{"Skeleton", "Zombie", "Slime", "Kobold", "Ant", "Cockatrice", "Pyrolisk", "Werewolf", "Wolf", "Warg", "Hell-hound", "Gas Spore", "Gremlin", "Gargoyle", "Mind Flayer", "Imp", "Mimic", "Nymph", "Goblin", "Orc", "Mastodon", "Kraken", "Spider", "Scorpion", "Unicorn", "Narwhal", "Narhorse", "Worm", "Angel", "Archon", "Bat", "Centaur", "Dragon", "Elemental", "Minotaur", "Lich", "Mummy", "Naga", "Ogre", "Snake", "Troll", "Ghoul", "Golem", "Doppelganger", "Ghost", "Shade", "Demon", "Pit Fiend", "Balrog"}
-	monsterRare = []string{"Yanthra", "Baelzebub"}
+	fantasy := &NamePack{Name: "fantasy"}
+	fantasy.MonsterSmall = []string{"Tiny", "Small", "Weak", "Infected", "Sick", "Fragile", "Impaired", "Blind"}
+	fantasy.MonsterLarge = []string{"Large", "Giant", "Huge", "Epic", "King", "Champion", "Queen", "Master", "Lord"}
+	fantasy.MonsterUnique = []string{"blood", "death", "rot", "sneeze", "pus", "spit", "puke", "burn", "shot", "rend", "slice", "maim", "boil", "singe", "taunt", "scab", "scratch"}
+	fantasy.MonsterNames = []string{"Skeleton", "Zombie", "Slime", "Kobold", "Ant", "Cockatrice", "Pyrolisk", "Werewolf", "Wolf", "Warg", "Hell-hound", "Gas Spore", "Gremlin", "Gargoyle", "Mind Flayer", "Imp", "Mimic", "Nymph", "Goblin", "Orc", "Mastodon", "Kraken", "Spider", "Scorpion", "Unicorn", "Narwhal", "Narhorse", "Worm", "Angel", "Archon", "Bat", "Centaur", "Dragon", "Elemental", "Minotaur", "Lich", "Mummy", "Naga", "Ogre", "Snake", "Troll", "Ghoul", "Golem", "Doppelganger", "Ghost", "Shade", "Demon", "Pit Fiend", "Balrog"}
+	fantasy.MonsterRare = []string{"Yanthra", "Baelzebub"}
 
 	healthColors = []color.Color{color.RGBA{0, 0, 0, 1}, color.RGBA{153, 0, 0, 1}, color.RGBA{204, 0, 0, 1}, color.RGBA{255, 153, 0, 1}, color.RGBA{255, 204, 0, 1}, color.RGBA{0, 204, 0, 1}}
 	healthRatios = []float64{0, 0.5, 0.625, 0.75, 0.875, 1}
@@ -231,10 +297,10 @@ func init() {
 
 	itemColors = []color.Color{color.RGBA{0, 0, 0, 1}, color.RGBA{0, 204, 0, 1}, color.RGBA{0, 0, 204, 1}, color.RGBA{132, 37, 201, 1}, color.RGBA{255, 153, 0, 1}}
 
-	prefixes = []string{"Iron", "Wooden", "Bronze", "Tin", "Golden", "Silver", "Platinum", "Titanium", "Irradiated", "Liquid", "Steel", "Chilling", "Icy", "Fiery", "Frozen", "Poisoned", "Toxic", "Concrete", "Slippery", "Metal", "Pointy", "Huge", "Massive", "Chrome", "Glass", "Transparent", "Black", "Universal", "Sticky", "Heavy", "Epic", "Eternal", "Ethereal", "Stainless", "Radiant", "Gleaming", "Smoldering", "Charged", "Static", "Roaring", "Talking", "Singing", "Imaginary", "Quintissential", "Glowing", "Raging", "Acrobat's", "Amber", "Angel's", "Archangel's", "Arching", "Arcadian", "Artisan's", "Astral", "Azure", "Beserker", "Beryl", "Blazing", "Blessed", "Blighting", "Boreal", "Brutal", "Burgundy", "Buzzing", "Celestial", "Chromatic", "Cobalt", "Condensing", "Consecrated", "Coral", "Corrosive", "Crimson", "Cruel", "Cunning", "Deadly", "Dense", "Devious", "Divine", "Echoing", "Elysian", "Emerald", "Faithful", "Fanatic", "Feral", "Ferocious", "Fine", "Flaming", "Foul", "Freezing", "Furious", "Garnet", "Glacial", "Glimmering", "Glorious", "Great Wyrm's", "Grinding", "Guardian's", "Dark", "Hallowed", "Hexing", "Hibernal", "Holy", "Howling", "Jade", "Jagged", "King's", "Knight's", "Lapis", "Lord's", "Lunar", "Master's", "Mercilless", "Meteoric", "Mnemonic", "Noxious", "Ocher", "Pestilent", "Prismatic", "Psychic", "Pure", "Resonant", "Ruby", "Rugged", "Russet", "Sacred", "Sapphire", "Savage", "Septic", "Serpent's", "Shadow", "Sharp", "Shimmering", "Shocking", "Soldier's", "Strong", "Sturdy", "Tireless", "Triumphant", "Unearthly", "Valkyrie's", "Venomous", "Veteran's", "Vicious", "Victorious", "Vigorous", "Viridian", "Volcanic", "Wailing", "Warrior's", "Wyrm's", "Quality", "Poetic"}
-	suffixes = []string{"Maiming", "Destruction", "Brutality", "Crushing", "Fire", "Lava", "Ice", "Poison", "Pestilence", "Death", "Deliverance", "Chastity", "Rock", "Metal", "Death", "Damnation", "Strength", "Skill", "Dismemberment", "Spines", "the Whale", "the Bear", "Thunder", "Lightning", "the Owl", "the Shark", "the Moon", "the Sun", "the Cosmos", "the Elephant", "the Tiger", "the Snake", "Suffering", "Rainbows", "Reversal", "Eternity", "Rending", "the Idol", "the Narhorse", "the Narwhal", "the Dolphin", "the Ages", "Alacrity", "the Atlas", "Balance", "Bashing", "the Bat", "Blight", "Blocking", "Brilliance", "Burning", "Butchery", "Carnage", "the Centaur", "Chance", "the Kraken", "the Colossus", "Craftmanship", "Defiance", "Ease", "Energy", "Enlightenment", "Equilibrium", "Evisceration", "Excellence", "Flame", "Fortune", "the Fox", "Frost", "the Gargantuan", "the Giant", "the Glacier", "Gore", "Greed", "Guarding", "Incineration", "the Jackal", "the Lamprey", "the Leech", "Life", "the Locust", "Luck", "the Magus", "the Mammoth", "Might", "the Mind", "the Ox", "Pacing", "Perfection", "Radiance", "Protection", "Regeneration", "the Sentinel", "Speed", "Slaying", "Spikes", "the Squid", "Stability", "Storms", "Thawing", "Thorns", "the Titan", "Transcendence", "the Vampire", "the Wolf", "Venom", "Warding", "Vileness", "Winter", "the Wraith", "Benevolence", "Malevolence", "Justice"}
-	uniques = []string{"Eagles Mane", "Dragontaint", "Abortious", "Jessicer", "Torsionrod", "Brainpan", "Hell's Wrath", "Furious Expulsion", "Clutterspork", "Bekludgeon", "Bloodwood", "Frostmourne", "Doombringer", "Hyperion", "The Redeemer", "Blood Fell", "Reaper's Toll", "Stormwrath", "Widowmaker", "Fleshtaster", "Ghostwail", "Bloodcrust", "Plaguesnot", "Mindender", "Fungal Growth", "Earth's Edge", "Zealbringer", "Soul's Blessing", "Ripjaw", "The Patriarch", "Silencer", "Battletorrent", "Angel's Song", "Rustwarden"}
-	itemNames = [][]string{
+	fantasy.Prefixes = []string{"Iron", "Wooden", "Bronze", "Tin", "Golden", "Silver", "Platinum", "Titanium", "Irradiated", "Liquid", "Steel", "Chilling", "Icy", "Fiery", "Frozen", "Poisoned", "Toxic", "Concrete", "Slippery", "Metal", "Pointy", "Huge", "Massive", "Chrome", "Glass", "Transparent", "Black", "Universal", "Sticky", "Heavy", "Epic", "Eternal", "Ethereal", "Stainless", "Radiant", "Gleaming", "Smoldering", "Charged", "Static", "Roaring", "Talking", "Singing", "Imaginary", "Quintissential", "Glowing", "Raging", "Acrobat's", "Amber", "Angel's", "Archangel's", "Arching", "Arcadian", "Artisan's", "Astral", "Azure", "Beserker", "Beryl", "Blazing", "Blessed", "Blighting", "Boreal", "Brutal", "Burgundy", "Buzzing", "Celestial", "Chromatic", "Cobalt", "Condensing", "Consecrated", "Coral", "Corrosive", "Crimson", "Cruel", "Cunning", "Deadly", "Dense", "Devious", "Divine", "Echoing", "Elysian", "Emerald", "Faithful", "Fanatic", "Feral", "Ferocious", "Fine", "Flaming", "Foul", "Freezing", "Furious", "Garnet", "Glacial", "Glimmering", "Glorious", "Great Wyrm's", "Grinding", "Guardian's", "Dark", "Hallowed", "Hexing", "Hibernal", "Holy", "Howling", "Jade", "Jagged", "King's", "Knight's", "Lapis", "Lord's", "Lunar", "Master's", "Mercilless", "Meteoric", "Mnemonic", "Noxious", "Ocher", "Pestilent", "Prismatic", "Psychic", "Pure", "Resonant", "Ruby", "Rugged", "Russet", "Sacred", "Sapphire", "Savage", "Septic", "Serpent's", "Shadow", "Sharp", "Shimmering", "Shocking", "Soldier's", "Strong", "Sturdy", "Tireless", "Triumphant", "Unearthly", "Valkyrie's", "Venomous", "Veteran's", "Vicious", "Victorious", "Vigorous", "Viridian", "Volcanic", "Wailing", "Warrior's", "Wyrm's", "Quality", "Poetic"}
+	fantasy.Suffixes = []string{"Maiming", "Destruction", "Brutality", "Crushing", "Fire", "Lava", "Ice", "Poison", "Pestilence", "Death", "Deliverance", "Chastity", "Rock", "Metal", "Death", "Damnation", "Strength", "Skill", "Dismemberment", "Spines", "the Whale", "the Bear", "Thunder", "Lightning", "the Owl", "the Shark", "the Moon", "the Sun", "the Cosmos", "the Elephant", "the Tiger", "the Snake", "Suffering", "Rainbows", "Reversal", "Eternity", "Rending", "the Idol", "the Narhorse", "the Narwhal", "the Dolphin", "the Ages", "Alacrity", "the Atlas", "Balance", "Bashing", "the Bat", "Blight", "Blocking", "Brilliance", "Burning", "Butchery", "Carnage", "the Centaur", "Chance", "the Kraken", "the Colossus", "Craftmanship", "Defiance", "Ease", "Energy", "Enlightenment", "Equilibrium", "Evisceration", "Excellence", "Flame", "Fortune", "the Fox", "Frost", "the Gargantuan", "the Giant", "the Glacier", "Gore", "Greed", "Guarding", "Incineration", "the Jackal", "the Lamprey", "the Leech", "Life", "the Locust", "Luck", "the Magus", "the Mammoth", "Might", "the Mind", "the Ox", "Pacing", "Perfection", "Radiance", "Protection", "Regeneration", "the Sentinel", "Speed", "Slaying", "Spikes", "the Squid", "Stability", "Storms", "Thawing", "Thorns", "the Titan", "Transcendence", "the Vampire", "the Wolf", "Venom", "Warding", "Vileness", "Winter", "the Wraith", "Benevolence", "Malevolence", "Justice"}
+	fantasy.Uniques = []string{"Eagles Mane", "Dragontaint", "Abortious", "Jessicer", "Torsionrod", "Brainpan", "Hell's Wrath", "Furious Expulsion", "Clutterspork", "Bekludgeon", "Bloodwood", "Frostmourne", "Doombringer", "Hyperion", "The Redeemer", "Blood Fell", "Reaper's Toll", "Stormwrath", "Widowmaker", "Fleshtaster", "Ghostwail", "Bloodcrust", "Plaguesnot", "Mindender", "Fungal Growth", "Earth's Edge", "Zealbringer", "Soul's Blessing", "Ripjaw", "The Patriarch", "Silencer", "Battletorrent", "Angel's Song", "Rustwarden"}
+	fantasy.ItemNames = [][]string{
 		//ITEM_WEAPON
 		[]string{"Sword", "Axe", "Broadsword", "Two Handed Sword", "Pike", "Knife", "Dagger", "Polearm", "Mace", "Mallet", "Whip", "Longsword", "Battle Axe", "Two Handed Axe", "Blade", "Glaive", "Club", "Morning Star", "Flail", "War Hammer", "Maul", "Great Maul", "Scythe", "Poleaxe", "Halberd", "Scepter", "Staff", "Spear", "Trident", "Short Sword", "Scimitar", "Sabre", "Claymore", "Bastard Sword", "Cestus"},
 		//ITEM_HEAD
@@ -243,12 +309,13 @@ func init() {
 		[]string{"Quilted Armor", "Leather Armor", "Hard Leather Armor", "Studded Leather Armor", "Ring Mail", "Scale Mail", "Chain Mail", "Splint Mail", "Light Plate", "Field Plate", "Plate Mail", "Full Plate Mail", "Mesh Armor", "Linked Mail"},
 	}
 
-	bannedPrefixes = []string{"Plastic", "Paper", "Cracked", "Blunt", "Broken", "Fragile", "Icey"}
-	bannedItemNames = [][]string{
+	fantasy.BannedPrefixes = []string{"Plastic", "Paper", "Cracked", "Blunt", "Broken", "Fragile", "Icey"}
+	fantasy.BannedItemNames = [][]string{
 		[]string{"Scabbard"},
 		[]string{},
 		[]string{},
 	}
+	namePacks[fantasy.Name] = fantasy
 
 	levelGroup := AchievementGroup("level")
 	achievements.add(NewAchievement(AchievementID("level1"), levelGroup, "Fresh meat", "Reach level 1", NewGoal(STAT_LEVEL, 1)))
@@ -286,38 +353,51 @@ func init() {
 	achievements.add(NewAchievement(AchievementID("itemrarity1"), itemRarityGroup, "Special", "Find a special item", NewGoal(STAT_ITEM_RARITY, 2)))
 	achievements.add(NewAchievement(AchievementID("itemrarity2"), itemRarityGroup, "Magic", "Find a magic item", NewGoal(STAT_ITEM_RARITY, 3)))
 	achievements.add(NewAchievement(AchievementID("itemrarity3"), itemRarityGroup, "Rare", "Find a rare item", NewGoal(STAT_ITEM_RARITY, 4)))
-	achievements.add(NewAchievement(AchievementID("itemrarity4"), itemRarityGroup, "Legendary", "Find a legendary item", NewGoal(STAT_ITEM_RARITY, 5)))
+	achievements.add(NewAchievement(AchievementID("itemrarity4"), itemRarityGroup, "Legendary", "Find a legendary item", NewGoal(STAT_ITEM_RARITY, 5)).WithReward(&Reward{Color: "#9925c9"}))
 	sizeRarityGroup := AchievementGroup("size")
 	achievements.add(NewAchievement(AchievementID("sizesmall"), sizeRarityGroup, "Candy from a baby", "Defeat a small monster", NewGoal(STAT_SMALL_DEFEATED, 1)))
 	achievements.add(NewAchievement(AchievementID("sizelarge"), sizeRarityGroup, "The bigger they are", "Defeat a large monster", NewGoal(STAT_LARGE_DEFEATED, 1)))
 	achievements.add(NewAchievement(AchievementID("sizeunique"), sizeRarityGroup, "Pulling teeth", "Defeat a unique monster", NewGoal(STAT_UNIQUE_DEFEATED, 1)))
 	achievements.add(NewAchievement(AchievementID("sizerare"), sizeRarityGroup, "Impossible!", "Defeat a rare monster", NewGoal(STAT_RARE_DEFEATED, 1)))
 	dkpRarityGroup := AchievementGroup("dkp")
-	achievements.add(NewAchievement(AchievementID("dkp1"), dkpRarityGroup, "Dragonslayer", "Slay a dragon", NewGoal(STAT_DKP, 1)))
-	achievements.add(NewAchievement(AchievementID("dkp5"), dkpRarityGroup, "Drachentöter", "Slay 5 dragons", NewGoal(STAT_DKP, 5)))
-	achievements.add(NewAchievement(AchievementID("dkp10"), dkpRarityGroup, "Bearer of the dragonscale", "Slay 10 dragons", NewGoal(STAT_DKP, 10)))
-	achievements.add(NewAchievement(AchievementID("dkp50"), dkpRarityGroup, "Lord of the dragon", "Slay 50 dragons", NewGoal(STAT_DKP, 50)))
-	achievements.add(NewAchievement(AchievementID("dkp100"), dkpRarityGroup, "The dragon ascendant", "Slay 100 dragons", NewGoal(STAT_DKP, 100)))
+	achievements.add(NewAchievement(AchievementID("dkp1"), dkpRarityGroup, "Dragonslayer", "Slay a dragon", NewGoal(STAT_DKP, 1)).WithReward(&Reward{Title: " the Dragonslayer", Icon: "♛", IconLevel: 0}))
+	achievements.add(NewAchievement(AchievementID("dkp5"), dkpRarityGroup, "Drachentöter", "Slay 5 dragons", NewGoal(STAT_DKP, 5)).WithReward(&Reward{Title: " der Drachentöter", Icon: "♛", IconLevel: 25}))
+	achievements.add(NewAchievement(AchievementID("dkp10"), dkpRarityGroup, "Bearer of the dragonscale", "Slay 10 dragons", NewGoal(STAT_DKP, 10)).WithReward(&Reward{Title: ", Bearer of the Dragonscale", Icon: "♛", IconLevel: 50, XPBonus: 0.05}))
+	achievements.add(NewAchievement(AchievementID("dkp50"), dkpRarityGroup, "Lord of the dragon", "Slay 50 dragons", NewGoal(STAT_DKP, 50)).WithReward(&Reward{Title: ", Lord of the Dragon", Icon: "♛", IconLevel: 75, XPBonus: 0.1}))
+	achievements.add(NewAchievement(AchievementID("dkp100"), dkpRarityGroup, "The dragon ascendant", "Slay 100 dragons", NewGoal(STAT_DKP, 100)).WithReward(&Reward{Title: ", The Dragon Ascendant", Icon: "♛", IconLevel: 100, XPBonus: 0.15}))
 	dmpRarityGroup := AchievementGroup("dmp")
-	achievements.add(NewAchievement(AchievementID("dmp1"), dmpRarityGroup, "Unlucky", "Miss a dragon", NewGoal(STAT_DMP, 1)))
-	achievements.add(NewAchievement(AchievementID("dmp5"), dmpRarityGroup, "Hapless luck", "Miss 5 dragons", NewGoal(STAT_DMP, 5)))
-	achievements.add(NewAchievement(AchievementID("dmp10"), dmpRarityGroup, "Dire luck", "Miss 10 dragons", NewGoal(STAT_DMP, 10)))
-	achievements.add(NewAchievement(AchievementID("dmp50"), dmpRarityGroup, "Tragic luck", "Miss 50 dragons", NewGoal(STAT_DMP, 50)))
-	achievements.add(NewAchievement(AchievementID("dmp100"), dmpRarityGroup, "Catastrophic luck", "Miss 100 dragons", NewGoal(STAT_DMP, 100)))
+	achievements.add(NewAchievement(AchievementID("dmp1"), dmpRarityGroup, "Unlucky", "Miss a dragon", NewGoal(STAT_DMP, 1)).WithReward(&Reward{Icon: "☹", IconLevel: 0}))
+	achievements.add(NewAchievement(AchievementID("dmp5"), dmpRarityGroup, "Hapless luck", "Miss 5 dragons", NewGoal(STAT_DMP, 5)).WithReward(&Reward{Icon: "☹", IconLevel: 25}))
+	achievements.add(NewAchievement(AchievementID("dmp10"), dmpRarityGroup, "Dire luck", "Miss 10 dragons", NewGoal(STAT_DMP, 10)).WithReward(&Reward{Icon: "☹", IconLevel: 50, XPBonus: 0.05}))
+	achievements.add(NewAchievement(AchievementID("dmp50"), dmpRarityGroup, "Tragic luck", "Miss 50 dragons", NewGoal(STAT_DMP, 50)).WithReward(&Reward{Icon: "☹", IconLevel: 75, XPBonus: 0.1}))
+	achievements.add(NewAchievement(AchievementID("dmp100"), dmpRarityGroup, "Catastrophic luck", "Miss 100 dragons", NewGoal(STAT_DMP, 100)).WithReward(&Reward{Icon: "☹", IconLevel: 100, XPBonus: 0.15}))
 	helpedGroup := AchievementGroup("helped")
-	achievements.add(NewAchievement(AchievementID("helped100"), helpedGroup, "Team player", "Help with 100 fights, without getting the killing blow", NewGoal(STAT_HELPED, 100)))
-	achievements.add(NewAchievement(AchievementID("helped1000"), helpedGroup, "Selfless", "Help with 1000 fights, without getting the killing blow", NewGoal(STAT_HELPED, 1000)))
+	achievements.add(NewAchievement(AchievementID("helped100"), helpedGroup, "Team player", "Help with 100 fights, without getting the killing blow", NewGoal(STAT_HELPED, 100)).WithReward(&Reward{XPBonus: 0.05}))
+	achievements.add(NewAchievement(AchievementID("helped1000"), helpedGroup, "Selfless", "Help with 1000 fights, without getting the killing blow", NewGoal(STAT_HELPED, 1000)).WithReward(&Reward{XPBonus: 0.1}))
+	tournamentGroup := AchievementGroup("tournament")
+	achievements.add(NewAchievement(AchievementID("tournament1"), tournamentGroup, "Champion", "Win a tournament", NewGoal(STAT_TOURNAMENTS_WON, 1)).WithReward(&Reward{Title: " the Champion", Color: "#cc9900"}))
+	achievements.add(NewAchievement(AchievementID("tournament5"), tournamentGroup, "Grand champion", "Win 5 tournaments", NewGoal(STAT_TOURNAMENTS_WON, 5)).WithReward(&Reward{Title: " the Grand Champion", Color: "#ff9900"}))
 }
 
 func NewRPGPlugin(settings *PluginSettings) *RPGPlugin {
 	if settings == nil {
 		settings = DefaultSettings
 	}
-	return &RPGPlugin{settings: settings}
+	return &RPGPlugin{settings: settings, games: make(map[ServerName]map[RoomName]*Game), instances: NewRoomInstances(), kills: &KillFeed{}}
+}
+
+func (rpg *RPGPlugin) Settings() *PluginSettings {
+	return rpg.settings
 }
 
 func (rpg *RPGPlugin) Init(bot *Bot) {
-	joinchan := FilterSelf(rpg.settings.GetEventHandler(bot, client.JOIN))
+	rpg.kills.Load()
+	HandleHTTP("/rpg/kills.html", rpg.kills)
+	HandleHTTP("/rpg/kills.atom", rpg.kills)
+	HandleHTTP("/rpg/live/", rpg)
+
+	joinchan := rpg.settings.GetEventHandler(bot, client.JOIN, IsSelf())
+	transferchan := rpg.settings.HandleCommand(bot, rpgTransferCommand)
 
 	for {
 		select {
@@ -325,37 +405,51 @@ func (rpg *RPGPlugin) Init(bot *Bot) {
 			if !ok {
 				return
 			}
-			go rpg.game(bot, event.Server, RoomName(event.Line.Target()))
+			rpg.instances.Join(event, func(event *Event, joins <-chan *Event) {
+				rpg.game(bot, event.Server, RoomName(event.Line.Target()), joins)
+			})
+		case event, ok := <-transferchan:
+			if !ok {
+				return
+			}
+			rpg.TransferCommand(event)
 		}
 	}
 }
 
-func (rpg *RPGPlugin) game(bot *Bot, server *Server, room RoomName) {
+// Runs the game in a room until we leave it, joins is sent our later joins to the room while the game is running.
+func (rpg *RPGPlugin) game(bot *Bot, server *Server, room RoomName, joins <-chan *Event) {
 	logging.Info("Creating rpg in", server.Name, room)
 	defer logging.Info("Stopped rpg in", server.Name, room)
 
-	game := &Game{}
+	game := &Game{settings: rpg.settings}
 
 	game.Load(server.Name, room)
-
-	// If we have heard this event, we can assume that we should be listenening to this room, don't filter through settings.
-	disconnectchan := bot.GetEventHandler(client.DISCONNECTED)
-	partchan := FilterSelfRoom(bot.GetEventHandler(client.PART), server.Name, room)
-	messagechan := FilterRoom(bot.GetEventHandler(client.PRIVMSG), server.Name, room)
-	listenchan := FilterSimpleCommand(FilterServer(bot.GetEventHandler(client.PRIVMSG), server.Name), "!rpglisten")
-	statschan := FilterSimpleCommand(FilterServer(bot.GetEventHandler(client.PRIVMSG), server.Name), "!rpgstats")
-	fightchan := FilterSimpleCommand(FilterRoom(bot.GetEventHandler(client.PRIVMSG), server.Name, room), "!rpgfight")
-
-	hasQuit := false
-	quit := func() {
-		if !hasQuit {
-			bot.RemoveEventHandler(disconnectchan)
-			bot.RemoveEventHandler(partchan)
-			bot.RemoveEventHandler(messagechan)
-			hasQuit = true
-		}
-
-	}
+	rpg.register(server.Name, room, game)
+	defer rpg.unregister(server.Name, room)
+
+	// The game runs for as long as we are in the room, so leaving isn't filtered through settings. Messages and commands
+	// are, so a game in a room the plugin has just been disabled or banned in ignores them until it is enabled again.
+	disconnectchan := bot.GetEventHandler(client.DISCONNECTED, IsServer(server.Name))
+	partchan := bot.GetEventHandler(client.PART, IsSelf(), IsRoom(server.Name, room))
+	messagechan := rpg.settings.GetEventHandler(bot, client.PRIVMSG, IsRoom(server.Name, room), IsSettled())
+	listenchan := rpg.settings.HandleCommand(bot, rpgListenCommand, IsServer(server.Name))
+	statschan := rpg.settings.HandleCommand(bot, rpgStatsCommand, IsServer(server.Name))
+	fightchan := rpg.settings.HandleCommand(bot, rpgFightCommand, IsRoom(server.Name, room))
+	tournamentchan := rpg.settings.HandleCommand(bot, rpgTournamentCommand, IsRoom(server.Name, room))
+	alertchan := rpg.settings.HandleCommand(bot, rpgAlertCommand, IsServer(server.Name))
+	itemchan := rpg.settings.HandleCommand(bot, rpgItemCommand, IsRoom(server.Name, room))
+	mechan := rpg.settings.HandleCommand(bot, rpgMeCommand, IsRoom(server.Name, room))
+	biochan := rpg.settings.HandleCommand(bot, rpgBioCommand, IsRoom(server.Name, room))
+	classchan := rpg.settings.HandleCommand(bot, rpgClassCommand, IsRoom(server.Name, room))
+	tradechan := rpg.settings.HandleCommand(bot, rpgTradeCommand, IsRoom(server.Name, room))
+	guildchan := rpg.settings.HandleCommand(bot, rpgGuildCommand, IsRoom(server.Name, room))
+	comparechan := rpg.settings.HandleCommand(bot, rpgCompareCommand, IsRoom(server.Name, room))
+	eventchan := rpg.settings.HandleCommand(bot, rpgEventCommand, IsRoom(server.Name, room))
+	repairchan := rpg.settings.HandleCommand(bot, rpgRepairCommand, IsRoom(server.Name, room))
+	gamblechan := rpg.settings.HandleCommand(bot, rpgGambleCommand, IsRoom(server.Name, room))
+	topchan := rpg.settings.HandleCommand(bot, rpgTopCommand, IsRoom(server.Name, room))
+	karmachan := rpg.settings.GetEventHandler(bot, KARMA, IsRoom(server.Name, room))
 
 	save := func() {
 		game.Save()
@@ -363,40 +457,84 @@ func (rpg *RPGPlugin) game(bot *Bot, server *Server, room RoomName) {
 	}
 	save()
 
-	saveticker := time.NewTicker(5 * time.Minute).C
-	savequit := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	saved := make(chan bool)
+
+	// On a disconnect or a part, we need to close our handlers, otherwise a second join would trigger another copy of this function.
+	defer func() {
+		bot.RemoveEventHandlers(disconnectchan, partchan, messagechan, listenchan, statschan, fightchan, tournamentchan, alertchan, itemchan, mechan, biochan, classchan, tradechan, guildchan, comparechan, eventchan, repairchan, gamblechan, topchan, karmachan)
+		cancel()
+		<-saved
+		save()
+		logging.Info("Goroutines:", runtime.NumGoroutine(), "Event handlers:", bot.EventHandlerCount())
+	}()
+
 	// Save in a goroutine so it does not block the RPG, but only do one save at a time
 	go func() {
+		defer close(saved)
+		saveticker := time.NewTicker(5 * time.Minute)
+		defer saveticker.Stop()
 		for {
 			select {
-			case <-saveticker:
+			case <-saveticker.C:
 				save()
-			case <-savequit:
+			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 
+	// Checks for xp events starting and ending, and world bosses spawning.
+	eventticker := time.NewTicker(time.Minute)
+	defer eventticker.Stop()
+
+	// Fires when the current tournament stage is over, nil when there is no tournament.
+	var tournamentTimer <-chan time.Time
+
 	for {
 		select {
-		// On a disconnect or a part, we need to close our handlers, otherwise a second join would trigger another copy of this function.
-		case _, ok := <-disconnectchan:
+		case <-disconnectchan:
+			return
+		case <-server.Done():
+			return
+		case <-partchan:
+			return
+		case <-joins:
+			logging.Info("Rejoined rpg in", server.Name, room)
+		case event, ok := <-messagechan:
 			if !ok {
 				return
 			}
-			quit()
-		case _, ok := <-partchan:
-			if !ok {
-				return
+			// Nobody can be attacked or counterattacked fairly while half the room is split away.
+			if server.InNetsplit() {
+				continue
 			}
-			quit()
-		case event, ok := <-messagechan:
-			if !ok {
-				return
+			if monster := game.Attack(event); monster != nil {
+				monster.EmitKill(bot, event.Server, game)
+				rpg.kills.Add(game, monster)
+				go rpg.kills.Publish(rpg.settings)
+			} else {
+				if warning := game.NearDeathWarning(); warning != "" {
+					rpg.settings.Send(server, RPGAnnounceStyle(server.Name, room), string(room), warning)
+				}
+				if counter := game.Counterattack(); counter != "" {
+					rpg.settings.Send(server, RPGAnnounceStyle(server.Name, room), string(room), counter)
+				}
+				for nick, alert := range game.LowHealthAlerts() {
+					rpg.settings.Privmsg(server, nick, alert)
+				}
 			}
-			game.Attack(event)
 		case <-time.After(1 * time.Minute):
+			if server.InNetsplit() {
+				continue
+			}
 			game.Heal()
+			if !rpg.settings.IsAllowed(server.Name, room) {
+				continue
+			}
+			if taunt := game.Taunt(); taunt != "" {
+				rpg.settings.Send(server, RPGAnnounceStyle(server.Name, room), string(room), taunt)
+			}
 		case event, ok := <-listenchan:
 			if !ok {
 				return
@@ -412,24 +550,181 @@ func (rpg *RPGPlugin) game(bot *Bot, server *Server, room RoomName) {
 				return
 			}
 			game.FightCommand(event)
+		case event, ok := <-tournamentchan:
+			if !ok {
+				return
+			}
+			if game.TournamentCommand(event) {
+				tournamentTimer = time.After(*rpgtournamentsignup)
+			}
+		case <-tournamentTimer:
+			tournamentTimer = nil
+			if game.TournamentRound(server) {
+				tournamentTimer = time.After(*rpgtournamentround)
+			}
+		case event, ok := <-alertchan:
+			if !ok {
+				return
+			}
+			game.AlertCommand(event)
+		case event, ok := <-itemchan:
+			if !ok {
+				return
+			}
+			game.ItemCommand(event)
+		case event, ok := <-mechan:
+			if !ok {
+				return
+			}
+			game.MeCommand(event)
+		case event, ok := <-biochan:
+			if !ok {
+				return
+			}
+			game.BioCommand(event)
+		case event, ok := <-classchan:
+			if !ok {
+				return
+			}
+			game.ClassCommand(event)
+		case event, ok := <-tradechan:
+			if !ok {
+				return
+			}
+			game.TradeCommand(event)
+		case event, ok := <-guildchan:
+			if !ok {
+				return
+			}
+			game.GuildCommand(event)
+		case event, ok := <-eventchan:
+			if !ok {
+				return
+			}
+			game.EventCommand(event)
+			game.AnnounceEvents(server, time.Now())
+		case event, ok := <-repairchan:
+			if !ok {
+				return
+			}
+			game.RepairCommand(event)
+		case event, ok := <-gamblechan:
+			if !ok {
+				return
+			}
+			game.GambleCommand(event)
+		case event, ok := <-topchan:
+			if !ok {
+				return
+			}
+			game.TopCommand(event)
+		case now := <-eventticker.C:
+			if !rpg.settings.IsAllowed(server.Name, room) {
+				continue
+			}
+			game.AnnounceEvents(server, now)
+			if announcement := game.SpawnBoss(now); announcement != "" {
+				rpg.settings.Send(server, RPGAnnounceStyle(server.Name, room), string(room), announcement)
+			}
+		case event, ok := <-comparechan:
+			if !ok {
+				return
+			}
+			game.CompareCommand(event)
+		case event, ok := <-karmachan:
+			if !ok {
+				return
+			}
+			game.KarmaXP(event)
 		}
 	}
+}
 
-	savequit <- true
+var (
+	karmaXPRooms     RoomValues
+	karmaXPRoomsOnce sync.Once
+)
+
+// Returns the xp gained for each karma given in a room, 0 if karma doesn't give xp.
+func karmaXP(server ServerName, room RoomName) int64 {
+	karmaXPRoomsOnce.Do(func() {
+		karmaXPRooms = ParseRoomValues(*rpgkarmaxp)
+	})
+	value, _ := karmaXPRooms.Get(server, room)
+	xp, _ := strconv.ParseInt(value, 10, 64)
+	return xp
+}
+
+var (
+	killSummaryRooms     RoomValues
+	killSummaryRoomsOnce sync.Once
+)
+
+// Returns true if kills in a room are summarized in the room, raid members are then only messaged about levels,
+// achievements and the next monster.
+func KillSummaryEnabled(server ServerName, room RoomName) bool {
+	killSummaryRoomsOnce.Do(func() {
+		killSummaryRooms = ParseRoomValues(*rpgkillsummary)
+	})
+	value, ok := killSummaryRooms.Get(server, room)
+	return ok && value == "on"
+}
+
+// Gives a character xp for karma given to its nick by another nick. Karma earned in the rpg doesn't give xp again.
+func (game *Game) KarmaXP(event *Event) {
+	karma := event.Payload.(*KarmaGiven)
+	xp := karmaXP(game.Server, game.Room) * int64(karma.Points)
+	if karma.From == "" || xp <= 0 {
+		return
+	}
+
+	game.Lock()
+	defer game.Unlock()
+
+	char := game.playerCharacter(event.Server, karma.Nick)
+	if char == nil {
+		return
+	}
+	if xp = char.KarmaXP(xp, int64(*rpgkarmaxpcap), game.soloDay(event.Time)); xp <= 0 {
+		return
+	}
+	levelled := char.GainXP(game.NamePack(), xp)
+	if char.Listening {
+		game.settings.Privmsg(event.Server, karma.Nick, Response(game.Server, game.Room, karma.Nick, "rpg.karma", ResponseVars{"From": karma.From, "Room": game.Room, "XP": xp}))
+		if levelled {
+			game.settings.Privmsg(event.Server, karma.Nick, Response(game.Server, game.Room, karma.Nick, "rpg.levelled", ResponseVars{"Room": game.Room, "Level": char.Level}))
+		}
+	}
+}
+
+// Returns the xp a character gains from karma on a day, no more than the cap a day, 0 for no cap.
+func (character *Character) KarmaXP(xp, cap int64, day string) int64 {
+	if character.KarmaDay != day {
+		character.KarmaDay = day
+		character.KarmaToday = 0
+	}
+	if cap > 0 && character.KarmaToday+xp > cap {
+		xp = cap - character.KarmaToday
+		if xp < 0 {
+			xp = 0
+		}
+	}
+	character.KarmaToday += xp
+	return xp
 }
 
 func (game *Game) ListenCommand(event *Event) {
 	game.Lock()
 	defer game.Unlock()
 
-	char := game.GetCharacter(event.Line.Nick, false)
+	char := game.playerCharacter(event.Server, event.Line.Nick)
 	if char == nil {
 		return
 	}
 	fields := strings.Fields(event.Line.Text())
 	if event.Line.Target() == event.Line.Nick {
 		// Private message to us, must include a room
-		if (len(fields) == 2 || len(fields) == 3) && fields[1] == string(game.Room) {
+		if (len(fields) == 2 || len(fields) == 3) && SameRoom(game.Server, RoomName(fields[1]), game.Room) {
 			if len(fields) == 3 {
 				char.Listening = fields[2] == "true"
 			}
@@ -438,7 +733,7 @@ func (game *Game) ListenCommand(event *Event) {
 			return
 		}
 	} else {
-		if event.Room == game.Room {
+		if SameRoom(game.Server, event.Room, game.Room) {
 			if len(fields) == 2 {
 				char.Listening = fields[1] == "true"
 			}
@@ -448,9 +743,9 @@ func (game *Game) ListenCommand(event *Event) {
 		}
 	}
 	if char.Listening {
-		event.Server.Conn.Privmsg(event.Line.Nick, "Listening in "+string(game.Room))
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Listening in "+string(game.Room))
 	} else {
-		event.Server.Conn.Privmsg(event.Line.Nick, "Not listening in "+string(game.Room))
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Not listening in "+string(game.Room))
 	}
 }
 
@@ -459,12 +754,142 @@ func (game *Game) FightCommand(event *Event) {
 	if len(fields) != 2 {
 		return
 	}
-	msg := game.Fight(event.Line.Nick, fields[1])
+	game.Lock()
+	key := game.characterKey(event.Server, event.Line.Nick)
+	game.Unlock()
+	if key == "" {
+		return
+	}
+	msg := game.Fight(key, fields[1])
 	if msg != "" {
-		event.Server.Conn.Privmsg(string(game.Room), msg)
+		game.settings.Privmsg(event.Server, string(game.Room), msg)
 	}
 }
 
+func (game *Game) ItemCommand(event *Event) {
+	game.RLock()
+	defer game.RUnlock()
+
+	args, err := rpgItemCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	character := game.GetCharacter(args.String("nick"), false)
+	if character == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, args.String("nick")+" has no character in "+string(game.Room))
+		return
+	}
+	slots := []int{SLOT_WEAPON, SLOT_HEAD, SLOT_BODY}
+	if args.Has("slot") {
+		slot := slotIndex(args.String("slot"))
+		if slot == -1 {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "Unknown slot, expected one of: "+strings.Join(slotNames, ", "))
+			return
+		}
+		slots = []int{slot}
+	}
+	items := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		items = append(items, character.ItemDescription(slot))
+	}
+	reply := fmt.Sprintf("%v: %v", SafeNick(game.Server, game.Room, character.Name), strings.Join(items, ", "))
+	if url := game.CharacterURL(character); url != "" {
+		reply += " " + url
+	}
+	game.settings.Privmsg(event.Server, string(game.Room), reply)
+}
+
+// Tells a nick its character's level, xp and items, with a link to its row on the game page.
+func (game *Game) MeCommand(event *Event) {
+	game.RLock()
+	defer game.RUnlock()
+
+	character := game.playerCharacter(event.Server, event.Line.Nick)
+	if character == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	items := make([]string, 0, NUM_SLOTS)
+	for slot := 0; slot < NUM_SLOTS; slot++ {
+		items = append(items, character.ItemDescription(slot))
+	}
+	reply := fmt.Sprintf("%v: level %v, %v/%v xp, %v gold, %v", SafeNick(game.Server, game.Room, character.Name), character.Level, character.XP, character.MaxXP(), character.Gold, strings.Join(items, ", "))
+	if url := game.CharacterURL(character); url != "" {
+		reply += " " + url
+	}
+	game.settings.Privmsg(event.Server, string(game.Room), reply)
+}
+
+func (game *Game) CompareCommand(event *Event) {
+	game.RLock()
+	defer game.RUnlock()
+
+	args, err := rpgCompareCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	attacker := game.GetCharacter(event.Line.Nick, false)
+	defender := game.GetCharacter(args.String("nick"), false)
+	if attacker == nil || defender == nil || attacker == defender {
+		return
+	}
+	game.settings.Privmsg(event.Server, string(game.Room), fmt.Sprintf("%v (level %v, %v atk, %v def) vs %v (level %v, %v atk, %v def). Hit chance: %v%% vs %v%%.",
+		SafeNick(game.Server, game.Room, attacker.Name), attacker.Level, attacker.WeaponLevel(), attacker.ArmorLevel(),
+		SafeNick(game.Server, game.Room, defender.Name), defender.Level, defender.WeaponLevel(), defender.ArmorLevel(),
+		int(100*HitChance(attacker, defender)), int(100*HitChance(defender, attacker))))
+}
+
+// Returns the index of a slot by name, or -1 if there is no slot with that name.
+func slotIndex(name string) int {
+	for i, slotName := range slotNames {
+		if strings.EqualFold(name, slotName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the item in a slot with its level, rarity and what it adds to a fight.
+func (character *Character) ItemDescription(slot int) string {
+	item := character.Items[slot]
+	if item == nil {
+		return fmt.Sprintf("%v: nothing", slotNames[slot])
+	}
+	contribution := "def"
+	if slot == SLOT_WEAPON {
+		contribution = "atk"
+	}
+	rarity := "unknown"
+	if item.Rarity >= 0 && item.Rarity < int64(len(rarityNames)) {
+		rarity = rarityNames[item.Rarity]
+	}
+	if item.Broken() {
+		return fmt.Sprintf("%v: %v (level %v, %v, broken)", slotNames[slot], item.Name, item.Level, rarity)
+	}
+	return fmt.Sprintf("%v: %v (level %v, %v, +%v %v, durability %v/%v)", slotNames[slot], item.Name, item.Level, rarity, item.Level, contribution, item.Durability, maxDurability)
+}
+
+// Returns the chance that attacker hits defender in a round of !rpgfight, see Game.fight.
+func HitChance(attacker, defender *Character) float64 {
+	attackRange := 20 + attacker.Level
+	defendRange := 20 + defender.Level
+	offset := attacker.WeaponLevel() - defender.ArmorLevel()
+	hits := int64(0)
+	for i := int64(0); i < attackRange; i++ {
+		// Count the defender rolls j where i + offset > j.
+		j := i + offset
+		switch {
+		case j > defendRange:
+			hits += defendRange
+		case j > 0:
+			hits += j
+		}
+	}
+	return float64(hits) / float64(attackRange*defendRange)
+}
+
 func (game *Game) StatsCommand(event *Event) {
 	game.Lock()
 	defer game.Unlock()
@@ -473,17 +898,21 @@ func (game *Game) StatsCommand(event *Event) {
 	target := event.Line.Target()
 	if target == event.Line.Nick {
 		// Private message to us, must include a room
-		if !(len(fields) == 2 && fields[1] == string(game.Room)) {
+		if !(len(fields) == 2 && SameRoom(game.Server, RoomName(fields[1]), game.Room)) {
 			// Don't send status update if message is targeting from the wrong room
 			return
 		}
 	} else {
-		if event.Room != game.Room {
+		if !SameRoom(game.Server, event.Room, game.Room) {
 			// Don't send status update if message is coming from the wrong room
 			return
 		}
 	}
-	event.Server.Conn.Privmsg(target, game.Stats())
+	stats := game.Stats()
+	if url := game.CharacterURL(game.playerCharacter(event.Server, event.Line.Nick)); url != "" {
+		stats += " You: " + url
+	}
+	game.settings.Privmsg(event.Server, target, stats)
 }
 
 func (game *Game) Stats() string {
@@ -492,7 +921,7 @@ func (game *Game) Stats() string {
 		if len(raid) > 0 {
 			raid += ", "
 		}
-		raid += game.GetCharacter(key, true).Name
+		raid += SafeNick(game.Server, game.Room, game.GetCharacter(key, true).Name)
 	}
 	return fmt.Sprintf("%v [%v]", game.Monster.Stats(), raid)
 }
@@ -505,20 +934,18 @@ func (game *Game) Load(server ServerName, room RoomName) {
 	game.Lock()
 	defer game.Unlock()
 
-	filename := "rpg/" + string(server) + string(room) + ".json"
-
-	if file, err := os.Open(filename); err == nil {
-		defer file.Close()
-		dec := json.NewDecoder(file)
-		if err := dec.Decode(game); err != nil {
-			logging.Info("Error loading game", server, room, err)
-		} else {
-			logging.Info("Loaded game for", server, room)
-		}
+	if err := SharedStore().Get("rpg", gameKey(server, room), game); err == nil {
+		logging.Info("Loaded game for", server, room)
+	} else if err == ErrNotFound {
+		logging.Info("No saved game for", server, room)
 	} else {
-		logging.Info("Error loading file", server, room, filename, err)
+		ReportError("rpg", "Error loading game", server, room, err)
 	}
 
+	if _, err := rpgMigrations.Run(game, &game.Version); err != nil {
+		ReportError("rpg", "Error migrating game", server, room, err)
+	}
+	game.mergeCaseVariants(server, room)
 	game.Init(server, room)
 }
 
@@ -526,58 +953,46 @@ func (game *Game) Save() {
 	game.Lock()
 	defer game.Unlock()
 
-	filename := "rpg/" + string(game.Server) + string(game.Room) + ".json"
-
-	if file, err := os.Create(filename); err == nil {
-		defer file.Close()
-		enc := json.NewEncoder(file)
-		if err := enc.Encode(game); err != nil {
-			logging.Info("Error saving game", game.Server, game.Room, err)
-		} else {
-			logging.Info("Saved game", game.Server, game.Room)
-		}
+	if err := SharedStore().Put("rpg", gameKey(game.Server, game.Room), game); err != nil {
+		ReportError("rpg", "Error saving game", game.Server, game.Room, err)
 	} else {
-		logging.Info("Error creating file", game.Server, game.Room, filename, err)
+		logging.Info("Saved game", game.Server, game.Room)
 	}
 }
 
-var gameTemplate = template.Must(template.New("root").Parse(gameTemplateSource))
+var gameTemplate = NewWebTemplate(gameTemplateSource)
 
-const gameTemplateSource = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
-<html>
-	<head>
-		<title>Septapus RPG: {{.Server}}/{{.Room}}</title>
-		<meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
-		<link rel="stylesheet" href="../css/septapus.css" type="text/css" media="screen">
-		<link rel="shortcut icon" href="../images/favicon.png">
-	</head>
-	<script src="//ajax.googleapis.com/ajax/libs/jquery/2.0.0/jquery.min.js"></script>
-	<style type="text/css">
-	{{.GenerateStyles}}
-	.moreinfo {
-		display: none;
-	}
-	</style>
-	<body>
-		<div class="title"><img src="../images/Septapus.png" alt="Septapus"></div>
+const gameTemplateSource = `{{define "head"}}<script src="//ajax.googleapis.com/ajax/libs/jquery/2.0.0/jquery.min.js"></script>{{end}}
+{{define "content"}}
+		{{with .ActiveEvent}}<p class="event">{{.Name}}: kills give {{.MultiplierString}} xp until {{$.TimeFormat.Date .End}} {{$.TimeFormat.Time .End}}.</p>{{end}}
 		<p>
 		<h2>Current Fight:</h2>
 		<table class="currentfight">
 		<tr><th>Name</th><th>Health</th><th>Raid</th></tr>
 		{{with .Monster}}
-		<tr><td class="name">{{.Name}}</td><td class="health bar{{.HealthBarPercentage}}">{{.Health}}/{{.MaxHealth}}</td><td class="raid">{{.CharacterList $}}</td>
+		<tr><td class="name">{{.Name}}{{if .Boss}} (world boss){{end}}</td><td class="health bar{{.HealthBarPercentage}}">{{.Health}}/{{.MaxHealth}}</td><td class="raid">{{.CharacterList $}}</td>
 		{{end}}
 		</table>
 		{{if .Characters}}
 		<p>
 		<h2>Characters:</h2>
 		<table class="characters">
-			<tr><th>Name</th><th>Level</th><th>XP</th><th>Items</th></tr>
+			<tr><th>Name</th><th>Class</th><th>Level</th><th>XP</th><th>Items</th></tr>
 			{{range $index, $element := .GetSortedCharacters}}
 			{{if $element.Level}}
-			<tr id="button{{$index}}" class="moreinfobutton"><td class="name">{{$element.NameStyle false}}</td><td class="level level{{$element.LevelPercentage $}}">{{$element.Level}}</td><td class="xp bar{{$element.XPPercentage}}">{{$element.XP}}/{{$element.MaxXP}}</td><td class="items">{{$element.ItemsList}}</td></tr>
-			<tr id="div{{$index}}" class="moreinfo"><td colspan="4"><h2>{{$element.NameStyle true}}</h2>{{if $element.OldItems}}<p><h3>Item History</h3>{{$element.OldItemsList}}{{end}}<p></td></tr>
+			<tr id="{{$element.Anchor}}" class="moreinfobutton" data-index="{{$index}}"><td class="name">{{$element.NameStyle false}}</td><td class="class">{{$element.Class}}</td><td class="level level{{$element.LevelPercentage $}}">{{$element.Level}}</td><td class="xp bar{{$element.XPPercentage}}">{{$element.XP}}/{{$element.MaxXP}}</td><td class="items">{{$element.ItemsList}}</td></tr>
+			<tr id="div{{$index}}" class="moreinfo"><td colspan="5">Loading...</td></tr>
+			{{end}}
 			{{end}}
+		</table>
+		{{end}}
+		{{with .GuildLeaderboard}}
+		<p>
+		<h2>Guilds:</h2>
+		<table class="guilds">
+			<tr><th>Name</th><th>Levels</th><th>XP</th><th>Members</th></tr>
+			{{range .}}
+			<tr><td class="name">{{.Guild.Name}}</td><td class="level">{{.Levels}}</td><td class="xp">{{.XP}}</td><td class="raid">{{.MemberList}}</td></tr>
 			{{end}}
 		</table>
 		{{end}}
@@ -586,25 +1001,47 @@ const gameTemplateSource = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "h
 		<h2>Previous Fights:</h2>
 		<table class="previousfights">
 			<tr><th>Name</th><th>Health</th><th>Slayed By</th><th>Raid</th></tr>
-			{{range .DefeatedReverse}}
+			{{range .FightsPage 1}}
 			<tr><td class="name">{{.Name}}</td><td class="health health{{.HealthPercentage}}">{{.Health}}/{{.MaxHealth}}</td><td class="slayed">{{.SlayedList $}}</td><td class="raid">{{.CharacterList $}}</td></tr>
 			{{end}}
 		</table>
+		{{.PageLinks 1}}
+		{{end}}
+		{{if .Tournaments}}
+		<p>
+		<h2>Tournaments:</h2>
+		<table class="tournaments">
+			<tr><th>Winner</th><th>Date</th><th>Entrants</th></tr>
+			{{range .TournamentsReverse}}
+			<tr><td class="name">&#127942; {{.WinnerName $}}</td><td class="date">{{.Finished.Format "2006-01-02"}}</td><td class="raid">{{.EntrantList $}}</td></tr>
+			{{end}}
+		</table>
 		{{end}}
 		<script type="text/javascript">
+			var details = {{.DetailsURL}};
 			$(".moreinfobutton").each(function(index) {
-				var id = $(this).attr('id');
-				id = "div" + id.substring(6)
+				var detail = "detail" + $(this).data("index");
+				var id = "div" + $(this).data("index");
 			  $(this).click(function() {
 			  	$(".moreinfo").each(function(index) {
 						if ($(this).attr("id") != id) {
 							$(this).hide();
 						}
 					});
+			  	if (!$("#" + id).data("loaded")) {
+			  		$("#" + id).data("loaded", true);
+			  		$("#" + id + " td").load(details + " #" + detail + " > *");
+			  	}
 			  	$("#" + id).toggle();
 			  });
 			});
+			// Links from irc point at a character's row, show its details.
+			if (location.hash) {
+				$(location.hash + ".moreinfobutton").click();
+			}
 		</script>
+{{end}}
+{{define "footer"}}
 		<p>
     <table>
     <tr>
@@ -626,11 +1063,10 @@ const gameTemplateSource = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "h
 	    </td>
 	    </tr>
     </table>
-	</body>
-</html>
+{{end}}
 `
 
-//<tr id="div{{$index}}" class="moreinfo"><td colspan="4"><h2>{{$element.NameStyle true}}</h2><h3>Achievements</h3>{{$element.AchievementsList}}{{if $element.OldItems}}<p><h3>Item History</h3>{{$element.OldItemsList}}{{end}}<p></td></tr>
+//<tr id="div{{$index}}" class="moreinfo"><td colspan="4"><h2>{{$element.NameStyle true}}</h2><h3>Achievements</h3>{{$element.AchievementsList $.TimeFormat}}{{if $element.OldItems}}<p><h3>Item History</h3>{{$element.OldItemsList}}{{end}}<p></td></tr>
 
 func colorString(color color.Color) string {
 	r, g, b, _ := color.RGBA()
@@ -679,64 +1115,39 @@ func barColor(ratio float64, colorratio float64, colors []color.Color, ratios []
 	return template.CSS(p("linear-gradient", cs, ratio*100.0) + p("-o-linear-gradient", cs, ratio*100.0) + p("-moz-linear-gradient", cs, ratio*100.0) + p("-webkit-linear-gradient", cs, ratio*100.0) + p("-ms-linear-gradient", cs, ratio*100.0))
 }
 
-func (game *Game) GenerateStyles() template.CSS {
-	str := ""
-	for i := 0; i <= 200; i++ {
-		str += fmt.Sprintf(".health%d { color: %v; }\n", i, lerpColorString(float64(i)/float64(200), healthColors, healthRatios))
-	}
-	for i := 0; i <= 100; i++ {
-		str += fmt.Sprintf(".raid%d { color: %v; }\n", i, lerpColorString(float64(i)/float64(100), raidColors, raidRatios))
-		str += fmt.Sprintf(".level%d { color: %v; }\n", i, lerpColorString(float64(i)/float64(100), levelColors, levelRatios))
-		barRatio := float64(i) / float64(100)
-		str += fmt.Sprintf(".bar%d { %v }\n", i, barColor(barRatio, 0.5+barRatio/2.0, healthColors, healthRatios))
-	}
-	for i := 0; i < len(itemColors); i++ {
-		str += fmt.Sprintf(".item%d { color: %v; }\n", i, colorString(itemColors[i]))
-	}
-	return template.CSS(str)
-}
-
-func (game *Game) Upload() {
-	game.Lock()
-	defer game.Unlock()
-
-	filename := strings.Replace(string(game.Server)+string(game.Room)+".html", "#", ":", -1)
-
+// Uploads a file to the rpg server, render writes the contents of the file. Spooled if the server can't be reached.
+func uploadRPGFile(filename string, render func(w io.Writer) error) {
 	b := &bytes.Buffer{}
 
 	w := multipart.NewWriter(b)
 	defer w.Close()
 
 	if err := w.WriteField("key", *rpgkey); err != nil {
-		logging.Error("Error creating key:", err)
+		ReportError("rpg", "Error creating key:", err)
 		return
 	}
 
 	if err := w.WriteField("filename", filename); err != nil {
-		logging.Error("Error creating filename:", err)
+		ReportError("rpg", "Error creating filename:", err)
 		return
 	}
 
 	formfile, err := w.CreateFormFile("rpg", filename)
 	if err != nil {
-		logging.Error("Error creating form file:", err)
+		ReportError("rpg", "Error creating form file:", err)
 		return
 	}
 
-	if err := gameTemplate.Execute(formfile, game); err != nil {
-		logging.Error("Error executing template:", err)
+	if err := render(formfile); err != nil {
+		ReportError("rpg", "Error executing template:", err)
 	}
 
 	w.Close()
 
 	logging.Info("Uploading rpg", filename, *rpgurl, *rpgkey)
 
-	if resp, err := http.Post(*rpgurl, w.FormDataContentType(), b); err != nil {
-		logging.Error("Error posting comic to server:", err)
-		return
-	} else {
-		defer resp.Body.Close()
-	}
+	// Pages are uploaded again as the game changes, only the latest version of a page waits for the server.
+	SharedUploadQueue().Upload("rpg "+filename, "rpg:"+filename, *rpgurl, w.FormDataContentType(), b.Bytes())
 }
 
 func (game *Game) Init(server ServerName, room RoomName) {
@@ -751,7 +1162,7 @@ func (game *Game) Init(server ServerName, room RoomName) {
 	} else {
 		toRemove := make([]string, 0)
 		for key, character := range game.Characters {
-			character.Migrate()
+			character.Refresh(game.NamePack())
 			if character.Level == 0 && character.XP == 0 {
 				toRemove = append(toRemove, key)
 			}
@@ -760,6 +1171,17 @@ func (game *Game) Init(server ServerName, room RoomName) {
 			delete(game.Characters, key)
 		}
 	}
+	// Guilds whose members have all been removed are disbanded.
+	for key, guild := range game.Guilds {
+		if guild == nil || len(game.GuildMembers(guild)) == 0 {
+			delete(game.Guilds, key)
+		}
+	}
+	for _, character := range game.Characters {
+		if game.Guilds[character.Guild] == nil {
+			character.Guild = ""
+		}
+	}
 	if game.Monster == nil {
 		game.Monster = game.NewMonster()
 	}
@@ -771,6 +1193,9 @@ func (game *Game) Init(server ServerName, room RoomName) {
 		for _, monster := range game.Defeated {
 			monster.assignStats(character)
 		}
+		for _, result := range game.Tournaments {
+			result.assignStats(character)
+		}
 		achievements.check(character.stats, character.Achievements)
 	}
 }
@@ -779,33 +1204,53 @@ func NameKey(name string) string {
 	return strings.ToLower(name)
 }
 
-func (character *Character) Migrate() {
-	for {
-		if character.XP >= character.MaxXP() {
-			character.XP -= character.MaxXP()
-			character.Level++
-		} else {
-			break
+// Upgrades old rpg saves, add new migrations to the end when the save format changes.
+var rpgMigrations = NewMigrations("rpg")
+
+func init() {
+	rpgMigrations.Add(1, "level up characters with overflowing xp", func(save interface{}) error {
+		game := save.(*Game)
+		for _, character := range game.Characters {
+			for character.XP >= character.MaxXP() {
+				character.XP -= character.MaxXP()
+				character.Level++
+			}
+			if character.Items == nil {
+				character.Items = make(Items, NUM_SLOTS)
+			}
+			if character.Achievements == nil {
+				character.Achievements = make(AchievementsEarned)
+			}
 		}
-	}
-	if character.Items == nil {
-		character.Items = make(Items, NUM_SLOTS)
-	}
-	character.AddItems()
-	if character.Achievements == nil {
-		character.Achievements = make(AchievementsEarned)
-	}
+		return nil
+	})
+	rpgMigrations.Add(2, "give items durability", func(save interface{}) error {
+		game := save.(*Game)
+		for _, character := range game.Characters {
+			for _, item := range character.Items {
+				if item != nil {
+					item.Durability = maxDurability
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Fills empty item slots, filters banned names and rebuilds stats, run whenever a character is loaded.
+func (character *Character) Refresh(pack *NamePack) {
+	character.AddItems(pack)
 	character.stats = make(Stats)
 	character.stats[STAT_LEVEL] = character.Level
 	for _, item := range character.OldItems {
-		item.Migrate()
+		item.Filter(pack)
 		if item.Rarity+1 > character.stats[STAT_ITEM_RARITY] {
 			character.stats[STAT_ITEM_RARITY] = item.Rarity + 1
 		}
 	}
 	for _, item := range character.Items {
 		if item != nil {
-			item.Migrate()
+			item.Filter(pack)
 			if item.Rarity+1 > character.stats[STAT_ITEM_RARITY] {
 				character.stats[STAT_ITEM_RARITY] = item.Rarity + 1
 			}
@@ -813,14 +1258,15 @@ func (character *Character) Migrate() {
 	}
 }
 
-func (item *Item) Migrate() {
+// Replaces any banned words in the item's name.
+func (item *Item) Filter(pack *NamePack) {
 	name := item.Name
-	for _, str := range bannedPrefixes {
-		name = strings.Replace(name, str, prefixes[rand.Intn(len(prefixes))], -1)
+	for _, str := range pack.BannedPrefixes {
+		name = strings.Replace(name, str, pack.Prefixes[rand.Intn(len(pack.Prefixes))], -1)
 	}
-	for i, _ := range bannedItemNames {
-		for _, str := range bannedItemNames[i] {
-			name = strings.Replace(name, str, itemNames[i][rand.Intn(len(itemNames[i]))], -1)
+	for i, _ := range pack.BannedItemNames {
+		for _, str := range pack.BannedItemNames[i] {
+			name = strings.Replace(name, str, pack.ItemNames[i][rand.Intn(len(pack.ItemNames[i]))], -1)
 		}
 	}
 	item.Name = name
@@ -837,23 +1283,20 @@ func (character *Character) ItemLevel() int64 {
 }
 
 func (character *Character) WeaponLevel() int64 {
-	if character.Items[SLOT_WEAPON] == nil {
-		return 0
-	}
-	return character.Items[SLOT_WEAPON].Level
+	return character.slotLevel(SLOT_WEAPON)
 }
 
 func (character *Character) ArmorLevel() int64 {
 	count := int64(0)
 	for i := 0; i < NUM_SLOTS; i++ {
-		if character.Items[i] != nil && i != SLOT_WEAPON {
-			count += character.Items[i].Level
+		if i != SLOT_WEAPON {
+			count += character.slotLevel(i)
 		}
 	}
 	return count
 }
 
-func (character *Character) AddItems() {
+func (character *Character) AddItems(pack *NamePack) {
 	for i := character.ItemLevel(); i < character.Level; i++ {
 		slot := rand.Intn(NUM_SLOTS)
 		item := character.Items[slot]
@@ -864,12 +1307,18 @@ func (character *Character) AddItems() {
 				character.OldItems = append(character.OldItems, item)
 			}
 		}
-		item = NewItem(slot, itemLevel)
-		character.Items[slot] = item
-		if item.Rarity+1 > character.stats[STAT_ITEM_RARITY] {
-			character.stats[STAT_ITEM_RARITY] = item.Rarity + 1
-		}
+		character.equip(slot, NewItem(pack, slot, itemLevel))
+	}
+}
 
+// Puts an item in a slot, keeping the item it replaces if it was worth anything.
+func (character *Character) equip(slot int, item *Item) {
+	if old := character.Items[slot]; old != nil && old.Rarity >= ITEM_NORMAL {
+		character.OldItems = append(character.OldItems, old)
+	}
+	character.Items[slot] = item
+	if item.Rarity+1 > character.stats[STAT_ITEM_RARITY] {
+		character.stats[STAT_ITEM_RARITY] = item.Rarity + 1
 	}
 }
 
@@ -894,12 +1343,12 @@ func (character *Character) OldItemsList() template.HTML {
 	return itemList(character.OldItems)
 }
 
-func RandomItemName(slot int, level int64) (string, int64) {
+func RandomItemName(pack *NamePack, slot int, level int64) (string, int64) {
 	if slot == SLOT_WEAPON && level >= 10 && rand.Float64() > 0.95 {
-		return uniques[rand.Intn(len(uniques))], ITEM_UNIQUE
+		return pack.Uniques[rand.Intn(len(pack.Uniques))], ITEM_UNIQUE
 	}
 
-	names := itemNames[slot]
+	names := pack.ItemNames[slot]
 	name := names[rand.Intn(len(names))]
 
 	chance := int64(4)
@@ -913,9 +1362,9 @@ func RandomItemName(slot int, level int64) (string, int64) {
 	for i := 0; i < 2; i++ {
 		if rand.Float64() < float64(level-chance)/float64(chance) {
 			if prefix {
-				name = prefixes[rand.Intn(len(prefixes))] + " " + name
+				name = pack.Prefixes[rand.Intn(len(pack.Prefixes))] + " " + name
 			} else {
-				name = name + " of " + suffixes[rand.Intn(len(suffixes))]
+				name = name + " of " + pack.Suffixes[rand.Intn(len(pack.Suffixes))]
 			}
 			level -= chance
 			prefix = !prefix
@@ -926,9 +1375,9 @@ func RandomItemName(slot int, level int64) (string, int64) {
 	return name, rarity
 }
 
-func NewItem(slot int, level int64) *Item {
-	name, rarity := RandomItemName(slot, level)
-	return &Item{name, level, rarity}
+func NewItem(pack *NamePack, slot int, level int64) *Item {
+	name, rarity := RandomItemName(pack, slot, level)
+	return &Item{name, level, rarity, maxDurability}
 }
 
 func XPNeededForLevel(level int64) int64 {
@@ -942,13 +1391,13 @@ func (character *Character) MaxXP() int64 {
 	return XPNeededForLevel(character.Level)
 }
 
-func (character *Character) GainXP(xp int64) bool {
+func (character *Character) GainXP(pack *NamePack, xp int64) bool {
 	character.XP += xp
 	levelled := false
 	for character.XP >= character.MaxXP() {
 		character.XP -= character.MaxXP()
 		character.Level++
-		character.AddItems()
+		character.AddItems(pack)
 		levelled = true
 	}
 	return levelled
@@ -969,6 +1418,48 @@ func (game *Game) GetCharacter(name string, create bool) *Character {
 	return character
 }
 
+// Returns the key of the character a nick plays as: the character its services account owns, otherwise the nick's own
+// character unless another account owns it, which a logged in nick claims. Returns an empty key if the nick can't play
+// as anyone. The game must be locked.
+func (game *Game) characterKey(server *Server, nick string) string {
+	if account, _ := server.Account(nick); account != "" {
+		for key, character := range game.Characters {
+			if character.Account != "" && SameAccount(game.Server, character.Account, account) {
+				return key
+			}
+		}
+	}
+	key := NameKey(nick)
+	character := game.Characters[key]
+	if character == nil {
+		return key
+	}
+	allowed, owner := server.Owns(character.Account, nick)
+	if !allowed {
+		return ""
+	}
+	character.Account = owner
+	return key
+}
+
+// Returns a response for a nick in the game's room, nick may be empty for responses to the whole room. The room is
+// always in vars as Room.
+func (game *Game) Response(nick, key string, vars ResponseVars) string {
+	if vars == nil {
+		vars = ResponseVars{}
+	}
+	vars["Room"] = game.Room
+	return Response(game.Server, game.Room, nick, key, vars)
+}
+
+// Returns the character a nick plays as, nil if it doesn't have one or can't play. The game must be locked.
+func (game *Game) playerCharacter(server *Server, nick string) *Character {
+	if key := game.characterKey(server, nick); key != "" {
+		return game.GetCharacter(key, false)
+	}
+	return nil
+}
+
 func (game *Game) GetSortedCharacters() Characters {
 	characters := make(Characters, 0)
 	for _, value := range game.Characters {
@@ -979,30 +1470,31 @@ func (game *Game) GetSortedCharacters() Characters {
 }
 
 func (game *Game) NewMonster() *Monster {
+	pack := game.NamePack()
 	health := int64(len(game.Defeated))
 	difficulty := 1.0
-	name := monsterNames[rand.Intn(len(monsterNames))]
+	name := pack.MonsterNames[rand.Intn(len(pack.MonsterNames))]
 	prefix := "a"
 	r := rand.Float64()
 	if r > 0.99 && health > 100 {
 		difficulty += 4 + rand.Float64()*5
-		name = monsterRare[rand.Intn(len(monsterRare))]
+		name = pack.MonsterRare[rand.Intn(len(pack.MonsterRare))]
 		prefix = ""
 	} else if r > 0.94 {
 		difficulty += 1 + rand.Float64()
-		first := monsterUnique[rand.Intn(len(monsterUnique))]
+		first := pack.MonsterUnique[rand.Intn(len(pack.MonsterUnique))]
 		second := ""
 		for second == "" || second == first {
-			second = monsterUnique[rand.Intn(len(monsterUnique))]
+			second = pack.MonsterUnique[rand.Intn(len(pack.MonsterUnique))]
 		}
 		name = strings.ToUpper(string(first[0])) + first[1:] + second
 		prefix = ""
 	} else if r > 0.74 {
 		difficulty += rand.Float64()
-		name = monsterLarge[rand.Intn(len(monsterLarge))] + " " + name
+		name = pack.MonsterLarge[rand.Intn(len(pack.MonsterLarge))] + " " + name
 	} else if r > 0.54 {
 		difficulty -= rand.Float64() / 2.0
-		name = monsterSmall[rand.Intn(len(monsterSmall))] + " " + name
+		name = pack.MonsterSmall[rand.Intn(len(pack.MonsterSmall))] + " " + name
 	}
 	health = int64(float64(health) * difficulty)
 	if health < 1 {
@@ -1030,6 +1522,74 @@ func (monster *Monster) AddCharacter(name string) {
 	monster.Characters[key]++
 }
 
+func (monster *Monster) AddDamage(name string, damage int64) {
+	if monster.Damage == nil {
+		monster.Damage = make(map[string]int64)
+	}
+	monster.Damage[NameKey(name)] += damage
+}
+
+// Returns the fraction of the raid's contribution made by each character, by damage if it was tracked, otherwise by messages.
+func (monster *Monster) Contributions() map[string]float64 {
+	counts := monster.Damage
+	if len(counts) == 0 {
+		counts = monster.Characters
+	}
+	total := int64(0)
+	for _, count := range counts {
+		total += count
+	}
+	contributions := make(map[string]float64)
+	for key, count := range counts {
+		if total > 0 {
+			contributions[key] = float64(count) / float64(total)
+		}
+	}
+	return contributions
+}
+
+// Returns the xp a raid member earns from a kill worth xp, before any catch up bonus.
+func (monster *Monster) ShareXP(key string, xp int64, contributions map[string]float64, average float64, max int64) int64 {
+	if *rpgxpmodel == XP_MODEL_AVERAGE {
+		count := monster.Characters[key]
+		// You have to beat the average amount of talking to get full XP.
+		if count < int64(average) {
+			return int64(float64(xp) * float64(count) / float64(max))
+		}
+		return xp
+	}
+	// An equal share of the damage earns full xp, less than that is scaled down to the floor.
+	share := contributions[key] * float64(len(monster.Characters))
+	if share > 1 {
+		share = 1
+	}
+	if share < *rpgxpfloor {
+		share = *rpgxpfloor
+	}
+	exp := int64(float64(xp) * share)
+	if exp < 1 {
+		exp = 1
+	}
+	return exp
+}
+
+// Returns the raid members with the percentage of the damage they dealt, largest first.
+func (monster *Monster) ContributionList(game *Game) string {
+	contributions := monster.Contributions()
+	keys := make([]string, 0, len(contributions))
+	for key, _ := range contributions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return contributions[keys[i]] > contributions[keys[j]]
+	})
+	list := make([]string, len(keys))
+	for i, key := range keys {
+		list[i] = fmt.Sprintf("%v %d%%", game.GetCharacter(key, true).Name, int(contributions[key]*100+0.5))
+	}
+	return strings.Join(list, ", ")
+}
+
 func (monster *Monster) Heal(health int64) {
 	monster.Health += health
 	if monster.Health > monster.MaxHealth {
@@ -1080,9 +1640,10 @@ func (monster *Monster) CharacterList(game *Game) template.HTML {
 			max = c
 		}
 	}
+	contributions := monster.Contributions()
 	str := ""
 	for name, c := range monster.Characters {
-		str += fmt.Sprintf("<span class=\"raid%d\">%s</span>, ", int((float64(c)/float64(max))*100), game.GetCharacter(name, true).Name)
+		str += fmt.Sprintf("<span class=\"raid%d\">%s (%d%%)</span>, ", int((float64(c)/float64(max))*100), game.GetCharacter(name, true).Name, int(contributions[name]*100+0.5))
 	}
 	if str == "" {
 		return template.HTML(str)
@@ -1109,35 +1670,27 @@ func (character *Character) LevelPercentage(game *Game) int {
 }
 
 func (character *Character) NameStyle(includeTitle bool) template.HTML {
-	name := character.Name
+	name := template.HTMLEscapeString(character.Name)
 	prefix := ""
 	title := ""
+	color := ""
+	if favorite := character.FavoriteTitle(); favorite != "" {
+		title = fmt.Sprintf("<span class=\"raid100\">%v</span>", template.HTMLEscapeString(favorite))
+	}
 
-	if !character.Achievements["dkp100"].IsZero() {
-		title = "<span class=\"raid100\">, The Dragon Ascendant</span>"
-		prefix = "<span class=\"level100\">♛</span>"
-	} else if !character.Achievements["dkp50"].IsZero() {
-		title = "<span class=\"raid100\">, Lord of the Dragon</span>"
-		prefix = "<span class=\"level75\">♛</span>"
-	} else if !character.Achievements["dkp10"].IsZero() {
-		title = "<span class=\"raid100\">, Bearer of the Dragonscale</span>"
-		prefix = "<span class=\"level50\">♛</span>"
-	} else if !character.Achievements["dkp5"].IsZero() {
-		title = "<span class=\"raid100\"> der Drachentöter</span>"
-		prefix = "<span class=\"level25\">♛</span>"
-	} else if !character.Achievements["dkp1"].IsZero() {
-		title = "<span class=\"raid100\"> the Dragonslayer</span>"
-		prefix = "<span class=\"level0\">♛</span>"
-	} else if !character.Achievements["dmp100"].IsZero() {
-		prefix = "<span class=\"level100\">☹</span>"
-	} else if !character.Achievements["dmp50"].IsZero() {
-		prefix = "<span class=\"level75\">☹</span>"
-	} else if !character.Achievements["dmp10"].IsZero() {
-		prefix = "<span class=\"level50\">☹</span>"
-	} else if !character.Achievements["dmp5"].IsZero() {
-		prefix = "<span class=\"level25\">☹</span>"
-	} else if !character.Achievements["dmp1"].IsZero() {
-		prefix = "<span class=\"level0\">☹</span>"
+	for _, reward := range character.Rewards() {
+		if prefix == "" && reward.Icon != "" {
+			prefix = fmt.Sprintf("<span class=\"level%d\">%v</span>", reward.IconLevel, reward.Icon)
+		}
+		if title == "" && reward.Title != "" {
+			title = fmt.Sprintf("<span class=\"raid100\">%v</span>", template.HTMLEscapeString(reward.Title))
+		}
+		if color == "" && reward.Color != "" {
+			color = reward.Color
+		}
+	}
+	if color != "" {
+		name = fmt.Sprintf("<span style=\"color: %v\">%v</span>", template.HTMLEscapeString(color), name)
 	}
 	if includeTitle {
 		return template.HTML(fmt.Sprintf("%v%v%v", prefix, name, title))
@@ -1145,7 +1698,7 @@ func (character *Character) NameStyle(includeTitle bool) template.HTML {
 	return template.HTML(fmt.Sprintf("%v%v", prefix, name))
 }
 
-func (character *Character) AchievementsList() template.HTML {
+func (character *Character) AchievementsList(format *TimeFormat) template.HTML {
 	str := ""
 
 	var lastGroup AchievementGroup
@@ -1160,9 +1713,9 @@ func (character *Character) AchievementsList() template.HTML {
 		}
 		time := character.Achievements[achievement.ID]
 		if !time.IsZero() {
-			str += fmt.Sprintf("<div class=\"achievement earned\"><span class=\"name earned\">%v</span><br><span class=\"date earned\">Earned %v</span><br><span class=\"description earned\">%v</span></div>", achievement.Name, time.Format("02 Jan 2006"), achievement.Description)
+			str += fmt.Sprintf("<div class=\"achievement earned\"><span class=\"name earned\">%v</span><br><span class=\"date earned\">Earned %v</span><br><span class=\"description earned\">%v</span>%v</div>", achievement.Name, format.Date(time), achievement.Description, achievement.RewardHTML())
 		} else if !shownNext {
-			str += fmt.Sprintf("<div class=\"achievement unearned\"><span class=\"name unearned\">%v</span><br><span class=\"date unearned\">Not earned</span><br><span class=\"description unearned\">%v</span></div>", achievement.Name, achievement.Description)
+			str += fmt.Sprintf("<div class=\"achievement unearned\"><span class=\"name unearned\">%v</span><br><span class=\"date unearned\">Not earned</span><br><span class=\"description unearned\">%v</span>%v</div>", achievement.Name, achievement.Description, achievement.RewardHTML())
 			shownNext = true
 		}
 	}
@@ -1171,7 +1724,11 @@ func (character *Character) AchievementsList() template.HTML {
 }
 
 func (game *Game) Heal() {
+	game.Lock()
+	defer game.Unlock()
+
 	game.Monster.Heal(1)
+	game.liveUpdate("heal", "")
 }
 
 func (monster *Monster) assignStats(character *Character) {
@@ -1213,32 +1770,55 @@ func (monster *Monster) assignStats(character *Character) {
 	}
 }
 
-func (game *Game) Attack(event *Event) {
+// Returns the monster if it was defeated by this attack.
+func (game *Game) Attack(event *Event) *Monster {
 	if strings.HasPrefix(event.Line.Text(), "!") {
-		return
+		return nil
 	}
 
 	game.Lock()
 	name := event.Line.Nick
-	key := NameKey(name)
+	if NameKey(name) == NameKey(event.Server.Conn.Me().Nick) {
+		game.Unlock()
+		return nil
+	}
+	// Someone using a nick whose character another account owns can't fight as it.
+	key := game.characterKey(event.Server, name)
+	if key == "" {
+		game.Unlock()
+		return nil
+	}
 
-	// Create the character if it doesn't exist
-	char := game.GetCharacter(name, true)
-	char.Name = name
+	// Create the character if it doesn't exist, a character played from another nick keeps its name.
+	char := game.GetCharacter(key, true)
+	if key == NameKey(name) {
+		char.Name = name
+		if char.Account == "" {
+			char.Account, _ = event.Server.Account(name)
+		}
+	}
+	name = char.Name
 
-	if key == NameKey(event.Server.Conn.Me().Nick) || (key == game.Last && !*rpgallowrepeats) {
+	if key == game.Last && !*rpgallowrepeats {
 		game.Unlock()
-		return
+		return nil
 	}
 	game.Last = key
 	monster := game.Monster
 	monster.AddCharacter(name)
-	monster.Health -= int64(len(monster.Characters))
+	damage := char.ClassDamage(int64(len(monster.Characters)))
+	monster.AddDamage(name, damage)
+	monster.Health -= damage
 	if monster.Health <= 0 {
 		game.Defeated = append(game.Defeated, monster)
-		game.Monster = game.NewMonster()
+		if monster.Boss {
+			game.Monster = game.afterBoss()
+		} else {
+			game.Monster = game.NewMonster()
+			game.Monster.Born = event.Time
+		}
 
-		monster.Died = time.Now()
+		monster.Died = event.Time
 		xp := int64(float64(len(monster.Characters)) * monster.Difficulty)
 		if xp < 1 {
 			xp = 1
@@ -1263,6 +1843,7 @@ func (game *Game) Attack(event *Event) {
 			}
 		}
 		average /= float64(len(monster.Characters))
+		contributions := monster.Contributions()
 		slayedName := game.GetCharacter(monster.Slayed, true).Name
 
 		prefix := monster.Prefix
@@ -1273,41 +1854,107 @@ func (game *Game) Attack(event *Event) {
 		if newprefix != "" {
 			newprefix = newprefix + " "
 		}
-		for n, count := range monster.Characters {
+		summary := KillSummaryEnabled(game.Server, game.Room)
+		loot := []string{}
+		for n, _ := range monster.Characters {
 			char := game.GetCharacter(n, true)
+			contribution := int(contributions[n]*100 + 0.5)
+			oldItems := make(Items, len(char.Items))
+			copy(oldItems, char.Items)
 
-			exp := xp
-			// You have to beat the average amount of talking to get full XP.
-			if count < int64(average) {
-				exp = int64(float64(exp) * float64(count) / float64(max))
-			}
+			exp := monster.ShareXP(n, xp, contributions, average, max)
 			extra := maxLevel - char.Level
 			if extra > exp {
 				extra = exp
 			}
 			exp += extra
+			if monster.Boss {
+				exp = int64(float64(exp)**rpgbossxp + 0.5)
+			}
+			exp = game.EventXP(exp, event.Time)
+			exp += int64(float64(exp) * char.XPBonus())
+			exp = char.ClassXP(exp)
+			exp = game.GuildXP(char, monster, exp)
+			exp = char.WoundedXP(exp)
+			if cap := soloCap(game.Server, game.Room); cap > 0 && len(monster.Characters) == 1 {
+				exp = char.SoloXP(exp, cap, game.soloDay(event.Time))
+			}
 
-			levelled := char.GainXP(exp)
+			levelled := char.GainXP(game.NamePack(), exp)
+			var drop *Item
+			if monster.Boss {
+				drop = char.BossDrop(game.NamePack())
+			}
+			char.Gold += goldForXP(exp)
+			broke := char.Wear()
+			for slot, item := range char.Items {
+				if item != nil && item != oldItems[slot] && item.Rarity > ITEM_NORMAL {
+					loot = append(loot, fmt.Sprintf("%v: %v", char.Name, item.Name))
+				}
+			}
 			monster.assignStats(char)
-			achievements.check(char.stats, char.Achievements)
+			earned := achievements.check(char.stats, char.Achievements)
 			if char.Listening {
-				if n == monster.Slayed {
-					event.Server.Conn.Privmsg(n, fmt.Sprintf("You just slayed %v%v in %v and gained %d xp.", prefix, monster.Name, game.Room, exp))
-				} else {
-					event.Server.Conn.Privmsg(n, fmt.Sprintf("You helped %v slay %v%v in %v and gained %d xp.", slayedName, prefix, monster.Name, game.Room, exp))
+				// Rooms with a kill summary are told about the kill instead.
+				if !summary {
+					if n == monster.Slayed {
+						game.settings.Privmsg(event.Server, n, Response(game.Server, game.Room, n, "rpg.slayed", ResponseVars{"Monster": prefix + monster.Name, "Room": game.Room, "Damage": contribution, "XP": exp}))
+					} else {
+						game.settings.Privmsg(event.Server, n, Response(game.Server, game.Room, n, "rpg.helped", ResponseVars{"Slayer": slayedName, "Monster": prefix + monster.Name, "Room": game.Room, "Damage": contribution, "XP": exp}))
+					}
+				}
+				if drop != nil {
+					game.settings.Privmsg(event.Server, n, Response(game.Server, game.Room, n, "rpg.bossdrop", ResponseVars{"Item": drop.Name, "Monster": monster.Name, "Room": game.Room}))
 				}
 				if levelled {
-					event.Server.Conn.Privmsg(n, fmt.Sprintf("You just levelled up in %v to level %d!", game.Room, char.Level))
+					game.settings.Privmsg(event.Server, n, Response(game.Server, game.Room, n, "rpg.levelled", ResponseVars{"Room": game.Room, "Level": char.Level}))
+				}
+				for _, item := range broke {
+					game.settings.Privmsg(event.Server, n, Response(game.Server, game.Room, n, "rpg.broke", ResponseVars{"Item": item.Name, "Room": game.Room, "Cost": item.RepairCost()}))
 				}
-				event.Server.Conn.Privmsg(n, fmt.Sprintf("You see %v%v approaching.", newprefix, game.Monster.Stats()))
+				for _, achievement := range earned {
+					msg := Response(game.Server, game.Room, n, "rpg.earned", ResponseVars{"Achievement": achievement.Name, "Room": game.Room})
+					if achievement.Reward != nil {
+						msg += fmt.Sprintf(" Reward: %v.", achievement.Reward)
+					}
+					game.settings.Privmsg(event.Server, n, msg)
+				}
+				game.settings.Privmsg(event.Server, n, Response(game.Server, game.Room, n, "rpg.approaching", ResponseVars{"Monster": newprefix + game.Monster.Stats(), "Room": game.Room}))
 			}
 		}
+		if monster.Boss && !summary {
+			game.settings.Send(event.Server, RPGAnnounceStyle(game.Server, game.Room), string(game.Room), Response(game.Server, game.Room, "", "rpg.bossslain", ResponseVars{"Slayer": slayedName, "Monster": monster.Name, "Raid": len(monster.Characters)}))
+		}
+		if summary {
+			sort.Strings(loot)
+			game.settings.Send(event.Server, RPGAnnounceStyle(game.Server, game.Room), string(game.Room), Response(game.Server, game.Room, "", "rpg.killsummary", ResponseVars{"Slayer": slayedName, "Monster": prefix + monster.Name, "Raid": len(monster.Characters), "Loot": strings.Join(loot, ", ")}))
+		}
+		game.liveUpdate("kill", fmt.Sprintf("%v slayed %v%v", slayedName, prefix, monster.Name))
 		game.Unlock()
 		game.Save()
-		game.Upload()
-	} else {
-		game.Unlock()
+		// Live rooms are watched through the live view, so only upload them with the regular save.
+		if !LiveEnabled(game.Server, game.Room) {
+			game.Upload()
+		}
+		return monster
 	}
+	game.liveUpdate("attack", fmt.Sprintf("%v hits for %d", name, damage))
+	game.Unlock()
+	return nil
+}
+
+// Emits RPG_KILL for the death of a monster, so other plugins can react to it.
+func (monster *Monster) EmitKill(bot *Bot, server *Server, game *Game) {
+	game.RLock()
+	slayed := game.GetCharacter(monster.Slayed, true).Name
+	text := fmt.Sprintf("%v slayed %v (%v)", slayed, monster.Name, monster.ContributionList(game))
+	kill := &RPGKill{Monster: monster.Name, Difficulty: monster.Difficulty, Slayer: slayed, Contributions: make(map[string]float64), Born: monster.Born}
+	for key, contribution := range monster.Contributions() {
+		kill.Contributions[game.GetCharacter(key, true).Name] = contribution
+	}
+	game.RUnlock()
+
+	bot.Emit(RPG_KILL, server, game.Room, slayed, text, monster.Died, kill)
 }
 
 // Returns true when attacker makes a hit.
@@ -1315,6 +1962,19 @@ func (game *Game) fight(attacker, defender *Character) bool {
 	return rand.Int63n(20+attacker.Level)+attacker.WeaponLevel() > rand.Int63n(20+defender.Level)+defender.ArmorLevel()
 }
 
+// Returns the number of hits each character makes in 5 rounds of attacks.
+func (game *Game) exchange(attacker, defender *Character) (attackerHits, defenderHits int) {
+	for i := 0; i < 5; i++ {
+		if game.fight(attacker, defender) {
+			attackerHits++
+		}
+		if game.fight(defender, attacker) {
+			defenderHits++
+		}
+	}
+	return
+}
+
 func (game *Game) Fight(attackerName, defenderName string) string {
 	game.Lock()
 	defer game.Unlock()
@@ -1322,23 +1982,16 @@ func (game *Game) Fight(attackerName, defenderName string) string {
 	attacker := game.GetCharacter(attackerName, false)
 	defender := game.GetCharacter(defenderName, false)
 
-	attackerHits := 0
-	defenderHits := 0
-
 	if attacker == nil || defender == nil || attacker == defender {
 		return ""
 	}
 
-	for i := 0; i < 5; i++ {
-		if game.fight(attacker, defender) {
-			attackerHits++
-		}
-		if game.fight(defender, attacker) {
-			defenderHits++
-		}
-	}
+	attackerHits, defenderHits := game.exchange(attacker, defender)
+
+	attackerMention := SafeNick(game.Server, game.Room, attacker.Name)
+	defenderMention := SafeNick(game.Server, game.Room, defender.Name)
 
-	description := fmt.Sprintf("%v (%v atk, %v def) vs %v (%v atk, %v def). ", attacker.Name, attacker.WeaponLevel(), attacker.ArmorLevel(), defender.Name, defender.WeaponLevel(), defender.ArmorLevel())
+	description := fmt.Sprintf("%v (%v atk, %v def) vs %v (%v atk, %v def). ", attackerMention, attacker.WeaponLevel(), attacker.ArmorLevel(), defenderMention, defender.WeaponLevel(), defender.ArmorLevel())
 	switch {
 	case attackerHits == defenderHits:
 		if attackerHits == 0 {
@@ -1347,14 +2000,14 @@ func (game *Game) Fight(attackerName, defenderName string) string {
 		return fmt.Sprintf("%vTie. (%v to %v)", description, attackerHits, defenderHits)
 	case attackerHits > defenderHits:
 		if defenderHits == 0 {
-			return fmt.Sprintf("%v%v Wins. Flawless Victory!", description, attacker.Name)
+			return fmt.Sprintf("%v%v Wins. Flawless Victory!", description, attackerMention)
 		}
-		return fmt.Sprintf("%v%v Wins. (%v to %v)", description, attacker.Name, attackerHits, defenderHits)
+		return fmt.Sprintf("%v%v Wins. (%v to %v)", description, attackerMention, attackerHits, defenderHits)
 	case defenderHits > attackerHits:
 		if attackerHits == 0 {
-			return fmt.Sprintf("%v%v Wins. Flawless Victory!", description, defender.Name)
+			return fmt.Sprintf("%v%v Wins. Flawless Victory!", description, defenderMention)
 		}
-		return fmt.Sprintf("%v%v Wins. (%v to %v)", description, defender.Name, defenderHits, attackerHits)
+		return fmt.Sprintf("%v%v Wins. (%v to %v)", description, defenderMention, defenderHits, attackerHits)
 	}
 	return ""
 }
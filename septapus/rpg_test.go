@@ -0,0 +1,53 @@
+package septapus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// A game keeps running after its plugin is disabled or banned in the room, but must stop hearing the room.
+func TestRunningGameIgnoresDisabledRoom(t *testing.T) {
+	for _, disable := range []struct {
+		name string
+		fn   func(s *PluginSettings)
+	}{
+		{"!plugin disable", func(s *PluginSettings) { s.SetDisabled(true) }},
+		{"!plugin ban", func(s *PluginSettings) { s.AddBannedRoom("synirc", "#septapus") }},
+	} {
+		bot := &Bot{}
+		server := &Server{Name: "synirc"}
+		rpg := NewRPGPlugin(NewPluginSettings())
+		// Subscribed like Game's handlers in RPGPlugin.game.
+		messagechan := rpg.settings.GetEventHandler(bot, client.PRIVMSG, IsRoom(server.Name, "#septapus"))
+		fightchan := rpg.settings.HandleCommand(bot, rpgFightCommand, IsRoom(server.Name, "#septapus"))
+
+		say := func(text string) {
+			line := &client.Line{Nick: "iopred", Cmd: client.PRIVMSG, Args: []string{"#septapus", text}}
+			go bot.BroadcastEvent(client.PRIVMSG, &Event{Server: server, Room: "#septapus", Line: line, Time: time.Now()})
+		}
+
+		say("hello")
+		if nextEvent(messagechan) == nil {
+			t.Fatalf("%v: the game didn't hear a message before it was disabled", disable.name)
+		}
+		say("!rpgfight septapus")
+		// Commands are messages too.
+		if nextEvent(fightchan) == nil || nextEvent(messagechan) == nil {
+			t.Fatalf("%v: the game didn't hear a command before it was disabled", disable.name)
+		}
+
+		disable.fn(rpg.settings)
+		say("hello")
+		say("!rpgfight septapus")
+		select {
+		case event := <-messagechan:
+			t.Errorf("%v: the game heard %q after it was disabled", disable.name, event.Line.Text())
+		case event := <-fightchan:
+			t.Errorf("%v: the game heard %q after it was disabled", disable.name, event.Line.Text())
+		case <-time.After(50 * time.Millisecond):
+		}
+		bot.RemoveEventHandlers(messagechan, fightchan)
+	}
+}
@@ -0,0 +1,74 @@
+package septapus
+
+import (
+	"fmt"
+)
+
+var rpgAlertCommand = NewCommand("!rpgalert <percent:int> [room]").WithHelp("Alerts you when the monster drops below a percentage of its health, 0 turns alerts off.")
+
+// Tracks who has been alerted about the current monster, not persisted.
+type alerts struct {
+	monster *Monster
+	alerted map[string]bool
+}
+
+func (game *Game) AlertCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgAlertCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	if event.Line.Target() == event.Line.Nick {
+		// Private message to us, must include a room
+		if RoomName(args.String("room")) != game.Room {
+			return
+		}
+	} else if event.Room != game.Room {
+		return
+	}
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		return
+	}
+	percent := args.Int("percent")
+	if percent < 0 || percent > 100 {
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Alert percentage must be between 0 and 100.")
+		return
+	}
+	char.Alert = int64(percent)
+	if percent == 0 {
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Low health alerts disabled in "+string(game.Room))
+		return
+	}
+	msg := fmt.Sprintf("You will be alerted when a monster in %v drops below %d%% health.", game.Room, percent)
+	if !char.Listening {
+		msg += " Alerts are only sent while listening, use !rpglisten true."
+	}
+	game.settings.Privmsg(event.Server, event.Line.Nick, msg)
+}
+
+// Returns the alert to send to each listening character whose threshold the current monster has dropped below.
+// Each character is only alerted once per monster.
+func (game *Game) LowHealthAlerts() map[string]string {
+	game.Lock()
+	defer game.Unlock()
+
+	monster := game.Monster
+	if game.alerts == nil || game.alerts.monster != monster {
+		game.alerts = &alerts{monster, make(map[string]bool)}
+	}
+	messages := make(map[string]string)
+	for key, char := range game.Characters {
+		if !char.Listening || char.Alert == 0 || game.alerts.alerted[key] {
+			continue
+		}
+		if monster.Health*100 < monster.MaxHealth*char.Alert {
+			game.alerts.alerted[key] = true
+			messages[char.Name] = fmt.Sprintf("%v in %v is below %d%% health, %v", monster.Name, game.Room, char.Alert, monster.Stats())
+		}
+	}
+	return messages
+}
@@ -0,0 +1,128 @@
+package septapus
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var rpgbiolength = rpgOptions.Int("biolength", 200, "Most characters in a character's biography, longer ones are cut short")
+
+var rpgBioCommand = NewCommand("!rpgbio clear", "!rpgbio title <title...>", "!rpgbio <text...>").WithHelp("Sets the biography shown on your character's page, or which earned title follows its name.")
+
+// Returns text without irc formatting or control characters, cut to at most length characters.
+func sanitizeBio(text string, length int) string {
+	str := make([]rune, 0, len(text))
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		if r == '\x03' {
+			// Colors are followed by up to two digits, and optionally a comma and up to two more.
+			i += colorCodeLength(text[i:])
+			continue
+		}
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			continue
+		}
+		str = append(str, r)
+	}
+	if len(str) > length {
+		str = str[:length]
+	}
+	return strings.TrimSpace(string(str))
+}
+
+// Returns the length of the color numbers after an irc color code, eg: 4,12.
+func colorCodeLength(text string) int {
+	digits := func(start int) int {
+		n := 0
+		for start+n < len(text) && n < 2 && text[start+n] >= '0' && text[start+n] <= '9' {
+			n++
+		}
+		return n
+	}
+	n := digits(0)
+	if n > 0 && n < len(text) && text[n] == ',' {
+		if background := digits(n + 1); background > 0 {
+			n += 1 + background
+		}
+	}
+	return n
+}
+
+// Returns the titles of every achievement the character has earned, not just the best in each group.
+func (character *Character) EarnedTitles() []string {
+	titles := make([]string, 0)
+	for _, achievement := range achievements {
+		if achievement.Reward != nil && achievement.Reward.Title != "" && !character.Achievements[achievement.ID].IsZero() {
+			titles = append(titles, achievement.Reward.Title)
+		}
+	}
+	return titles
+}
+
+// Returns the character's favorite title if it has still earned it, otherwise an empty string.
+func (character *Character) FavoriteTitle() string {
+	if character.Title == "" {
+		return ""
+	}
+	for _, title := range character.EarnedTitles() {
+		if title == character.Title {
+			return title
+		}
+	}
+	return ""
+}
+
+// Sets the biography or favorite title of the nick's character, shown on its detail panel.
+func (game *Game) BioCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgBioCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	switch {
+	case args.Pattern == "!rpgbio clear":
+		char.Bio = ""
+		char.Title = ""
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Cleared your biography and title in "+string(game.Room))
+	case args.Has("title"):
+		want := strings.TrimLeft(args.String("title"), ", ")
+		titles := make([]string, 0)
+		for _, title := range char.EarnedTitles() {
+			name := strings.TrimLeft(title, ", ")
+			if strings.EqualFold(name, want) {
+				char.Title = title
+				game.settings.Privmsg(event.Server, event.Line.Nick, fmt.Sprintf("You are now known as %v%v in %v.", char.Name, title, game.Room))
+				return
+			}
+			titles = append(titles, name)
+		}
+		if len(titles) == 0 {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "You haven't earned any titles in "+string(game.Room))
+			return
+		}
+		game.settings.Privmsg(event.Server, event.Line.Nick, "You haven't earned that title, choose one of: "+strings.Join(titles, ", "))
+	default:
+		bio := sanitizeBio(args.String("text"), *rpgbiolength)
+		if bio == "" {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "Usage: "+rpgBioCommand.Usage())
+			return
+		}
+		char.Bio = bio
+		msg := "Your biography in " + string(game.Room) + " is set"
+		if sanitizeBio(args.String("text"), len(args.String("text"))) != bio {
+			msg += fmt.Sprintf(", cut to %d characters", *rpgbiolength)
+		}
+		game.settings.Privmsg(event.Server, event.Line.Nick, msg+".")
+	}
+}
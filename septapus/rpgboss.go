@@ -0,0 +1,100 @@
+package septapus
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var rpgboss = rpgOptions.String("boss", "", "Comma separated list of rooms and how often a world boss spawns in them, eg: synirc/#septapus=6h")
+var rpgbosshealth = rpgOptions.Float64("bosshealth", 10, "Health of a world boss, as a multiple of the health a normal monster would have")
+var rpgbossxp = rpgOptions.Float64("bossxp", 3, "Multiplier of the xp a world boss's raid gains")
+var rpgbossunique = rpgOptions.Float64("bossunique", 0.1, "Chance a world boss drops a unique weapon rather than a rare item")
+
+var (
+	bossRooms     RoomValues
+	bossRoomsOnce sync.Once
+)
+
+// Returns how often a world boss spawns in a room, 0 if they don't.
+func bossInterval(server ServerName, room RoomName) time.Duration {
+	bossRoomsOnce.Do(func() {
+		bossRooms = ParseRoomValues(*rpgboss)
+	})
+	value, _ := bossRooms.Get(server, room)
+	interval, _ := time.ParseDuration(value)
+	return interval
+}
+
+// Returns a world boss, its health scaled from the health a normal monster would have.
+func (game *Game) NewBoss(now time.Time) *Monster {
+	pack := game.NamePack()
+	health := int64(float64(len(game.Defeated)+1) * *rpgbosshealth)
+	if health < 1 {
+		health = 1
+	}
+	return &Monster{
+		Name:       pack.MonsterRare[rand.Intn(len(pack.MonsterRare))],
+		MaxHealth:  health,
+		Health:     health,
+		Difficulty: 2,
+		Characters: make(map[string]int64),
+		Born:       now,
+		Boss:       true,
+	}
+}
+
+// Spawns a world boss if one is due, the monster it interrupts is fought again once the boss is slain. Returns the
+// announcement of the boss, or an empty string if none spawned.
+func (game *Game) SpawnBoss(now time.Time) string {
+	game.Lock()
+	defer game.Unlock()
+
+	interval := bossInterval(game.Server, game.Room)
+	if interval <= 0 {
+		return ""
+	}
+	if game.NextBoss.IsZero() {
+		game.NextBoss = now.Add(interval)
+	}
+	if now.Before(game.NextBoss) || game.Monster.Boss {
+		return ""
+	}
+	game.NextBoss = now.Add(interval)
+	game.Interrupted = game.Monster
+	game.Monster = game.NewBoss(now)
+	game.liveUpdate("boss", game.Monster.Name+" spawned")
+	return Response(game.Server, game.Room, "", "rpg.bossspawn", ResponseVars{"Monster": game.Monster.Stats(), "Room": game.Room, "XP": *rpgbossxp})
+}
+
+// Returns the monster fought after a world boss is slain, the one it interrupted if there was one.
+func (game *Game) afterBoss() *Monster {
+	monster := game.Interrupted
+	game.Interrupted = nil
+	if monster == nil {
+		monster = game.NewMonster()
+	}
+	return monster
+}
+
+// Returns an item dropped by a world boss, rare whatever its level, or sometimes a unique weapon.
+func NewBossItem(pack *NamePack, slot int, level int64) *Item {
+	if slot == SLOT_WEAPON && rand.Float64() < *rpgbossunique {
+		return &Item{pack.Uniques[rand.Intn(len(pack.Uniques))], level, ITEM_UNIQUE, maxDurability}
+	}
+	names := pack.ItemNames[slot]
+	name := pack.Prefixes[rand.Intn(len(pack.Prefixes))] + " " + names[rand.Intn(len(names))] + " of " + pack.Suffixes[rand.Intn(len(pack.Suffixes))]
+	return &Item{name, level, ITEM_RARE, maxDurability}
+}
+
+// Gives a character a world boss's drop, replacing the item in a random slot with a better one. Returns the drop.
+func (character *Character) BossDrop(pack *NamePack) *Item {
+	slot := rand.Intn(NUM_SLOTS)
+	level := int64(1)
+	if old := character.Items[slot]; old != nil {
+		level = old.Level + 1
+	}
+	item := NewBossItem(pack, slot, level)
+	character.equip(slot, item)
+	return item
+}
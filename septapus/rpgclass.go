@@ -0,0 +1,117 @@
+package septapus
+
+import (
+	"fmt"
+	"strings"
+)
+
+var rpgClassCommand = NewCommand("!rpgclass [class]").WithHelp("Sets your character's class, warrior, mage or rogue, or shows what each one does.")
+
+// A class a character can pick with !rpgclass.
+type CharacterClass struct {
+	Name string
+	// Multipliers of the damage each attack deals, which is the character's share of the raid's xp, and of xp gained.
+	Damage float64
+	XP     float64
+	// Added to the level of the item in each slot in fights, while it isn't broken.
+	SlotBonus [NUM_SLOTS]int64
+}
+
+var characterClasses = []*CharacterClass{
+	{Name: "warrior", Damage: 1.5, XP: 1, SlotBonus: [NUM_SLOTS]int64{SLOT_WEAPON: 1, SLOT_BODY: 2}},
+	{Name: "mage", Damage: 1, XP: 1.25, SlotBonus: [NUM_SLOTS]int64{SLOT_HEAD: 2}},
+	{Name: "rogue", Damage: 1.25, XP: 1.1, SlotBonus: [NUM_SLOTS]int64{SLOT_WEAPON: 2}},
+}
+
+// Returns a class by name, or nil if there isn't one.
+func GetCharacterClass(name string) *CharacterClass {
+	for _, class := range characterClasses {
+		if strings.EqualFold(class.Name, name) {
+			return class
+		}
+	}
+	return nil
+}
+
+// Returns what a class does, eg: warrior: 1.5x damage, +1 weapon, +2 body.
+func (class *CharacterClass) String() string {
+	parts := make([]string, 0)
+	if class.Damage != 1 {
+		parts = append(parts, fmt.Sprintf("%vx damage", class.Damage))
+	}
+	if class.XP != 1 {
+		parts = append(parts, fmt.Sprintf("%vx xp", class.XP))
+	}
+	for slot, bonus := range class.SlotBonus {
+		if bonus != 0 {
+			parts = append(parts, fmt.Sprintf("+%d %v", bonus, slotNames[slot]))
+		}
+	}
+	return class.Name + ": " + strings.Join(parts, ", ")
+}
+
+// Returns the character's class, or nil if it hasn't picked one.
+func (character *Character) CharacterClass() *CharacterClass {
+	return GetCharacterClass(character.Class)
+}
+
+// Returns the damage an attack by the character deals.
+func (character *Character) ClassDamage(damage int64) int64 {
+	if class := character.CharacterClass(); class != nil {
+		damage = int64(float64(damage)*class.Damage + 0.5)
+	}
+	return damage
+}
+
+// Returns the xp the character gains.
+func (character *Character) ClassXP(xp int64) int64 {
+	if class := character.CharacterClass(); class != nil {
+		xp = int64(float64(xp)*class.XP + 0.5)
+	}
+	return xp
+}
+
+// Returns the fight level of the item in a slot, 0 if there isn't one or it is broken.
+func (character *Character) slotLevel(slot int) int64 {
+	item := character.Items[slot]
+	if item == nil || item.Broken() {
+		return 0
+	}
+	level := item.Level
+	if class := character.CharacterClass(); class != nil {
+		level += class.SlotBonus[slot]
+	}
+	return level
+}
+
+// Sets the class of the nick's character, or lists the classes.
+func (game *Game) ClassCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgClassCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	classes := make([]string, len(characterClasses))
+	for i, class := range characterClasses {
+		classes[i] = class.String()
+	}
+	if !args.Has("class") {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.classes", ResponseVars{"Classes": strings.Join(classes, "; ")}))
+		return
+	}
+	class := GetCharacterClass(args.String("class"))
+	if class == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.unknownclass", ResponseVars{"Classes": strings.Join(classes, "; ")}))
+		return
+	}
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	char.Class = class.Name
+	game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.classchosen", ResponseVars{"Name": char.Name, "Class": class.Name}))
+}
@@ -0,0 +1,68 @@
+package septapus
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var rpgcounterchance = rpgOptions.Float64("counterchance", 0.02, "Chance that a monster counterattacks after being hit, 0 to disable")
+var rpgcounterduration = rpgOptions.Duration("counterduration", 10*time.Minute, "How long a character gains reduced xp after failing to defend a counterattack")
+var rpgcounterpenalty = rpgOptions.Float64("counterpenalty", 0.5, "Fraction of xp lost while wounded by a counterattack")
+
+// Returns the attack level of the monster, scaled by its difficulty and the average level of the raid.
+func (monster *Monster) AttackLevel(game *Game) int64 {
+	level := 0.0
+	for n, _ := range monster.Characters {
+		level += float64(game.GetCharacter(n, true).Level)
+	}
+	if len(monster.Characters) > 0 {
+		level /= float64(len(monster.Characters))
+	}
+	return int64(level * monster.Difficulty)
+}
+
+// Returns true when the character's armor holds against the monster.
+func (game *Game) defend(character *Character, monster *Monster) bool {
+	return rand.Int63n(20+character.Level)+character.ArmorLevel() > rand.Int63n(20+monster.AttackLevel(game))
+}
+
+// Returns true if the character is still wounded from a counterattack.
+func (character *Character) IsWounded() bool {
+	return time.Now().Before(character.Wounded)
+}
+
+// Reduces xp gained while the character is wounded.
+func (character *Character) WoundedXP(xp int64) int64 {
+	if !character.IsWounded() {
+		return xp
+	}
+	xp -= int64(float64(xp) * *rpgcounterpenalty)
+	if xp < 1 {
+		xp = 1
+	}
+	return xp
+}
+
+// Has the monster occasionally strike back at the last attacker, returns a message describing the counterattack or an
+// empty string if there wasn't one.
+func (game *Game) Counterattack() string {
+	game.Lock()
+	defer game.Unlock()
+
+	if game.Last == "" || rand.Float64() >= *rpgcounterchance {
+		return ""
+	}
+	char := game.GetCharacter(game.Last, false)
+	if char == nil || char.IsWounded() {
+		return ""
+	}
+	name := SafeNick(game.Server, game.Room, char.Name)
+	message := fmt.Sprintf("%v lashes out at %v, but their armor holds!", game.monsterName(), name)
+	if !game.defend(char, game.Monster) {
+		char.Wounded = time.Now().Add(*rpgcounterduration)
+		message = fmt.Sprintf("%v lashes out at %v, wounding them! They will gain %d%% less xp for %v.", game.monsterName(), name, int(*rpgcounterpenalty*100+0.5), *rpgcounterduration)
+	}
+	game.liveUpdate("counter", message)
+	return message
+}
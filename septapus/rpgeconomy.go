@@ -0,0 +1,142 @@
+package septapus
+
+import (
+	"math/rand"
+	"strings"
+)
+
+var rpggoldrate = rpgOptions.Float64("goldrate", 0.1, "Gold a character earns from a kill for each xp it gains, at least 1")
+var rpgwear = rpgOptions.Int("wear", 1, "Durability each equipped item loses in a kill, items at 0 are broken and add nothing to fights until repaired")
+var rpgrepaircost = rpgOptions.Float64("repaircost", 0.2, "Gold to repair a point of an item's durability, for each of the item's levels")
+var rpggambleodds = rpgOptions.Float64("gambleodds", 0.45, "Chance of winning a bet with !rpggamble, a win pays back twice the bet")
+
+var (
+	rpgRepairCommand = NewCommand("!rpgrepair [slot]").WithHelp("Repairs your character's items with gold, or just one slot's.")
+	rpgGambleCommand = NewCommand("!rpggamble <gold:int>").WithHelp("Bets your character's gold, a win pays back twice the bet.")
+)
+
+// Durability of a new or fully repaired item.
+const maxDurability = 100
+
+func (item *Item) Broken() bool {
+	return item.Durability <= 0
+}
+
+// Returns the gold earned for gaining xp in a kill.
+func goldForXP(xp int64) int64 {
+	gold := int64(float64(xp) * *rpggoldrate)
+	if gold < 1 {
+		gold = 1
+	}
+	return gold
+}
+
+// Wears down each of the character's items after a kill, returns the items that broke.
+func (character *Character) Wear() []*Item {
+	broke := make([]*Item, 0)
+	for _, item := range character.Items {
+		if item == nil || item.Broken() {
+			continue
+		}
+		item.Durability -= int64(*rpgwear)
+		if item.Durability <= 0 {
+			item.Durability = 0
+			broke = append(broke, item)
+		}
+	}
+	return broke
+}
+
+// Returns the gold needed to fully repair an item.
+func (item *Item) RepairCost() int64 {
+	return int64(float64((maxDurability-item.Durability)*item.Level)**rpgrepaircost + 0.5)
+}
+
+// Repairs the nick's items, or the item in one slot, as long as it can afford each one.
+func (game *Game) RepairCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgRepairCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	slots := []int{SLOT_WEAPON, SLOT_HEAD, SLOT_BODY}
+	if args.Has("slot") {
+		slot := slotIndex(args.String("slot"))
+		if slot == -1 {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.unknownslot", ResponseVars{"Slots": strings.Join(slotNames, ", ")}))
+			return
+		}
+		slots = []int{slot}
+	}
+	repaired := make([]string, 0)
+	unaffordable := make([]string, 0)
+	spent := int64(0)
+	for _, slot := range slots {
+		item := char.Items[slot]
+		if item == nil || item.Durability >= maxDurability {
+			continue
+		}
+		cost := item.RepairCost()
+		if cost > char.Gold {
+			unaffordable = append(unaffordable, game.Response(event.Line.Nick, "rpg.repaircost", ResponseVars{"Item": item.Name, "Cost": cost}))
+			continue
+		}
+		char.Gold -= cost
+		spent += cost
+		item.Durability = maxDurability
+		repaired = append(repaired, item.Name)
+	}
+	msgs := make([]string, 0, 2)
+	switch {
+	case len(repaired) > 0:
+		msgs = append(msgs, game.Response(event.Line.Nick, "rpg.repaired", ResponseVars{"Items": strings.Join(repaired, ", "), "Spent": spent, "Gold": char.Gold}))
+	case len(unaffordable) == 0:
+		msgs = append(msgs, game.Response(event.Line.Nick, "rpg.nothingtorepair", nil))
+	}
+	if len(unaffordable) > 0 {
+		msgs = append(msgs, game.Response(event.Line.Nick, "rpg.cantrepair", ResponseVars{"Items": strings.Join(unaffordable, ", ")}))
+	}
+	game.settings.Privmsg(event.Server, event.Line.Nick, strings.Join(msgs, " "))
+}
+
+// Bets some of the nick's gold, the house keeps the difference between the odds and even money.
+func (game *Game) GambleCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgGambleCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	if char.Gold <= 0 {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nogold", nil))
+		return
+	}
+	bet := int64(args.Int("gold"))
+	if bet <= 0 || bet > char.Gold {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.badbet", ResponseVars{"Gold": char.Gold}))
+		return
+	}
+	name := SafeNick(game.Server, game.Room, char.Name)
+	if rand.Float64() < *rpggambleodds {
+		char.Gold += bet
+		game.settings.Privmsg(event.Server, string(game.Room), game.Response("", "rpg.gamblewon", ResponseVars{"Nick": name, "Bet": bet, "Gold": char.Gold}))
+	} else {
+		char.Gold -= bet
+		game.settings.Privmsg(event.Server, string(game.Room), game.Response("", "rpg.gamblelost", ResponseVars{"Nick": name, "Bet": bet, "Gold": char.Gold}))
+	}
+}
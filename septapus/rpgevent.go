@@ -0,0 +1,181 @@
+package septapus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var rpgEventCommand = NewCommand("!rpgevent list", "!rpgevent cancel <id:int>", "!rpgevent <multiplier> <start> <end> [name...]").WithHelp("Admins only, schedules an xp event in the room, eg: !rpgevent 2 now 48h Double XP weekend. Start is now or a time like 2006-01-02T15:04 in the room's timezone, end is a time or how long after the start.")
+
+// How the times of an event are given to !rpgevent, in the room's timezone.
+const rpgEventTimeLayout = "2006-01-02T15:04"
+
+// A window in which kills in a room give more xp, persisted with the game.
+type XPEvent struct {
+	ID         int
+	Name       string
+	Multiplier float64
+	Start      time.Time
+	End        time.Time
+	// Set once the room has been told the event started, and ended.
+	Started bool
+	Ended   bool
+}
+
+type XPEvents []*XPEvent
+
+func (event *XPEvent) Active(now time.Time) bool {
+	return !now.Before(event.Start) && now.Before(event.End)
+}
+
+// Returns the multiplier, eg: 2x.
+func (event *XPEvent) MultiplierString() string {
+	return strconv.FormatFloat(event.Multiplier, 'f', -1, 64) + "x"
+}
+
+// Returns the event running at a time, the one with the highest multiplier if several overlap, or nil if there isn't one.
+func (game *Game) ActiveEventAt(now time.Time) *XPEvent {
+	var active *XPEvent
+	for _, event := range game.Events {
+		if event.Active(now) && (active == nil || event.Multiplier > active.Multiplier) {
+			active = event
+		}
+	}
+	return active
+}
+
+// Returns the event running now, shown as a banner on the game page.
+func (game *Game) ActiveEvent() *XPEvent {
+	return game.ActiveEventAt(time.Now())
+}
+
+// Returns the xp gained from a kill at a time, multiplied by the event running then. Events don't stack.
+func (game *Game) EventXP(xp int64, now time.Time) int64 {
+	if event := game.ActiveEventAt(now); event != nil {
+		return int64(float64(xp)*event.Multiplier + 0.5)
+	}
+	return xp
+}
+
+// Returns the announcements of events that have started or ended since the last call, and forgets ended events.
+// The game must be locked.
+func (game *Game) eventAnnouncements(now time.Time) []string {
+	announcements := make([]string, 0)
+	events := make(XPEvents, 0, len(game.Events))
+	for _, event := range game.Events {
+		vars := ResponseVars{"Name": event.Name, "Multiplier": event.MultiplierString(), "Room": game.Room, "End": DurationString(event.End.Sub(now))}
+		if !event.Started && !now.Before(event.Start) && now.Before(event.End) {
+			event.Started = true
+			announcements = append(announcements, Response(game.Server, game.Room, "", "rpg.eventstart", vars))
+		}
+		if !now.Before(event.End) {
+			if event.Started {
+				announcements = append(announcements, Response(game.Server, game.Room, "", "rpg.eventend", vars))
+			}
+			continue
+		}
+		events = append(events, event)
+	}
+	game.Events = events
+	return announcements
+}
+
+// Announces the events that have started or ended in the room.
+func (game *Game) AnnounceEvents(server *Server, now time.Time) {
+	game.Lock()
+	announcements := game.eventAnnouncements(now)
+	game.Unlock()
+
+	for _, announcement := range announcements {
+		game.settings.Send(server, RPGAnnounceStyle(game.Server, game.Room), string(game.Room), announcement)
+	}
+}
+
+// Parses the start of an event, now or a time in the room's timezone.
+func parseEventStart(value string, now time.Time, location *time.Location) (time.Time, error) {
+	if strings.EqualFold(value, "now") {
+		return now, nil
+	}
+	return time.ParseInLocation(rpgEventTimeLayout, value, location)
+}
+
+// Parses the end of an event, a time in the room's timezone or a duration after the start.
+func parseEventEnd(value string, start time.Time, location *time.Location) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return start.Add(duration), nil
+	}
+	return time.ParseInLocation(rpgEventTimeLayout, value, location)
+}
+
+// Schedules, lists and cancels the room's xp events.
+func (game *Game) EventCommand(event *Event) {
+	args, err := rpgEventCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	if !IsAdmin(event) {
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Only admins can schedule xp events.")
+		return
+	}
+
+	game.Lock()
+	defer game.Unlock()
+
+	format := game.TimeFormat()
+	describe := func(xpEvent *XPEvent) string {
+		return fmt.Sprintf("#%d %v %v xp, %v %v to %v %v", xpEvent.ID, xpEvent.Name, xpEvent.MultiplierString(), format.Date(xpEvent.Start), format.Time(xpEvent.Start), format.Date(xpEvent.End), format.Time(xpEvent.End))
+	}
+	switch {
+	case args.Pattern == "!rpgevent list":
+		if len(game.Events) == 0 {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "No xp events are scheduled in "+string(game.Room))
+			return
+		}
+		lines := make([]string, len(game.Events))
+		for i, xpEvent := range game.Events {
+			lines[i] = describe(xpEvent)
+		}
+		PrivmsgLines(event.Server, event.Line.Nick, lines)
+	case strings.HasPrefix(args.Pattern, "!rpgevent cancel"):
+		for i, xpEvent := range game.Events {
+			if xpEvent.ID == args.Int("id") {
+				game.Events = append(game.Events[:i], game.Events[i+1:]...)
+				game.settings.Privmsg(event.Server, event.Line.Nick, "Cancelled "+describe(xpEvent))
+				return
+			}
+		}
+		game.settings.Privmsg(event.Server, event.Line.Nick, "No xp event with that id, see !rpgevent list.")
+	default:
+		multiplier, err := strconv.ParseFloat(strings.TrimSuffix(args.String("multiplier"), "x"), 64)
+		if err != nil || multiplier <= 0 {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "The multiplier must be a positive number, eg: 2 or 1.5.")
+			return
+		}
+		start, err := parseEventStart(args.String("start"), event.Time, format.Location)
+		if err != nil {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "Bad start, use now or a time like "+rpgEventTimeLayout+".")
+			return
+		}
+		end, err := parseEventEnd(args.String("end"), start, format.Location)
+		if err != nil || !end.After(start) || !end.After(event.Time) {
+			game.settings.Privmsg(event.Server, event.Line.Nick, "Bad end, use a duration like 48h or a time like "+rpgEventTimeLayout+" after the start.")
+			return
+		}
+		name := args.String("name")
+		if name == "" {
+			name = "XP event"
+		}
+		id := 1
+		for _, xpEvent := range game.Events {
+			if xpEvent.ID >= id {
+				id = xpEvent.ID + 1
+			}
+		}
+		xpEvent := &XPEvent{ID: id, Name: name, Multiplier: multiplier, Start: start, End: end}
+		game.Events = append(game.Events, xpEvent)
+		game.settings.Privmsg(event.Server, event.Line.Nick, "Scheduled "+describe(xpEvent))
+	}
+}
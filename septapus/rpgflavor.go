@@ -0,0 +1,102 @@
+package septapus
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var rpgflavor = rpgOptions.String("flavor", "", "Comma separated list of rooms that get monster taunts and low health warnings, with the minimum time between taunts, eg: synirc/#septapus=10m,*/*=off")
+
+// Monsters below this percentage of their health are announced as near death.
+const nearDeathPercentage = 10
+
+var (
+	flavorIntervals     RoomValues
+	flavorIntervalsOnce sync.Once
+)
+
+var taunts = []string{
+	"%v licks its wounds and laughs at your feeble attempts.",
+	"%v roars, its wounds knitting back together.",
+	"%v yawns. Is that all you've got?",
+	"%v sniffs the air, sensing weakness.",
+	"%v catches its breath and glares at the raid.",
+}
+
+var nearDeathWarnings = []string{
+	"%v is staggering, one last push!",
+	"%v is bleeding badly, it won't last much longer!",
+	"%v looks ready to flee, finish it!",
+}
+
+// Flavor state for a game, not persisted.
+type flavor struct {
+	lastTaunt time.Time
+	warned    *Monster
+}
+
+// Returns the minimum time between taunts in a room, and false if flavor messages are disabled.
+func FlavorInterval(server ServerName, room RoomName) (time.Duration, bool) {
+	flavorIntervalsOnce.Do(func() {
+		flavorIntervals = ParseRoomValues(*rpgflavor)
+	})
+	value, ok := flavorIntervals.Get(server, room)
+	if !ok || value == "off" {
+		return 0, false
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return interval, true
+}
+
+// Returns the monster's name with its prefix, capitalised to start a sentence, eg: A Tiny Goblin.
+func (game *Game) monsterName() string {
+	name := game.Monster.Name
+	if game.Monster.Prefix != "" {
+		name = game.Monster.Prefix + " " + name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Returns a taunt from the monster as it heals, or an empty string if the room should not be taunted yet.
+func (game *Game) Taunt() string {
+	game.Lock()
+	defer game.Unlock()
+
+	interval, ok := FlavorInterval(game.Server, game.Room)
+	if !ok || len(game.Monster.Characters) == 0 || game.Monster.Health >= game.Monster.MaxHealth {
+		return ""
+	}
+	if game.flavor == nil {
+		game.flavor = &flavor{}
+	}
+	if time.Since(game.flavor.lastTaunt) < interval {
+		return ""
+	}
+	game.flavor.lastTaunt = time.Now()
+	return fmt.Sprintf(taunts[rand.Intn(len(taunts))], game.monsterName())
+}
+
+// Returns a warning the first time the current monster drops below nearDeathPercentage, otherwise an empty string.
+func (game *Game) NearDeathWarning() string {
+	game.Lock()
+	defer game.Unlock()
+
+	if _, ok := FlavorInterval(game.Server, game.Room); !ok {
+		return ""
+	}
+	if game.flavor == nil {
+		game.flavor = &flavor{}
+	}
+	monster := game.Monster
+	if game.flavor.warned == monster || monster.Health*100 >= monster.MaxHealth*nearDeathPercentage {
+		return ""
+	}
+	game.flavor.warned = monster
+	return fmt.Sprintf(nearDeathWarnings[rand.Intn(len(nearDeathWarnings))], game.monsterName())
+}
@@ -0,0 +1,208 @@
+package septapus
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var rpgguildxp = rpgOptions.Float64("guildxp", 0.05, "Extra xp a character gains from a kill for each guildmate in the same raid, as a fraction of its xp")
+var rpgguildmaxxp = rpgOptions.Float64("guildmaxxp", 0.25, "Most extra xp a character gains from guildmates in a raid, as a fraction of its xp")
+var rpgguildsize = rpgOptions.Int("guildsize", 20, "Most characters in a guild")
+var rpgguildnamelength = rpgOptions.Int("guildnamelength", 24, "Most characters in a guild's name")
+
+var rpgGuildCommand = NewCommand("!rpgguild create <name...>", "!rpgguild join <name...>", "!rpgguild leave", "!rpgguild info [name...]").WithHelp("Creates, joins or leaves a guild in the room, or shows a guild's members. Guildmates in the same raid gain extra xp.")
+
+// Guild names are letters, numbers, spaces and a little punctuation, so they can't pass for irc formatting or look like
+// another guild's name.
+var guildNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 '_-]*$`)
+
+// A guild of characters in a room, formed with !rpgguild. Characters keep the key of their guild in Character.Guild.
+type Guild struct {
+	Name    string
+	Founder string
+	Created time.Time
+}
+
+// A guild's place on the leaderboard.
+type GuildStanding struct {
+	Guild   *Guild
+	Members Characters
+	Levels  int64
+	XP      int64
+}
+
+// Returns the character's guild, or nil if it isn't in one.
+func (game *Game) CharacterGuild(character *Character) *Guild {
+	if character.Guild == "" {
+		return nil
+	}
+	return game.Guilds[character.Guild]
+}
+
+// Returns the members of a guild, highest level first.
+func (game *Game) GuildMembers(guild *Guild) Characters {
+	key := NameKey(guild.Name)
+	members := make(Characters, 0)
+	for _, character := range game.Characters {
+		if character.Guild == key {
+			members = append(members, character)
+		}
+	}
+	sort.Sort(members)
+	return members
+}
+
+// Returns the guilds with members, the highest total level first.
+func (game *Game) GuildLeaderboard() []*GuildStanding {
+	standings := make([]*GuildStanding, 0, len(game.Guilds))
+	for _, guild := range game.Guilds {
+		standing := &GuildStanding{Guild: guild, Members: game.GuildMembers(guild)}
+		if len(standing.Members) == 0 {
+			continue
+		}
+		for _, member := range standing.Members {
+			standing.Levels += member.Level
+			standing.XP += member.XP
+		}
+		standings = append(standings, standing)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Levels == standings[j].Levels {
+			return standings[i].XP > standings[j].XP
+		}
+		return standings[i].Levels > standings[j].Levels
+	})
+	return standings
+}
+
+// Returns the names of the guild's members for the leaderboard.
+func (standing *GuildStanding) MemberList() string {
+	names := make([]string, len(standing.Members))
+	for i, member := range standing.Members {
+		names[i] = member.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns the xp the character gains from a kill, with the bonus for each guildmate in the raid.
+func (game *Game) GuildXP(character *Character, monster *Monster, xp int64) int64 {
+	if character.Guild == "" {
+		return xp
+	}
+	key := NameKey(character.Name)
+	guildmates := 0
+	for n, _ := range monster.Characters {
+		if n != key && game.GetCharacter(n, true).Guild == character.Guild {
+			guildmates++
+		}
+	}
+	bonus := float64(guildmates) * *rpgguildxp
+	if bonus > *rpgguildmaxxp {
+		bonus = *rpgguildmaxxp
+	}
+	return xp + int64(float64(xp)*bonus+0.5)
+}
+
+// Removes the character from its guild, a guild is disbanded when its last member leaves.
+func (game *Game) leaveGuild(character *Character) {
+	guild := game.CharacterGuild(character)
+	character.Guild = ""
+	if guild != nil && len(game.GuildMembers(guild)) == 0 {
+		delete(game.Guilds, NameKey(guild.Name))
+	}
+}
+
+// Returns a reason a guild can't be named name, in nick's language, or an empty string if it can.
+func (game *Game) guildNameProblem(nick, name string) string {
+	switch {
+	case utf8.RuneCountInString(name) > *rpgguildnamelength:
+		return game.Response(nick, "rpg.guildnamelength", ResponseVars{"Max": *rpgguildnamelength})
+	case !guildNamePattern.MatchString(name):
+		return game.Response(nick, "rpg.guildnamechars", nil)
+	}
+	return ""
+}
+
+// Creates, joins, leaves and shows guilds for the nick's character.
+func (game *Game) GuildCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgGuildCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	name := strings.Join(strings.Fields(args.String("name")), " ")
+	if args.Pattern == "!rpgguild info [name...]" {
+		var guild *Guild
+		if name != "" {
+			guild = game.Guilds[NameKey(name)]
+		} else if char := game.playerCharacter(event.Server, event.Line.Nick); char != nil {
+			guild = game.CharacterGuild(char)
+		}
+		if guild == nil {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.noguild", nil))
+			return
+		}
+		standing := &GuildStanding{Guild: guild, Members: game.GuildMembers(guild)}
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildinfo", ResponseVars{"Guild": guild.Name, "Founder": guild.Founder, "Founded": guild.Created.Format("2006-01-02"), "Count": len(standing.Members), "Members": standing.MemberList()}))
+		return
+	}
+
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	current := game.CharacterGuild(char)
+
+	switch args.Pattern {
+	case "!rpgguild leave":
+		if current == nil {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.notinguild", nil))
+			return
+		}
+		game.leaveGuild(char)
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildleft", ResponseVars{"Name": char.Name, "Guild": current.Name}))
+	case "!rpgguild create <name...>":
+		if problem := game.guildNameProblem(event.Line.Nick, name); problem != "" {
+			game.settings.Privmsg(event.Server, event.Line.Nick, problem)
+			return
+		}
+		if game.Guilds[NameKey(name)] != nil {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildexists", ResponseVars{"Guild": name}))
+			return
+		}
+		if current != nil {
+			game.leaveGuild(char)
+		}
+		if game.Guilds == nil {
+			game.Guilds = make(map[string]*Guild)
+		}
+		game.Guilds[NameKey(name)] = &Guild{name, char.Name, event.Time}
+		char.Guild = NameKey(name)
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildfounded", ResponseVars{"Name": char.Name, "Guild": name}))
+	default:
+		guild := game.Guilds[NameKey(name)]
+		switch {
+		case guild == nil:
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildmissing", ResponseVars{"Guild": name}))
+			return
+		case guild == current:
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.inguild", ResponseVars{"Guild": guild.Name}))
+			return
+		case len(game.GuildMembers(guild)) >= *rpgguildsize:
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildfull", ResponseVars{"Guild": guild.Name, "Max": *rpgguildsize}))
+			return
+		}
+		if current != nil {
+			game.leaveGuild(char)
+		}
+		char.Guild = NameKey(guild.Name)
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.guildjoined", ResponseVars{"Name": char.Name, "Guild": guild.Name}))
+	}
+}
@@ -0,0 +1,230 @@
+package septapus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var rpgkillsurl = rpgOptions.String("killsurl", "http://septapus.com/rpg/kills.html", "Public url of the recent kills page, used to link from the kills feed")
+
+const maxKills = 100
+
+// The kills are kept with the games, under a key no room has.
+const killsKey = "kills"
+
+// Returns the key this process saves its kills under, each shard has its own so they don't overwrite each other's.
+func killsStoreKey() string {
+	if Sharded() {
+		return killsKey + "-" + *shardid
+	}
+	return killsKey
+}
+
+// Returns true if key holds kills, of this process or a shard.
+func isKillsKey(key string) bool {
+	return key == killsKey || strings.HasPrefix(key, killsKey+"-")
+}
+
+// A slain monster, from any game.
+type Kill struct {
+	Server   ServerName
+	Room     RoomName
+	Monster  string
+	Slayer   string
+	RaidSize int
+	Died     time.Time
+}
+
+// The most recent kills across every game, newest first.
+type KillFeed struct {
+	sync.RWMutex
+	Kills []*Kill
+}
+
+func (feed *KillFeed) Add(game *Game, monster *Monster) {
+	game.RLock()
+	kill := &Kill{
+		Server:   game.Server,
+		Room:     game.Room,
+		Monster:  monster.Name,
+		Slayer:   game.GetCharacter(monster.Slayed, true).Name,
+		RaidSize: len(monster.Characters),
+		Died:     monster.Died,
+	}
+	game.RUnlock()
+
+	feed.Lock()
+	defer feed.Unlock()
+
+	feed.Kills = append([]*Kill{kill}, feed.Kills...)
+	if len(feed.Kills) > maxKills {
+		feed.Kills = feed.Kills[:maxKills]
+	}
+}
+
+func (feed *KillFeed) Load() {
+	feed.Lock()
+	defer feed.Unlock()
+
+	if err := SharedStore().Get("rpg", killsStoreKey(), feed); err == nil {
+		logging.Info("Loaded kills")
+	} else if err != ErrNotFound {
+		ReportError("rpg", "Error loading kills", err)
+	}
+}
+
+func (feed *KillFeed) Save() {
+	feed.RLock()
+	defer feed.RUnlock()
+
+	if err := SharedStore().Put("rpg", killsStoreKey(), feed); err != nil {
+		ReportError("rpg", "Error saving kills", err)
+	} else {
+		logging.Info("Saved kills")
+	}
+}
+
+// Saves the feed and uploads the page and atom feed. When the servers are split between shards only the lead shard
+// uploads, with the kills every shard has saved.
+func (feed *KillFeed) Publish(settings *PluginSettings) {
+	feed.Save()
+	if !LeadShard() || settings.SkipUpload("kills.html, kills.atom") {
+		return
+	}
+
+	published := feed
+	if Sharded() {
+		published = loadShardKills()
+	}
+	published.RLock()
+	defer published.RUnlock()
+
+	uploadRPGFile("kills.html", published.WriteHTML)
+	uploadRPGFile("kills.atom", published.WriteAtom)
+}
+
+// Returns the most recent kills saved by every shard, newest first.
+func loadShardKills() *KillFeed {
+	merged := &KillFeed{}
+	keys, err := SharedStore().List("rpg")
+	if err != nil {
+		ReportError("rpg", "Error listing kills", err)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, killsKey+"-") {
+			continue
+		}
+		shard := &KillFeed{}
+		if err := SharedStore().Get("rpg", key, shard); err != nil {
+			ReportError("rpg", "Error loading kills", key, err)
+			continue
+		}
+		merged.Kills = append(merged.Kills, shard.Kills...)
+	}
+	sort.SliceStable(merged.Kills, func(i, j int) bool {
+		return merged.Kills[i].Died.After(merged.Kills[j].Died)
+	})
+	if len(merged.Kills) > maxKills {
+		merged.Kills = merged.Kills[:maxKills]
+	}
+	return merged
+}
+
+func (feed *KillFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	feed.RLock()
+	defer feed.RUnlock()
+
+	var err error
+	if strings.HasSuffix(r.URL.Path, ".atom") {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		err = feed.WriteAtom(w)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = feed.WriteHTML(w)
+	}
+	if err != nil {
+		ReportError("rpg", "Error serving kills:", err)
+	}
+}
+
+// Writes the kills page, the caller must hold the lock.
+func (feed *KillFeed) WriteHTML(w io.Writer) error {
+	return killsTemplate.Execute(w, &WebPage{
+		Title: "Septapus RPG: Recent Kills",
+		Feeds: []WebLink{{Name: "Recent Kills", URL: "kills.atom"}},
+		Data:  feed,
+	})
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string    `xml:"title"`
+	ID      string    `xml:"id"`
+	Updated time.Time `xml:"updated"`
+	Summary string    `xml:"summary"`
+	Link    atomLink  `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated time.Time    `xml:"updated"`
+	Author  string       `xml:"author>name"`
+	Link    atomLink     `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+// Writes the kills as an atom feed, the caller must hold the lock.
+func (feed *KillFeed) WriteAtom(w io.Writer) error {
+	atom := &atomFeed{
+		Title:  "Septapus RPG: Recent Kills",
+		ID:     *rpgkillsurl,
+		Author: "Septapus",
+		Link:   atomLink{Href: *rpgkillsurl},
+	}
+	if len(feed.Kills) > 0 {
+		atom.Updated = feed.Kills[0].Died
+	}
+	for _, kill := range feed.Kills {
+		atom.Entries = append(atom.Entries, &atomEntry{
+			Title:   fmt.Sprintf("%v slayed %v in %v/%v", kill.Slayer, kill.Monster, kill.Server, kill.Room),
+			ID:      fmt.Sprintf("tag:septapus,%v:%v/%v/%d", kill.Died.Format("2006-01-02"), kill.Server, kill.Room, kill.Died.UnixNano()),
+			Updated: kill.Died,
+			Summary: fmt.Sprintf("%v slayed %v with a raid of %d in %v/%v.", kill.Slayer, kill.Monster, kill.RaidSize, kill.Server, kill.Room),
+			Link:    atomLink{Href: *rpgkillsurl},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return enc.Encode(atom)
+}
+
+var killsTemplate = NewWebTemplate(killsTemplateSource)
+
+const killsTemplateSource = `{{define "content"}}
+		<p>
+		<h2>Recent Kills:</h2>
+		<table class="previousfights">
+			<tr><th>Name</th><th>Channel</th><th>Slayed By</th><th>Raid</th><th>Died</th></tr>
+			{{range .Kills}}
+			<tr><td class="name">{{.Monster}}</td><td class="room">{{.Server}}/{{.Room}}</td><td class="slayed">{{.Slayer}}</td><td class="raid">{{.RaidSize}}</td><td class="date">{{.Died.Format "2006-01-02 15:04"}}</td></tr>
+			{{end}}
+		</table>
+{{end}}
+`
@@ -0,0 +1,210 @@
+package septapus
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var rpglive = rpgOptions.String("live", "", "Comma separated list of rooms with a live view of the current fight served from pasteaddr, eg: synirc/#septapus=on,*/*=off")
+
+// Updates queued for a slow viewer before it is dropped.
+const liveBuffer = 16
+
+var (
+	liveRooms     RoomValues
+	liveRoomsOnce sync.Once
+)
+
+// Returns true if a room has a live view, rooms with a live view are only uploaded with the regular save.
+func LiveEnabled(server ServerName, room RoomName) bool {
+	liveRoomsOnce.Do(func() {
+		liveRooms = ParseRoomValues(*rpglive)
+	})
+	value, ok := liveRooms.Get(server, room)
+	return ok && value == "on"
+}
+
+// An update sent to live viewers, Event is one of attack, kill, heal, counter or state.
+type LiveUpdate struct {
+	Event     string
+	Text      string
+	Monster   string
+	Health    int64
+	MaxHealth int64
+	Raid      int
+	Time      time.Time
+}
+
+// The viewers of a game's live view, not persisted.
+type liveView struct {
+	sync.Mutex
+	viewers map[chan []byte]bool
+}
+
+// Sends an update describing the current monster to every viewer, the game lock must be held.
+// Viewers that can't keep up are dropped rather than blocking the game.
+func (game *Game) liveUpdate(event, text string) {
+	if game.live == nil {
+		return
+	}
+	game.live.Lock()
+	defer game.live.Unlock()
+
+	if len(game.live.viewers) == 0 {
+		return
+	}
+	update, err := json.Marshal(game.liveState(event, text))
+	if err != nil {
+		ReportError("rpg", "Error encoding live update:", err)
+		return
+	}
+	for viewer, _ := range game.live.viewers {
+		select {
+		case viewer <- update:
+		default:
+			delete(game.live.viewers, viewer)
+			close(viewer)
+		}
+	}
+}
+
+func (game *Game) liveState(event, text string) *LiveUpdate {
+	return &LiveUpdate{
+		Event:     event,
+		Text:      text,
+		Monster:   game.monsterName(),
+		Health:    game.Monster.Health,
+		MaxHealth: game.Monster.MaxHealth,
+		Raid:      len(game.Monster.Characters),
+		Time:      time.Now(),
+	}
+}
+
+// Streams updates to a websocket until it closes or falls behind.
+func (game *Game) serveLive(ws *WebSocket) {
+	viewer := make(chan []byte, liveBuffer)
+
+	game.Lock()
+	if game.live == nil {
+		game.live = &liveView{viewers: make(map[chan []byte]bool)}
+	}
+	state, err := json.Marshal(game.liveState("state", ""))
+	game.live.Lock()
+	game.live.viewers[viewer] = true
+	game.live.Unlock()
+	game.Unlock()
+
+	defer func() {
+		game.live.Lock()
+		if game.live.viewers[viewer] {
+			delete(game.live.viewers, viewer)
+			close(viewer)
+		}
+		game.live.Unlock()
+		ws.Close()
+	}()
+
+	if err != nil || ws.WriteText(string(state)) != nil {
+		return
+	}
+	for {
+		select {
+		case update, ok := <-viewer:
+			if !ok || ws.WriteText(string(update)) != nil {
+				return
+			}
+		case <-ws.Closed():
+			return
+		}
+	}
+}
+
+// Serves the live page at /rpg/live/<server>/<room>, and its websocket at /rpg/live/<server>/<room>/ws.
+// The room is given without its #.
+func (rpg *RPGPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rpg/live/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "ws") {
+		http.NotFound(w, r)
+		return
+	}
+	server, room := ServerName(parts[0]), RoomName("#"+parts[1])
+
+	game := rpg.runningGame(server, room)
+
+	if game == nil || !LiveEnabled(server, room) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 3 {
+		ws, err := UpgradeWebSocket(w, r)
+		if err != nil {
+			logging.Info("Error upgrading live view", server, room, err)
+			return
+		}
+		game.serveLive(ws)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := liveTemplate.Execute(w, game); err != nil {
+		ReportError("rpg", "Error executing live template:", err)
+	}
+}
+
+var liveTemplate = template.Must(template.New("root").Parse(liveTemplateSource))
+
+const liveTemplateSource = `<!DOCTYPE html>
+<html>
+	<head>
+		<title>Septapus RPG: {{.Server}}/{{.Room}} live</title>
+		<meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+		<style type="text/css">
+		body { background: transparent; color: #fff; font-family: sans-serif; text-shadow: 1px 1px 2px #000; }
+		.bar { width: 100%; height: 24px; background: #400; border: 1px solid #000; }
+		.fill { height: 100%; background: #c00; transition: width 0.3s; }
+		.ticker { list-style: none; padding: 0; }
+		.kill { color: #fc0; }
+		.counter { color: #f80; }
+		</style>
+	</head>
+	<body>
+		<h2 id="monster"></h2>
+		<div class="bar"><div id="fill" class="fill"></div></div>
+		<p id="health"></p>
+		<ul id="ticker" class="ticker"></ul>
+		<script type="text/javascript">
+			function connect() {
+				var url = (location.protocol == "https:" ? "wss://" : "ws://") + location.host + location.pathname.replace(/\/$/, "") + "/ws";
+				var socket = new WebSocket(url);
+				socket.onmessage = function(message) {
+					var update = JSON.parse(message.data);
+					document.getElementById("monster").textContent = update.Monster;
+					document.getElementById("health").textContent = update.Health + "/" + update.MaxHealth + " health, " + update.Raid + " in the raid";
+					document.getElementById("fill").style.width = Math.max(0, 100 * update.Health / update.MaxHealth) + "%";
+					if (update.Text) {
+						var ticker = document.getElementById("ticker");
+						var item = document.createElement("li");
+						item.className = update.Event;
+						item.textContent = update.Text;
+						ticker.insertBefore(item, ticker.firstChild);
+						while (ticker.children.length > 10) {
+							ticker.removeChild(ticker.lastChild);
+						}
+					}
+				};
+				socket.onclose = function() {
+					setTimeout(connect, 5000);
+				};
+			}
+			connect();
+		</script>
+	</body>
+</html>
+`
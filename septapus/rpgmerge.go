@@ -0,0 +1,88 @@
+package septapus
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/fluffle/golog/logging"
+)
+
+// Defeated monsters in the order they died.
+type monstersByDeath Monsters
+
+func (m monstersByDeath) Len() int           { return len(m) }
+func (m monstersByDeath) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m monstersByDeath) Less(i, j int) bool { return m[i].Died.Before(m[j].Died) }
+
+// Returns the keys of games saved under another case of a room's name, from before rooms were case insensitive.
+func gameCaseVariants(server ServerName, room RoomName) []string {
+	keys, err := SharedStore().List("rpg")
+	if err != nil {
+		return nil
+	}
+	key := gameKey(server, room)
+	variants := make([]string, 0)
+	for _, other := range keys {
+		if !strings.HasPrefix(other, string(server)) || strings.HasSuffix(other, mergedSuffix) {
+			continue
+		}
+		otherRoom := RoomName(strings.TrimPrefix(other, string(server)))
+		if !isRoomTarget(string(otherRoom)) || !SameRoom(server, otherRoom, room) {
+			continue
+		}
+		if other != key {
+			variants = append(variants, other)
+		}
+	}
+	return variants
+}
+
+// Merged games are kept under their key with this suffix.
+const mergedSuffix = ".merged"
+
+// Merges the games saved under other cases of the room's name into this one, the game must be locked.
+// The merged games are moved to a .merged key, so they are only merged once and can be checked by hand.
+func (game *Game) mergeCaseVariants(server ServerName, room RoomName) {
+	store := SharedStore()
+	for _, key := range gameCaseVariants(server, room) {
+		other := &Game{}
+		if err := store.Get("rpg", key, other); err != nil {
+			ReportError("rpg", "Error loading game to merge", key, err)
+			continue
+		}
+		if _, err := rpgMigrations.Run(other, &other.Version); err != nil {
+			ReportError("rpg", "Error migrating game to merge", key, err)
+			continue
+		}
+		game.merge(other)
+		if err := store.Put("rpg", key+mergedSuffix, other); err != nil {
+			ReportError("rpg", "Error keeping merged game", key, err)
+			continue
+		}
+		if err := store.Delete("rpg", key); err != nil {
+			ReportError("rpg", "Error removing merged game", key, err)
+			continue
+		}
+		logging.Info("Merged game", key, "into", server, room)
+	}
+}
+
+// Merges another game into this one. A character in both keeps whichever has the higher level, defeated monsters
+// and tournaments from both are kept.
+func (game *Game) merge(other *Game) {
+	if game.Characters == nil {
+		game.Characters = make(map[string]*Character)
+	}
+	for key, character := range other.Characters {
+		existing := game.Characters[key]
+		if existing == nil || character.Level > existing.Level || (character.Level == existing.Level && character.XP > existing.XP) {
+			game.Characters[key] = character
+		}
+	}
+	if game.Monster == nil {
+		game.Monster = other.Monster
+	}
+	game.Defeated = append(game.Defeated, other.Defeated...)
+	sort.Stable(monstersByDeath(game.Defeated))
+	game.Tournaments = append(game.Tournaments, other.Tournaments...)
+}
@@ -0,0 +1,225 @@
+package septapus
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"strings"
+	"sync"
+)
+
+var rpgfightsperpage = rpgOptions.Int("fightsperpage", 10, "Number of previous fights shown on each page of the rpg web page")
+
+var (
+	rpgStyles       string
+	rpgStylesOnce   sync.Once
+	rpgStylesUpload sync.Once
+)
+
+// The stylesheet is the same for every game, so it is generated once and uploaded once per run.
+const rpgStylesFilename = "rpg.css"
+
+// A page of previous fights, pages after the first are uploaded as separate files.
+type fightsPage struct {
+	Game *Game
+	Page int
+}
+
+// Returns the shared rpg stylesheet.
+func RPGStyles() string {
+	rpgStylesOnce.Do(func() {
+		str := ".moreinfo { display: none; }\n"
+		str += CSSRampClasses("health", 200, healthColors, healthRatios, cssColor)
+		str += CSSRampClasses("raid", 100, raidColors, raidRatios, cssColor)
+		str += CSSRampClasses("level", 100, levelColors, levelRatios, cssColor)
+		str += CSSRampClasses("bar", 100, healthColors, healthRatios, func(ratio float64, color template.CSS) string {
+			return string(barColor(ratio, 0.5+ratio/2.0, healthColors, healthRatios))
+		})
+		str += CSSColorClasses("item", itemColors)
+		rpgStyles = str
+	})
+	return rpgStyles
+}
+
+// Returns the url of the stylesheet, versioned by its contents so it can be cached until it changes.
+func (game *Game) StylesURL() string {
+	hash := fnv.New32a()
+	io.WriteString(hash, RPGStyles())
+	return fmt.Sprintf("%v?v=%08x", rpgStylesFilename, hash.Sum32())
+}
+
+func uploadRPGStyles() {
+	rpgStylesUpload.Do(func() {
+		uploadRPGFile(rpgStylesFilename, func(w io.Writer) error {
+			_, err := io.WriteString(w, RPGStyles())
+			return err
+		})
+	})
+}
+
+// Returns the filename of a game's page with a suffix, eg: synirc:septapus.details.html.
+func (game *Game) filename(suffix string) string {
+	return roomPath(game.Server, game.Room) + suffix + ".html"
+}
+
+// Returns how dates are shown on the game's pages, in the room's locale.
+func (game *Game) TimeFormat() *TimeFormat {
+	return GetTimeFormat(game.Server, game.Room, "")
+}
+
+// Returns a relative link to one of the game's files, the ./ stops the : being read as a url scheme.
+func (game *Game) link(suffix string) string {
+	return "./" + game.filename(suffix)
+}
+
+func pageSuffix(page int) string {
+	if page <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(".fights%d", page)
+}
+
+// Returns the number of pages of previous fights.
+func (game *Game) FightPages() int {
+	pages := (len(game.DefeatedReverse()) + *rpgfightsperpage - 1) / *rpgfightsperpage
+	if pages < 1 {
+		pages = 1
+	}
+	return pages
+}
+
+// Returns the previous fights shown on a page, starting from 1.
+func (game *Game) FightsPage(page int) Monsters {
+	defeated := game.DefeatedReverse()
+	start := (page - 1) * *rpgfightsperpage
+	if start >= len(defeated) {
+		return Monsters{}
+	}
+	end := start + *rpgfightsperpage
+	if end > len(defeated) {
+		end = len(defeated)
+	}
+	return defeated[start:end]
+}
+
+// Returns links to every page of previous fights, or nothing if there is only one page.
+func (game *Game) PageLinks(page int) template.HTML {
+	pages := game.FightPages()
+	if pages <= 1 {
+		return ""
+	}
+	links := make([]string, pages)
+	for i := 1; i <= pages; i++ {
+		if i == page {
+			links[i-1] = fmt.Sprintf("<b>%d</b>", i)
+		} else {
+			links[i-1] = fmt.Sprintf("<a href=\"%v\">%d</a>", template.HTMLEscapeString(game.link(pageSuffix(i))), i)
+		}
+	}
+	return template.HTML("<p class=\"pages\">Pages: " + strings.Join(links, " ") + "</p>")
+}
+
+// Returns the public url of the game's page.
+func (game *Game) PageURL() string {
+	return siteURL("rpg/" + game.filename(""))
+}
+
+// Returns the id of a character's row on the game page, it stays the same for as long as the character keeps its name.
+// Characters that haven't reached level 1 don't have a row.
+func (character *Character) Anchor() string {
+	id := "character-"
+	for _, r := range NameKey(character.Name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			id += string(r)
+		} else {
+			id += fmt.Sprintf("_%x", r)
+		}
+	}
+	return id
+}
+
+// Returns a link to a character's row on the game page, or an empty string if it doesn't have one yet.
+func (game *Game) CharacterURL(character *Character) string {
+	if character == nil || character.Level == 0 {
+		return ""
+	}
+	return game.PageURL() + "#" + character.Anchor()
+}
+
+// Returns the link to the character detail panels, loaded when a character is clicked.
+func (game *Game) DetailsURL() string {
+	return game.link(".details")
+}
+
+var fightsTemplate = NewWebTemplate(fightsTemplateSource)
+
+const fightsTemplateSource = `{{define "content"}}
+		<p>
+		<h2>Previous Fights:</h2>
+		<table class="previousfights">
+			<tr><th>Name</th><th>Health</th><th>Slayed By</th><th>Raid</th></tr>
+			{{range .Game.FightsPage .Page}}
+			<tr><td class="name">{{.Name}}</td><td class="health health{{.HealthPercentage}}">{{.Health}}/{{.MaxHealth}}</td><td class="slayed">{{.SlayedList $.Game}}</td><td class="raid">{{.CharacterList $.Game}}</td></tr>
+			{{end}}
+		</table>
+		{{.Game.PageLinks .Page}}
+{{end}}
+`
+
+var detailsTemplate = template.Must(template.New("root").Parse(detailsTemplateSource))
+
+// Detail panels for each character, ids match the rows on the game page.
+const detailsTemplateSource = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+	<head>
+		<title>Septapus RPG: {{.Server}}/{{.Room}} characters</title>
+		<meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+	</head>
+	<body>
+		{{range $index, $element := .GetSortedCharacters}}
+		{{if $element.Level}}
+		<div id="detail{{$index}}"><h2>{{$element.NameStyle true}}</h2>{{with $element.CharacterClass}}<p class="class">{{.}}</p>{{end}}{{with $.CharacterGuild $element}}<p class="guild">Guild: {{.Name}}</p>{{end}}{{if $element.Bio}}<p class="bio">{{$element.Bio}}</p>{{end}}{{if $element.OldItems}}<p><h3>Item History</h3>{{$element.OldItemsList}}{{end}}<p></div>
+		{{end}}
+		{{end}}
+	</body>
+</html>
+`
+
+// Returns one of the game's pages in the shared layout, data is the dot of its template.
+func (game *Game) webPage(title string, data interface{}) *WebPage {
+	return &WebPage{
+		Title:  fmt.Sprintf("Septapus RPG: %v/%v%v", game.Server, game.Room, title),
+		Styles: []string{game.StylesURL()},
+		Nav:    RoomNav(game.Server, game.Room, "RPG"),
+		Data:   data,
+	}
+}
+
+func (game *Game) Upload() {
+	game.Lock()
+	defer game.Unlock()
+
+	if game.settings.SkipUpload(game.filename("")) {
+		return
+	}
+
+	uploadRPGStyles()
+	game.writePages(uploadRPGFile)
+}
+
+// Renders each of the game's pages, write is given the filename and a render func for each. The caller must hold the lock.
+func (game *Game) writePages(write func(filename string, render func(w io.Writer) error)) {
+	write(game.filename(""), func(w io.Writer) error {
+		return gameTemplate.Execute(w, game.webPage("", game))
+	})
+	write(game.filename(".details"), func(w io.Writer) error {
+		return detailsTemplate.Execute(w, game)
+	})
+	for page := 2; page <= game.FightPages(); page++ {
+		page := page
+		write(game.filename(pageSuffix(page)), func(w io.Writer) error {
+			return fightsTemplate.Execute(w, game.webPage(fmt.Sprintf(" previous fights, page %d", page), &fightsPage{game, page}))
+		})
+	}
+}
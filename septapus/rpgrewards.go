@@ -0,0 +1,79 @@
+package septapus
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// What a character gets for earning an achievement. Rewards are worked out from the achievements a character has earned,
+// so changing a reward applies to everyone who has already earned it.
+type Reward struct {
+	// Shown after the character's name, including any leading punctuation, eg: ", The Dragon Ascendant".
+	Title string
+	// Shown before the character's name, with a level css class from 0 to 100.
+	Icon      string
+	IconLevel int
+	// Css color for the character's name.
+	Color string
+	// Extra fraction of xp gained from kills, eg: 0.05 for 5%.
+	XPBonus float64
+}
+
+func (achievement *Achievement) WithReward(reward *Reward) *Achievement {
+	achievement.Reward = reward
+	return achievement
+}
+
+// Returns a short description of the reward, eg: title "the Dragonslayer", +5% xp.
+func (reward *Reward) String() string {
+	parts := make([]string, 0)
+	if reward.Title != "" {
+		parts = append(parts, fmt.Sprintf("title \"%v\"", strings.TrimLeft(reward.Title, ", ")))
+	}
+	if reward.Icon != "" {
+		parts = append(parts, "icon "+reward.Icon)
+	}
+	if reward.Color != "" {
+		parts = append(parts, "name color "+reward.Color)
+	}
+	if reward.XPBonus != 0 {
+		parts = append(parts, fmt.Sprintf("+%v%% xp", int(reward.XPBonus*100+0.5)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns the reward of the highest earned achievement in each group, in the order the groups were added.
+func (character *Character) Rewards() []*Reward {
+	rewards := make([]*Reward, 0)
+	groups := make(map[AchievementGroup]int)
+	for _, achievement := range achievements {
+		if achievement.Reward == nil || character.Achievements[achievement.ID].IsZero() {
+			continue
+		}
+		if i, ok := groups[achievement.Group]; ok {
+			rewards[i] = achievement.Reward
+		} else {
+			groups[achievement.Group] = len(rewards)
+			rewards = append(rewards, achievement.Reward)
+		}
+	}
+	return rewards
+}
+
+// Returns the extra fraction of xp the character gains from their rewards.
+func (character *Character) XPBonus() float64 {
+	bonus := 0.0
+	for _, reward := range character.Rewards() {
+		bonus += reward.XPBonus
+	}
+	return bonus
+}
+
+// Returns the reward for the achievements page, or nothing if the achievement has no reward.
+func (achievement *Achievement) RewardHTML() string {
+	if achievement.Reward == nil {
+		return ""
+	}
+	return fmt.Sprintf("<br><span class=\"reward\">Reward: %v</span>", template.HTMLEscapeString(achievement.Reward.String()))
+}
@@ -0,0 +1,46 @@
+package septapus
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+var rpgsolocap = rpgOptions.String("solocap", "", "Comma separated list of rooms and the xp a character gains a day from monsters it fought alone before that xp diminishes, eg: synirc/#septapus=500")
+
+var (
+	soloCapRooms     RoomValues
+	soloCapRoomsOnce sync.Once
+)
+
+// Returns the xp a character can gain a day in a room from monsters it fought alone at the full rate, 0 for no cap.
+func soloCap(server ServerName, room RoomName) int64 {
+	soloCapRoomsOnce.Do(func() {
+		soloCapRooms = ParseRoomValues(*rpgsolocap)
+	})
+	value, _ := soloCapRooms.Get(server, room)
+	xp, _ := strconv.ParseInt(value, 10, 64)
+	return xp
+}
+
+// Returns the xp a character gains for a monster it fought alone on a day. Once it has gained the cap that day, each
+// kill's xp is scaled by the cap over the solo xp it has gained, so grinding alone earns less and less.
+func (character *Character) SoloXP(xp, cap int64, day string) int64 {
+	if character.SoloDay != day {
+		character.SoloDay = day
+		character.SoloToday = 0
+	}
+	if character.SoloToday >= cap {
+		xp = int64(float64(xp) * float64(cap) / float64(character.SoloToday))
+		if xp < 1 {
+			xp = 1
+		}
+	}
+	character.SoloToday += xp
+	return xp
+}
+
+// Returns the day something in the game's room happened on, in the room's timezone, for the solo and karma xp caps.
+func (game *Game) soloDay(when time.Time) string {
+	return when.In(GetTimeFormat(game.Server, game.Room, "").Location).Format("2006-01-02")
+}
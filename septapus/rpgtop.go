@@ -0,0 +1,47 @@
+package septapus
+
+var rpgtopsize = rpgOptions.Int("topsize", 25, "Most characters or guilds listed by !rpgtop, long lists are pasted")
+
+var rpgTopCommand = NewCommand("!rpgtop", "!rpgtop guilds").WithHelp("Shows the room's leaderboard of characters, or of guilds.")
+
+// Returns the room's leaderboard, of characters or guilds, one place to a line.
+func (game *Game) Top(guilds bool) []string {
+	lines := make([]string, 0)
+	if guilds {
+		for i, standing := range game.GuildLeaderboard() {
+			if i == *rpgtopsize {
+				break
+			}
+			lines = append(lines, game.Response("", "rpg.topguild", ResponseVars{"Rank": i + 1, "Guild": standing.Guild.Name, "Levels": standing.Levels, "Count": len(standing.Members)}))
+		}
+		if len(lines) == 0 {
+			return []string{game.Response("", "rpg.topnoguilds", nil)}
+		}
+		return append([]string{game.Response("", "rpg.topguilds", nil)}, lines...)
+	}
+	for _, character := range game.GetSortedCharacters() {
+		if len(lines) == *rpgtopsize || character.Level == 0 {
+			break
+		}
+		lines = append(lines, game.Response("", "rpg.topcharacter", ResponseVars{"Rank": len(lines) + 1, "Name": SafeNick(game.Server, game.Room, character.Name), "Level": character.Level, "XP": character.XP}))
+	}
+	if len(lines) == 0 {
+		return []string{game.Response("", "rpg.topnocharacters", nil)}
+	}
+	return append([]string{game.Response("", "rpg.topcharacters", nil)}, lines...)
+}
+
+// Replies with the room's leaderboard, pasted when it is longer than pastelines.
+func (game *Game) TopCommand(event *Event) {
+	args, err := rpgTopCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+
+	game.Lock()
+	lines := game.Top(args.Pattern == "!rpgtop guilds")
+	game.Unlock()
+
+	game.settings.ReplyLines(event, lines)
+}
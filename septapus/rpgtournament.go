@@ -0,0 +1,210 @@
+package septapus
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+var rpgtournamentsignup = rpgOptions.Duration("tournamentsignup", 2*time.Minute, "How long characters have to join a tournament before it starts")
+var rpgtournamentround = rpgOptions.Duration("tournamentround", 30*time.Second, "Time between the rounds of a tournament")
+
+var rpgTournamentCommand = NewCommand("!rpgtournament", "!rpgtournament join").WithHelp("Starts a tournament between the room's characters, or joins the one starting.")
+
+// Matches are best of this many fights.
+const tournamentBestOf = 5
+
+// A finished tournament, persisted with the game.
+type TournamentResult struct {
+	Winner   string
+	Entrants []string
+	Finished time.Time
+}
+
+type TournamentResults []*TournamentResult
+
+// A tournament in progress, keys are character name keys.
+type tournament struct {
+	signup    bool
+	entrants  []string
+	remaining []string
+	round     int
+}
+
+func (result *TournamentResult) assignStats(character *Character) {
+	if NameKey(character.Name) == result.Winner {
+		character.stats[STAT_TOURNAMENTS_WON]++
+	}
+}
+
+func (game *Game) characterName(key string) string {
+	if character := game.GetCharacter(key, false); character != nil {
+		return character.Name
+	}
+	return key
+}
+
+func (result *TournamentResult) WinnerName(game *Game) string {
+	return game.characterName(result.Winner)
+}
+
+func (result *TournamentResult) EntrantList(game *Game) string {
+	names := make([]string, len(result.Entrants))
+	for i, key := range result.Entrants {
+		names[i] = game.characterName(key)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (game *Game) TournamentsReverse() TournamentResults {
+	num := len(game.Tournaments)
+	if num > 20 {
+		num = 20
+	}
+	results := make(TournamentResults, num)
+	for i := 0; i < num; i++ {
+		results[i] = game.Tournaments[len(game.Tournaments)-1-i]
+	}
+	return results
+}
+
+func (t *tournament) join(key string) bool {
+	for _, entrant := range t.entrants {
+		if entrant == key {
+			return false
+		}
+	}
+	t.entrants = append(t.entrants, key)
+	return true
+}
+
+// Handles !rpgtournament, returns true if a tournament was opened for signups.
+func (game *Game) TournamentCommand(event *Event) bool {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgTournamentCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return false
+	}
+	room := string(game.Room)
+	character := game.playerCharacter(event.Server, event.Line.Nick)
+	if args.Pattern == "!rpgtournament join" {
+		switch {
+		case game.tournament == nil:
+			game.settings.Privmsg(event.Server, event.Line.Nick, "There is no tournament in "+room+", start one with !rpgtournament")
+		case !game.tournament.signup:
+			game.settings.Privmsg(event.Server, event.Line.Nick, "The tournament in "+room+" has already started.")
+		case character == nil:
+			game.settings.Privmsg(event.Server, event.Line.Nick, "You need a character in "+room+" to join the tournament.")
+		case game.tournament.join(NameKey(character.Name)):
+			game.settings.Privmsg(event.Server, room, fmt.Sprintf("%v has joined the tournament. (%v entrants)", SafeNick(game.Server, game.Room, character.Name), len(game.tournament.entrants)))
+		}
+		return false
+	}
+	if game.tournament != nil {
+		if game.tournament.signup {
+			game.settings.Privmsg(event.Server, event.Line.Nick, fmt.Sprintf("A tournament in %v is taking entrants (%v so far), type !rpgtournament join to enter.", room, len(game.tournament.entrants)))
+		} else {
+			game.settings.Privmsg(event.Server, event.Line.Nick, fmt.Sprintf("A tournament in %v is in round %v with %v characters remaining.", room, game.tournament.round, len(game.tournament.remaining)))
+		}
+		return false
+	}
+	game.tournament = &tournament{signup: true}
+	if character != nil {
+		game.tournament.join(NameKey(character.Name))
+	}
+	game.settings.Privmsg(event.Server, room, fmt.Sprintf("A tournament begins in %v! Type !rpgtournament join to enter.", DurationString(*rpgtournamentsignup)))
+	return true
+}
+
+// Fights a best of tournamentBestOf match, returning the winner and the number of fights each character won.
+func (game *Game) match(a, b *Character) (winner, loser *Character, winnerWins, loserWins int) {
+	aWins, bWins := 0, 0
+	needed := tournamentBestOf/2 + 1
+	// Ties are fought again, but give up eventually and toss a coin.
+	for i := 0; i < tournamentBestOf*10 && aWins < needed && bWins < needed; i++ {
+		aHits, bHits := game.exchange(a, b)
+		switch {
+		case aHits > bHits:
+			aWins++
+		case bHits > aHits:
+			bWins++
+		}
+	}
+	if aWins > bWins || (aWins == bWins && rand.Intn(2) == 0) {
+		return a, b, aWins, bWins
+	}
+	return b, a, bWins, aWins
+}
+
+// Runs the next stage of the tournament, returns true if there are more rounds to come.
+func (game *Game) TournamentRound(server *Server) bool {
+	game.Lock()
+	defer game.Unlock()
+
+	t := game.tournament
+	if t == nil {
+		return false
+	}
+	room := string(game.Room)
+	if t.signup {
+		t.signup = false
+		if len(t.entrants) < 2 {
+			game.settings.Send(server, RPGAnnounceStyle(game.Server, game.Room), room, "Not enough entrants, the tournament has been cancelled.")
+			game.tournament = nil
+			return false
+		}
+		t.remaining = make([]string, len(t.entrants))
+		for i, j := range rand.Perm(len(t.entrants)) {
+			t.remaining[i] = t.entrants[j]
+		}
+		game.settings.Send(server, RPGAnnounceStyle(game.Server, game.Room), room, fmt.Sprintf("The tournament begins with %v entrants!", len(t.entrants)))
+	}
+
+	t.round++
+	next := make([]string, 0, len(t.remaining)/2+1)
+	results := make([]string, 0, len(t.remaining)/2+1)
+	for i := 0; i < len(t.remaining); i += 2 {
+		a := game.GetCharacter(t.remaining[i], false)
+		if i+1 == len(t.remaining) {
+			if a != nil {
+				next = append(next, t.remaining[i])
+				results = append(results, SafeNick(game.Server, game.Room, a.Name)+" has a bye")
+			}
+			continue
+		}
+		b := game.GetCharacter(t.remaining[i+1], false)
+		switch {
+		case a == nil && b == nil:
+		case a == nil:
+			next = append(next, t.remaining[i+1])
+		case b == nil:
+			next = append(next, t.remaining[i])
+		default:
+			winner, loser, winnerWins, loserWins := game.match(a, b)
+			next = append(next, NameKey(winner.Name))
+			results = append(results, fmt.Sprintf("%v beats %v (%v to %v)", SafeNick(game.Server, game.Room, winner.Name), SafeNick(game.Server, game.Room, loser.Name), winnerWins, loserWins))
+		}
+	}
+	t.remaining = next
+	game.settings.Send(server, RPGAnnounceStyle(game.Server, game.Room), room, fmt.Sprintf("Tournament round %v: %v", t.round, strings.Join(results, ", ")))
+
+	if len(t.remaining) > 1 {
+		return true
+	}
+	game.tournament = nil
+	if len(t.remaining) == 0 {
+		return false
+	}
+	result := &TournamentResult{Winner: t.remaining[0], Entrants: t.entrants, Finished: time.Now()}
+	game.Tournaments = append(game.Tournaments, result)
+	if winner := game.GetCharacter(result.Winner, false); winner != nil {
+		result.assignStats(winner)
+		achievements.check(winner.stats, winner.Achievements)
+		game.settings.Send(server, RPGAnnounceStyle(game.Server, game.Room), room, fmt.Sprintf("%v wins the tournament!", SafeNick(game.Server, game.Room, winner.Name)))
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package septapus
+
+import (
+	"strings"
+	"time"
+)
+
+var rpgtradelevels = rpgOptions.Int("tradelevels", 5, "Most levels apart two characters can be and still trade items, so a high level character can't gear up a new one")
+var rpgtradetimeout = rpgOptions.Duration("tradetimeout", 2*time.Minute, "How long the other character has to accept a trade")
+var rpgtradehistory = rpgOptions.Int("tradehistory", 100, "Most trades kept in each room's history")
+
+var rpgTradeCommand = NewCommand("!rpgtrade accept", "!rpgtrade decline", "!rpgtrade <nick> <slot>").WithHelp("Offers to swap the item in one of your character's slots for the item in the same slot of a nick's character, who has to accept.")
+
+// A trade offered with !rpgtrade, waiting for the other character to accept.
+type tradeOffer struct {
+	from, to string
+	slot     int
+	// The items offered, the trade is called off if either has changed by the time it is accepted.
+	gave, got *Item
+	expires   time.Time
+}
+
+// A completed trade, kept in the game's history.
+type Trade struct {
+	Time time.Time
+	From string
+	To   string
+	Slot string
+	// The names of the items From gave and got.
+	Gave string
+	Got  string
+}
+
+// Returns a reason the characters can't trade, in nick's language, or an empty string if they can.
+func (game *Game) tradeProblem(nick string, from, to *Character, slot int) string {
+	levels := from.Level - to.Level
+	if levels < 0 {
+		levels = -levels
+	}
+	switch {
+	case from == to:
+		return game.Response(nick, "rpg.tradeself", nil)
+	case levels > int64(*rpgtradelevels):
+		return game.Response(nick, "rpg.tradelevels", ResponseVars{"Name": to.Name, "Levels": levels, "Max": *rpgtradelevels})
+	case from.Items[slot] == nil:
+		return game.Response(nick, "rpg.tradenoitem", ResponseVars{"Slot": slotNames[slot]})
+	case to.Items[slot] == nil:
+		return game.Response(nick, "rpg.tradetheirnoitem", ResponseVars{"Name": to.Name, "Slot": slotNames[slot]})
+	}
+	return ""
+}
+
+// Offers, accepts and declines trades of the nick's character.
+func (game *Game) TradeCommand(event *Event) {
+	game.Lock()
+	defer game.Unlock()
+
+	args, err := rpgTradeCommand.Parse(event.Line.Text())
+	if err != nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	char := game.playerCharacter(event.Server, event.Line.Nick)
+	if char == nil {
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacter", nil))
+		return
+	}
+	key := NameKey(char.Name)
+	if game.trades == nil {
+		game.trades = make(map[string]*tradeOffer)
+	}
+	offer := game.trades[key]
+	if offer != nil && !event.Time.Before(offer.expires) {
+		delete(game.trades, key)
+		offer = nil
+	}
+
+	switch args.Pattern {
+	case "!rpgtrade accept", "!rpgtrade decline":
+		if offer == nil {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.tradenone", nil))
+			return
+		}
+		delete(game.trades, key)
+		from := game.GetCharacter(offer.from, false)
+		if args.Pattern == "!rpgtrade decline" {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.tradedeclined", nil))
+			if from != nil {
+				game.settings.Privmsg(event.Server, from.Name, game.Response(from.Name, "rpg.tradedeclinedyours", ResponseVars{"Name": char.Name}))
+			}
+			return
+		}
+		if from == nil || from.Items[offer.slot] != offer.gave || char.Items[offer.slot] != offer.got {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.tradechanged", nil))
+			return
+		}
+		if problem := game.tradeProblem(event.Line.Nick, from, char, offer.slot); problem != "" {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.tradecalledoff", ResponseVars{"Problem": problem}))
+			return
+		}
+		from.Items[offer.slot], char.Items[offer.slot] = offer.got, offer.gave
+		for _, c := range []*Character{from, char} {
+			if rarity := c.Items[offer.slot].Rarity + 1; rarity > c.stats[STAT_ITEM_RARITY] {
+				c.stats[STAT_ITEM_RARITY] = rarity
+			}
+		}
+		game.Trades = append(game.Trades, &Trade{event.Time, from.Name, char.Name, slotNames[offer.slot], offer.gave.Name, offer.got.Name})
+		if extra := len(game.Trades) - *rpgtradehistory; extra > 0 {
+			game.Trades = game.Trades[extra:]
+		}
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.traded", ResponseVars{"Gave": offer.got.Name, "Name": from.Name, "Got": offer.gave.Name}))
+		game.settings.Privmsg(event.Server, from.Name, game.Response(from.Name, "rpg.tradeaccepted", ResponseVars{"Name": char.Name, "Got": offer.got.Name}))
+	default:
+		slot := slotIndex(args.String("slot"))
+		if slot == -1 {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.unknownslot", ResponseVars{"Slots": strings.Join(slotNames, ", ")}))
+			return
+		}
+		other := game.GetCharacter(args.String("nick"), false)
+		if other == nil {
+			game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.nocharacterfor", ResponseVars{"Nick": args.String("nick")}))
+			return
+		}
+		if problem := game.tradeProblem(event.Line.Nick, char, other, slot); problem != "" {
+			game.settings.Privmsg(event.Server, event.Line.Nick, problem)
+			return
+		}
+		otherKey := NameKey(other.Name)
+		game.trades[otherKey] = &tradeOffer{key, otherKey, slot, char.Items[slot], other.Items[slot], event.Time.Add(*rpgtradetimeout)}
+		game.settings.Privmsg(event.Server, event.Line.Nick, game.Response(event.Line.Nick, "rpg.tradeoffered", ResponseVars{"Name": other.Name, "Gave": char.Items[slot].Name, "Got": other.Items[slot].Name, "Timeout": DurationString(*rpgtradetimeout)}))
+		game.settings.Privmsg(event.Server, other.Name, game.Response(other.Name, "rpg.tradeoffer", ResponseVars{"Name": char.Name, "Gave": char.ItemDescription(slot), "Got": other.Items[slot].Name}))
+	}
+}
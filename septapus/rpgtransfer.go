@@ -0,0 +1,158 @@
+package septapus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var rpgTransferCommand = NewCommand("!rpgtransfer copy <nick> <from> <to>", "!rpgtransfer move <nick> <from> <to>").WithHelp("Moves your character to another room, admins can copy or move anyone's.")
+
+// Returns the key a game is stored under in the rpg namespace, rooms that differ only in case share one, see FoldRoom.
+func gameKey(server ServerName, room RoomName) string {
+	return string(server) + string(FoldRoom(server, room))
+}
+
+// Stores every game together, if any can't be written none are replaced. The caller must hold the games' locks.
+func writeGames(games ...*Game) error {
+	values := make(map[string]interface{})
+	for _, game := range games {
+		values[gameKey(game.Server, game.Room)] = game
+	}
+	return SharedStore().PutAll("rpg", values)
+}
+
+func cloneCharacter(character *Character) (*Character, error) {
+	b, err := json.Marshal(character)
+	if err != nil {
+		return nil, err
+	}
+	clone := &Character{stats: make(Stats)}
+	if err := json.Unmarshal(b, clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// Copies a character between games, removing it from the source if move is set.
+// The character's stats are rebuilt from the destination's defeated monsters, earned achievements are kept.
+// Both games are stored before returning, if writing fails neither game is changed.
+func TransferCharacter(from, to *Game, name string, move bool) error {
+	if from == to || (from.Server == to.Server && SameRoom(from.Server, from.Room, to.Room)) {
+		return errors.New("Cannot transfer a character to the same room.")
+	}
+	// Lock in a consistent order so two transfers can not deadlock.
+	first, second := from, to
+	if gameKey(to.Server, to.Room) < gameKey(from.Server, from.Room) {
+		first, second = to, from
+	}
+	first.Lock()
+	defer first.Unlock()
+	second.Lock()
+	defer second.Unlock()
+
+	key := NameKey(name)
+	character := from.Characters[key]
+	if character == nil {
+		return fmt.Errorf("%v has no character in %v.", name, from.Room)
+	}
+	if to.Characters[key] != nil {
+		return fmt.Errorf("%v already has a character in %v.", name, to.Room)
+	}
+	clone, err := cloneCharacter(character)
+	if err != nil {
+		return err
+	}
+	clone.Refresh(to.NamePack())
+	for _, monster := range to.Defeated {
+		monster.assignStats(clone)
+	}
+	achievements.check(clone.stats, clone.Achievements)
+
+	to.Characters[key] = clone
+	damage, raiding := from.Monster.Characters[key]
+	if move {
+		delete(from.Characters, key)
+		delete(from.Monster.Characters, key)
+	}
+
+	games := []*Game{to}
+	if move {
+		games = append(games, from)
+	}
+	if err := writeGames(games...); err != nil {
+		delete(to.Characters, key)
+		if move {
+			from.Characters[key] = character
+			if raiding {
+				from.Monster.Characters[key] = damage
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns the running game for a room, or nil if the room is not running.
+func (rpg *RPGPlugin) runningGame(server ServerName, room RoomName) *Game {
+	rpg.Lock()
+	defer rpg.Unlock()
+
+	return rpg.games[server][FoldRoom(server, room)]
+}
+
+// Returns the running game for a room, or loads it from disk if the room is not running.
+func (rpg *RPGPlugin) getGame(server ServerName, room RoomName) *Game {
+	game := rpg.runningGame(server, room)
+	if game == nil {
+		game = &Game{}
+		game.Load(server, room)
+	}
+	return game
+}
+
+func (rpg *RPGPlugin) register(server ServerName, room RoomName, game *Game) {
+	rpg.Lock()
+	defer rpg.Unlock()
+
+	if rpg.games[server] == nil {
+		rpg.games[server] = make(map[RoomName]*Game)
+	}
+	rpg.games[server][FoldRoom(server, room)] = game
+}
+
+func (rpg *RPGPlugin) unregister(server ServerName, room RoomName) {
+	rpg.Lock()
+	defer rpg.Unlock()
+
+	delete(rpg.games[server], FoldRoom(server, room))
+}
+
+func (rpg *RPGPlugin) TransferCommand(event *Event) {
+	args, err := rpgTransferCommand.Parse(event.Line.Text())
+	if err != nil {
+		rpg.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	move := strings.HasPrefix(args.Pattern, "!rpgtransfer move")
+	nick := args.String("nick")
+	if !IsAdmin(event) && !(move && NameKey(nick) == NameKey(event.Line.Nick)) {
+		rpg.settings.Privmsg(event.Server, event.Line.Nick, "Only admins can copy characters, or move other people's characters.")
+		return
+	}
+	from := rpg.getGame(event.Server.Name, RoomName(args.String("from")))
+	to := rpg.getGame(event.Server.Name, RoomName(args.String("to")))
+	if err := TransferCharacter(from, to, nick, move); err != nil {
+		rpg.settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+		return
+	}
+	go from.Upload()
+	go to.Upload()
+
+	verb := "Copied"
+	if move {
+		verb = "Moved"
+	}
+	rpg.settings.Privmsg(event.Server, event.Line.Nick, fmt.Sprintf("%v %v from %v to %v.", verb, nick, from.Room, to.Room))
+}
@@ -0,0 +1,256 @@
+package septapus
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/golog/logging"
+)
+
+var servicestimeout = servicesOptions.Duration("timeout", 15*time.Second, "How long joining rooms waits for us to identify to services after connecting, servers that don't say when we are logged in always wait this long")
+
+const (
+	CAP          EventName = "CAP"
+	AUTHENTICATE EventName = "AUTHENTICATE"
+)
+
+// Numerics for logging in to services.
+const (
+	RPL_LOGGEDIN    = "900"
+	RPL_SASLSUCCESS = "903"
+	ERR_SASLFAIL    = "904"
+	ERR_SASLTOOLONG = "905"
+	ERR_SASLABORTED = "906"
+	ERR_SASLALREADY = "907"
+)
+
+// Capabilities requested while registering, for every server. server-time tags lines with when the server sent them,
+// and batch wraps a bouncer's buffer playback, see lineTime and BouncerState.
+var registrationCapabilities = []string{"server-time", "batch"}
+
+// Whether we have logged in to services since connecting. Rooms aren't joined until we have, or servicestimeout passes.
+type ServicesState struct {
+	sync.Mutex
+	loggedIn bool
+	// Set while a SASL exchange is in progress.
+	sasl bool
+	// Capabilities requested while registering that the server hasn't answered, and whether CAP END has been sent.
+	pending map[string]bool
+	capEnd  bool
+	// Closed once we are logged in, replaced when we disconnect.
+	done     chan bool
+	finished bool
+}
+
+func NewServicesState() *ServicesState {
+	return &ServicesState{done: make(chan bool)}
+}
+
+func (state *ServicesState) reset() {
+	state.Lock()
+	defer state.Unlock()
+
+	state.loggedIn = false
+	state.sasl = false
+	state.done = make(chan bool)
+	state.finished = false
+}
+
+func (state *ServicesState) loggedInNow() {
+	state.Lock()
+	defer state.Unlock()
+
+	state.loggedIn = true
+	state.sasl = false
+	if !state.finished {
+		close(state.done)
+		state.finished = true
+	}
+}
+
+func (state *ServicesState) setSASL(sasl bool) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.sasl = sasl
+}
+
+func (state *ServicesState) inSASL() bool {
+	state.Lock()
+	defer state.Unlock()
+
+	return state.sasl
+}
+
+// Requests the registration capabilities, registration is held until endCapabilities sends CAP END.
+func (state *ServicesState) requestCapabilities(server *Server) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.pending = make(map[string]bool)
+	state.capEnd = false
+	// Requested one at a time, a server that doesn't have one of them would refuse the whole request.
+	for _, capability := range registrationCapabilities {
+		state.pending[capability] = true
+		server.Conn.Raw("CAP REQ :" + capability)
+	}
+}
+
+// Marks the capabilities in a CAP ACK or NAK as answered.
+func (state *ServicesState) answered(caps string) {
+	state.Lock()
+	defer state.Unlock()
+
+	for _, c := range strings.Fields(caps) {
+		delete(state.pending, strings.TrimLeft(c, "-~="))
+	}
+}
+
+// Sends CAP END once every requested capability has been answered and SASL has finished.
+func (state *ServicesState) endCapabilities(server *Server) {
+	state.Lock()
+	defer state.Unlock()
+
+	if state.capEnd || state.sasl || len(state.pending) > 0 {
+		return
+	}
+	state.capEnd = true
+	server.Conn.Raw("CAP END")
+}
+
+// Called once we are registered, a server that registered us without CAP END doesn't hold registration for it.
+func (state *ServicesState) registered() {
+	state.Lock()
+	defer state.Unlock()
+
+	state.capEnd = true
+}
+
+// Returns the password we identify to services with on a server, from its options or -services.passwords.
+func (server *Server) servicesPassword() string {
+	if server.ServicesPassword != "" {
+		return server.ServicesPassword
+	}
+	return servicesPassword(server.Name)
+}
+
+// Returns the account we log in to, the nick we were configured with unless another is given.
+func (server *Server) servicesAccount() string {
+	if server.ServicesAccount != "" {
+		return server.ServicesAccount
+	}
+	return server.Config.Me.Nick
+}
+
+// Returns true if we have logged in to services since connecting.
+func (server *Server) LoggedIn() bool {
+	server.services.Lock()
+	defer server.services.Unlock()
+
+	return server.services.loggedIn
+}
+
+// Waits until we have logged in to services or servicestimeout passes, servers without a services password don't wait.
+// Returns false if stop is closed first.
+func (server *Server) waitForServices(stop chan bool) bool {
+	if server.servicesPassword() == "" {
+		return true
+	}
+	server.services.Lock()
+	done := server.services.done
+	server.services.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(*servicestimeout):
+		logging.Warn("Timed out identifying to services, joining anyway", server.Name)
+	case <-stop:
+		return false
+	}
+	return true
+}
+
+// Logs in to services with the server's services password each time we connect, with SASL PLAIN if the server is
+// set to use it and NickServ IDENTIFY otherwise, or if SASL fails.
+func ServicesPlugin(bot *Bot, settings *PluginSettings) {
+	events := make(chan *Event)
+	for _, name := range []EventName{client.REGISTER, client.CONNECTED, client.DISCONNECTED, CAP, AUTHENTICATE, RPL_LOGGEDIN, RPL_SASLSUCCESS, ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED, ERR_SASLALREADY} {
+		go func(channel chan *Event) {
+			for event := range channel {
+				events <- event
+			}
+		}(bot.GetEventHandler(name))
+	}
+	for event := range events {
+		server, line := event.Server, event.Line
+		services := server.services
+		password := server.servicesPassword()
+		switch EventName(line.Cmd) {
+		case client.REGISTER:
+			// goirc has already sent NICK and USER, servers hold registration until CAP END once they see the request.
+			// Servers that don't know CAP ignore it and register us anyway.
+			if (server.SASL || servicesUseSASL(server.Name)) && password != "" {
+				services.setSASL(true)
+				server.Conn.Raw("CAP REQ :sasl")
+			}
+			services.requestCapabilities(server)
+		case CAP:
+			// eg: CAP * ACK :sasl, replies to requests made after registering are left to the plugins that made them.
+			if len(line.Args) < 3 || (line.Args[1] != "ACK" && line.Args[1] != "NAK") {
+				continue
+			}
+			services.answered(line.Args[2])
+			if services.inSASL() && hasCapability(line.Args[2], "sasl") {
+				if line.Args[1] == "ACK" {
+					server.Conn.Raw("AUTHENTICATE PLAIN")
+				} else {
+					logging.Warn("Server doesn't support SASL, using NickServ", server.Name)
+					services.setSASL(false)
+				}
+			}
+			services.endCapabilities(server)
+		case AUTHENTICATE:
+			if len(line.Args) > 0 && line.Args[0] == "+" && services.inSASL() {
+				account := server.servicesAccount()
+				server.Conn.Raw("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(account+"\x00"+account+"\x00"+password)))
+			}
+		case RPL_SASLSUCCESS, ERR_SASLALREADY:
+			logging.Info("Logged in with SASL", server.Name)
+			services.loggedInNow()
+			services.endCapabilities(server)
+		case ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED:
+			ReportError("services", "SASL failed, using NickServ", server.Name, line.Text())
+			services.setSASL(false)
+			services.endCapabilities(server)
+		case RPL_LOGGEDIN:
+			services.loggedInNow()
+		case client.CONNECTED:
+			services.registered()
+			if password == "" || server.LoggedIn() {
+				continue
+			}
+			// Registered before SASL finished, the server didn't wait for it.
+			services.setSASL(false)
+			if server.ServicesAccount != "" {
+				server.Privmsg(nickServ, "IDENTIFY "+server.ServicesAccount+" "+password)
+			} else {
+				server.Privmsg(nickServ, "IDENTIFY "+password)
+			}
+		case client.DISCONNECTED:
+			services.reset()
+		}
+	}
+}
+
+// Returns true if a space separated list of capabilities has one, ignoring modifiers, eg: -sasl.
+func hasCapability(caps, capability string) bool {
+	for _, c := range strings.Fields(caps) {
+		if strings.TrimLeft(c, "-~=") == capability {
+			return true
+		}
+	}
+	return false
+}
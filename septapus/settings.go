@@ -0,0 +1,208 @@
+package septapus
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var (
+	namedSettings     = make(map[string]*PluginSettings)
+	namedSettingsLock sync.RWMutex
+)
+
+// Creates settings that are saved to disk and can be changed at runtime with the !plugin command.
+// Any saved state is loaded immediately, bans and forces added in code afterwards are applied on top of it.
+func NewNamedPluginSettings(name string) *PluginSettings {
+	namedSettingsLock.Lock()
+	defer namedSettingsLock.Unlock()
+
+	if s := namedSettings[name]; s != nil {
+		return s
+	}
+	s := NewPluginSettings()
+	s.Name = name
+	s.Load()
+	s.applyConfig()
+	namedSettings[name] = s
+	return s
+}
+
+func GetNamedPluginSettings(name string) *PluginSettings {
+	namedSettingsLock.RLock()
+	defer namedSettingsLock.RUnlock()
+
+	return namedSettings[name]
+}
+
+func NamedPluginSettingsNames() []string {
+	namedSettingsLock.RLock()
+	defer namedSettingsLock.RUnlock()
+
+	names := make([]string, 0, len(namedSettings))
+	for name, _ := range namedSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type savedPluginSettings struct {
+	BannedServers map[ServerName]bool
+	BannedRooms   map[ServerName]map[RoomName]bool
+	ForcedServers map[ServerName]bool
+	ForcedRooms   map[ServerName]map[RoomName]bool
+	DryRun        bool `json:",omitempty"`
+	Disabled      bool `json:",omitempty"`
+}
+
+func (s *PluginSettings) Load() {
+	s.Lock()
+	defer s.Unlock()
+
+	filename := "settings/" + s.Name + ".json"
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+		saved := &savedPluginSettings{}
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(saved); err != nil {
+			ReportError("settings", "Error loading settings", s.Name, err)
+			return
+		}
+		if saved.BannedServers != nil {
+			s.bannedServers = saved.BannedServers
+		}
+		if saved.BannedRooms != nil {
+			s.bannedRooms = saved.BannedRooms
+		}
+		if saved.ForcedServers != nil {
+			s.forcedServers = saved.ForcedServers
+		}
+		if saved.ForcedRooms != nil {
+			s.forcedRooms = saved.ForcedRooms
+		}
+		s.dryRun = saved.DryRun
+		s.disabled = saved.Disabled
+		logging.Info("Loaded settings for", s.Name)
+	} else {
+		logging.Info("Error loading file", s.Name, filename, err)
+	}
+}
+
+func (s *PluginSettings) Save() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Name == "" {
+		return
+	}
+
+	filename := "settings/" + s.Name + ".json"
+
+	saved := &savedPluginSettings{s.bannedServers, s.bannedRooms, s.forcedServers, s.forcedRooms, s.dryRun, s.disabled}
+	if err := SaveSharedFile(filename, saved, "BannedServers", "BannedRooms", "ForcedServers", "ForcedRooms"); err != nil {
+		ReportError("settings", "Error saving settings", s.Name, err)
+		return
+	}
+	// Other shards' servers were merged in.
+	s.bannedServers, s.bannedRooms, s.forcedServers, s.forcedRooms = saved.BannedServers, saved.BannedRooms, saved.ForcedServers, saved.ForcedRooms
+	logging.Info("Saved settings", s.Name)
+}
+
+var pluginCommand = NewCommand("!plugin list", "!plugin enable <plugin> [room]", "!plugin disable <plugin> [room]", "!plugin ban <plugin> [room]", "!plugin unban <plugin> [room]", "!plugin force <plugin> [room]", "!plugin unforce <plugin> [room]", "!plugin dryrun <plugin> <setting>").WithHelp("Admins only, lists plugins or changes where they run.")
+
+func NewSettingsPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(SettingsPlugin, settings)
+}
+
+func SettingsPlugin(bot *Bot, settings *PluginSettings) {
+	channel := settings.HandleCommand(bot, pluginCommand)
+	for event := range channel {
+		if !IsAdmin(event) {
+			continue
+		}
+		args, err := pluginCommand.Parse(event.Line.Text())
+		if err != nil {
+			event.Server.Privmsg(event.Line.Nick, err.Error())
+			continue
+		}
+		respond := func(key string, vars ResponseVars) {
+			event.Server.Privmsg(event.Line.Nick, Response(event.Server.Name, event.Room, event.Line.Nick, key, vars))
+		}
+		subcommand := strings.Fields(args.Pattern)[1]
+		if subcommand == "list" {
+			names := bot.PluginNames()
+			for i, name := range names {
+				if bot.Plugin(name).Settings().IsDisabled() {
+					names[i] = Response(event.Server.Name, event.Room, event.Line.Nick, "settings.pluginoff", ResponseVars{"Plugin": name})
+				}
+			}
+			respond("settings.plugins", ResponseVars{"Plugins": strings.Join(names, ", ")})
+			continue
+		}
+
+		s := GetNamedPluginSettings(args.String("plugin"))
+		if s == nil {
+			respond("settings.unknown", ResponseVars{"Plugin": args.String("plugin")})
+			continue
+		}
+		// Without a room, enable and disable the plugin everywhere.
+		if (subcommand == "enable" || subcommand == "disable") && !args.Has("room") {
+			if bot.Plugin(s.Name) == nil {
+				respond("settings.notrunning", ResponseVars{"Plugin": s.Name})
+				continue
+			}
+			s.SetDisabled(subcommand == "disable")
+			s.Save()
+			respond("settings.all."+subcommand, ResponseVars{"Plugin": s.Name})
+			continue
+		}
+		if subcommand == "dryrun" {
+			setting := strings.ToLower(args.String("setting"))
+			if setting != "on" && setting != "off" {
+				respond("settings.baddryrun", nil)
+				continue
+			}
+			s.SetDryRun(setting == "on")
+			s.Save()
+			respond("settings.dryrun."+setting, ResponseVars{"Plugin": s.Name})
+			continue
+		}
+		room := event.Room
+		if args.Has("room") {
+			room = RoomName(args.String("room"))
+		} else if !event.Line.Public() {
+			respond("settings.roomrequired", nil)
+			continue
+		}
+		server := event.Server.Name
+		switch subcommand {
+		case "ban":
+			s.RemoveForcedRoom(server, room)
+			s.AddBannedRoom(server, room)
+		case "unban":
+			s.RemoveBannedRoom(server, room)
+		case "force":
+			s.RemoveBannedRoom(server, room)
+			s.AddForcedRoom(server, room)
+		case "unforce":
+			s.RemoveForcedRoom(server, room)
+		case "enable":
+			// Only forced if the room would still be banned, so a later unban of the server isn't overridden.
+			s.RemoveBannedRoom(server, room)
+			if s.IsBannedServer(server) || s.IsBannedRoom(server, room) {
+				s.AddForcedRoom(server, room)
+			}
+		case "disable":
+			s.RemoveForcedRoom(server, room)
+			s.AddBannedRoom(server, room)
+		}
+		s.Save()
+		respond("settings.room."+subcommand, ResponseVars{"Plugin": s.Name, "Server": server, "Room": room})
+	}
+}
@@ -0,0 +1,491 @@
+package septapus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var shardOptions = NewOptions("shard")
+
+var shardid = shardOptions.String("id", "", "Name of this process when servers are split between several processes, see septapus supervise. When empty every server is connected")
+var sharddir = shardOptions.String("dir", "shards", "Directory shared by every shard, where they agree which shard connects each server")
+var shardlease = shardOptions.Duration("lease", 30*time.Second, "How long a shard's claim to a server lasts without being renewed, the servers of a shard that dies move to the others after this")
+
+// Returns true if this process is one of several that split the servers between them.
+func Sharded() bool {
+	return *shardid != ""
+}
+
+// Written by a shard every time it renews its leases, shards that haven't written one within a lease are dead.
+type ShardHeartbeat struct {
+	ID      string
+	PID     int
+	Host    string
+	Updated time.Time
+}
+
+// A shard's claim to connect a server.
+type ServerLease struct {
+	Shard   string
+	Expires time.Time
+}
+
+func shardHeartbeatPath(id string) string {
+	return filepath.Join(*sharddir, "shards", url.PathEscape(id)+".json")
+}
+
+func serverLeasePath(name ServerName) string {
+	return filepath.Join(*sharddir, "servers", url.PathEscape(string(name))+".json")
+}
+
+// Writes to a temporary file first, so other shards never read half a file.
+func writeShardFile(filename string, value interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%v.%d.tmp", filename, os.Getpid())
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, filename)
+}
+
+// Holds a lock on a file shared by the shards while it is read and written, so two shards don't change it at once.
+// Returns a func that releases the lock, a shard that dies holding it releases it too.
+func lockShardFile(filename string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() { file.Close() }, nil
+}
+
+func readShardFile(filename string, value interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Returns the lease on a server, or nil if no shard has claimed it.
+func readServerLease(name ServerName) *ServerLease {
+	lease := &ServerLease{}
+	if err := readShardFile(serverLeasePath(name), lease); err != nil {
+		return nil
+	}
+	return lease
+}
+
+// Returns true if this process saves a server's state, the shard holding the server's lease does when the servers are
+// split.
+func ShardHolds(name ServerName) bool {
+	if !Sharded() {
+		return true
+	}
+	lease := readServerLease(name)
+	return lease != nil && lease.Shard == *shardid
+}
+
+// Saves state every shard keeps in the same file, eg: karma.json. The file is read again under its lock and each shard
+// only replaces the servers it holds in the serverFields of value, which are keyed by server, so shards saving at once
+// don't lose each other's changes. The servers other shards hold are decoded into value, so a shard that takes over a
+// server has what its last shard saved. value's other objects are merged by key, its own keys winning, and anything
+// else is replaced. The caller must hold value's write lock.
+func SaveSharedFile(filename string, value interface{}, serverFields ...string) error {
+	if !Sharded() {
+		return writeShardFile(filename, value)
+	}
+	unlock, err := lockShardFile(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &ours); err != nil {
+		return err
+	}
+	theirs := make(map[string]json.RawMessage)
+	if err := readShardFile(filename, &theirs); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	keyedByServer := make(map[string]bool)
+	for _, field := range serverFields {
+		keyedByServer[field] = true
+	}
+	held := make(map[string]bool)
+	taken := make(map[string]json.RawMessage)
+	for field, saved := range theirs {
+		savedKeys := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(saved, &savedKeys); err != nil {
+			continue
+		}
+		ourKeys := make(map[string]json.RawMessage)
+		json.Unmarshal(ours[field], &ourKeys)
+		if ourKeys == nil {
+			ourKeys = make(map[string]json.RawMessage)
+		}
+		takenKeys := make(map[string]json.RawMessage)
+		for key, v := range savedKeys {
+			if keyedByServer[field] {
+				if _, ok := held[key]; !ok {
+					held[key] = ShardHolds(ServerName(key))
+				}
+				if held[key] {
+					continue
+				}
+			} else if _, ok := ourKeys[key]; ok {
+				continue
+			}
+			ourKeys[key] = v
+			takenKeys[key] = v
+		}
+		if ours[field], err = json.Marshal(ourKeys); err != nil {
+			return err
+		}
+		if taken[field], err = json.Marshal(takenKeys); err != nil {
+			return err
+		}
+	}
+	if err := writeShardFile(filename, ours); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(taken); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Returns the ids of the shards that have written a heartbeat within a lease, sorted.
+func LiveShards() []string {
+	files, _ := ioutil.ReadDir(filepath.Join(*sharddir, "shards"))
+	live := make([]string, 0, len(files))
+	for _, info := range files {
+		if !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		heartbeat := &ShardHeartbeat{}
+		if err := readShardFile(filepath.Join(*sharddir, "shards", info.Name()), heartbeat); err != nil {
+			continue
+		}
+		if time.Since(heartbeat.Updated) < *shardlease {
+			live = append(live, heartbeat.ID)
+		}
+	}
+	sort.Strings(live)
+	return live
+}
+
+// Returns true if this process writes the files shared by every server, eg: the kill feed pages. The first live shard
+// does when the servers are split, so only one process writes them.
+func LeadShard() bool {
+	if !Sharded() {
+		return true
+	}
+	live := LiveShards()
+	return len(live) > 0 && live[0] == *shardid
+}
+
+// Removes a shard's heartbeat and leases, so the other shards take over its servers without waiting for the leases to expire.
+func ReleaseShard(id string) {
+	os.Remove(shardHeartbeatPath(id))
+	files, _ := ioutil.ReadDir(filepath.Join(*sharddir, "servers"))
+	for _, info := range files {
+		filename := filepath.Join(*sharddir, "servers", info.Name())
+		lease := &ServerLease{}
+		if err := readShardFile(filename, lease); err == nil && lease.Shard == id {
+			os.Remove(filename)
+		}
+	}
+}
+
+// A shard connects its share of the servers, the rest are connected by the other shards using the same sharddir.
+// Every shard is given all of the servers. Each claims servers nobody holds until it has its share, and releases
+// servers it holds beyond its share when shards join, so the servers stay spread evenly as shards come and go.
+// Only servers move between shards, state saved by plugins is shared through the store, and the files every server
+// shares are saved with SaveSharedFile. A server's rooms are only saved by the shard that holds it. Each shard saves its kills under its own key, and only the lead shard uploads the kill
+// feed, see LeadShard.
+type Shard struct {
+	sync.Mutex
+	ID string
+
+	bot     *Bot
+	servers map[ServerName]*Server
+	owned   map[ServerName]bool
+	quit    chan bool
+	done    chan bool
+}
+
+func NewShard(bot *Bot, servers []*Server) *Shard {
+	shard := &Shard{
+		ID:      *shardid,
+		bot:     bot,
+		servers: make(map[ServerName]*Server),
+		owned:   make(map[ServerName]bool),
+		quit:    make(chan bool),
+		done:    make(chan bool),
+	}
+	for _, server := range servers {
+		shard.servers[server.Name] = server
+	}
+	return shard
+}
+
+// Returns the names of the servers this shard has connected, sorted.
+func (shard *Shard) Owned() []ServerName {
+	shard.Lock()
+	defer shard.Unlock()
+
+	names := make([]string, 0, len(shard.owned))
+	for name, _ := range shard.owned {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	owned := make([]ServerName, len(names))
+	for i, name := range names {
+		owned[i] = ServerName(name)
+	}
+	return owned
+}
+
+func (shard *Shard) heartbeat() error {
+	host, _ := os.Hostname()
+	return writeShardFile(shardHeartbeatPath(shard.ID), &ShardHeartbeat{shard.ID, os.Getpid(), host, time.Now()})
+}
+
+// Adds a server this shard has claimed to the bot, without the shard's lock as connecting can take a while.
+func (shard *Shard) connect(name ServerName) {
+	logging.Info("Shard connecting", shard.ID, name)
+	if _, err := shard.bot.AddServer(shard.servers[name]); err != nil {
+		ReportError("shard", "Error connecting", name, err)
+	}
+}
+
+// Removes the server from the bot, its plugins have stopped and saved by the time this returns, so its lease can be released.
+func (shard *Shard) disconnect(name ServerName) {
+	delete(shard.owned, name)
+	logging.Info("Shard disconnecting", shard.ID, name)
+	shard.bot.RemoveServer(name)
+}
+
+// Takes the lease on a server until expires if it is free, has expired or is already this shard's. The lease is read
+// and written under its lock, so only one of the shards claiming a server at once gets it.
+func (shard *Shard) lease(name ServerName, expires time.Time) (bool, error) {
+	unlock, err := lockShardFile(serverLeasePath(name))
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if lease := readServerLease(name); lease != nil && lease.Shard != shard.ID && lease.Expires.After(time.Now()) {
+		return false, nil
+	}
+	return true, writeShardFile(serverLeasePath(name), &ServerLease{shard.ID, expires})
+}
+
+// Renews this shard's leases, drops servers another shard has taken, and claims or releases servers until this shard holds its share.
+func (shard *Shard) Update() {
+	for _, name := range shard.update() {
+		shard.connect(name)
+	}
+}
+
+// Does the work of Update holding the shard's lock, returns the servers this shard claimed for Update to connect.
+func (shard *Shard) update() []ServerName {
+	shard.Lock()
+	defer shard.Unlock()
+
+	if err := shard.heartbeat(); err != nil {
+		ReportError("shard", "Error writing heartbeat", shard.ID, err)
+		return nil
+	}
+	live := LiveShards()
+	if len(live) == 0 {
+		live = []string{shard.ID}
+	}
+	names := make([]string, 0, len(shard.servers))
+	for name, _ := range shard.servers {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	share := (len(names) + len(live) - 1) / len(live)
+
+	expires := time.Now().Add(*shardlease)
+	for _, n := range names {
+		name := ServerName(n)
+		if !shard.owned[name] {
+			continue
+		}
+		if lease := readServerLease(name); lease == nil || lease.Shard != shard.ID {
+			// Our lease expired and another shard claimed the server, it is theirs now.
+			shard.disconnect(name)
+		}
+	}
+	for i := len(names) - 1; i >= 0 && len(shard.owned) > share; i-- {
+		name := ServerName(names[i])
+		if shard.owned[name] {
+			shard.disconnect(name)
+			os.Remove(serverLeasePath(name))
+		}
+	}
+	claimed := make([]ServerName, 0)
+	for _, n := range names {
+		name := ServerName(n)
+		if shard.owned[name] {
+			if ok, err := shard.lease(name, expires); err != nil {
+				ReportError("shard", "Error renewing lease", name, err)
+			} else if !ok {
+				// Another shard claimed the server between reading and renewing our lease.
+				shard.disconnect(name)
+			}
+			continue
+		}
+		if len(shard.owned) >= share {
+			continue
+		}
+		ok, err := shard.lease(name, expires)
+		if err != nil {
+			ReportError("shard", "Error claiming", name, err)
+			continue
+		}
+		if ok {
+			shard.owned[name] = true
+			claimed = append(claimed, name)
+		}
+	}
+	return claimed
+}
+
+// Keeps this shard's leases until Stop is called, then releases its servers to the other shards.
+func (shard *Shard) Run() {
+	defer close(shard.done)
+
+	shard.Update()
+	ticker := time.NewTicker(*shardlease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			shard.Update()
+		case <-shard.quit:
+			shard.Lock()
+			for name, _ := range shard.owned {
+				shard.disconnect(name)
+			}
+			shard.Unlock()
+			ReleaseShard(shard.ID)
+			return
+		}
+	}
+}
+
+func (shard *Shard) Stop() {
+	close(shard.quit)
+	<-shard.done
+}
+
+// Runs count shards of this binary with args, restarting any that exit. A shard that exits has its servers released
+// straight away so the others take them over, and takes its share back when it is restarted.
+// Shards are stopped by closing their input, when the supervisor is interrupted or its own input closes.
+func Supervise(count int, args []string) error {
+	if count < 1 {
+		return fmt.Errorf("Need at least one shard, not %d.", count)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan bool)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		close(stop)
+	}()
+
+	wait := sync.WaitGroup{}
+	for i := 1; i <= count; i++ {
+		wait.Add(1)
+		go func(id string) {
+			defer wait.Done()
+			superviseShard(executable, args, id, stop)
+		}(fmt.Sprintf("shard%d", i))
+	}
+	wait.Wait()
+	return nil
+}
+
+func superviseShard(executable string, args []string, id string, stop chan bool) {
+	// Doubled each time the shard exits soon after starting, so a shard that can't start doesn't spin.
+	backoff := time.Second
+	for {
+		started := time.Now()
+		cmd := exec.Command(executable, args...)
+		cmd.Env = append(os.Environ(), optionEnv("shard", "id")+"="+id)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		// The bot quits when its input closes.
+		input, err := cmd.StdinPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			ReportError("shard", "Error starting shard", id, err)
+		} else {
+			logging.Info("Started shard", id, cmd.Process.Pid)
+			exited := make(chan error, 1)
+			go func() {
+				exited <- cmd.Wait()
+			}()
+			select {
+			case err := <-exited:
+				logging.Info("Shard exited", id, err)
+				ReleaseShard(id)
+			case <-stop:
+				input.Close()
+				<-exited
+				return
+			}
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		} else if backoff < time.Minute {
+			backoff *= 2
+		}
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package septapus
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveSharedFile(t *testing.T) {
+	dir := t.TempDir()
+	oldID, oldDir := *shardid, *sharddir
+	defer func() { *shardid, *sharddir = oldID, oldDir }()
+	*shardid, *sharddir = "shard1", filepath.Join(dir, "shards")
+
+	expires := time.Now().Add(time.Minute)
+	writeShardFile(serverLeasePath("synirc"), &ServerLease{"shard1", expires})
+	writeShardFile(serverLeasePath("freenode"), &ServerLease{"shard2", expires})
+
+	// What shard2 saved, and what shard1 has in memory since it loaded the file before shard2 saved.
+	filename := filepath.Join(dir, "karma.json")
+	other := NewKarmaStore()
+	other.Rooms["synirc"] = map[RoomName]map[string]*NickKarma{"#septapus": {"iopred": {"iopred", 1}}}
+	other.Rooms["freenode"] = map[RoomName]map[string]*NickKarma{"#go-nuts": {"rob": {"rob", 5}}}
+	writeShardFile(filename, other)
+	store := NewKarmaStore()
+	store.Rooms["synirc"] = map[RoomName]map[string]*NickKarma{"#septapus": {"iopred": {"iopred", 2}}}
+	store.Rooms["freenode"] = map[RoomName]map[string]*NickKarma{"#go-nuts": {"rob": {"rob", 3}}}
+
+	if err := SaveSharedFile(filename, store, "Rooms"); err != nil {
+		t.Fatalf("SaveSharedFile() = %v", err)
+	}
+	saved := NewKarmaStore()
+	if err := readShardFile(filename, saved); err != nil {
+		t.Fatal(err)
+	}
+	// Each shard's servers are as it saved them.
+	if karma := saved.Rooms["synirc"]["#septapus"]["iopred"]; karma == nil || karma.Karma != 2 {
+		t.Errorf("Saved karma on the held server = %v, want 2", karma)
+	}
+	if karma := saved.Rooms["freenode"]["#go-nuts"]["rob"]; karma == nil || karma.Karma != 5 {
+		t.Errorf("Saved karma on another shard's server = %v, want 5", karma)
+	}
+	// And the other shard's servers were taken into memory.
+	if karma := store.Get("freenode", "#go-nuts", "rob"); karma != 5 {
+		t.Errorf("Karma on another shard's server = %v, want 5", karma)
+	}
+	if karma := store.Get("synirc", "#septapus", "iopred"); karma != 2 {
+		t.Errorf("Karma on the held server = %v, want 2", karma)
+	}
+}
@@ -0,0 +1,281 @@
+package septapus
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fluffle/golog/logging"
+)
+
+// Writes the generated files of a static site into its directory, keeping the first error.
+type siteWriter struct {
+	dir     string
+	written int
+	err     error
+}
+
+func (site *siteWriter) write(filename string, render func(w io.Writer) error) {
+	if site.err != nil {
+		return
+	}
+	path := filepath.Join(site.dir, filepath.FromSlash(filename))
+	if site.err = os.MkdirAll(filepath.Dir(path), 0755); site.err != nil {
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		site.err = err
+		return
+	}
+	if err := render(file); err != nil {
+		file.Close()
+		site.err = err
+		return
+	}
+	if site.err = file.Close(); site.err == nil {
+		site.written++
+	}
+}
+
+func (site *siteWriter) copy(filename, from string) {
+	site.write(filename, func(w io.Writer) error {
+		file, err := os.Open(from)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	})
+}
+
+// The rooms and servers a site has pages for.
+type siteRooms map[ServerName]map[RoomName]bool
+
+func (rooms siteRooms) add(server ServerName, room RoomName) {
+	if rooms[server] == nil {
+		rooms[server] = make(map[RoomName]bool)
+	}
+	if room != "" {
+		rooms[server][room] = true
+	}
+}
+
+// Returns the servers in order, with their rooms in order.
+func (rooms siteRooms) sorted() []*siteServer {
+	servers := make([]*siteServer, 0, len(rooms))
+	for server, roomSet := range rooms {
+		s := &siteServer{Name: server, Nav: RoomNav(server, "", "")}
+		for room, _ := range roomSet {
+			s.Rooms = append(s.Rooms, &siteRoom{room, RoomNav(server, room, "")})
+		}
+		sort.Sort(siteRoomsByName(s.Rooms))
+		servers = append(servers, s)
+	}
+	sort.Sort(siteServersByName(servers))
+	return servers
+}
+
+type siteServer struct {
+	Name  ServerName
+	Nav   []WebLink
+	Rooms []*siteRoom
+}
+
+type siteRoom struct {
+	Name RoomName
+	Nav  []WebLink
+}
+
+type siteServersByName []*siteServer
+
+func (s siteServersByName) Len() int           { return len(s) }
+func (s siteServersByName) Swap(a, b int)      { s[a], s[b] = s[b], s[a] }
+func (s siteServersByName) Less(a, b int) bool { return s[a].Name < s[b].Name }
+
+type siteRoomsByName []*siteRoom
+
+func (s siteRoomsByName) Len() int           { return len(s) }
+func (s siteRoomsByName) Swap(a, b int)      { s[a], s[b] = s[b], s[a] }
+func (s siteRoomsByName) Less(a, b int) bool { return s[a].Name < s[b].Name }
+
+// Splits a file or directory named by roomPath, eg: synirc:septapus, into its server and room.
+func splitRoomPath(name string) (ServerName, RoomName, bool) {
+	i := strings.IndexAny(name, ":&")
+	if i <= 0 {
+		return "", "", false
+	}
+	return ServerName(name[:i]), RoomName(strings.Replace(name[i:], ":", "#", 1)), true
+}
+
+// Reads a saved game without the rpg running, case variants are left to be merged when the rpg loads it.
+func readSavedGame(key string, server ServerName, room RoomName) (*Game, error) {
+	game := &Game{}
+	if err := SharedStore().Get("rpg", key, game); err != nil {
+		return nil, err
+	}
+	if _, err := rpgMigrations.Run(game, &game.Version); err != nil {
+		return nil, err
+	}
+	game.Init(server, room)
+	return game, nil
+}
+
+// Writes every room's rpg pages and comic gallery, the rpg kills, and each server's meets and lifters into dir as a
+// static site, an alternative to uploading them. Pages link to each other and to the site's stylesheet and images
+// under -web.siteurl, which should be where dir is served from. Comics are only in the galleries if -comic.keepdir was
+// set when they were made. Reads the store and the state in the working directory, the bot should not be running.
+// Returns the number of files written.
+func ExportSite(dir string) (int, error) {
+	site := &siteWriter{dir: dir}
+	rooms := make(siteRooms)
+	staticSite = make(siteRooms)
+	defer func() { staticSite = nil }()
+
+	keys, _ := SharedStore().List("rpg")
+	for _, key := range keys {
+		if isKillsKey(key) || strings.HasSuffix(key, mergedSuffix) {
+			continue
+		}
+		server, room, ok := splitRoomPath(strings.Replace(key, "#", ":", 1))
+		if !ok {
+			continue
+		}
+		game, err := readSavedGame(key, server, room)
+		if err != nil {
+			ReportError("site", "Error reading game", key, err)
+			continue
+		}
+		rooms.add(server, room)
+		staticSite.add(server, room)
+		game.Lock()
+		game.writePages(func(filename string, render func(w io.Writer) error) {
+			site.write("rpg/"+filename, render)
+		})
+		game.Unlock()
+	}
+	site.write("rpg/"+rpgStylesFilename, func(w io.Writer) error {
+		_, err := io.WriteString(w, RPGStyles())
+		return err
+	})
+	kills := &KillFeed{}
+	kills.Load()
+	site.write("rpg/kills.html", kills.WriteHTML)
+	site.write("rpg/kills.atom", kills.WriteAtom)
+
+	// Kept comics by room, oldest first.
+	comics := make(map[ServerName]map[RoomName][]string)
+	if *comickeepdir != "" {
+		dirs, _ := ioutil.ReadDir(*comickeepdir)
+		for _, info := range dirs {
+			server, room, ok := splitRoomPath(info.Name())
+			if !info.IsDir() || !ok {
+				continue
+			}
+			room = FoldRoom(server, room)
+			rooms.add(server, room)
+			if comics[server] == nil {
+				comics[server] = make(map[RoomName][]string)
+			}
+			images, _ := ioutil.ReadDir(filepath.Join(*comickeepdir, info.Name()))
+			for _, image := range images {
+				if strings.HasSuffix(image.Name(), ".png") {
+					comics[server][room] = append(comics[server][room], image.Name())
+					site.copy("comics/"+roomPath(server, room)+"/"+image.Name(), filepath.Join(*comickeepdir, info.Name(), image.Name()))
+				}
+			}
+		}
+	}
+
+	saved := make(map[ServerName]bool)
+	keys, _ = SharedStore().List("prs")
+	for _, key := range keys {
+		rooms.add(ServerName(key), "")
+		saved[ServerName(key)] = true
+	}
+
+	for server, roomSet := range rooms {
+		stats := &bytes.Buffer{}
+		serverStats := &ComicStats{}
+		serverStats.Load(server)
+		if err := serverStats.Render(stats); err != nil {
+			ReportError("site", "Error rendering comic stats", server, err)
+		}
+		for room, _ := range roomSet {
+			// Newest first.
+			names := comics[server][room]
+			images := make([]string, len(names))
+			for i, name := range names {
+				images[len(names)-1-i] = name
+			}
+			site.write("comics/"+roomPath(server, room)+"/index.html", func(w io.Writer) error {
+				return galleryTemplate.Execute(w, &WebPage{
+					Title: "Septapus comics: " + string(server) + "/" + string(room),
+					Nav:   RoomNav(server, room, "Comics"),
+					Data: struct {
+						Server ServerName
+						Room   RoomName
+						Stats  template.HTML
+						Comics []string
+					}{server, room, template.HTML(stats.String()), images},
+				})
+			})
+		}
+
+		prs := &PRS{}
+		if saved[server] {
+			prs.Load(server)
+		} else {
+			prs.Init()
+		}
+		site.write("pr/meets/"+string(server)+".html", func(w io.Writer) error {
+			return prs.WriteMeetHistory(w, server)
+		})
+		site.write("pr/"+string(server)+".html", func(w io.Writer) error {
+			return prs.WriteLifters(w, server)
+		})
+	}
+
+	site.write("index.html", func(w io.Writer) error {
+		return siteIndexTemplate.Execute(w, &WebPage{Title: "Septapus", Data: rooms.sorted()})
+	})
+	if site.err == nil {
+		logging.Info("Exported site", dir, site.written, "files")
+	}
+	return site.written, site.err
+}
+
+var galleryTemplate = NewWebTemplate(galleryTemplateSource)
+
+const galleryTemplateSource = `{{define "content"}}
+		<h1>Comics from {{.Server}}/{{.Room}}</h1>
+		{{.Stats}}
+		{{range .Comics}}
+		<p class="comic"><img src="{{.}}" alt="Comic"></p>
+		{{else}}
+		<p>No comics yet.</p>
+		{{end}}
+{{end}}
+`
+
+var siteIndexTemplate = NewWebTemplate(siteIndexTemplateSource)
+
+const siteIndexTemplateSource = `{{define "content"}}
+		<p><a href="rpg/kills.html">Recent Kills</a></p>
+		{{range .}}
+		<h2>{{.Name}}</h2>
+		<p>{{range $i, $link := .Nav}}{{if $i}} | {{end}}<a href="{{$link.URL}}">{{$link.Name}}</a>{{end}}</p>
+		<table class="rooms">
+			{{range .Rooms}}
+			<tr><td class="name">{{.Name}}</td><td>{{range $i, $link := .Nav}}{{if $i}} | {{end}}<a href="{{$link.URL}}">{{$link.Name}}</a>{{end}}</td></tr>
+			{{end}}
+		</table>
+		{{end}}
+{{end}}
+`
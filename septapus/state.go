@@ -0,0 +1,299 @@
+package septapus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns an empty value of the type saved under a key, or nil for a namespace the state tools don't know.
+func stateValue(namespace, key string) interface{} {
+	switch {
+	case namespace == "prs":
+		return &PRS{}
+	case namespace == "rpg" && isKillsKey(key):
+		return &KillFeed{}
+	case namespace == "rpg":
+		return &Game{}
+	}
+	return nil
+}
+
+// Returns the saved json of a key as it is stored.
+func readStateJSON(namespace, key string) ([]byte, error) {
+	if stateValue(namespace, key) == nil {
+		return nil, fmt.Errorf("Unknown namespace %v, expected rpg or prs", namespace)
+	}
+	var raw json.RawMessage
+	if err := SharedStore().Get(namespace, key, &raw); err != nil {
+		return nil, storeError(namespace, key, err)
+	}
+	return raw, nil
+}
+
+// Returns the saved json of a key indented for reading.
+func ShowState(namespace, key string) (string, error) {
+	data, err := readStateJSON(namespace, key)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "\t"); err != nil {
+		return "", storeError(namespace, key, err)
+	}
+	return out.String(), nil
+}
+
+// Decodes saved json into the key's type, fields the type doesn't have are an error.
+func decodeState(namespace, key string, data []byte) (interface{}, error) {
+	value := stateValue(namespace, key)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Returns the problems with a save, an empty list if it can be loaded as it is.
+func CheckState(namespace, key string) ([]string, error) {
+	data, err := readStateJSON(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	return checkStateJSON(namespace, key, data), nil
+}
+
+func checkStateJSON(namespace, key string, data []byte) []string {
+	value, err := decodeState(namespace, key, data)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	problems := make([]string, 0)
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	switch value := value.(type) {
+	case *Game:
+		if value.Version > rpgMigrations.Latest() {
+			problem("version %d is newer than the latest rpg version %d", value.Version, rpgMigrations.Latest())
+		}
+		for key, character := range value.Characters {
+			if character == nil {
+				problem("character %v is null", key)
+				continue
+			}
+			if NameKey(character.Name) != key {
+				problem("character %v is keyed as %v, expected %v", character.Name, key, NameKey(character.Name))
+			}
+			if character.Level < 0 || character.XP < 0 || character.Gold < 0 {
+				problem("character %v has a negative level, xp or gold", key)
+			}
+			if character.Class != "" && character.CharacterClass() == nil {
+				problem("character %v has unknown class %v", key, character.Class)
+			}
+			if character.Guild != "" && value.Guilds[character.Guild] == nil {
+				problem("character %v is in unknown guild %v", key, character.Guild)
+			}
+			if len(character.Items) > NUM_SLOTS {
+				problem("character %v has %d item slots, expected at most %d", key, len(character.Items), NUM_SLOTS)
+			}
+			for slot, item := range character.Items {
+				if item == nil {
+					continue
+				}
+				if item.Rarity < ITEM_JUNK || item.Rarity > ITEM_UNIQUE {
+					problem("character %v item %d has unknown rarity %d", key, slot, item.Rarity)
+				}
+				if item.Durability < 0 || item.Durability > maxDurability {
+					problem("character %v item %d has durability %d, expected 0 to %d", key, slot, item.Durability, maxDurability)
+				}
+			}
+		}
+		for key, guild := range value.Guilds {
+			if guild == nil {
+				problem("guild %v is null", key)
+			} else if NameKey(guild.Name) != key {
+				problem("guild %v is keyed as %v, expected %v", guild.Name, key, NameKey(guild.Name))
+			}
+		}
+		if monster := value.Monster; monster != nil && (monster.Health < 0 || monster.Health > monster.MaxHealth) {
+			problem("monster %v has health %d of %d", monster.Name, monster.Health, monster.MaxHealth)
+		}
+		for _, event := range value.Events {
+			if !event.End.After(event.Start) {
+				problem("xp event #%d ends before it starts", event.ID)
+			}
+		}
+	case *PRS:
+		if value.Version > prMigrations.Latest() {
+			problem("version %d is newer than the latest pr version %d", value.Version, prMigrations.Latest())
+		}
+		for key, lifter := range value.Lifters {
+			if lifter == nil {
+				problem("lifter %v is null", key)
+			} else if strings.ToLower(lifter.Nick) != key {
+				problem("lifter %v is keyed as %v, expected %v", lifter.Nick, key, strings.ToLower(lifter.Nick))
+			}
+		}
+	}
+	return problems
+}
+
+// Returns a line for each figure worked out from a save, eg: the number of characters and their total gold.
+func StateStats(namespace, key string) ([]string, error) {
+	data, err := readStateJSON(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeState(namespace, key, data)
+	if err != nil {
+		return nil, storeError(namespace, key, err)
+	}
+	lines := []string{fmt.Sprintf("size: %d bytes", len(data))}
+	stat := func(name string, value interface{}) {
+		lines = append(lines, fmt.Sprintf("%v: %v", name, value))
+	}
+	switch value := value.(type) {
+	case *Game:
+		stat("version", value.Version)
+		playing, highest, xp, gold, broken := 0, int64(0), int64(0), int64(0), 0
+		rarities := make([]int, len(rarityNames))
+		for _, character := range value.Characters {
+			if character == nil {
+				continue
+			}
+			if character.Level > 0 {
+				playing++
+			}
+			if character.Level > highest {
+				highest = character.Level
+			}
+			xp += character.XP
+			gold += character.Gold
+			for _, item := range character.Items {
+				if item == nil {
+					continue
+				}
+				if item.Rarity >= 0 && int(item.Rarity) < len(rarities) {
+					rarities[item.Rarity]++
+				}
+				if item.Broken() {
+					broken++
+				}
+			}
+		}
+		stat("characters", fmt.Sprintf("%d, %d playing", len(value.Characters), playing))
+		stat("highest level", highest)
+		stat("total xp", xp)
+		stat("total gold", gold)
+		items := make([]string, len(rarities))
+		for i, count := range rarities {
+			items[i] = fmt.Sprintf("%d %v", count, rarityNames[i])
+		}
+		stat("items", strings.Join(items, ", "))
+		stat("broken items", broken)
+		stat("monsters defeated", len(value.Defeated))
+		stat("tournaments", len(value.Tournaments))
+		stat("xp events", len(value.Events))
+		stat("trades", len(value.Trades))
+		stat("guilds", len(value.Guilds))
+	case *PRS:
+		stat("version", value.Version)
+		lifts, private := 0, 0
+		for _, lifter := range value.Lifters {
+			if lifter == nil {
+				continue
+			}
+			if lifter.Private {
+				private++
+			}
+			for _, history := range lifter.Lifts {
+				lifts += len(history)
+			}
+		}
+		stat("lifters", fmt.Sprintf("%d, %d private", len(value.Lifters), private))
+		stat("lifts", lifts)
+		stat("open meets", len(value.Meets))
+		stat("closed meets", len(value.MeetHistory))
+	case *KillFeed:
+		stat("kills", len(value.Kills))
+	}
+	return lines, nil
+}
+
+// Sets a field of a save, path is the dotted names of the fields, map keys and list indices leading to it, eg:
+// Characters.iopred.Gold. The value is json, anything that isn't valid json is set as a string. The save is only
+// written if it still passes CheckState, and the store is locked while it is changed, see LockStore.
+func EditState(namespace, key, path, value string) error {
+	unlock, err := LockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := readStateJSON(namespace, key)
+	if err != nil {
+		return err
+	}
+	var save interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Numbers are kept as they were written, int64s don't survive a trip through float64.
+	decoder.UseNumber()
+	if err := decoder.Decode(&save); err != nil {
+		return storeError(namespace, key, err)
+	}
+	var v interface{}
+	decoder = json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil || decoder.More() {
+		v = value
+	}
+	if err := setStatePath(save, strings.Split(path, "."), v); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	edited, err := json.Marshal(save)
+	if err != nil {
+		return err
+	}
+	if problems := checkStateJSON(namespace, key, edited); len(problems) > 0 {
+		return fmt.Errorf("Not saving, the edit leaves %v/%v with problems: %v", namespace, key, strings.Join(problems, "; "))
+	}
+	return SharedStore().Put(namespace, key, json.RawMessage(edited))
+}
+
+// Sets the value at path below parent, the last name may add a map key but the rest must already exist.
+func setStatePath(parent interface{}, path []string, v interface{}) error {
+	name := path[0]
+	switch parent := parent.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			parent[name] = v
+			return nil
+		}
+		child, ok := parent[name]
+		if !ok {
+			keys := make([]string, 0, len(parent))
+			for key, _ := range parent {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("no %v, expected one of: %v", name, strings.Join(keys, ", "))
+		}
+		return setStatePath(child, path[1:], v)
+	case []interface{}:
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 0 || index >= len(parent) {
+			return fmt.Errorf("no index %v, the list has %d items", name, len(parent))
+		}
+		if len(path) == 1 {
+			parent[index] = v
+			return nil
+		}
+		return setStatePath(parent[index], path[1:], v)
+	}
+	return fmt.Errorf("%v isn't in an object or list", name)
+}
@@ -0,0 +1,286 @@
+package septapus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var storeOptions = NewOptions("store")
+
+var storebackend = storeOptions.String("backend", "file", "Where rpg games, prs and aliases are kept, file: a json file for each under store.dir, bolt: a BoltDB database at store.path")
+var storedir = storeOptions.String("dir", ".", "Directory the file store keeps its namespaces in, eg: rpg and prs")
+var storepath = storeOptions.String("path", "septapus.db", "Database file of the bolt store")
+
+// Returned by Store.Get for a key that hasn't been put.
+var ErrNotFound = errors.New("not found")
+
+// Returned for a key that would name a file outside its namespace in the file store.
+var ErrBadKey = errors.New("keys can't be empty or contain a / \\ or ..")
+
+// Keeps json values by key in namespaces, eg: the rpg's games in rpg. Keys are only unique within a namespace, and
+// every store rejects keys with ErrBadKey that the file store can't keep, so state can move between stores.
+type Store interface {
+	// Decodes the value of a key into v, returns ErrNotFound if there isn't one.
+	Get(namespace, key string, v interface{}) error
+	// Replaces the value of a key, readers see either the old value or the new one.
+	Put(namespace, key string, v interface{}) error
+	// Replaces the values of several keys, if any can't be encoded none are replaced. Whether a failed write can leave
+	// some replaced depends on the store.
+	PutAll(namespace string, values map[string]interface{}) error
+	// Removes a key, removing a key that doesn't exist isn't an error.
+	Delete(namespace, key string) error
+	// Returns the keys in a namespace in order, an empty namespace has none.
+	List(namespace string) ([]string, error)
+}
+
+var (
+	sharedStore     Store
+	sharedStoreErr  error
+	sharedStoreOnce sync.Once
+	// Guards sharedStore for openedSharedStore, which mustn't open it.
+	sharedStoreLock sync.Mutex
+)
+
+// Opens the store picked by store.backend the first time it is called, and returns it or why it couldn't be opened.
+// There is no fallback, a bot that saved to the files while its state is in a database would lose it. Shards can't
+// share a bolt store, only one process can have the database open.
+func OpenSharedStore() (Store, error) {
+	sharedStoreOnce.Do(func() {
+		sharedStoreLock.Lock()
+		defer sharedStoreLock.Unlock()
+
+		switch *storebackend {
+		case "bolt":
+			if Sharded() {
+				sharedStoreErr = errors.New("The bolt store can't be shared by shards, use the file store.")
+				return
+			}
+			store, err := NewBoltStore(*storepath)
+			if err != nil {
+				sharedStoreErr = fmt.Errorf("Error opening bolt store %v: %v", *storepath, err)
+				return
+			}
+			sharedStore = store
+			logging.Info("Opened bolt store", *storepath)
+		case "file":
+			sharedStore = NewFileStore(*storedir)
+		default:
+			sharedStoreErr = fmt.Errorf("Unknown store backend %v, expected file or bolt.", *storebackend)
+		}
+	})
+	return sharedStore, sharedStoreErr
+}
+
+// Returns the shared store if this process has opened it, or nil.
+func openedSharedStore() Store {
+	sharedStoreLock.Lock()
+	defer sharedStoreLock.Unlock()
+
+	return sharedStore
+}
+
+// Returns the store picked by store.backend, see OpenSharedStore. If it couldn't be opened every call on the returned
+// store fails with the reason, nothing is saved anywhere else.
+func SharedStore() Store {
+	store, err := OpenSharedStore()
+	if err != nil {
+		return &failedStore{err}
+	}
+	return store
+}
+
+// A store that couldn't be opened.
+type failedStore struct {
+	err error
+}
+
+func (store *failedStore) Get(namespace, key string, v interface{}) error { return store.err }
+func (store *failedStore) Put(namespace, key string, v interface{}) error { return store.err }
+func (store *failedStore) PutAll(namespace string, values map[string]interface{}) error {
+	return store.err
+}
+func (store *failedStore) Delete(namespace, key string) error      { return store.err }
+func (store *failedStore) List(namespace string) ([]string, error) { return nil, store.err }
+
+// Keeps each value in its own file, dir/namespace/key.json. Values are written to a temporary file first and renamed
+// over the old one.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir}
+}
+
+// Returns an error if a key would name a file outside its namespace, eg: ../settings/ops.
+func checkStoreKey(namespace, key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.Contains(key, "..") {
+		return storeError(namespace, key, ErrBadKey)
+	}
+	return nil
+}
+
+func (store *FileStore) filename(namespace, key string) string {
+	return filepath.Join(store.dir, namespace, key+".json")
+}
+
+func (store *FileStore) Get(namespace, key string, v interface{}) error {
+	if err := checkStoreKey(namespace, key); err != nil {
+		return err
+	}
+	file, err := os.Open(store.filename(namespace, key))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(v)
+}
+
+// Writes a value to a new temporary file next to its file, returns the temporary file's name. Each write has its own
+// temporary file, so two writes of a key at once don't write into the same one.
+func (store *FileStore) writeTemp(namespace, key string, v interface{}) (string, error) {
+	filename := store.filename(namespace, key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmp := file.Name()
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+func (store *FileStore) Put(namespace, key string, v interface{}) error {
+	return store.PutAll(namespace, map[string]interface{}{key: v})
+}
+
+// Writes every value to a temporary file before replacing any, so a value that can't be encoded or written replaces
+// nothing. The files are then renamed over the old ones one at a time, which isn't atomic: if a rename fails, the
+// values renamed before it stay replaced and the rest keep their old values.
+func (store *FileStore) PutAll(namespace string, values map[string]interface{}) error {
+	for key, _ := range values {
+		if err := checkStoreKey(namespace, key); err != nil {
+			return err
+		}
+	}
+	tmps := make(map[string]string)
+	for key, v := range values {
+		tmp, err := store.writeTemp(namespace, key, v)
+		if err != nil {
+			for _, tmp := range tmps {
+				os.Remove(tmp)
+			}
+			return err
+		}
+		tmps[key] = tmp
+	}
+	for key, tmp := range tmps {
+		if err := os.Rename(tmp, store.filename(namespace, key)); err != nil {
+			for _, tmp := range tmps {
+				os.Remove(tmp)
+			}
+			return storeError(namespace, key, err)
+		}
+		delete(tmps, key)
+	}
+	return nil
+}
+
+func (store *FileStore) Delete(namespace, key string) error {
+	if err := checkStoreKey(namespace, key); err != nil {
+		return err
+	}
+	if err := os.Remove(store.filename(namespace, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *FileStore) List(namespace string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(store.dir, namespace))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(files))
+	for _, info := range files {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			keys = append(keys, strings.TrimSuffix(info.Name(), ".json"))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Returns an error naming the value that couldn't be stored.
+func storeError(namespace, key string, err error) error {
+	return fmt.Errorf("%v/%v: %w", namespace, key, err)
+}
+
+// Returns the file held by the process writing the store, see LockStore.
+func storeLockPath() string {
+	return filepath.Join(*storedir, "septapus.lock")
+}
+
+// Takes the store for this process, so the bot and septapus state don't write the same saves at once. The lock file
+// holds our pid, a lock left by a process that has exited is taken over. Returns a func that releases the lock.
+func LockStore() (func(), error) {
+	filename := storeLockPath()
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintln(file, os.Getpid())
+			file.Close()
+			return func() { os.Remove(filename) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && processRunning(pid) {
+			return nil, fmt.Errorf("The store is locked by process %d, stop the bot first. Remove %v if it isn't running.", pid, filename)
+		}
+		logging.Info("Taking over the store lock left by process", pid)
+		os.Remove(filename)
+	}
+	return nil, fmt.Errorf("Couldn't lock the store, %v keeps being created.", filename)
+}
+
+// Returns true if a process with the pid is running.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
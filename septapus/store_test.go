@@ -0,0 +1,46 @@
+package septapus
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreKeys(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "store"))
+
+	for _, key := range []string{"", "../settings", "a/b", `a\b`, ".."} {
+		if err := store.Put("rpg", key, 1); !errors.Is(err, ErrBadKey) {
+			t.Errorf("Put(%q) = %v, want ErrBadKey", key, err)
+		}
+		if err := store.Get("rpg", key, new(int)); !errors.Is(err, ErrBadKey) {
+			t.Errorf("Get(%q) = %v, want ErrBadKey", key, err)
+		}
+	}
+	// A bad key replaces none of the others.
+	if err := store.PutAll("rpg", map[string]interface{}{"synirc": 1, "../synirc": 2}); !errors.Is(err, ErrBadKey) {
+		t.Errorf("PutAll() = %v, want ErrBadKey", err)
+	}
+	if err := store.Get("rpg", "synirc", new(int)); err != ErrNotFound {
+		t.Errorf("Get(synirc) = %v, want ErrNotFound", err)
+	}
+
+	if err := store.PutAll("rpg", map[string]interface{}{"synirc": 1, "freenode": 2}); err != nil {
+		t.Fatalf("PutAll() = %v", err)
+	}
+	value := 0
+	if err := store.Get("rpg", "freenode", &value); err != nil || value != 2 {
+		t.Errorf("Get(freenode) = %v, %v, want 2", value, err)
+	}
+	// No temporary files are left behind.
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "store", "rpg"))
+	if len(files) != 2 {
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("rpg holds %v, want synirc.json and freenode.json", names)
+	}
+}
@@ -0,0 +1,110 @@
+package septapus
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Keeps every namespace as a bucket in one BoltDB database, each write is a transaction.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// Opens or creates the database, failing if another process has it open.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db}, nil
+}
+
+func (store *BoltStore) Close() error {
+	return store.db.Close()
+}
+
+// Writes a consistent copy of the database from one read transaction, so writes carry on while it is copied. header
+// is called with the copy's size and returns where to write it.
+func (store *BoltStore) Snapshot(header func(size int64) (io.Writer, error)) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		w, err := header(tx.Size())
+		if err != nil {
+			return err
+		}
+		_, err = tx.WriteTo(w)
+		return err
+	})
+}
+
+func (store *BoltStore) Get(namespace, key string, v interface{}) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(namespace))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+func (store *BoltStore) Put(namespace, key string, v interface{}) error {
+	return store.PutAll(namespace, map[string]interface{}{key: v})
+}
+
+func (store *BoltStore) PutAll(namespace string, values map[string]interface{}) error {
+	encoded := make(map[string][]byte)
+	for key, v := range values {
+		if err := checkStoreKey(namespace, key); err != nil {
+			return err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return storeError(namespace, key, err)
+		}
+		encoded[key] = data
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(namespace))
+		if err != nil {
+			return err
+		}
+		for key, data := range encoded {
+			if err := bucket.Put([]byte(key), data); err != nil {
+				return storeError(namespace, key, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (store *BoltStore) Delete(namespace, key string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(namespace))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// Keys are kept in byte order, which is the order they are listed in.
+func (store *BoltStore) List(namespace string) ([]string, error) {
+	keys := []string{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(namespace))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
@@ -0,0 +1,147 @@
+package septapus
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var traceCommand = NewCommand("!trace <setting>").WithHelp("Admins only, logs the path of events through the plugins, on or off.")
+
+var (
+	// Non zero while tracing, read on every broadcast so it is atomic rather than locked.
+	tracing  int32
+	eventIDs uint64
+)
+
+// Returns true if events are being traced.
+func IsTracing() bool {
+	return atomic.LoadInt32(&tracing) != 0
+}
+
+func SetTracing(on bool) {
+	value := int32(0)
+	if on {
+		value = 1
+	}
+	atomic.StoreInt32(&tracing, value)
+}
+
+// Gives an event an id the first time it is traced, events rebroadcast by aliases get their own id.
+func traceID(event *Event) uint64 {
+	if event.ID == 0 {
+		event.ID = atomic.AddUint64(&eventIDs, 1)
+	}
+	return event.ID
+}
+
+func tracef(event *Event, format string, args ...interface{}) {
+	logging.Info(fmt.Sprintf("trace #%d ", event.ID) + fmt.Sprintf(format, args...))
+}
+
+func traceEvent(event *Event) {
+	traceID(event)
+	text := ""
+	if event.Line != nil {
+		text = event.Line.Cmd + " " + event.Line.Nick + " " + event.Line.Text()
+	}
+	tracef(event, "%v %v: %v", event.Server.Name, event.Room, strings.TrimSpace(text))
+}
+
+// Returns a readable name for a subscriber, the plugin name for named settings.
+func subscriberName(subscriber Subscriber) string {
+	if subscriber == nil {
+		return "bot"
+	}
+	if s, ok := subscriber.(*PluginSettings); ok && s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%T", subscriber)
+}
+
+// Returns the name of a predicate's function, eg: septapus.IsCommand.func1.
+func predicateName(predicate EventPredicate) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(predicate).Pointer()); f != nil {
+		name := f.Name()
+		return name[strings.LastIndex(name, "/")+1:]
+	}
+	return "predicate"
+}
+
+// Returns true if one of the predicates is a command or regex that the event doesn't match.
+func waitingForCommand(event *Event, predicates []EventPredicate) bool {
+	for _, predicate := range predicates {
+		name := predicateName(predicate)
+		for _, command := range []string{".IsCommand.", ".IsSimpleCommand.", ".IsRegex."} {
+			if strings.Contains(name, command) && !predicate(event) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the first predicate that rejects the event, or -1 if they all pass.
+func failedPredicate(event *Event, predicates []EventPredicate) int {
+	for i, predicate := range predicates {
+		if !predicate(event) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Broadcasts an event while logging its path through subscribers and predicates, and how long each handler took to accept it.
+// The dispatcher lock must be held.
+func (e *EventDispatcher) traceBroadcast(event *Event) {
+	traceEvent(event)
+	start := time.Now()
+	delivered := 0
+	for subscriber, sub := range e.subscriptions {
+		name := subscriberName(subscriber)
+		if !sub.allows(event) {
+			tracef(event, "%v: not allowed in %v %v", name, event.Server.Name, event.Room)
+			continue
+		}
+		for channel, predicates := range sub.channels {
+			if i := failedPredicate(event, predicates); i != -1 {
+				// Most handlers are waiting for another command, those are left out to keep the trace readable.
+				if !waitingForCommand(event, predicates) {
+					tracef(event, "%v: rejected by %v (%d of %d)", name, predicateName(predicates[i]), i+1, len(predicates))
+				}
+				continue
+			}
+			sent := time.Now()
+			channel <- event
+			delivered++
+			tracef(event, "%v: delivered in %v, %d queued", name, time.Since(sent), len(channel))
+		}
+	}
+	tracef(event, "dispatched to %d handlers in %v", delivered, time.Since(start))
+}
+
+// Turns event tracing on and off with !trace on|off, only admins can trace.
+func TracePlugin(bot *Bot, settings *PluginSettings) {
+	channel := bot.HandleCommand(traceCommand)
+	for event := range channel {
+		if !IsAdmin(event) {
+			continue
+		}
+		args, err := traceCommand.Parse(event.Line.Text())
+		setting := ""
+		if err == nil {
+			setting = strings.ToLower(args.String("setting"))
+		}
+		if setting != "on" && setting != "off" {
+			event.Server.Privmsg(event.Line.Nick, "Bad setting, use !trace on or !trace off.")
+			continue
+		}
+		SetTracing(setting == "on")
+		event.Server.Privmsg(event.Line.Nick, "Tracing "+setting+", events are logged with their path through each plugin.")
+	}
+}
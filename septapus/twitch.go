@@ -0,0 +1,271 @@
+package septapus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var twitchOptions = NewOptions("twitch")
+
+var twitchstreams = twitchOptions.String("streams", "", "Comma separated list of Twitch streamers to announce when they go live, eg: streamer=server/#room")
+var twitchinterval = twitchOptions.Duration("interval", 2*time.Minute, "How often followed Twitch streamers are checked")
+var twitchcooldown = twitchOptions.Duration("cooldown", 30*time.Minute, "Streams that go live again within this long of being announced aren't announced again")
+var twitchclientid = twitchOptions.String("clientid", "", "Twitch application client id")
+var twitchclientsecret = twitchOptions.String("clientsecret", "", "Twitch application client secret")
+var twitchtemplate = twitchOptions.String("template", "{{.Name}} is live: {{.Title}} ({{.Game}}, up {{.Uptime}}) https://twitch.tv/{{.Login}}", "Template used to announce live streams")
+
+type TwitchTarget struct {
+	Server ServerName
+	Room   RoomName
+}
+
+type TwitchAnnouncement struct {
+	Login  string
+	Name   string
+	Title  string
+	Game   string
+	Uptime string
+}
+
+type twitchStream struct {
+	UserLogin string    `json:"user_login"`
+	UserName  string    `json:"user_name"`
+	GameName  string    `json:"game_name"`
+	Title     string    `json:"title"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type TwitchWatcher struct {
+	Login   string
+	Targets []*TwitchTarget
+
+	live      bool
+	announced time.Time
+}
+
+// Parses a list of streamer=server/#room mappings. A streamer may be mapped to several rooms.
+func ParseTwitchWatchers(str string) ([]*TwitchWatcher, error) {
+	watchers := make([]*TwitchWatcher, 0)
+	byLogin := make(map[string]*TwitchWatcher)
+	for _, mapping := range strings.Split(str, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad streamer mapping: %v", mapping)
+		}
+		target := strings.SplitN(parts[1], "/", 2)
+		if len(target) != 2 || target[0] == "" || target[1] == "" {
+			return nil, fmt.Errorf("Bad streamer target: %v", parts[1])
+		}
+		login := strings.ToLower(parts[0])
+		watcher := byLogin[login]
+		if watcher == nil {
+			watcher = &TwitchWatcher{Login: login}
+			byLogin[login] = watcher
+			watchers = append(watchers, watcher)
+		}
+		watcher.Targets = append(watcher.Targets, &TwitchTarget{ServerName(target[0]), RoomName(target[1])})
+	}
+	return watchers, nil
+}
+
+// Returns how long a stream has been live, eg: 2h05m.
+func FormatUptime(started time.Time) string {
+	uptime := time.Since(started)
+	if uptime < time.Minute {
+		return "just now"
+	}
+	return fmt.Sprintf("%dh%02dm", int(uptime.Hours()), int(uptime.Minutes())%60)
+}
+
+// An app access token, fetched with the client id and secret and refreshed when it expires.
+type twitchToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var twitchAuth = &twitchToken{}
+
+func (t *twitchToken) get() (string, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	if *twitchclientid == "" || *twitchclientsecret == "" {
+		return "", errors.New("A Twitch client id and secret are required.")
+	}
+	resp, err := http.PostForm("https://id.twitch.tv/oauth2/token", url.Values{
+		"client_id":     {*twitchclientid},
+		"client_secret": {*twitchclientsecret},
+		"grant_type":    {"client_credentials"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected status from Twitch auth: %v", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	t.token = token.AccessToken
+	// Refreshed a little early so a poll never races the expiry.
+	t.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
+}
+
+func (t *twitchToken) clear() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.token = ""
+}
+
+// Returns the live streams of the watched streamers, keyed by login.
+func twitchLiveStreams(watchers []*TwitchWatcher) (map[string]*twitchStream, error) {
+	token, err := twitchAuth.get()
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	for _, watcher := range watchers {
+		query.Add("user_login", watcher.Login)
+	}
+	req, err := http.NewRequest("GET", "https://api.twitch.tv/helix/streams?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Client-Id", *twitchclientid)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := SharedFetcher().Do(req, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		twitchAuth.clear()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %v from Twitch", resp.StatusCode)
+	}
+	var streams struct {
+		Data []*twitchStream `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body, &streams); err != nil {
+		return nil, err
+	}
+	live := make(map[string]*twitchStream)
+	for _, stream := range streams.Data {
+		live[strings.ToLower(stream.UserLogin)] = stream
+	}
+	return live, nil
+}
+
+// Updates the watcher with its stream, returning the announcement if it has just gone live.
+// Streams that were live on the first poll, or that come back within the cooldown, aren't announced.
+func (watcher *TwitchWatcher) Update(stream *twitchStream, seeded bool) (string, error) {
+	wasLive := watcher.live
+	watcher.live = stream != nil
+	if !watcher.live || wasLive || !seeded {
+		if watcher.live && !seeded {
+			watcher.announced = time.Now()
+		}
+		return "", nil
+	}
+	if time.Since(watcher.announced) < *twitchcooldown {
+		logging.Debug("Not announcing", watcher.Login, "again within the cooldown")
+		return "", nil
+	}
+	watcher.announced = time.Now()
+	return executeTwitchTemplate(&TwitchAnnouncement{watcher.Login, stream.UserName, stream.Title, stream.GameName, FormatUptime(stream.StartedAt)})
+}
+
+func executeTwitchTemplate(announcement *TwitchAnnouncement) (string, error) {
+	t, err := template.New("twitch").Parse(*twitchtemplate)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, announcement); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func NewTwitchPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(TwitchPlugin, settings)
+}
+
+// Announces when followed Twitch streamers go live.
+func TwitchPlugin(bot *Bot, settings *PluginSettings) {
+	watchers, err := ParseTwitchWatchers(*twitchstreams)
+	if err != nil {
+		logging.Error("Error parsing twitch streamers:", err)
+		return
+	}
+	if len(watchers) == 0 {
+		return
+	}
+
+	seeded := false
+	poll := func() {
+		// Helix takes at most 100 logins a request.
+		for start := 0; start < len(watchers); start += 100 {
+			end := start + 100
+			if end > len(watchers) {
+				end = len(watchers)
+			}
+			live, err := twitchLiveStreams(watchers[start:end])
+			if err != nil {
+				ReportError("twitch", "Error polling twitch streams", err)
+				return
+			}
+			for _, watcher := range watchers[start:end] {
+				message, err := watcher.Update(live[watcher.Login], seeded)
+				if err != nil {
+					ReportError("twitch", "Error executing twitch template:", err)
+					continue
+				}
+				if message == "" {
+					continue
+				}
+				for _, target := range watcher.Targets {
+					if !settings.IsAllowed(target.Server, target.Room) {
+						continue
+					}
+					server := bot.GetServer(target.Server)
+					if server == nil || server.Conn == nil || !server.Conn.Connected() {
+						continue
+					}
+					settings.Privmsg(server, string(target.Room), message)
+				}
+			}
+		}
+		seeded = true
+	}
+
+	poll()
+	for _ = range time.Tick(*twitchinterval) {
+		poll()
+	}
+}
@@ -0,0 +1,300 @@
+package septapus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var uploadOptions = NewOptions("upload")
+
+var uploaddir = uploadOptions.String("dir", "uploads", "Directory comics and rpg pages are spooled to when they can't be uploaded, they are retried in the order they were made")
+var uploadretry = uploadOptions.Duration("retry", 30*time.Second, "Delay before spooled uploads are retried, doubled after each failed retry")
+var uploadretrymax = uploadOptions.Duration("retrymax", 30*time.Minute, "Longest delay between retries of spooled uploads")
+var uploadqueuesize = uploadOptions.Int("queuesize", 500, "Most uploads spooled, the oldest is dropped when there are more")
+
+var uploadQueueCommand = NewCommand("!uploadqueue").WithHelp("Admins only, shows the uploads waiting to be retried.")
+
+// An upload waiting to be retried, saved in uploaddir.
+type spooledUpload struct {
+	ID string
+	// Uploads with the same key replace each other, eg: an rpg page uploaded again before the last upload got through.
+	Key         string `json:",omitempty"`
+	Description string
+	URL         string
+	ContentType string
+	Body        []byte
+	Queued      time.Time
+}
+
+// Posts uploads to the comic and rpg servers, spooling the ones that fail to disk to retry with backoff in order.
+type UploadQueue struct {
+	sync.Mutex
+	// The spooled uploads in the order they were made, the ids of files in uploaddir.
+	pending  []string
+	keys     map[string]string
+	last     string
+	retrying bool
+	next     time.Time
+	lastErr  error
+}
+
+var (
+	uploadQueue     *UploadQueue
+	uploadQueueOnce sync.Once
+)
+
+// Returns the upload queue shared by every plugin, retrying anything spooled before we were last stopped.
+func SharedUploadQueue() *UploadQueue {
+	uploadQueueOnce.Do(func() {
+		uploadQueue = &UploadQueue{keys: make(map[string]string)}
+		uploadQueue.load()
+	})
+	return uploadQueue
+}
+
+func (queue *UploadQueue) load() {
+	queue.Lock()
+	defer queue.Unlock()
+
+	files, err := ioutil.ReadDir(*uploaddir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		upload, err := readSpooledUpload(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			ReportError("upload", "Error reading spooled upload", file.Name(), err)
+			continue
+		}
+		queue.pending = append(queue.pending, upload.ID)
+		if upload.Key != "" {
+			queue.keys[upload.Key] = upload.ID
+		}
+		queue.last = upload.ID
+	}
+	sort.Strings(queue.pending)
+	if len(queue.pending) > 0 {
+		logging.Info("Retrying spooled uploads", len(queue.pending))
+		queue.retrying = true
+		go queue.retry()
+	}
+}
+
+func spooledUploadFilename(id string) string {
+	return filepath.Join(*uploaddir, id+".json")
+}
+
+func readSpooledUpload(id string) (*spooledUpload, error) {
+	data, err := ioutil.ReadFile(spooledUploadFilename(id))
+	if err != nil {
+		return nil, err
+	}
+	upload := &spooledUpload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Posts an upload, returns an error if the server couldn't be reached or failed and the upload should be retried.
+func postUpload(url, contentType string, body []byte) error {
+	resp, err := http.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%v returned %v", url, resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		// Retrying won't change the answer, eg: a bad key.
+		ReportError("upload", "Upload rejected by", url, resp.Status)
+	}
+	return nil
+}
+
+// Uploads a body to url, spooling it to retry later if the server can't be reached. Uploads made while others are
+// spooled are spooled behind them, so they are received in order. A spooled upload with the same key is replaced.
+// Returns nil if the upload was sent now.
+func (queue *UploadQueue) Upload(description, key, url, contentType string, body []byte) error {
+	queue.Lock()
+	spooled := len(queue.pending) > 0
+	queue.Unlock()
+
+	if !spooled {
+		err := postUpload(url, contentType, body)
+		if err == nil {
+			return nil
+		}
+		ReportError("upload", "Error uploading, spooling", description, err)
+		queue.Lock()
+		queue.lastErr = err
+		queue.Unlock()
+	}
+	queue.spool(&spooledUpload{Key: key, Description: description, URL: url, ContentType: contentType, Body: body, Queued: time.Now()})
+	return fmt.Errorf("Spooled %v to retry.", description)
+}
+
+func (queue *UploadQueue) spool(upload *spooledUpload) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	// Ids sort in the order uploads were made.
+	upload.ID = fmt.Sprintf("%020d", upload.Queued.UnixNano())
+	if upload.ID <= queue.last {
+		var last int64
+		fmt.Sscanf(queue.last, "%d", &last)
+		upload.ID = fmt.Sprintf("%020d", last+1)
+	}
+	queue.last = upload.ID
+
+	if err := os.MkdirAll(*uploaddir, 0755); err != nil {
+		ReportError("upload", "Error creating upload directory, dropping", upload.Description, err)
+		return
+	}
+	data, err := json.Marshal(upload)
+	if err == nil {
+		err = ioutil.WriteFile(spooledUploadFilename(upload.ID), data, 0644)
+	}
+	if err != nil {
+		ReportError("upload", "Error spooling, dropping", upload.Description, err)
+		return
+	}
+
+	if upload.Key != "" {
+		if replaced, ok := queue.keys[upload.Key]; ok {
+			queue.remove(replaced)
+		}
+		queue.keys[upload.Key] = upload.ID
+	}
+	queue.pending = append(queue.pending, upload.ID)
+	for len(queue.pending) > *uploadqueuesize {
+		logging.Warn("Upload queue full, dropping", queue.pending[0])
+		queue.remove(queue.pending[0])
+	}
+	if !queue.retrying {
+		queue.retrying = true
+		go queue.retry()
+	}
+}
+
+// Removes a spooled upload, the queue must be locked.
+func (queue *UploadQueue) remove(id string) {
+	for i, pending := range queue.pending {
+		if pending == id {
+			queue.pending = append(queue.pending[:i], queue.pending[i+1:]...)
+			break
+		}
+	}
+	for key, keyed := range queue.keys {
+		if keyed == id {
+			delete(queue.keys, key)
+		}
+	}
+	if err := os.Remove(spooledUploadFilename(id)); err != nil && !os.IsNotExist(err) {
+		ReportError("upload", "Error removing spooled upload", id, err)
+	}
+}
+
+// Retries the spooled uploads with backoff until they have all been sent.
+func (queue *UploadQueue) retry() {
+	delay := *uploadretry
+	for {
+		queue.Lock()
+		queue.next = time.Now().Add(delay)
+		queue.Unlock()
+
+		time.Sleep(delay)
+		if queue.flush() {
+			return
+		}
+		if delay *= 2; delay > *uploadretrymax {
+			delay = *uploadretrymax
+		}
+	}
+}
+
+// Sends spooled uploads oldest first, stopping at the first that fails so the rest stay in order.
+// Returns true once the queue is empty.
+func (queue *UploadQueue) flush() bool {
+	for {
+		queue.Lock()
+		if len(queue.pending) == 0 {
+			queue.retrying = false
+			queue.lastErr = nil
+			queue.Unlock()
+			return true
+		}
+		id := queue.pending[0]
+		queue.Unlock()
+
+		upload, err := readSpooledUpload(id)
+		if err != nil {
+			ReportError("upload", "Error reading spooled upload, dropping", id, err)
+		} else if err := postUpload(upload.URL, upload.ContentType, upload.Body); err != nil {
+			logging.Info("Retrying spooled upload failed", upload.Description, err)
+			queue.Lock()
+			queue.lastErr = err
+			queue.Unlock()
+			return false
+		} else {
+			logging.Info("Uploaded spooled", upload.Description)
+		}
+
+		queue.Lock()
+		queue.remove(id)
+		queue.Unlock()
+	}
+}
+
+// Describes the queue for !uploadqueue.
+func (queue *UploadQueue) Status() []string {
+	queue.Lock()
+	defer queue.Unlock()
+
+	if len(queue.pending) == 0 {
+		return []string{"No uploads waiting."}
+	}
+	wait := queue.next.Sub(time.Now())
+	if wait < 0 {
+		wait = 0
+	}
+	lines := []string{fmt.Sprintf("%d uploads waiting, retrying in %v.", len(queue.pending), DurationString(wait))}
+	if queue.lastErr != nil {
+		lines = append(lines, "Last error: "+queue.lastErr.Error())
+	}
+	if upload, err := readSpooledUpload(queue.pending[0]); err == nil {
+		lines = append(lines, fmt.Sprintf("Oldest: %v, waiting %v.", upload.Description, DurationString(time.Since(upload.Queued))))
+	}
+	return lines
+}
+
+func NewUploadQueuePlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(UploadQueuePlugin, settings)
+}
+
+// Tells admins how many uploads are spooled with !uploadqueue.
+func UploadQueuePlugin(bot *Bot, settings *PluginSettings) {
+	queue := SharedUploadQueue()
+	channel := settings.HandleCommand(bot, uploadQueueCommand)
+	for event := range channel {
+		if !IsAdmin(event) {
+			continue
+		}
+		PrivmsgLines(event.Server, event.Line.Nick, queue.Status())
+	}
+}
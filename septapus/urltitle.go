@@ -0,0 +1,143 @@
+package septapus
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var urlOptions = NewOptions("url")
+
+var urlrobots = urlOptions.Bool("robots", false, "Don't fetch titles for pages that robots.txt disallows")
+var urlrefreshes = urlOptions.Int("refreshes", 3, "Most meta refresh redirects followed when fetching a title")
+var urlloginfallback = urlOptions.String("loginfallback", "", "Sent instead of a title for pages behind a login, eg: (login required). Nothing is sent if empty")
+
+var (
+	metaRefreshPattern = regexp.MustCompile(`(?i)<meta[^>]+http-equiv=["']?refresh["']?[^>]*content=["']?\s*\d*\s*;\s*url=['"]?([^"'>\s]+)`)
+	loginPathPattern   = regexp.MustCompile(`(?i)/(login|log-in|signin|sign-in|sign_in|auth|session)(/|\?|$|\.)`)
+	passwordPattern    = regexp.MustCompile(`(?i)<input[^>]+type=["']?password`)
+)
+
+// Returned by FetchTitle for pages behind a login.
+var ErrLoginRequired = errors.New("Login required.")
+
+// Returned by FetchTitle for pages robots.txt disallows.
+var ErrDisallowed = errors.New("Disallowed by robots.txt.")
+
+// Returns the title of a page, following meta refreshes and checking robots.txt if urlrobots is set.
+// Returns ErrLoginRequired if the page is behind a login, and an empty title if the page has none.
+func FetchTitle(link string) (string, error) {
+	for refreshes := 0; ; refreshes++ {
+		if *urlrobots && !RobotsAllowed(link) {
+			return "", ErrDisallowed
+		}
+		resp, err := SharedFetcher().Get(link)
+		if err != nil {
+			return "", err
+		}
+		if isLoginWall(resp) {
+			return "", ErrLoginRequired
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			return "", nil
+		}
+		contents := strings.Replace(string(resp.Body), "\n", "", -1)
+		if refresh := metaRefreshPattern.FindStringSubmatch(contents); refresh != nil && refreshes < *urlrefreshes {
+			if next := resolveURL(resp.URL, html.UnescapeString(refresh[1])); next != "" && next != link {
+				link = next
+				continue
+			}
+		}
+		if title := titlePattern.FindStringSubmatch(contents); title != nil {
+			return strings.TrimSpace(html.UnescapeString(title[1])), nil
+		}
+		return "", nil
+	}
+}
+
+// Returns true if a response looks like a login page rather than the page that was asked for.
+func isLoginWall(resp *FetchResponse) bool {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return true
+	}
+	if u, err := url.Parse(resp.URL); err == nil && loginPathPattern.MatchString(u.Path) {
+		return true
+	}
+	return passwordPattern.Match(resp.Body)
+}
+
+// Returns the absolute url of a link found on a page, or an empty string if either can't be parsed.
+func resolveURL(base, link string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	l, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return b.ResolveReference(l).String()
+}
+
+// Returns true if robots.txt allows the bot to fetch a url. Missing or unreadable robots.txt files allow everything.
+func RobotsAllowed(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	resp, err := SharedFetcher().Get(u.Scheme + "://" + u.Host + "/robots.txt")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return true
+	}
+	path := u.RequestURI()
+	allowed, longest := true, -1
+	for _, rule := range robotsRules(string(resp.Body)) {
+		if rule.path != "" && strings.HasPrefix(path, rule.path) && len(rule.path) > longest {
+			allowed, longest = rule.allow, len(rule.path)
+		}
+	}
+	return allowed
+}
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+// Returns the rules in robots.txt for the bot, the bot's own group wins over the * group.
+func robotsRules(robots string) []robotsRule {
+	groups := make(map[string][]robotsRule)
+	agents := []string{}
+	inRules := false
+	for _, line := range strings.Split(robots, "\n") {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		switch key {
+		case "user-agent":
+			// A user-agent after rules starts a new group.
+			if inRules {
+				agents = []string{}
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			for _, agent := range agents {
+				groups[agent] = append(groups[agent], robotsRule{key == "allow", value})
+			}
+		}
+	}
+	if rules, ok := groups[fetchUserAgent]; ok {
+		return rules
+	}
+	return groups["*"]
+}
@@ -0,0 +1,153 @@
+package septapus
+
+import (
+	"fmt"
+	"html/template"
+	"image/color"
+	"net/url"
+	"strings"
+)
+
+var webOptions = NewOptions("web")
+
+var websiteurl = webOptions.String("siteurl", "http://septapus.com/", "Public url of the site the rpg pages are uploaded to, generated pages link to its stylesheet, images and each other")
+
+// The rooms with a saved game, set while ExportSite renders so pages link to each other within the exported site.
+var staticSite siteRooms
+
+// A link in a generated page's navigation, Current is the page being shown.
+type WebLink struct {
+	Name    string
+	URL     string
+	Current bool
+}
+
+// A page in the shared layout. Data is the dot of the page's content template.
+type WebPage struct {
+	Title string
+	// Extra stylesheets after the site's, eg: the rpg's generated classes.
+	Styles []string
+	// Atom feeds of the page.
+	Feeds []WebLink
+	Nav   []WebLink
+	Data  interface{}
+}
+
+var webLayout = template.Must(template.New("layout").Funcs(template.FuncMap{"siteURL": siteURL}).Parse(webLayoutSource))
+
+// Pages define content, and can define head and footer to add to them.
+const webLayoutSource = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+	<head>
+		<title>{{.Title}}</title>
+		<meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+		<link rel="stylesheet" href="{{siteURL "css/septapus.css"}}" type="text/css" media="screen">
+		{{range .Styles}}<link rel="stylesheet" href="{{.}}" type="text/css" media="screen">
+		{{end}}<link rel="shortcut icon" href="{{siteURL "images/favicon.png"}}">
+		{{range .Feeds}}<link rel="alternate" type="application/atom+xml" title="{{.Name}}" href="{{.URL}}">
+		{{end}}{{block "head" .Data}}{{end}}
+	</head>
+	<body>
+		<div class="title"><img src="{{siteURL "images/Septapus.png"}}" alt="Septapus"></div>
+		{{if .Nav}}<p class="nav">{{range $i, $link := .Nav}}{{if $i}} | {{end}}{{if $link.Current}}<b>{{$link.Name}}</b>{{else}}<a href="{{$link.URL}}">{{$link.Name}}</a>{{end}}{{end}}</p>
+		{{end}}{{template "content" .Data}}
+		{{block "footer" .Data}}{{end}}
+	</body>
+</html>
+`
+
+// Returns a template that renders content, which must define "content", in the shared layout. Execute it with a *WebPage.
+func NewWebTemplate(content string) *template.Template {
+	layout := template.Must(webLayout.Clone())
+	return template.Must(layout.Parse(content))
+}
+
+// Returns the public url of a file on the site.
+func siteURL(path string) string {
+	return strings.TrimRight(*websiteurl, "/") + "/" + path
+}
+
+// A page every room can have, url returns an empty string when a room doesn't have one.
+type roomPage struct {
+	name string
+	url  func(server ServerName, room RoomName) string
+}
+
+// The pages linked between in a room's navigation, in order.
+var roomPages = []roomPage{
+	{"RPG", func(server ServerName, room RoomName) string {
+		if room == "" || (staticSite != nil && !staticSite[server][room]) {
+			return ""
+		}
+		game := &Game{Server: server, Room: room}
+		return game.PageURL()
+	}},
+	{"Live", func(server ServerName, room RoomName) string {
+		if room == "" || staticSite != nil || *pasteaddr == "" || !LiveEnabled(server, room) {
+			return ""
+		}
+		return strings.TrimRight(*pastebaseurl, "/") + "/rpg/live/" + string(server) + "/" + strings.TrimPrefix(string(room), "#")
+	}},
+	{"Comics", func(server ServerName, room RoomName) string {
+		if room != "" && staticSite != nil {
+			return siteURL("comics/" + roomPath(server, room) + "/index.html")
+		}
+		if room == "" || *comicgalleryurl == "" {
+			return ""
+		}
+		return *comicgalleryurl + "?" + url.Values{"server": {string(server)}, "room": {string(room)}}.Encode()
+	}},
+	{"Meets", func(server ServerName, room RoomName) string {
+		if staticSite != nil {
+			return siteURL("pr/meets/" + string(server) + ".html")
+		}
+		return MeetsURL(server)
+	}},
+	{"Lifters", func(server ServerName, room RoomName) string {
+		if staticSite == nil {
+			return ""
+		}
+		return siteURL("pr/" + string(server) + ".html")
+	}},
+}
+
+// Returns a server and room as one path element, eg: synirc:septapus.
+func roomPath(server ServerName, room RoomName) string {
+	return strings.Replace(string(server)+string(room), "#", ":", -1)
+}
+
+// Returns the navigation between a room's pages, current is the name of the page being shown. The room is empty for
+// pages about a whole server.
+func RoomNav(server ServerName, room RoomName, current string) []WebLink {
+	links := make([]WebLink, 0, len(roomPages))
+	for _, page := range roomPages {
+		if url := page.url(server, room); url != "" {
+			links = append(links, WebLink{page.name, url, page.name == current})
+		}
+	}
+	return links
+}
+
+// Returns a class for each step of a color ramp, eg: .health0 to .health200, style writes the declarations for a color.
+func CSSRampClasses(name string, steps int, colors []color.Color, ratios []float64, style func(ratio float64, color template.CSS) string) string {
+	str := ""
+	for i := 0; i <= steps; i++ {
+		ratio := float64(i) / float64(steps)
+		str += fmt.Sprintf(".%v%d { %v }\n", name, i, style(ratio, lerpColorString(ratio, colors, ratios)))
+	}
+	return str
+}
+
+// Returns a class for each color, eg: .item0 to .item5.
+func CSSColorClasses(name string, colors []color.Color) string {
+	str := ""
+	for i, c := range colors {
+		str += fmt.Sprintf(".%v%d { color: %v; }\n", name, i, colorString(c))
+	}
+	return str
+}
+
+// A CSSRampClasses style that sets the text color.
+func cssColor(ratio float64, color template.CSS) string {
+	return fmt.Sprintf("color: %v;", color)
+}
@@ -0,0 +1,183 @@
+package septapus
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	websocketText  = 0x1
+	websocketClose = 0x8
+	websocketPing  = 0x9
+	websocketPong  = 0xA
+
+	// Browsers only send us control frames, so anything larger is a misbehaving client.
+	maxWebSocketPayload = 4096
+
+	websocketWriteTimeout = 10 * time.Second
+)
+
+// A minimal server side websocket, enough to push text messages to a browser.
+type WebSocket struct {
+	sync.Mutex
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	closed chan bool
+	once   sync.Once
+}
+
+// Upgrades an http request to a websocket, the websocket is closed when the client closes it or a write fails.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "Expected a websocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("Not a websocket request.")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websockets are not supported", http.StatusInternalServerError)
+		return nil, errors.New("Response can not be hijacked.")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ws := &WebSocket{conn: conn, rw: rw, closed: make(chan bool)}
+	go ws.read()
+	return ws, nil
+}
+
+// Returns a channel that is closed when the websocket closes.
+func (ws *WebSocket) Closed() <-chan bool {
+	return ws.closed
+}
+
+func (ws *WebSocket) Close() error {
+	var err error
+	ws.once.Do(func() {
+		ws.Lock()
+		ws.writeFrame(websocketClose, nil)
+		ws.Unlock()
+		err = ws.conn.Close()
+		close(ws.closed)
+	})
+	return err
+}
+
+func (ws *WebSocket) WriteText(text string) error {
+	ws.Lock()
+	err := ws.writeFrame(websocketText, []byte(text))
+	ws.Unlock()
+	if err != nil {
+		ws.Close()
+	}
+	return err
+}
+
+// Writes a single unmasked frame, the lock must be held.
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+// Reads frames from the client, answering pings and closing when the client does.
+func (ws *WebSocket) read() {
+	defer ws.Close()
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case websocketClose:
+			return
+		case websocketPing:
+			ws.Lock()
+			err = ws.writeFrame(websocketPong, payload)
+			ws.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(ws.rw, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(ws.rw, extended); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(ws.rw, extended); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > maxWebSocketPayload {
+		return 0, nil, errors.New("Websocket frame too large.")
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(ws.rw, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,169 @@
+package septapus
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var whoisCommand = NewCommand("!whois <nick>").WithHelp("Shows who is using a nick.")
+
+// Numerics in a WHOIS reply, the nick being looked up is always the second argument.
+const (
+	RPL_WHOISUSER     = "311"
+	RPL_WHOISSERVER   = "312"
+	RPL_WHOISIDLE     = "317"
+	RPL_ENDOFWHOIS    = "318"
+	RPL_WHOISCHANNELS = "319"
+	RPL_WHOISACCOUNT  = "330"
+	ERR_NOSUCHNICK    = "401"
+)
+
+// Unanswered lookups are forgotten after this, in case the server never ends the reply.
+const whoisTimeout = time.Minute
+
+// A WHOIS reply as it arrives, and the requests waiting for it.
+type whoisReply struct {
+	nick     string
+	user     string
+	host     string
+	server   string
+	account  string
+	idle     time.Duration
+	channels []RoomName
+	asked    time.Time
+	requests []*Event
+}
+
+// Returns a room from a WHOIS channel list, without the prefix showing the nick's status in it, eg: @#septapus.
+func whoisRoom(channel string) RoomName {
+	room := strings.TrimLeft(channel, "~@%+!")
+	if strings.HasPrefix(room, "&") && len(room) > 1 && strings.ContainsAny(room[1:2], "#&") {
+		room = room[1:]
+	}
+	return RoomName(room)
+}
+
+// Returns the summary of a reply for the nick that asked for it.
+func (reply *whoisReply) summary(event *Event) string {
+	shared := make([]string, 0)
+	for _, room := range reply.channels {
+		if event.Server.InRoom(room) {
+			shared = append(shared, string(room))
+		}
+	}
+	return Response(event.Server.Name, event.Room, event.Line.Nick, "whois.summary", ResponseVars{
+		"Nick":    reply.nick,
+		"User":    reply.user,
+		"Host":    reply.host,
+		"Account": reply.account,
+		"Server":  reply.server,
+		"Idle":    DurationString(reply.idle),
+		"Shared":  strings.Join(shared, ", "),
+	})
+}
+
+func NewWhoisPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(WhoisPlugin, settings)
+}
+
+// Looks up a nick with !whois <nick> and summarizes its account, server, idle time and the rooms it shares with us.
+func WhoisPlugin(bot *Bot, settings *PluginSettings) {
+	pending := make(map[ServerName]map[string]*whoisReply)
+
+	replies := make(chan *Event)
+	for _, numeric := range []EventName{RPL_WHOISUSER, RPL_WHOISSERVER, RPL_WHOISIDLE, RPL_ENDOFWHOIS, RPL_WHOISCHANNELS, RPL_WHOISACCOUNT, ERR_NOSUCHNICK} {
+		go func(channel chan *Event) {
+			for event := range channel {
+				replies <- event
+			}
+		}(bot.GetEventHandler(numeric))
+	}
+
+	// Replies go to the room the lookup was asked in, or to the nick if it was asked privately.
+	reply := func(event *Event, text string) {
+		target := event.Line.Nick
+		if event.Line.Public() {
+			target = event.Line.Target()
+		}
+		settings.Privmsg(event.Server, target, text)
+	}
+
+	channel := settings.HandleCommand(bot, whoisCommand)
+	for {
+		select {
+		case event, ok := <-channel:
+			if !ok {
+				return
+			}
+			args, err := whoisCommand.Parse(event.Line.Text())
+			if err != nil {
+				settings.Privmsg(event.Server, event.Line.Nick, err.Error())
+				continue
+			}
+			server, nick := event.Server.Name, args.String("nick")
+			if pending[server] == nil {
+				pending[server] = make(map[string]*whoisReply)
+			}
+			for key, waiting := range pending[server] {
+				if time.Since(waiting.asked) > whoisTimeout {
+					delete(pending[server], key)
+				}
+			}
+			if waiting := pending[server][NameKey(nick)]; waiting != nil {
+				waiting.requests = append(waiting.requests, event)
+				continue
+			}
+			pending[server][NameKey(nick)] = &whoisReply{nick: nick, asked: time.Now(), requests: []*Event{event}}
+			event.Server.Conn.Whois(nick)
+		case event := <-replies:
+			args := event.Line.Args
+			if len(args) < 2 {
+				continue
+			}
+			waiting := pending[event.Server.Name][NameKey(args[1])]
+			if waiting == nil {
+				continue
+			}
+			waiting.nick = args[1]
+			switch event.Line.Cmd {
+			case RPL_WHOISUSER:
+				if len(args) > 3 {
+					waiting.user, waiting.host = args[2], args[3]
+				}
+			case RPL_WHOISSERVER:
+				if len(args) > 2 {
+					waiting.server = args[2]
+				}
+			case RPL_WHOISIDLE:
+				if len(args) > 2 {
+					if seconds, err := strconv.Atoi(args[2]); err == nil {
+						waiting.idle = time.Duration(seconds) * time.Second
+					}
+				}
+			case RPL_WHOISCHANNELS:
+				for _, channel := range strings.Fields(event.Line.Text()) {
+					waiting.channels = append(waiting.channels, whoisRoom(channel))
+				}
+			case RPL_WHOISACCOUNT:
+				if len(args) > 2 {
+					waiting.account = args[2]
+				}
+			case ERR_NOSUCHNICK:
+				delete(pending[event.Server.Name], NameKey(args[1]))
+				for _, request := range waiting.requests {
+					reply(request, Response(request.Server.Name, request.Room, request.Line.Nick, "whois.nosuchnick", ResponseVars{"Nick": waiting.nick}))
+				}
+			case RPL_ENDOFWHOIS:
+				delete(pending[event.Server.Name], NameKey(args[1]))
+				// A nick that doesn't exist ends with 318 too, after the 401 has been answered.
+				if waiting.user == "" {
+					continue
+				}
+				for _, request := range waiting.requests {
+					reply(request, waiting.summary(request))
+				}
+			}
+		}
+	}
+}
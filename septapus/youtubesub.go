@@ -0,0 +1,381 @@
+package septapus
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fluffle/golog/logging"
+)
+
+var youtubeOptions = NewOptions("youtube")
+
+var youtubeinterval = youtubeOptions.Duration("interval", 10*time.Minute, "How often subscribed YouTube channels and playlists are checked for new videos")
+var youtubetemplate = youtubeOptions.String("template", "[{{.Channel}}] New video: {{.Title}} {{.URL}}", "Template used to announce new videos")
+
+var ytSubCommand = NewCommand("!ytsub list", "!ytsub remove <channel>", "!ytsub <channel>").WithHelp("Posts a YouTube channel's new videos in the room, or lists or removes the room's subscriptions.")
+
+var (
+	youTubeChannelIDPattern  = regexp.MustCompile(`^UC[\w-]{22}$`)
+	youTubePlaylistIDPattern = regexp.MustCompile(`^(PL|UU|OL|FL|LL)[\w-]+$`)
+	youTubeChannelURLPattern = regexp.MustCompile(`youtube\.com/channel/(UC[\w-]{22})`)
+	youTubeUserURLPattern    = regexp.MustCompile(`youtube\.com/user/([\w-]+)`)
+	youTubeHandlePattern     = regexp.MustCompile(`^(?:https?://)?(?:www\.)?(?:youtube\.com/)?(@[\w.-]+)`)
+	youTubePageChannelID     = regexp.MustCompile(`"(?:channelId|externalId)":"(UC[\w-]{22})"`)
+)
+
+type youTubeFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		VideoID string `xml:"videoId"`
+		Title   string `xml:"title"`
+		Link    struct {
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Author struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+	} `xml:"entry"`
+}
+
+type YouTubeTarget struct {
+	Server ServerName
+	Room   RoomName
+}
+
+type YouTubeAnnouncement struct {
+	Channel string
+	Title   string
+	URL     string
+}
+
+// A channel or playlist feed, Query selects it, eg: channel_id=UC... or playlist_id=PL...
+// Seen holds the videos in the feed when it was last polled, so only new uploads are announced.
+type YouTubeSubscription struct {
+	Query string
+	Name  string
+	Seen  map[string]bool
+}
+
+func (sub *YouTubeSubscription) FeedURL() string {
+	return "https://www.youtube.com/feeds/videos.xml?" + sub.Query
+}
+
+// Polls the feed, returning the announcements for videos that have not been seen before.
+// The first poll only records the current videos so subscribing does not flood the room.
+func (sub *YouTubeSubscription) Poll() ([]string, error) {
+	req, err := http.NewRequest("GET", sub.FeedURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := SharedFetcher().Do(req, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %v for %v", resp.StatusCode, sub.Query)
+	}
+	var feed youTubeFeed
+	if err := xml.Unmarshal(resp.Body, &feed); err != nil {
+		return nil, err
+	}
+	if feed.Title != "" {
+		sub.Name = feed.Title
+	} else if sub.Name == "" {
+		sub.Name = sub.Query
+	}
+
+	messages := make([]string, 0)
+	seen := make(map[string]bool)
+	// Newest first in the feed, announced oldest first.
+	for i := len(feed.Entries) - 1; i >= 0; i-- {
+		entry := feed.Entries[i]
+		seen[entry.VideoID] = true
+		if sub.Seen == nil || sub.Seen[entry.VideoID] {
+			continue
+		}
+		channel := entry.Author.Name
+		if channel == "" {
+			channel = sub.Name
+		}
+		if message, err := executeYouTubeTemplate(&YouTubeAnnouncement{channel, entry.Title, entry.Link.Href}); err == nil {
+			messages = append(messages, message)
+		} else {
+			ReportError("ytsub", "Error executing youtube template:", err)
+		}
+	}
+	sub.Seen = seen
+	return messages, nil
+}
+
+func executeYouTubeTemplate(announcement *YouTubeAnnouncement) (string, error) {
+	t, err := template.New("youtube").Parse(*youtubetemplate)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, announcement); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Returns the feed query for a channel id, playlist id, user, @handle or a url to any of them.
+func ParseYouTubeChannel(channel string) (string, error) {
+	if u, err := url.Parse(channel); err == nil && strings.Contains(u.Host, "youtube.com") {
+		if list := u.Query().Get("list"); list != "" {
+			return "playlist_id=" + list, nil
+		}
+	}
+	if match := youTubeChannelURLPattern.FindStringSubmatch(channel); match != nil {
+		return "channel_id=" + match[1], nil
+	}
+	if match := youTubeUserURLPattern.FindStringSubmatch(channel); match != nil {
+		return "user=" + match[1], nil
+	}
+	if youTubeChannelIDPattern.MatchString(channel) {
+		return "channel_id=" + channel, nil
+	}
+	if youTubePlaylistIDPattern.MatchString(channel) {
+		return "playlist_id=" + channel, nil
+	}
+	// Handles have no feed of their own, the channel id is read from the channel page.
+	if match := youTubeHandlePattern.FindStringSubmatch(channel); match != nil {
+		resp, err := SharedFetcher().Get("https://www.youtube.com/" + match[1])
+		if err != nil {
+			return "", err
+		}
+		if id := youTubePageChannelID.FindSubmatch(resp.Body); id != nil {
+			return "channel_id=" + string(id[1]), nil
+		}
+		return "", fmt.Errorf("Couldn't find the channel for %v.", match[1])
+	}
+	if strings.Contains(channel, "/") || strings.Contains(channel, ".") {
+		return "", fmt.Errorf("Bad channel, use a channel or playlist url, id or @handle.")
+	}
+	return "user=" + channel, nil
+}
+
+// Every room's subscriptions and the polling state of each feed, saved to youtube.json.
+type YouTubeSubscriptions struct {
+	sync.RWMutex
+	Rooms map[ServerName]map[RoomName][]string
+	Feeds map[string]*YouTubeSubscription
+}
+
+func NewYouTubeSubscriptions() *YouTubeSubscriptions {
+	return &YouTubeSubscriptions{
+		Rooms: make(map[ServerName]map[RoomName][]string),
+		Feeds: make(map[string]*YouTubeSubscription),
+	}
+}
+
+func (subs *YouTubeSubscriptions) Load() {
+	subs.Lock()
+	defer subs.Unlock()
+
+	if file, err := os.Open("youtube.json"); err == nil {
+		defer file.Close()
+		dec := json.NewDecoder(file)
+		if err := dec.Decode(subs); err != nil {
+			ReportError("ytsub", "Error loading youtube subscriptions", err)
+		} else {
+			logging.Info("Loaded youtube subscriptions")
+		}
+	}
+	if subs.Rooms == nil {
+		subs.Rooms = make(map[ServerName]map[RoomName][]string)
+	}
+	if subs.Feeds == nil {
+		subs.Feeds = make(map[string]*YouTubeSubscription)
+	}
+}
+
+func (subs *YouTubeSubscriptions) Save() {
+	subs.Lock()
+	defer subs.Unlock()
+
+	if err := SaveSharedFile("youtube.json", subs, "Rooms"); err != nil {
+		ReportError("ytsub", "Error saving youtube subscriptions", err)
+	}
+}
+
+// Subscribes a room to a feed, returns false if it is already subscribed.
+func (subs *YouTubeSubscriptions) Add(server ServerName, room RoomName, sub *YouTubeSubscription) bool {
+	subs.Lock()
+	defer subs.Unlock()
+
+	if subs.Rooms[server] == nil {
+		subs.Rooms[server] = make(map[RoomName][]string)
+	}
+	for _, query := range subs.Rooms[server][room] {
+		if query == sub.Query {
+			return false
+		}
+	}
+	subs.Rooms[server][room] = append(subs.Rooms[server][room], sub.Query)
+	if subs.Feeds[sub.Query] == nil {
+		subs.Feeds[sub.Query] = sub
+	}
+	return true
+}
+
+// Unsubscribes a room from a feed, feeds no room is subscribed to are forgotten. Returns false if the room wasn't subscribed.
+func (subs *YouTubeSubscriptions) Remove(server ServerName, room RoomName, query string) bool {
+	subs.Lock()
+	defer subs.Unlock()
+
+	queries := subs.Rooms[server][room]
+	for i, q := range queries {
+		if q == query {
+			subs.Rooms[server][room] = append(queries[:i], queries[i+1:]...)
+			if len(subs.targets(query)) == 0 {
+				delete(subs.Feeds, query)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of a room's subscriptions, sorted.
+func (subs *YouTubeSubscriptions) List(server ServerName, room RoomName) []string {
+	subs.RLock()
+	defer subs.RUnlock()
+
+	names := make([]string, 0)
+	for _, query := range subs.Rooms[server][room] {
+		if sub := subs.Feeds[query]; sub != nil && sub.Name != "" {
+			names = append(names, sub.Name)
+		} else {
+			names = append(names, query)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the rooms subscribed to a feed, the caller must hold the lock.
+func (subs *YouTubeSubscriptions) targets(query string) []*YouTubeTarget {
+	targets := make([]*YouTubeTarget, 0)
+	for server, rooms := range subs.Rooms {
+		for room, queries := range rooms {
+			for _, q := range queries {
+				if q == query {
+					targets = append(targets, &YouTubeTarget{server, room})
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// Polls every feed and announces new videos to the rooms subscribed to them.
+func (subs *YouTubeSubscriptions) poll(bot *Bot, settings *PluginSettings) {
+	subs.Lock()
+	defer subs.Unlock()
+
+	for query, sub := range subs.Feeds {
+		messages, err := sub.Poll()
+		if err != nil {
+			ReportError("ytsub", "Error polling youtube feed", query, err)
+			continue
+		}
+		for _, target := range subs.targets(query) {
+			if !settings.IsAllowed(target.Server, target.Room) {
+				continue
+			}
+			server := bot.GetServer(target.Server)
+			if server == nil || server.Conn == nil || !server.Conn.Connected() {
+				continue
+			}
+			for _, message := range messages {
+				settings.Privmsg(server, string(target.Room), message)
+			}
+		}
+	}
+}
+
+func NewYouTubeSubscriptionsPlugin(settings *PluginSettings) Plugin {
+	return NewSimplePlugin(YouTubeSubscriptionsPlugin, settings)
+}
+
+// Announces new uploads from subscribed channels and playlists. Admins subscribe a room with !ytsub <channel>
+// and unsubscribe with !ytsub remove <channel>, anyone can see a room's subscriptions with !ytsub list.
+func YouTubeSubscriptionsPlugin(bot *Bot, settings *PluginSettings) {
+	subs := NewYouTubeSubscriptions()
+	subs.Load()
+	defer subs.Save()
+
+	channel := settings.HandleCommand(bot, ytSubCommand)
+
+	ticker := time.NewTicker(*youtubeinterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-channel:
+			if !ok {
+				return
+			}
+			server, room, nick := event.Server.Name, event.Room, event.Line.Nick
+			args, err := ytSubCommand.Parse(event.Line.Text())
+			if err != nil {
+				event.Server.Privmsg(nick, err.Error())
+				continue
+			}
+			if args.Pattern == "!ytsub list" {
+				names := subs.List(server, room)
+				if len(names) == 0 {
+					settings.Reply(event, Response(server, room, "", "ytsub.none", nil))
+				} else {
+					settings.Reply(event, Response(server, room, "", "ytsub.list", ResponseVars{"Subscriptions": strings.Join(names, ", ")}))
+				}
+				continue
+			}
+			if !event.Line.Public() || !IsAdmin(event) {
+				event.Server.Privmsg(nick, Response(server, room, nick, "ytsub.adminonly", nil))
+				continue
+			}
+			query, err := ParseYouTubeChannel(args.String("channel"))
+			if err != nil {
+				event.Server.Privmsg(nick, err.Error())
+				continue
+			}
+			if args.Pattern == "!ytsub remove <channel>" {
+				if subs.Remove(server, room, query) {
+					subs.Save()
+					settings.Reply(event, Response(server, room, "", "ytsub.unsubscribed", ResponseVars{"Channel": args.String("channel")}))
+				} else {
+					event.Server.Privmsg(nick, Response(server, room, nick, "ytsub.notsubscribed", ResponseVars{"Channel": args.String("channel")}))
+				}
+				continue
+			}
+			// Polled once before subscribing, so the feed is known to exist and its current videos aren't announced.
+			sub := &YouTubeSubscription{Query: query}
+			if _, err := sub.Poll(); err != nil {
+				event.Server.Privmsg(nick, Response(server, room, nick, "ytsub.badfeed", ResponseVars{"Error": err.Error()}))
+				continue
+			}
+			if subs.Add(server, room, sub) {
+				subs.Save()
+				settings.Reply(event, Response(server, room, "", "ytsub.subscribed", ResponseVars{"Channel": sub.Name}))
+			} else {
+				event.Server.Privmsg(nick, Response(server, room, nick, "ytsub.already", ResponseVars{"Channel": sub.Name}))
+			}
+		case <-ticker.C:
+			subs.poll(bot, settings)
+			subs.Save()
+		}
+	}
+}